@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/location"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Values for --pre-hook-failure.
+const (
+	hookFailureAbort    = "abort"
+	hookFailureContinue = "continue"
+)
+
+// postHookTimeout limits the runtime of the post-hook. The post-hook does not
+// use the context of the backup, which may already have been cancelled.
+var postHookTimeout = 10 * time.Minute
+
+// backupHooks runs the commands passed to backup with --pre-hook and
+// --post-hook.
+type backupHooks struct {
+	opts  BackupOptions
+	gopts GlobalOptions
+
+	// output is called for each line the hooks write to stdout or stderr,
+	// warn for failed hooks.
+	output func(hook, line string)
+	warn   func(msg string, args ...interface{})
+
+	// snapshot and id are set once the snapshot has been saved.
+	snapshot *restic.Snapshot
+	id       restic.ID
+}
+
+// env returns the environment variables for the hook, in addition to the
+// environment of restic.
+func (h *backupHooks) env(hook string) []string {
+	return []string{
+		"RESTIC_HOOK=" + hook,
+		"RESTIC_REPOSITORY=" + location.StripPassword(h.gopts.Repo),
+	}
+}
+
+// pre runs the pre-hook. Depending on --pre-hook-failure, a failing hook
+// aborts the backup.
+func (h *backupHooks) pre(ctx context.Context) error {
+	if h.opts.PreHook == "" {
+		return nil
+	}
+
+	err := runHook(ctx, h.opts.PreHook, h.env("pre"), func(line string) {
+		h.output("pre", line)
+	})
+	if err == nil {
+		return nil
+	}
+
+	if h.opts.PreHookFailure == hookFailureContinue {
+		h.warn("pre-hook failed, continuing the backup: %v\n", err)
+		return nil
+	}
+	return errors.Fatalf("pre-hook failed: %v", err)
+}
+
+// post runs the post-hook, backupErr is the error the backup finished with.
+// A failing post-hook is only reported. The hook also runs if the backup was
+// interrupted, it is stopped after postHookTimeout.
+func (h *backupHooks) post(backupErr error) {
+	if h.opts.PostHook == "" {
+		return
+	}
+
+	env := h.env("post")
+	env = append(env, fmt.Sprintf("RESTIC_EXIT_STATUS=%d", exitCode(backupErr)))
+	if backupErr != nil {
+		env = append(env, "RESTIC_ERROR="+backupErr.Error())
+	}
+	if !h.id.IsNull() {
+		env = append(env, "RESTIC_SNAPSHOT_ID="+h.id.String())
+	}
+	if h.snapshot != nil && h.snapshot.Summary != nil {
+		s := h.snapshot.Summary
+		env = append(env,
+			fmt.Sprintf("RESTIC_FILES_NEW=%d", s.FilesNew),
+			fmt.Sprintf("RESTIC_FILES_CHANGED=%d", s.FilesChanged),
+			fmt.Sprintf("RESTIC_FILES_UNMODIFIED=%d", s.FilesUnmodified),
+			fmt.Sprintf("RESTIC_DIRS_NEW=%d", s.DirsNew),
+			fmt.Sprintf("RESTIC_DIRS_CHANGED=%d", s.DirsChanged),
+			fmt.Sprintf("RESTIC_DIRS_UNMODIFIED=%d", s.DirsUnmodified),
+			fmt.Sprintf("RESTIC_DATA_BLOBS=%d", s.DataBlobs),
+			fmt.Sprintf("RESTIC_TREE_BLOBS=%d", s.TreeBlobs),
+			fmt.Sprintf("RESTIC_DATA_ADDED=%d", s.DataAdded),
+			fmt.Sprintf("RESTIC_TOTAL_FILES_PROCESSED=%d", s.FilesProcessed),
+			fmt.Sprintf("RESTIC_TOTAL_BYTES_PROCESSED=%d", s.BytesProcessed),
+			fmt.Sprintf("RESTIC_TOTAL_DURATION=%.3f", s.Duration().Seconds()),
+		)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), postHookTimeout)
+	defer cancel()
+
+	err := runHook(ctx, h.opts.PostHook, env, func(line string) {
+		h.output("post", line)
+	})
+	if err != nil {
+		h.warn("post-hook failed: %v\n", err)
+	}
+}
+
+// runHook runs command, which is split like a shell would do it. env is
+// added to the environment of the command. The output of the command is
+// passed to output line by line.
+func runHook(ctx context.Context, command string, env []string, output func(line string)) error {
+	args, err := backend.SplitShellStrings(command)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("no command specified")
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "StdoutPipe")
+	}
+	cmd.Stderr = cmd.Stdout
+
+	err = cmd.Start()
+	if err != nil {
+		return errors.Wrapf(err, "unable to start %q", args[0])
+	}
+
+	rd := bufio.NewReader(stdout)
+	for {
+		line, rerr := rd.ReadString('\n')
+		if line != "" {
+			output(strings.TrimRight(line, "\r\n"))
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			return errors.Wrap(rerr, "read output")
+		}
+	}
+
+	return cmd.Wait()
+}
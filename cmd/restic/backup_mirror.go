@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/restic/chunker"
+	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// backupMirror is a secondary repository passed to backup with --also-repo,
+// --repo2 or --repository-file2. Each repository gets its own snapshot, with its own
+// parent.
+type backupMirror struct {
+	repo *repository.Repository
+	lock *restic.Lock
+
+	parent *restic.Snapshot
+	chain  *restic.SnapshotChain
+
+	// id and err are the result of the backup to this repository.
+	// deadlineReached is set if the files could not be read again for the
+	// chunker parameters of the repository before the deadline.
+	id              restic.ID
+	err             error
+	deadlineReached bool
+}
+
+// envList returns the value of the environment variable name as a list with
+// a single entry, or nil if it is not set.
+func envList(name string) []string {
+	if value := os.Getenv(name); value != "" {
+		return []string{value}
+	}
+	return nil
+}
+
+// hasBackupMirror returns true if a secondary repository has been specified.
+func hasBackupMirror(opts BackupOptions) bool {
+	return len(opts.AlsoRepos) > 0 || len(opts.Repos2) > 0 || len(opts.RepositoryFiles2) > 0
+}
+
+// openBackupMirrors opens all secondary repositories. The password options
+// are used for all of them.
+func openBackupMirrors(opts BackupOptions, gopts GlobalOptions) ([]*backupMirror, error) {
+	var locations []secondaryRepoOptions
+	for _, repo := range append(append([]string(nil), opts.AlsoRepos...), opts.Repos2...) {
+		location := opts.secondaryRepoOptions
+		location.Repo, location.RepositoryFile = repo, ""
+		locations = append(locations, location)
+	}
+	for _, filename := range opts.RepositoryFiles2 {
+		location := opts.secondaryRepoOptions
+		location.Repo, location.RepositoryFile = "", filename
+		locations = append(locations, location)
+	}
+
+	mirrors := make([]*backupMirror, 0, len(locations))
+	for _, location := range locations {
+		mirrorGopts, err := fillSecondaryGlobalOpts(location, gopts, "secondary")
+		if err != nil {
+			return nil, err
+		}
+
+		repo, err := OpenRepository(mirrorGopts)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = repo.CheckCapabilities(repository.CapabilityAppend); err != nil {
+			return nil, err
+		}
+
+		mirrors = append(mirrors, &backupMirror{repo: repo})
+	}
+
+	return mirrors, nil
+}
+
+// groupBackupMirrors returns the mirrors which use the chunker polynomial pol
+// of the primary repository, and the remaining mirrors grouped by their
+// polynomial. The data for each group has to be split separately.
+func groupBackupMirrors(pol chunker.Pol, mirrors []*backupMirror) (same []*backupMirror, others [][]*backupMirror) {
+	index := make(map[chunker.Pol]int)
+	for _, m := range mirrors {
+		mpol := m.repo.Config().ChunkerPolynomial
+		if mpol == pol {
+			same = append(same, m)
+			continue
+		}
+
+		i, ok := index[mpol]
+		if !ok {
+			i = len(others)
+			index[mpol] = i
+			others = append(others, nil)
+		}
+		others[i] = append(others[i], m)
+	}
+	return same, others
+}
+
+// mirrorRepos returns the repositories of mirrors.
+func mirrorRepos(mirrors []*backupMirror) []restic.Repository {
+	repos := make([]restic.Repository, 0, len(mirrors))
+	for _, m := range mirrors {
+		repos = append(repos, m.repo)
+	}
+	return repos
+}
+
+// name returns the short ID of the repository, used in messages.
+func (m *backupMirror) name() string {
+	id := m.repo.Config().ID
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return id
+}
+
+// parentID returns the ID of the parent snapshot in the repository, or a
+// null ID if there is none.
+func (m *backupMirror) parentID() restic.ID {
+	if m.parent == nil {
+		return restic.ID{}
+	}
+	return *m.parent.ID()
+}
+
+// load loads the index of the secondary repository and finds the parent
+// snapshot for targets.
+func (m *backupMirror) load(ctx context.Context, opts BackupOptions, targets []string) error {
+	err := m.repo.LoadIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	if m.repo.WriteOnly() {
+		return nil
+	}
+
+	var snapshots restic.Snapshots
+	err = restic.ForAllSnapshots(ctx, m.repo, nil, func(id restic.ID, sn *restic.Snapshot, err error) error {
+		if err != nil {
+			return errors.Fatalf("unable to load snapshot %v from the secondary repository %v: %v", id.Str(), m.name(), err)
+		}
+		snapshots = append(snapshots, sn)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// --parent refers to a snapshot in the primary repository, so the
+	// latest complete snapshot is always used here
+	if !opts.Force && opts.FromTar == "" {
+		var complete restic.Snapshots
+		for _, sn := range snapshots {
+			if !sn.Incomplete {
+				complete = append(complete, sn)
+			}
+		}
+
+		id, err := restic.FindLatestSnapshotIn(complete, targets, []restic.TagList{}, []string{opts.Host})
+		if err == nil {
+			for _, sn := range complete {
+				if sn.ID().Equal(id) {
+					m.parent = sn
+				}
+			}
+		} else if err != restic.ErrNoSnapshotFound {
+			return err
+		}
+	}
+
+	m.chain, err = restic.BuildSnapshotChain(ctx, m.repo, snapshots)
+	return err
+}
+
+// saveSnapshot saves sn, the snapshot saved to the repository the archiver
+// used, to the secondary repository. The tree has already been saved by the
+// archiver. If skipIfUnchanged is set and the tree is the same as the tree of
+// the parent snapshot, no snapshot is saved and the returned ID is null.
+func (m *backupMirror) saveSnapshot(ctx context.Context, sn *restic.Snapshot, skipIfUnchanged bool) (restic.ID, error) {
+	if skipIfUnchanged && !sn.Incomplete && m.parent != nil && m.parent.Tree != nil && m.parent.Tree.Equal(*sn.Tree) {
+		return restic.ID{}, nil
+	}
+
+	msn := *sn
+	msn.Parent = nil
+	if m.parent != nil {
+		msn.Parent = m.parent.ID()
+	}
+
+	msn.ChainPrev, msn.ChainDigest = nil, nil
+	if m.chain != nil && !msn.Incomplete {
+		m.chain.Link(&msn)
+	}
+
+	id, err := m.repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, &msn)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	if m.chain != nil && !msn.Incomplete {
+		err = m.chain.SaveHead(ctx, m.repo, id, &msn)
+		if err != nil {
+			return restic.ID{}, err
+		}
+	}
+	return id, nil
+}
+
+// finish saves the snapshot to the secondary repository, unless an error
+// occurred while saving the data to it. The result is recorded in m.
+func (m *backupMirror) finish(ctx context.Context, archRepo *archiver.MirrorRepository, sn *restic.Snapshot, skipIfUnchanged bool) {
+	m.err = archRepo.Err(m.repo)
+	if m.err != nil {
+		return
+	}
+
+	m.id, m.err = m.saveSnapshot(ctx, sn, skipIfUnchanged)
+}
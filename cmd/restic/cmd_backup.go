@@ -11,6 +11,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
@@ -70,6 +71,13 @@ Exit status is 3 if some source data could not be read (incomplete snapshot crea
 
 // BackupOptions bundles all options for the backup command.
 type BackupOptions struct {
+	// secondaryRepoOptions holds the password options for the repositories
+	// passed with --also-repo, --repo2 and --repository-file2, which can be
+	// specified multiple times for backup.
+	secondaryRepoOptions
+	AlsoRepos               []string
+	Repos2                  []string
+	RepositoryFiles2        []string
 	Parent                  string
 	Force                   bool
 	Excludes                []string
@@ -80,9 +88,21 @@ type BackupOptions struct {
 	ExcludeIfPresent        []string
 	ExcludeCaches           bool
 	ExcludeLargerThan       string
+	ExcludeFileNames        []string
+	Includes                []string
+	InsensitiveIncludes     []string
+	ExcludeOlderThan        string
+	ExcludeNewerThan        string
+	ExcludeOwners           []string
+	ExcludeTypes            []string
+	ExcludeNoDump           bool
 	Stdin                   bool
+	StdinCommand            bool
 	StdinFilename           string
+	FromTar                 string
 	Tags                    restic.TagLists
+	Labels                  []string
+	Description             string
 	Host                    string
 	FilesFrom               []string
 	FilesFromVerbatim       []string
@@ -92,6 +112,14 @@ type BackupOptions struct {
 	IgnoreInode             bool
 	IgnoreCtime             bool
 	UseFsSnapshot           bool
+	DryRun                  bool
+	CheckpointInterval      time.Duration
+	Deadline                time.Duration
+	StopAt                  string
+	SkipIfUnchanged         bool
+	PreHook                 string
+	PostHook                string
+	PreHookFailure          string
 }
 
 var backupOptions BackupOptions
@@ -113,9 +141,21 @@ func init() {
 	f.StringArrayVar(&backupOptions.ExcludeIfPresent, "exclude-if-present", nil, "takes `filename[:header]`, exclude contents of directories containing filename (except filename itself) if header of that file is as provided (can be specified multiple times)")
 	f.BoolVar(&backupOptions.ExcludeCaches, "exclude-caches", false, `excludes cache directories that are marked with a CACHEDIR.TAG file. See https://bford.info/cachedir/ for the Cache Directory Tagging Standard`)
 	f.StringVar(&backupOptions.ExcludeLargerThan, "exclude-larger-than", "", "max `size` of the files to be backed up (allowed suffixes: k/K, m/M, g/G, t/T)")
+	f.StringArrayVarP(&backupOptions.Includes, "include", "i", nil, "include only items matching a `pattern`, parent directories are kept (can be specified multiple times)")
+	f.StringArrayVar(&backupOptions.InsensitiveIncludes, "iinclude", nil, "same as --include `pattern` but ignores the casing of filenames")
+	f.StringVar(&backupOptions.ExcludeOlderThan, "exclude-older-than", "", "exclude files modified more than `duration` ago (e.g. 1y5m7d2h)")
+	f.StringVar(&backupOptions.ExcludeNewerThan, "exclude-newer-than", "", "exclude files modified less than `duration` ago (e.g. 1y5m7d2h)")
+	f.StringArrayVar(&backupOptions.ExcludeOwners, "exclude-owner", nil, "exclude files and directories owned by `user` (name or uid, can be specified multiple times)")
+	f.StringArrayVar(&backupOptions.ExcludeTypes, "exclude-type", nil, "exclude items of the given `type` (file, symlink, dev, chardev, fifo or socket, can be specified multiple times)")
+	f.BoolVar(&backupOptions.ExcludeNoDump, "exclude-nodump", false, "exclude files and directories with the nodump attribute (Linux only)")
+	f.StringArrayVar(&backupOptions.ExcludeFileNames, "exclude-file-name", nil, "exclude items matching the gitignore-style patterns in files called `name` in their parent directories (can be specified multiple times)")
 	f.BoolVar(&backupOptions.Stdin, "stdin", false, "read backup from stdin")
+	f.BoolVar(&backupOptions.StdinCommand, "stdin-from-command", false, "run the command given as arguments and read the backup from its stdout")
 	f.StringVar(&backupOptions.StdinFilename, "stdin-filename", "stdin", "`filename` to use when reading from stdin")
+	f.StringVar(&backupOptions.FromTar, "from-tar", "", "read the backup from a tar `file` (use - for stdin)")
 	f.Var(&backupOptions.Tags, "tag", "add `tags` for the new snapshot in the format `tag[,tag,...]` (can be specified multiple times)")
+	f.StringArrayVar(&backupOptions.Labels, "label", nil, "set a `label` for the new snapshot in the format key=value (can be specified multiple times)")
+	f.StringVar(&backupOptions.Description, "description", "", "set a free-form `description` for the new snapshot")
 
 	f.StringVarP(&backupOptions.Host, "host", "H", "", "set the `hostname` for the snapshot manually. To prevent an expensive rescan use the \"parent\" flag")
 	f.StringVar(&backupOptions.Host, "hostname", "", "set the `hostname` for the snapshot manually")
@@ -132,6 +172,18 @@ func init() {
 	f.BoolVar(&backupOptions.WithAtime, "with-atime", false, "store the atime for all files and directories")
 	f.BoolVar(&backupOptions.IgnoreInode, "ignore-inode", false, "ignore inode number changes when checking for modified files")
 	f.BoolVar(&backupOptions.IgnoreCtime, "ignore-ctime", false, "ignore ctime changes when checking for modified files")
+	f.BoolVarP(&backupOptions.DryRun, "dry-run", "n", false, "do not upload or write any data, just show what would be done")
+	f.BoolVar(&backupOptions.SkipIfUnchanged, "skip-if-unchanged", false, "do not save a new snapshot if nothing has changed since the parent snapshot")
+	f.StringVar(&backupOptions.PreHook, "pre-hook", "", "run `command` before the backup is started")
+	f.StringVar(&backupOptions.PostHook, "post-hook", "", "run `command` after the backup has finished, also if it failed")
+	f.StringVar(&backupOptions.PreHookFailure, "pre-hook-failure", hookFailureAbort, "what to do if the pre-hook fails, `action` is either \"abort\" or \"continue\"")
+	f.StringArrayVar(&backupOptions.AlsoRepos, "also-repo", nil, "also save the backup to this secondary `repository` (can be specified multiple times)")
+	f.StringArrayVar(&backupOptions.Repos2, "repo2", envList("RESTIC_REPOSITORY2"), "secondary `repository` to save the backup to as well (can be specified multiple times, default: $RESTIC_REPOSITORY2)")
+	f.StringArrayVar(&backupOptions.RepositoryFiles2, "repository-file2", envList("RESTIC_REPOSITORY_FILE2"), "`file` from which to read the location of a secondary repository to save the backup to as well (can be specified multiple times, default: $RESTIC_REPOSITORY_FILE2)")
+	initSecondaryPasswordOptions(f, &backupOptions.secondaryRepoOptions, "secondary")
+	f.DurationVar(&backupOptions.CheckpointInterval, "checkpoint-interval", 0, "save an incomplete snapshot of the files completed so far every `duration` (e.g. 30m), so an interrupted backup can be resumed")
+	f.DurationVar(&backupOptions.Deadline, "deadline", 0, "stop starting new files after `duration` (e.g. 2h) and save a partial snapshot of the files completed so far")
+	f.StringVar(&backupOptions.StopAt, "stop-at", "", "stop starting new files at `time` of day (e.g. 06:00) and save a partial snapshot of the files completed so far")
 	if runtime.GOOS == "windows" {
 		f.BoolVar(&backupOptions.UseFsSnapshot, "use-fs-snapshot", false, "use filesystem snapshot where possible (currently only Windows VSS)")
 	}
@@ -257,21 +309,105 @@ func (opts BackupOptions) Check(gopts GlobalOptions, args []string) error {
 				return errors.Fatal("unable to read password from stdin when data is to be read from stdin, use --password-file or $RESTIC_PASSWORD")
 			}
 		}
+		if opts.FromTar == "-" {
+			return errors.Fatal("unable to read password from stdin when data is to be read from stdin, use --password-file or $RESTIC_PASSWORD")
+		}
 	}
 
-	if opts.Stdin {
+	if hasBackupMirror(opts) && opts.secondaryRepoOptions.PasswordFile == "" &&
+		opts.secondaryRepoOptions.PasswordCommand == "" && os.Getenv("RESTIC_PASSWORD2") == "" {
+		filesFrom := append(append(opts.FilesFrom, opts.FilesFromVerbatim...), opts.FilesFromRaw...)
+		for _, filename := range filesFrom {
+			if filename == "-" {
+				return errors.Fatal("unable to read the password of the secondary repository from stdin when data is to be read from stdin, use --password-file2 or $RESTIC_PASSWORD2")
+			}
+		}
+		if opts.Stdin || opts.FromTar == "-" {
+			return errors.Fatal("unable to read the password of the secondary repository from stdin when data is to be read from stdin, use --password-file2 or $RESTIC_PASSWORD2")
+		}
+	}
+
+	if opts.Stdin && opts.StdinCommand {
+		return errors.Fatal("--stdin and --stdin-from-command cannot be used together")
+	}
+
+	if opts.Stdin || opts.StdinCommand {
+		flag := "--stdin"
+		if opts.StdinCommand {
+			flag = "--stdin-from-command"
+		}
+
 		if len(opts.FilesFrom) > 0 {
-			return errors.Fatal("--stdin and --files-from cannot be used together")
+			return errors.Fatalf("%v and --files-from cannot be used together", flag)
 		}
 		if len(opts.FilesFromVerbatim) > 0 {
-			return errors.Fatal("--stdin and --files-from-verbatim cannot be used together")
+			return errors.Fatalf("%v and --files-from-verbatim cannot be used together", flag)
 		}
 		if len(opts.FilesFromRaw) > 0 {
-			return errors.Fatal("--stdin and --files-from-raw cannot be used together")
+			return errors.Fatalf("%v and --files-from-raw cannot be used together", flag)
 		}
+	}
+
+	if opts.Stdin && len(args) > 0 {
+		return errors.Fatal("--stdin was specified and files/dirs were listed as arguments")
+	}
+
+	if opts.StdinCommand && len(args) == 0 {
+		return errors.Fatal("--stdin-from-command was specified without a command")
+	}
+
+	if len(opts.ExcludeOwners) > 0 && runtime.GOOS == "windows" {
+		return errors.Fatal("--exclude-owner is not supported on Windows")
+	}
+
+	if opts.ExcludeNoDump && !fs.InodeFlagsSupported {
+		return errors.Fatal("--exclude-nodump is only supported on Linux")
+	}
+
+	switch opts.PreHookFailure {
+	case "", hookFailureAbort, hookFailureContinue:
+	default:
+		return errors.Fatalf("invalid value %q for --pre-hook-failure, must be %q or %q", opts.PreHookFailure, hookFailureAbort, hookFailureContinue)
+	}
+
+	if _, err := restic.ParseLabels(opts.Labels); err != nil {
+		return errors.Fatalf("invalid --label: %v", err)
+	}
+
+	if opts.CheckpointInterval < 0 {
+		return errors.Fatal("--checkpoint-interval must not be negative")
+	}
+
+	if opts.Deadline < 0 {
+		return errors.Fatal("--deadline must not be negative")
+	}
 
-		if len(args) > 0 {
-			return errors.Fatal("--stdin was specified and files/dirs were listed as arguments")
+	if opts.Deadline > 0 && opts.StopAt != "" {
+		return errors.Fatal("--deadline and --stop-at cannot be used together")
+	}
+
+	if flag := resumableFlag(opts); flag != "" && (opts.Stdin || opts.StdinCommand || opts.FromTar != "") {
+		return errors.Fatalf("%v cannot be used together with --stdin, --stdin-from-command or --from-tar", flag)
+	}
+
+	if opts.FromTar != "" {
+		switch {
+		case opts.Stdin || opts.StdinCommand:
+			return errors.Fatal("--from-tar cannot be used together with --stdin or --stdin-from-command")
+		case len(args) > 0:
+			return errors.Fatal("--from-tar was specified and files/dirs were listed as arguments")
+		case len(opts.FilesFrom) > 0 || len(opts.FilesFromVerbatim) > 0 || len(opts.FilesFromRaw) > 0:
+			return errors.Fatal("--from-tar and --files-from cannot be used together")
+		case opts.Parent != "":
+			return errors.Fatal("--from-tar and --parent cannot be used together")
+		case opts.ExcludeOtherFS:
+			return errors.Fatal("--from-tar and --one-file-system cannot be used together")
+		case opts.ExcludeCaches || len(opts.ExcludeIfPresent) > 0:
+			return errors.Fatal("--from-tar and --exclude-caches/--exclude-if-present cannot be used together")
+		case len(opts.ExcludeFileNames) > 0:
+			return errors.Fatal("--from-tar and --exclude-file-name cannot be used together")
+		case len(opts.ExcludeOwners) > 0 || opts.ExcludeNoDump:
+			return errors.Fatal("--from-tar and --exclude-owner/--exclude-nodump cannot be used together")
 		}
 	}
 
@@ -336,7 +472,7 @@ func collectRejectByNameFuncs(opts BackupOptions, repo *repository.Repository, t
 // from being saved in a snapshot based on path and file info
 func collectRejectFuncs(opts BackupOptions, repo *repository.Repository, targets []string) (fs []RejectFunc, err error) {
 	// allowed devices
-	if opts.ExcludeOtherFS && !opts.Stdin {
+	if opts.ExcludeOtherFS && !opts.Stdin && !opts.StdinCommand {
 		f, err := rejectByDevice(targets)
 		if err != nil {
 			return nil, err
@@ -344,7 +480,7 @@ func collectRejectFuncs(opts BackupOptions, repo *repository.Repository, targets
 		fs = append(fs, f)
 	}
 
-	if len(opts.ExcludeLargerThan) != 0 && !opts.Stdin {
+	if len(opts.ExcludeLargerThan) != 0 && !opts.Stdin && !opts.StdinCommand {
 		f, err := rejectBySize(opts.ExcludeLargerThan)
 		if err != nil {
 			return nil, err
@@ -352,6 +488,51 @@ func collectRejectFuncs(opts BackupOptions, repo *repository.Repository, targets
 		fs = append(fs, f)
 	}
 
+	if opts.Stdin || opts.StdinCommand {
+		// the remaining filters do not apply to data read from stdin
+		return fs, nil
+	}
+
+	for _, name := range opts.ExcludeFileNames {
+		f, err := rejectByIgnoreFile(name)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+
+	if len(opts.Includes) > 0 || len(opts.InsensitiveIncludes) > 0 {
+		fs = append(fs, rejectByIncludePattern(opts.Includes, opts.InsensitiveIncludes))
+	}
+
+	if opts.ExcludeOlderThan != "" || opts.ExcludeNewerThan != "" {
+		f, err := rejectByAge(opts.ExcludeOlderThan, opts.ExcludeNewerThan, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+
+	if len(opts.ExcludeOwners) > 0 {
+		f, err := rejectByOwner(opts.ExcludeOwners)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+
+	if len(opts.ExcludeTypes) > 0 {
+		f, err := rejectByType(opts.ExcludeTypes)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+
+	if opts.ExcludeNoDump {
+		fs = append(fs, rejectNoDump())
+	}
+
 	return fs, nil
 }
 
@@ -404,7 +585,7 @@ func readExcludePatternsFromFiles(excludeFiles []string) ([]string, error) {
 
 // collectTargets returns a list of target files/dirs from several sources.
 func collectTargets(opts BackupOptions, args []string) (targets []string, err error) {
-	if opts.Stdin {
+	if opts.Stdin || opts.StdinCommand || opts.FromTar != "" {
 		return nil, nil
 	}
 
@@ -471,7 +652,7 @@ func collectTargets(opts BackupOptions, args []string) (targets []string, err er
 
 // parent returns the ID of the parent snapshot. If there is none, nil is
 // returned.
-func findParentSnapshot(ctx context.Context, repo restic.Repository, opts BackupOptions, targets []string) (parentID *restic.ID, err error) {
+func findParentSnapshot(ctx context.Context, repo restic.Repository, opts BackupOptions, targets []string, snapshots restic.Snapshots) (parentID *restic.ID, err error) {
 	// Force using a parent
 	if !opts.Force && opts.Parent != "" {
 		id, err := restic.FindSnapshot(ctx, repo, opts.Parent)
@@ -484,7 +665,7 @@ func findParentSnapshot(ctx context.Context, repo restic.Repository, opts Backup
 
 	// Find last snapshot to set it as parent, if not already set
 	if !opts.Force && parentID == nil {
-		id, err := restic.FindLatestSnapshot(ctx, repo, targets, []restic.TagList{}, []string{opts.Host})
+		id, err := restic.FindLatestSnapshotIn(snapshots, targets, []restic.TagList{}, []string{opts.Host})
 		if err == nil {
 			parentID = &id
 		} else if err != restic.ErrNoSnapshotFound {
@@ -495,8 +676,76 @@ func findParentSnapshot(ctx context.Context, repo restic.Repository, opts Backup
 	return parentID, nil
 }
 
-func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Terminal, args []string) error {
-	err := opts.Check(gopts, args)
+// resumableFlag returns the flag which causes the backup to save incomplete
+// snapshots, or an empty string if none has been specified.
+func resumableFlag(opts BackupOptions) string {
+	switch {
+	case opts.CheckpointInterval > 0:
+		return "--checkpoint-interval"
+	case opts.Deadline > 0:
+		return "--deadline"
+	case opts.StopAt != "":
+		return "--stop-at"
+	}
+	return ""
+}
+
+// backupDeadline returns the time after which no new files are started, or
+// the zero time if there is no deadline. For --stop-at, this is the next
+// time the given time of day is reached after now.
+func backupDeadline(opts BackupOptions, now time.Time) (time.Time, error) {
+	if opts.Deadline > 0 {
+		return now.Add(opts.Deadline), nil
+	}
+
+	if opts.StopAt == "" {
+		return time.Time{}, nil
+	}
+
+	var stopAt time.Time
+	var err error
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		stopAt, err = time.ParseInLocation(layout, opts.StopAt, now.Location())
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return time.Time{}, errors.Fatalf("invalid time %q for --stop-at, must be HH:MM or HH:MM:SS", opts.StopAt)
+	}
+
+	deadline := time.Date(now.Year(), now.Month(), now.Day(),
+		stopAt.Hour(), stopAt.Minute(), stopAt.Second(), 0, now.Location())
+	if !deadline.After(now) {
+		deadline = deadline.AddDate(0, 0, 1)
+	}
+	return deadline, nil
+}
+
+// findCheckpoints returns the IDs of the checkpoints in snapshots which have
+// been left behind by earlier, interrupted backups of the same host and paths
+// as sn.
+func findCheckpoints(snapshots restic.Snapshots, sn *restic.Snapshot) restic.IDSet {
+	paths := append([]string(nil), sn.Paths...)
+	sort.Strings(paths)
+
+	ids := restic.NewIDSet()
+	for _, old := range snapshots {
+		if !old.Incomplete || old.Hostname != sn.Hostname || old.Time.After(sn.Time) {
+			continue
+		}
+
+		oldPaths := append([]string(nil), old.Paths...)
+		sort.Strings(oldPaths)
+		if strings.Join(oldPaths, "\x00") == strings.Join(paths, "\x00") {
+			ids.Insert(*old.ID())
+		}
+	}
+	return ids
+}
+
+func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Terminal, args []string) (err error) {
+	err = opts.Check(gopts, args)
 	if err != nil {
 		return err
 	}
@@ -514,6 +763,16 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		}
 	}
 
+	deadline, err := backupDeadline(opts, time.Now())
+	if err != nil {
+		return err
+	}
+
+	labels, err := restic.ParseLabels(opts.Labels)
+	if err != nil {
+		return err
+	}
+
 	var t tomb.Tomb
 
 	if gopts.verbosity >= 2 && !gopts.JSON {
@@ -525,6 +784,29 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		return err
 	}
 
+	if err = repo.CheckCapabilities(repository.CapabilityAppend); err != nil {
+		return err
+	}
+
+	if flag := resumableFlag(opts); flag != "" {
+		// incomplete snapshots are removed once a backup is complete, and
+		// they can only be used as a parent if the snapshots can be read
+		if repo.WriteOnly() {
+			return errors.Fatalf("%v cannot be used with a write-only key", flag)
+		}
+		if err = repo.CheckCapabilities(repository.CapabilityForget); err != nil {
+			return errors.Fatalf("%v needs a key which can remove snapshots: %v", flag, err)
+		}
+	}
+
+	var mirrors []*backupMirror
+	if hasBackupMirror(opts) {
+		mirrors, err = openBackupMirrors(opts, gopts)
+		if err != nil {
+			return err
+		}
+	}
+
 	type ArchiveProgressReporter interface {
 		CompleteItem(item string, previous, current *restic.Node, s archiver.ItemStats, d time.Duration)
 		StartFile(filename string)
@@ -535,6 +817,11 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		Run(ctx context.Context) error
 		Error(item string, fi os.FileInfo, err error) error
 		Finish(snapshotID restic.ID)
+		SetDryRun()
+		SetSkipped()
+		SetPartial()
+		AddSecondarySnapshot(repository string, id restic.ID, err error)
+		HookOutput(hook, line string)
 
 		// ui.StdioWrapper
 		Stdout() io.WriteCloser
@@ -562,16 +849,30 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	gopts.stdout, gopts.stderr = p.Stdout(), p.Stderr()
 
 	p.SetMinUpdatePause(calculateProgressInterval(!gopts.Quiet))
+	if opts.DryRun {
+		p.SetDryRun()
+	}
 
 	t.Go(func() error { return p.Run(t.Context(gopts.ctx)) })
 
-	if !gopts.JSON {
-		p.V("lock repository")
-	}
-	lock, err := lockRepo(gopts.ctx, repo)
-	defer unlockRepo(lock)
-	if err != nil {
-		return err
+	var lock *restic.Lock
+	if !opts.DryRun {
+		if !gopts.JSON {
+			p.V("lock repository")
+		}
+		lock, err = lockRepo(gopts.ctx, repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+
+		for _, mirror := range mirrors {
+			mirror.lock, err = lockRepo(gopts.ctx, mirror.repo)
+			defer unlockRepo(mirror.lock)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	// rejectByNameFuncs collect functions that can reject items from the backup based on path only
@@ -594,13 +895,66 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		return err
 	}
 
-	parentSnapshotID, err := findParentSnapshot(gopts.ctx, repo, opts, targets)
-	if err != nil {
-		return err
+	var parentSnapshotID *restic.ID
+	var chain *restic.SnapshotChain
+	var snapshots restic.Snapshots
+	if repo.WriteOnly() {
+		// write-only keys cannot decrypt snapshots and trees, so all files
+		// are read again. Data already in the repository is not uploaded.
+		if opts.Parent != "" {
+			return errors.Fatal("--parent cannot be used with a write-only key")
+		}
+	} else {
+		// the snapshots are only listed once, as listing is not consistent
+		// for all backends
+		err = restic.ForAllSnapshots(gopts.ctx, repo, nil, func(id restic.ID, sn *restic.Snapshot, err error) error {
+			if err != nil {
+				return errors.Fatalf("unable to load snapshot %v: %v", id.Str(), err)
+			}
+			snapshots = append(snapshots, sn)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// the inodes of files read from a tar archive are synthetic, so
+		// the change detection cannot use a parent snapshot
+		if opts.FromTar == "" {
+			parentSnapshotID, err = findParentSnapshot(gopts.ctx, repo, opts, targets, snapshots)
+			if err != nil {
+				return err
+			}
+		}
+
+		chain, err = restic.BuildSnapshotChain(gopts.ctx, repo, snapshots)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, mirror := range mirrors {
+		if !gopts.JSON {
+			p.V("load index files of the secondary repository %v", mirror.name())
+		}
+		err = mirror.load(gopts.ctx, opts, targets)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the blobs for mirrors with the chunker parameters of the primary
+	// repository are saved while the files are read for the primary
+	// repository. The files are read again for each other set of parameters.
+	sameMirrors, otherMirrors := groupBackupMirrors(repo.Config().ChunkerPolynomial, mirrors)
+	if len(otherMirrors) > 0 && (opts.Stdin || opts.StdinCommand || opts.FromTar != "") {
+		return errors.Fatalf("the secondary repository %v uses different chunker parameters, the data cannot be read again from stdin, a command or a tar archive", otherMirrors[0][0].name())
 	}
 
 	if !gopts.JSON {
-		if parentSnapshotID != nil {
+		if repo.WriteOnly() {
+			p.P("write-only key cannot read the parent snapshot, will read all files\n")
+		} else if parentSnapshotID != nil {
 			p.P("using parent snapshot %v\n", parentSnapshotID.Str())
 		} else {
 			p.P("no parent snapshot found, will read all files\n")
@@ -625,6 +979,31 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		return true
 	}
 
+	// the post-hook runs once the pre-hook has been started, regardless of
+	// how the backup ends
+	hooks := &backupHooks{
+		opts:   opts,
+		gopts:  gopts,
+		output: p.HookOutput,
+		warn:   p.E,
+	}
+	defer func() {
+		// release the locks first, the post-hook may access the repository
+		unlockRepo(lock)
+		for _, mirror := range mirrors {
+			unlockRepo(mirror.lock)
+		}
+		hooks.post(err)
+	}()
+
+	if opts.PreHook != "" && !gopts.JSON {
+		p.V("run pre-hook %v\n", opts.PreHook)
+	}
+	err = hooks.pre(gopts.ctx)
+	if err != nil {
+		return err
+	}
+
 	var targetFS fs.FS = fs.Local{}
 	if runtime.GOOS == "windows" && opts.UseFsSnapshot {
 		if err = fs.HasSufficientPrivilegesForVSS(); err != nil {
@@ -645,8 +1024,25 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		defer localVss.DeleteSnapshots()
 		targetFS = localVss
 	}
-	if opts.Stdin {
-		if !gopts.JSON {
+	var command []string
+	if opts.Stdin || opts.StdinCommand {
+		var source io.ReadCloser = os.Stdin
+		if opts.StdinCommand {
+			if !gopts.JSON {
+				p.V("read data from command %v", strings.Join(args, " "))
+			}
+			command = args
+			cmdReader, err := fs.NewCommandReader(gopts.ctx, command, gopts.stderr)
+			if err != nil {
+				return err
+			}
+			// kill the command if the backup is aborted before its output
+			// has been read, the error of the command is returned by Read
+			defer func() {
+				_ = cmdReader.Close()
+			}()
+			source = cmdReader
+		} else if !gopts.JSON {
 			p.V("read data from stdin")
 		}
 		filename := path.Join("/", opts.StdinFilename)
@@ -654,11 +1050,38 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 			ModTime:    timeStamp,
 			Name:       filename,
 			Mode:       0644,
-			ReadCloser: os.Stdin,
+			ReadCloser: source,
 		}
 		targets = []string{filename}
 	}
 
+	if opts.FromTar != "" {
+		source := os.Stdin
+		if opts.FromTar != "-" {
+			source, err = os.Open(opts.FromTar)
+			if err != nil {
+				return errors.Fatalf("unable to open tar archive: %v", err)
+			}
+			defer source.Close()
+		}
+
+		if !gopts.JSON {
+			p.V("read tar archive %v", opts.FromTar)
+		}
+		tarFS, err := fs.NewTar(source)
+		if err != nil {
+			return errors.Fatalf("unable to read tar archive %v: %v", opts.FromTar, err)
+		}
+		defer tarFS.Close()
+
+		if opts.TimeStamp == "" && !tarFS.NewestModTime().IsZero() {
+			timeStamp = tarFS.NewestModTime()
+		}
+
+		targetFS = tarFS
+		targets = []string{"/"}
+	}
+
 	sc := archiver.NewScanner(targetFS)
 	sc.SelectByName = selectByNameFilter
 	sc.Select = selectFilter
@@ -670,28 +1093,41 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	}
 	t.Go(func() error { return sc.Scan(t.Context(gopts.ctx), targets) })
 
-	arch := archiver.New(repo, targetFS, archiver.Options{})
-	arch.SelectByName = selectByNameFilter
-	arch.Select = selectFilter
-	arch.WithAtime = opts.WithAtime
 	success := true
-	arch.Error = func(item string, fi os.FileInfo, err error) error {
-		success = false
-		return p.Error(item, fi, err)
+	newArchiver := func(repo restic.Repository) *archiver.Archiver {
+		arch := archiver.New(repo, targetFS, archiver.Options{})
+		arch.SelectByName = selectByNameFilter
+		arch.Select = selectFilter
+		arch.WithAtime = opts.WithAtime
+		arch.DryRun = opts.DryRun
+		arch.Error = func(item string, fi os.FileInfo, err error) error {
+			success = false
+			return p.Error(item, fi, err)
+		}
+
+		if opts.IgnoreInode {
+			// --ignore-inode implies --ignore-ctime: on FUSE, the ctime is not
+			// reliable either.
+			arch.ChangeIgnoreFlags |= archiver.ChangeIgnoreCtime | archiver.ChangeIgnoreInode
+		}
+		if opts.IgnoreCtime {
+			arch.ChangeIgnoreFlags |= archiver.ChangeIgnoreCtime
+		}
+		return arch
 	}
+
+	var archRepo restic.Repository = repo
+	var mirrorRepo *archiver.MirrorRepository
+	if len(sameMirrors) > 0 {
+		mirrorRepo = archiver.NewMirrorRepository(repo, mirrorRepos(sameMirrors)...)
+		archRepo = mirrorRepo
+	}
+
+	arch := newArchiver(archRepo)
 	arch.CompleteItem = p.CompleteItem
 	arch.StartFile = p.StartFile
 	arch.CompleteBlob = p.CompleteBlob
 
-	if opts.IgnoreInode {
-		// --ignore-inode implies --ignore-ctime: on FUSE, the ctime is not
-		// reliable either.
-		arch.ChangeIgnoreFlags |= archiver.ChangeIgnoreCtime | archiver.ChangeIgnoreInode
-	}
-	if opts.IgnoreCtime {
-		arch.ChangeIgnoreFlags |= archiver.ChangeIgnoreCtime
-	}
-
 	if parentSnapshotID == nil {
 		parentSnapshotID = &restic.ID{}
 	}
@@ -702,15 +1138,119 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		Time:           timeStamp,
 		Hostname:       opts.Host,
 		ParentSnapshot: *parentSnapshotID,
+		Description:    opts.Description,
+		Labels:         labels,
+		Command:        command,
+		Chain:          chain,
+
+		CheckpointInterval: opts.CheckpointInterval,
+		SkipIfUnchanged:    opts.SkipIfUnchanged,
+		Deadline:           deadline,
 	}
 
 	if !gopts.JSON {
 		p.V("start backup on %v", targets)
 	}
-	_, id, err := arch.Snapshot(gopts.ctx, targets, snapshotOpts)
+	sn, id, err := arch.Snapshot(gopts.ctx, targets, snapshotOpts)
+	if errors.IsFatal(errors.Cause(err)) {
+		return err
+	}
 	if err != nil {
 		return errors.Fatalf("unable to save snapshot: %v", err)
 	}
+	hooks.snapshot, hooks.id = sn, id
+
+	skipped := opts.SkipIfUnchanged && !opts.DryRun && id.IsNull()
+	if skipped {
+		p.SetSkipped()
+	}
+	if sn.Incomplete {
+		p.SetPartial()
+	}
+
+	// a failure in a secondary repository does not affect the snapshot in
+	// the primary repository, it is reported once the backup is complete
+	if !opts.DryRun {
+		for _, mirror := range sameMirrors {
+			mirror.finish(gopts.ctx, mirrorRepo, sn, opts.SkipIfUnchanged)
+		}
+	}
+
+	// checkpoints and partial snapshots of earlier backups are superseded by
+	// the new snapshot, even if this backup does not save any itself. Keys
+	// which cannot remove snapshots leave them to forget.
+	canRemove := repo.CheckCapabilities(repository.CapabilityForget) == nil
+	if !opts.DryRun && !id.IsNull() && canRemove {
+		for oldID := range findCheckpoints(snapshots, sn) {
+			p.V("removing checkpoint %v of an interrupted backup\n", oldID.Str())
+			h := restic.Handle{Type: restic.SnapshotFile, Name: oldID.String()}
+			err = repo.Backend().Remove(gopts.ctx, h)
+			if err != nil {
+				p.E("unable to remove checkpoint %v: %v\n", oldID.Str(), err)
+			}
+		}
+	}
+
+	// the files are read again for the secondary repositories with other
+	// chunker parameters, the first repository of each group is used by
+	// the archiver and the snapshot is saved to the others afterwards
+	for _, group := range otherMirrors {
+		if opts.DryRun {
+			break
+		}
+
+		// the deadline also applies to the secondary repositories, they are
+		// skipped if it has already been reached
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			for _, mirror := range group {
+				mirror.deadlineReached = true
+			}
+			continue
+		}
+
+		leader := group[0]
+		if !gopts.JSON {
+			p.V("start backup to the secondary repository %v with other chunker parameters", leader.name())
+		}
+
+		var groupRepo restic.Repository = leader.repo
+		var groupMirrorRepo *archiver.MirrorRepository
+		if len(group) > 1 {
+			groupMirrorRepo = archiver.NewMirrorRepository(leader.repo, mirrorRepos(group[1:])...)
+			groupRepo = groupMirrorRepo
+		}
+
+		groupOpts := snapshotOpts
+		groupOpts.ParentSnapshot = leader.parentID()
+		groupOpts.Chain = leader.chain
+		groupOpts.CheckpointInterval = 0
+
+		var groupSn *restic.Snapshot
+		groupSn, leader.id, leader.err = newArchiver(groupRepo).Snapshot(gopts.ctx, targets, groupOpts)
+		if gopts.ctx.Err() != nil {
+			return gopts.ctx.Err()
+		}
+		if errors.Cause(leader.err) == archiver.ErrDeadlineReached {
+			for _, mirror := range group {
+				mirror.deadlineReached, mirror.err = true, nil
+			}
+			continue
+		}
+
+		for _, mirror := range group[1:] {
+			if leader.err != nil {
+				mirror.err = leader.err
+				continue
+			}
+			mirror.finish(gopts.ctx, groupMirrorRepo, groupSn, opts.SkipIfUnchanged)
+		}
+	}
+
+	if !opts.DryRun {
+		for _, mirror := range mirrors {
+			p.AddSecondarySnapshot(mirror.name(), mirror.id, mirror.err)
+		}
+	}
 
 	// cleanly shutdown all running goroutines
 	t.Kill(nil)
@@ -720,9 +1260,35 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 
 	// Report finished execution
 	p.Finish(id)
-	if !gopts.JSON {
+	if !gopts.JSON && skipped {
+		p.P("nothing changed since snapshot %s, skipped saving a new snapshot\n", parentSnapshotID.Str())
+	} else if !gopts.JSON && !opts.DryRun && sn.Incomplete {
+		p.P("deadline reached, partial snapshot %s saved\n", id.Str())
+	} else if !gopts.JSON && !opts.DryRun {
 		p.P("snapshot %s saved\n", id.Str())
 	}
+	mirrorFailed := false
+	for _, mirror := range mirrors {
+		if opts.DryRun {
+			break
+		}
+
+		switch {
+		case mirror.err != nil:
+			p.E("unable to save the backup to the secondary repository %v: %v\n", mirror.name(), mirror.err)
+			mirrorFailed = true
+		case gopts.JSON:
+		case mirror.deadlineReached:
+			p.P("deadline reached, skipped saving a snapshot to the secondary repository %v\n", mirror.name())
+		case mirror.id.IsNull():
+			p.P("nothing changed since snapshot %s in the secondary repository %v, skipped saving a new snapshot\n", mirror.parent.ID().Str(), mirror.name())
+		default:
+			p.P("snapshot %s saved to the secondary repository %v\n", mirror.id.Str(), mirror.name())
+		}
+	}
+	if mirrorFailed {
+		return errors.Fatal("the backup could not be saved to all secondary repositories")
+	}
 	if !success {
 		return ErrInvalidSourceData
 	}
@@ -9,6 +9,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	rtest "github.com/restic/restic/internal/test"
 )
@@ -111,3 +112,29 @@ func TestReadFilenamesRaw(t *testing.T) {
 	rtest.Assert(t, strings.Contains(err.Error(), "zero byte"),
 		"wrong error message: %v", err.Error())
 }
+
+func TestBackupDeadline(t *testing.T) {
+	now := time.Date(2021, 3, 4, 5, 30, 0, 0, time.Local)
+
+	for _, test := range []struct {
+		opts     BackupOptions
+		deadline time.Time
+	}{
+		{BackupOptions{}, time.Time{}},
+		{BackupOptions{Deadline: 2 * time.Hour}, time.Date(2021, 3, 4, 7, 30, 0, 0, time.Local)},
+		{BackupOptions{StopAt: "06:00"}, time.Date(2021, 3, 4, 6, 0, 0, 0, time.Local)},
+		{BackupOptions{StopAt: "05:30:01"}, time.Date(2021, 3, 4, 5, 30, 1, 0, time.Local)},
+		// times which have already passed refer to the next day
+		{BackupOptions{StopAt: "05:30"}, time.Date(2021, 3, 5, 5, 30, 0, 0, time.Local)},
+		{BackupOptions{StopAt: "01:00"}, time.Date(2021, 3, 5, 1, 0, 0, 0, time.Local)},
+	} {
+		deadline, err := backupDeadline(test.opts, now)
+		rtest.OK(t, err)
+		rtest.Assert(t, deadline.Equal(test.deadline), "%+v: expected deadline %v, got %v", test.opts, test.deadline, deadline)
+	}
+
+	for _, stopAt := range []string{"6", "25:00", "06:00pm", "tomorrow"} {
+		_, err := backupDeadline(BackupOptions{StopAt: stopAt}, now)
+		rtest.Assert(t, err != nil, "expected error for --stop-at %q", stopAt)
+	}
+}
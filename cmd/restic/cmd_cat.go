@@ -42,6 +42,10 @@ func runCat(gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	if err = repo.CheckCapabilities(repository.CapabilityRead); err != nil {
+		return err
+	}
+
 	if !gopts.NoLock {
 		lock, err := lockRepo(gopts.ctx, repo)
 		if err != nil {
@@ -121,6 +125,10 @@ func runCat(gopts GlobalOptions, args []string) error {
 		Println(string(buf))
 		return nil
 	case "masterkey":
+		if repo.WriteOnly() {
+			return errors.Fatal("write-only keys do not contain the master key")
+		}
+
 		buf, err := json.MarshalIndent(repo.Key(), "", "  ")
 		if err != nil {
 			return err
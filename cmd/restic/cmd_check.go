@@ -12,6 +12,7 @@ import (
 	"github.com/restic/restic/internal/checker"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 )
 
@@ -25,6 +26,15 @@ finds. It can also be used to read all data and therefore simulate a restore.
 By default, the "check" command will always load all data directly from the
 repository and not use a local cache.
 
+With --chain, the hash chain of the snapshots is verified as well. Snapshots
+which are missing and not listed in a tombstone saved by "forget" (gaps),
+snapshots with several successors (forks) and snapshots which do not match
+the chain (rewritten) are reported as errors. Tombstones are not signed: they
+are encrypted with the master key like all other files, so every key which
+holds the master key, including keys restricted to appending data, can save
+one. The chain detects snapshots which were lost or modified without a key,
+it does not make the history tamper-proof against the users of the repository.
+
 EXIT STATUS
 ===========
 
@@ -45,6 +55,7 @@ type CheckOptions struct {
 	ReadDataSubset string
 	CheckUnused    bool
 	WithCache      bool
+	Chain          bool
 }
 
 var checkOptions CheckOptions
@@ -57,6 +68,7 @@ func init() {
 	f.StringVar(&checkOptions.ReadDataSubset, "read-data-subset", "", "read a `subset` of data packs, specified as 'n/t' for specific subset or either 'x%' or 'x.y%' for random subset")
 	f.BoolVar(&checkOptions.CheckUnused, "check-unused", false, "find unused blobs")
 	f.BoolVar(&checkOptions.WithCache, "with-cache", false, "use the cache")
+	f.BoolVar(&checkOptions.Chain, "chain", false, "verify the hash chain of the snapshots")
 }
 
 func checkFlags(opts CheckOptions) error {
@@ -185,9 +197,19 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	if err = repo.CheckCapabilities(repository.CapabilityRead); err != nil {
+		return err
+	}
+
+	if repo.WriteOnly() {
+		return errors.Fatal("check cannot be used with a write-only key")
+	}
+
 	if !gopts.NoLock {
 		Verbosef("create exclusive lock for repository\n")
-		lock, err := lockRepoExclusive(gopts.ctx, repo)
+		// check does not modify the repository, so it also works for
+		// repositories which use unknown features
+		lock, err := lockRepository(gopts.ctx, repo, true)
 		defer unlockRepo(lock)
 		if err != nil {
 			return err
@@ -220,6 +242,7 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 
 	errorsFound := false
 	orphanedPacks := 0
+	damagedPacks := restic.NewIDSet()
 	errChan := make(chan error)
 
 	Verbosef("check all packs\n")
@@ -231,6 +254,9 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 			Verbosef("%v\n", err)
 			continue
 		}
+		if e, ok := err.(checker.PackError); ok {
+			damagedPacks.Insert(e.ID)
+		}
 		errorsFound = true
 		Warnf("%v\n", err)
 	}
@@ -259,6 +285,18 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		}
 	}
 
+	if opts.Chain {
+		Verbosef("check snapshot chain\n")
+		chain, err := restic.LoadSnapshotChain(gopts.ctx, repo)
+		if err != nil {
+			return err
+		}
+		for _, problem := range chain.Verify() {
+			errorsFound = true
+			Warnf("%v\n", problem)
+		}
+	}
+
 	if opts.CheckUnused {
 		for _, id := range chkr.UnusedBlobs(gopts.ctx) {
 			Verbosef("unused blob %v\n", id)
@@ -275,6 +313,9 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		go chkr.ReadPacks(gopts.ctx, packs, p, errChan)
 
 		for err := range errChan {
+			if e, ok := err.(checker.PackError); ok {
+				damagedPacks.Insert(e.ID)
+			}
 			errorsFound = true
 			Warnf("%v\n", err)
 		}
@@ -305,6 +346,14 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		doReadData(packs)
 	}
 
+	if len(damagedPacks) > 0 && repo.Config().HasFeature(restic.FeatureParity) {
+		ids := make([]string, 0, len(damagedPacks))
+		for _, id := range damagedPacks.List() {
+			ids = append(ids, id.String())
+		}
+		Printf("\nThe damaged packs can be restored from the parity files by running\n  restic repair packs %v\n", strings.Join(ids, " "))
+	}
+
 	if errorsFound {
 		return errors.Fatal("repository contains errors")
 	}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"golang.org/x/sync/errgroup"
 
@@ -37,9 +38,10 @@ new destination repository using the "init" command.
 // CopyOptions bundles all options for the copy command.
 type CopyOptions struct {
 	secondaryRepoOptions
-	Hosts []string
-	Tags  restic.TagLists
-	Paths []string
+	Hosts  []string
+	Tags   restic.TagLists
+	Paths  []string
+	Labels []string
 }
 
 var copyOptions CopyOptions
@@ -52,6 +54,7 @@ func init() {
 	f.StringArrayVarP(&copyOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
 	f.Var(&copyOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot ID is given")
 	f.StringArrayVar(&copyOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot ID is given")
+	f.StringArrayVar(&copyOptions.Labels, "label", nil, "only consider snapshots which have this `label` in the format `key[=value]`, when no snapshot ID is given")
 }
 
 func runCopy(opts CopyOptions, gopts GlobalOptions, args []string) error {
@@ -73,6 +76,13 @@ func runCopy(opts CopyOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	if err = srcRepo.CheckCapabilities(repository.CapabilityRead); err != nil {
+		return err
+	}
+	if err = dstRepo.CheckCapabilities(repository.CapabilityAppend); err != nil {
+		return err
+	}
+
 	srcLock, err := lockRepo(ctx, srcRepo)
 	defer unlockRepo(srcLock)
 	if err != nil {
@@ -96,7 +106,7 @@ func runCopy(opts CopyOptions, gopts GlobalOptions, args []string) error {
 	}
 
 	dstSnapshotByOriginal := make(map[restic.ID][]*restic.Snapshot)
-	for sn := range FindFilteredSnapshots(ctx, dstRepo, opts.Hosts, opts.Tags, opts.Paths, nil) {
+	for sn := range FindFilteredSnapshots(ctx, dstRepo, opts.Hosts, opts.Tags, opts.Paths, opts.Labels, nil) {
 		if sn.Original != nil && !sn.Original.IsNull() {
 			dstSnapshotByOriginal[*sn.Original] = append(dstSnapshotByOriginal[*sn.Original], sn)
 		}
@@ -104,10 +114,19 @@ func runCopy(opts CopyOptions, gopts GlobalOptions, args []string) error {
 		dstSnapshotByOriginal[*sn.ID()] = append(dstSnapshotByOriginal[*sn.ID()], sn)
 	}
 
+	// the copies are linked into the snapshot chain of the destination
+	var dstChain *restic.SnapshotChain
+	if !dstRepo.WriteOnly() {
+		dstChain, err = restic.LoadSnapshotChain(ctx, dstRepo)
+		if err != nil {
+			return err
+		}
+	}
+
 	// remember already processed trees across all snapshots
 	visitedTrees := restic.NewIDSet()
 
-	for sn := range FindFilteredSnapshots(ctx, srcRepo, opts.Hosts, opts.Tags, opts.Paths, args) {
+	for sn := range FindFilteredSnapshots(ctx, srcRepo, opts.Hosts, opts.Tags, opts.Paths, opts.Labels, args) {
 		Verbosef("\nsnapshot %s of %v at %s)\n", sn.ID().Str(), sn.Paths, sn.Time)
 
 		// check whether the destination has a snapshot with the same persistent ID which has similar snapshot fields
@@ -146,10 +165,20 @@ func runCopy(opts CopyOptions, gopts GlobalOptions, args []string) error {
 		if sn.Original == nil {
 			sn.Original = sn.ID()
 		}
+		sn.ChainPrev, sn.ChainDigest = nil, nil
+		if dstChain != nil {
+			dstChain.Link(sn)
+		}
 		newID, err := dstRepo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
 		if err != nil {
 			return err
 		}
+		if dstChain != nil {
+			err = dstChain.SaveHead(ctx, dstRepo, newID, sn)
+			if err != nil {
+				return err
+			}
+		}
 		Verbosef("snapshot %s saved\n", newID.Str())
 	}
 	return nil
@@ -166,6 +195,14 @@ func similarSnapshots(sna *restic.Snapshot, snb *restic.Snapshot) bool {
 	if !sna.HasPaths(snb.Paths) || !sna.HasTags(snb.Tags) {
 		return false
 	}
+	if sna.Description != snb.Description || len(sna.Labels) != len(snb.Labels) {
+		return false
+	}
+	for key, value := range sna.Labels {
+		if v, ok := snb.Labels[key]; !ok || v != value {
+			return false
+		}
+	}
 	for i, a := range sna.Excludes {
 		if a != snb.Excludes[i] {
 			return false
@@ -14,6 +14,7 @@ import (
 	"sort"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/spf13/cobra"
 	"golang.org/x/sync/errgroup"
 
@@ -92,10 +93,11 @@ type Pack struct {
 
 // Blob is the struct used in printPacks.
 type Blob struct {
-	Type   restic.BlobType `json:"type"`
-	Length uint            `json:"length"`
-	ID     restic.ID       `json:"id"`
-	Offset uint            `json:"offset"`
+	Type               restic.BlobType `json:"type"`
+	Length             uint            `json:"length"`
+	UncompressedLength uint            `json:"uncompressed_length,omitempty"`
+	ID                 restic.ID       `json:"id"`
+	Offset             uint            `json:"offset"`
 }
 
 func printPacks(ctx context.Context, repo *repository.Repository, wr io.Writer) error {
@@ -115,10 +117,11 @@ func printPacks(ctx context.Context, repo *repository.Repository, wr io.Writer)
 		}
 		for i, blob := range blobs {
 			p.Blobs[i] = Blob{
-				Type:   blob.Type,
-				Length: blob.Length,
-				ID:     blob.ID,
-				Offset: blob.Offset,
+				Type:               blob.Type,
+				Length:             blob.Length,
+				UncompressedLength: blob.UncompressedLength,
+				ID:                 blob.ID,
+				Offset:             blob.Offset,
 			}
 		}
 
@@ -147,6 +150,10 @@ func runDebugDump(gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	if err = repo.CheckCapabilities(repository.CapabilityRead); err != nil {
+		return err
+	}
+
 	if !gopts.NoLock {
 		lock, err := lockRepo(gopts.ctx, repo)
 		defer unlockRepo(lock)
@@ -308,6 +315,12 @@ func decryptUnsigned(ctx context.Context, k *crypto.Key, buf []byte) []byte {
 }
 
 func loadBlobs(ctx context.Context, repo restic.Repository, pack restic.ID, list []restic.Blob) error {
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		panic(err)
+	}
+	defer dec.Close()
+
 	be := repo.Backend()
 	h := restic.Handle{
 		Name: pack.String(),
@@ -328,7 +341,7 @@ func loadBlobs(ctx context.Context, repo restic.Repository, pack restic.ID, list
 			continue
 		}
 
-		key := repo.Key()
+		key := repo.Key().WithSealedFallback()
 
 		nonce, plaintext := buf[:key.NonceSize()], buf[key.NonceSize():]
 		plaintext, err = key.Open(plaintext[:0], nonce, plaintext, nil)
@@ -359,6 +372,15 @@ func loadBlobs(ctx context.Context, repo restic.Repository, pack restic.ID, list
 			continue
 		}
 
+		if blob.IsCompressed() {
+			decompressed, err := dec.DecodeAll(plaintext, nil)
+			if err != nil {
+				Printf("         failed to decompress blob %v: %v\n", blob.ID, err)
+			} else {
+				plaintext = decompressed
+			}
+		}
+
 		id := restic.Hash(plaintext)
 		var prefix string
 		if !id.Equal(blob.ID) {
@@ -527,7 +549,7 @@ func checkPackSize(blobs []restic.Blob, fileSize int64) {
 	})
 
 	for _, pb := range blobs {
-		Printf("      %v blob %v, offset %-6d, raw length %-6d\n", pb.Type, pb.ID, pb.Offset, pb.Length)
+		Printf("      %v blob %v, offset %-6d, raw length %-6d, uncompressed length %-6d\n", pb.Type, pb.ID, pb.Offset, pb.Length, pb.UncompressedLength)
 		if offset != uint64(pb.Offset) {
 			Printf("      hole in file, want offset %v, got %v\n", offset, pb.Offset)
 		}
@@ -535,10 +557,8 @@ func checkPackSize(blobs []restic.Blob, fileSize int64) {
 		size += uint64(pb.Length)
 	}
 
-	// compute header size, per blob: 1 byte type, 4 byte length, 32 byte id
-	size += uint64(restic.CiphertextLength(len(blobs) * (1 + 4 + 32)))
-	// length in uint32 little endian
-	size += 4
+	// compute header size, including the header length field
+	size += uint64(pack.CalculateHeaderSize(blobs))
 
 	if uint64(fileSize) != size {
 		Printf("      file sizes do not match: computed %v from index, file size is %v\n", size, fileSize)
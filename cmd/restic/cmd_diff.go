@@ -5,6 +5,7 @@ import (
 	"path"
 	"reflect"
 	"sort"
+	"strings"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
@@ -198,6 +199,33 @@ func (c *Comparer) collectDir(ctx context.Context, blobs restic.BlobSet, id rest
 	return nil
 }
 
+// changedMetadata returns the names of the metadata fields which differ
+// between node1 and node2.
+func changedMetadata(node1, node2 *restic.Node) []string {
+	var changed []string
+	add := func(field string, differs bool) {
+		if differs {
+			changed = append(changed, field)
+		}
+	}
+
+	add("mode", node1.Mode != node2.Mode)
+	add("owner", node1.UID != node2.UID || node1.GID != node2.GID ||
+		node1.User != node2.User || node1.Group != node2.Group)
+	add("mtime", !node1.ModTime.Equal(node2.ModTime))
+	add("atime", !node1.AccessTime.Equal(node2.AccessTime))
+	add("ctime", !node1.ChangeTime.Equal(node2.ChangeTime))
+	add("btime", !node1.BirthTime.Equal(node2.BirthTime))
+	add("inode", node1.Inode != node2.Inode || node1.DeviceID != node2.DeviceID)
+	add("links", node1.Links != node2.Links)
+	add("target", node1.LinkTarget != node2.LinkTarget)
+	add("device", node1.Device != node2.Device)
+	add("flags", node1.InodeFlags != node2.InodeFlags)
+	add("xattrs", !reflect.DeepEqual(node1.ExtendedAttributes, node2.ExtendedAttributes))
+
+	return changed
+}
+
 func uniqueNodeNames(tree1, tree2 *restic.Tree) (tree1Nodes, tree2Nodes map[string]*restic.Node, uniqueNames []string) {
 	names := make(map[string]struct{})
 	tree1Nodes = make(map[string]*restic.Node)
@@ -265,7 +293,11 @@ func (c *Comparer) diffTree(ctx context.Context, stats *DiffStats, prefix string
 			}
 
 			if mod != "" {
-				Printf("%-5s%v\n", mod, name)
+				var fields string
+				if changed := changedMetadata(node1, node2); strings.HasSuffix(mod, "U") && len(changed) > 0 {
+					fields = " (" + strings.Join(changed, ", ") + ")"
+				}
+				Printf("%-5s%v%v\n", mod, name, fields)
 			}
 
 			if node1.Type == "dir" && node2.Type == "dir" {
@@ -326,6 +358,10 @@ func runDiff(opts DiffOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	if err = repo.CheckCapabilities(repository.CapabilityRead); err != nil {
+		return err
+	}
+
 	if err = repo.LoadIndex(ctx); err != nil {
 		return err
 	}
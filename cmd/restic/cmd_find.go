@@ -11,6 +11,7 @@ import (
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/walker"
 )
@@ -51,6 +52,7 @@ type FindOptions struct {
 	ListLong           bool
 	Hosts              []string
 	Paths              []string
+	Labels             []string
 	Tags               restic.TagLists
 }
 
@@ -73,6 +75,7 @@ func init() {
 	f.StringArrayVarP(&findOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
 	f.Var(&findOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot-ID is given")
 	f.StringArrayVar(&findOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot-ID is given")
+	f.StringArrayVar(&findOptions.Labels, "label", nil, "only consider snapshots which have this `label` in the format `key[=value]`, when no snapshot-ID is given")
 }
 
 type findPattern struct {
@@ -525,6 +528,10 @@ func runFind(opts FindOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	if err = repo.CheckCapabilities(repository.CapabilityRead); err != nil {
+		return err
+	}
+
 	if !gopts.NoLock {
 		lock, err := lockRepo(gopts.ctx, repo)
 		defer unlockRepo(lock)
@@ -567,7 +574,7 @@ func runFind(opts FindOptions, gopts GlobalOptions, args []string) error {
 		}
 	}
 
-	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, opts.Snapshots) {
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, opts.Labels, opts.Snapshots) {
 		if f.blobIDs != nil || f.treeIDs != nil {
 			if err = f.findIDs(ctx, sn); err != nil && err.Error() != "OK" {
 				return err
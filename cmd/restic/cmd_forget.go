@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"io"
 
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"github.com/spf13/cobra"
 )
@@ -43,6 +44,7 @@ type ForgetOptions struct {
 	Hosts   []string
 	Tags    restic.TagLists
 	Paths   []string
+	Labels  []string
 	Compact bool
 
 	// Grouping
@@ -77,9 +79,10 @@ func init() {
 	f.Var(&forgetOptions.Tags, "tag", "only consider snapshots which include this `taglist` in the format `tag[,tag,...]` (can be specified multiple times)")
 
 	f.StringArrayVar(&forgetOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` (can be specified multiple times)")
+	f.StringArrayVar(&forgetOptions.Labels, "label", nil, "only consider snapshots which have this `label` in the format `key[=value]` (can be specified multiple times)")
 	f.BoolVarP(&forgetOptions.Compact, "compact", "c", false, "use compact output format")
 
-	f.StringVarP(&forgetOptions.GroupBy, "group-by", "g", "host,paths", "string for grouping snapshots by host,paths,tags")
+	f.StringVarP(&forgetOptions.GroupBy, "group-by", "g", "host,paths", "string for grouping snapshots by host,paths,tags,label:key")
 	f.BoolVarP(&forgetOptions.DryRun, "dry-run", "n", false, "do not delete anything, just print what would be done")
 	f.BoolVar(&forgetOptions.Prune, "prune", false, "automatically run the 'prune' command if snapshots have been removed")
 
@@ -98,6 +101,14 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	caps := []string{repository.CapabilityForget}
+	if opts.Prune {
+		caps = append(caps, repository.CapabilityPrune)
+	}
+	if err = repo.CheckCapabilities(caps...); err != nil {
+		return err
+	}
+
 	lock, err := lockRepoExclusive(gopts.ctx, repo)
 	defer unlockRepo(lock)
 	if err != nil {
@@ -108,9 +119,10 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 	defer cancel()
 
 	var snapshots restic.Snapshots
+	var removeSnapshots restic.Snapshots
 	removeSnIDs := restic.NewIDSet()
 
-	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, opts.Labels, args) {
 		snapshots = append(snapshots, sn)
 	}
 
@@ -121,6 +133,7 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 		for _, sn := range snapshots {
 			removeSnIDs.Insert(*sn.ID())
 		}
+		removeSnapshots = snapshots
 	} else {
 		snapshotGroups, _, err := restic.GroupSnapshots(snapshots, opts.GroupBy)
 		if err != nil {
@@ -166,19 +179,20 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 				fg.Tags = key.Tags
 				fg.Host = key.Hostname
 				fg.Paths = key.Paths
+				fg.Labels = key.Labels
 
 				keep, remove, reasons := restic.ApplyPolicy(snapshotGroup, policy)
 
 				if len(keep) != 0 && !gopts.Quiet && !gopts.JSON {
 					Printf("keep %d snapshots:\n", len(keep))
-					PrintSnapshots(globalOptions.stdout, keep, reasons, opts.Compact)
+					PrintSnapshots(globalOptions.stdout, keep, reasons, opts.Compact, false, false)
 					Printf("\n")
 				}
 				addJSONSnapshots(&fg.Keep, keep)
 
 				if len(remove) != 0 && !gopts.Quiet && !gopts.JSON {
 					Printf("remove %d snapshots:\n", len(remove))
-					PrintSnapshots(globalOptions.stdout, remove, nil, opts.Compact)
+					PrintSnapshots(globalOptions.stdout, remove, nil, opts.Compact, false, false)
 					Printf("\n")
 				}
 				addJSONSnapshots(&fg.Remove, remove)
@@ -190,13 +204,21 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 				for _, sn := range remove {
 					removeSnIDs.Insert(*sn.ID())
 				}
+				removeSnapshots = append(removeSnapshots, remove...)
 			}
 		}
 	}
 
 	if len(removeSnIDs) > 0 {
 		if !opts.DryRun {
-			err := DeleteFilesChecked(gopts, repo, removeSnIDs, restic.SnapshotFile)
+			// record the removal before deleting the snapshots, so that
+			// the snapshot chain stays intact if forget is interrupted
+			_, err := restic.SaveTombstone(gopts.ctx, repo, restic.NewTombstone("forget", removeSnapshots))
+			if err != nil {
+				return err
+			}
+
+			err = DeleteFilesChecked(gopts, repo, removeSnIDs, restic.SnapshotFile)
 			if err != nil {
 				return err
 			}
@@ -230,6 +252,7 @@ type ForgetGroup struct {
 	Tags    []string            `json:"tags"`
 	Host    string              `json:"host"`
 	Paths   []string            `json:"paths"`
+	Labels  map[string]string   `json:"labels,omitempty"`
 	Keep    []Snapshot          `json:"keep"`
 	Remove  []Snapshot          `json:"remove"`
 	Reasons []restic.KeepReason `json:"reasons"`
@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
@@ -16,11 +17,35 @@ import (
 )
 
 var cmdKey = &cobra.Command{
-	Use:   "key [flags] [list|add|remove|passwd] [ID]",
+	Use:   "key [flags] [list|add|remove|passwd|rotate-master|escrow split|escrow recover] [ID|share files]",
 	Short: "Manage keys (passwords)",
 	Long: `
 The "key" command manages keys (passwords) for accessing the repository.
 
+With "--write-only", the "add" sub-command creates a key which can only be used
+to add new backups: data is sealed to a public key of the repository and
+cannot be read again with the new key. Commands such as "restore", "prune" or
+"check --read-data" require a regular key. The repository must be prepared for
+write-only keys with "restic migrate write_only_keys" first.
+
+With "--capabilities", the "add" sub-command creates a key which can only run
+some commands. The capabilities are "read" (e.g. "snapshots", "check", "stats"
+and "restore"), "append" ("backup"), "forget" ("forget" and "tag"), "prune"
+("prune", "rebuild-index", "migrate" and "repair") and "key-admin" (all "key"
+sub-commands except "list"). This restriction is enforced by restic itself and
+not by cryptography: anyone with the password of a key can access the master
+key and do everything with the repository.
+
+The "rotate-master" sub-command replaces the master key of the repository. All
+data is rewritten with a new master key, afterwards all keys are removed and
+replaced by a single key with a new password. If it is interrupted, run it
+again and enter the same new password to resume the rotation.
+
+The "escrow split" sub-command splits the master key into several shares, any
+"--threshold" of which can recover it. The shares are printed or written to
+"--output-dir". If all passwords are lost, "escrow recover" reads the shares
+from the given files or from stdin and adds a new key to the repository.
+
 EXIT STATUS
 ===========
 
@@ -36,6 +61,13 @@ var (
 	newPasswordFile string
 	keyUsername     string
 	keyHostname     string
+	keyKDF          string
+	keyWriteOnly    bool
+	keyCapabilities string
+
+	keyEscrowShares    int
+	keyEscrowThreshold int
+	keyEscrowOutputDir string
 )
 
 func init() {
@@ -45,6 +77,12 @@ func init() {
 	flags.StringVarP(&newPasswordFile, "new-password-file", "", "", "`file` from which to read the new password")
 	flags.StringVarP(&keyUsername, "user", "", "", "the username for new keys")
 	flags.StringVarP(&keyHostname, "host", "", "", "the hostname for new keys")
+	flags.StringVarP(&keyKDF, "kdf", "", crypto.DefaultKDF, "the key derivation `function` for new keys (scrypt or argon2id)")
+	flags.BoolVarP(&keyWriteOnly, "write-only", "", false, "create a key which can only add new data to the repository")
+	flags.StringVarP(&keyCapabilities, "capabilities", "", "all", "comma-separated `list` of capabilities for new keys (read, append, forget, prune, key-admin or all)")
+	flags.IntVarP(&keyEscrowShares, "shares", "", 5, "split the master key into `n` shares")
+	flags.IntVarP(&keyEscrowThreshold, "threshold", "", 3, "number of shares required to recover the master key")
+	flags.StringVarP(&keyEscrowOutputDir, "output-dir", "", "", "write the shares to files in `dir`")
 }
 
 func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions) error {
@@ -54,6 +92,10 @@ func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions
 		UserName string `json:"userName"`
 		HostName string `json:"hostName"`
 		Created  string `json:"created"`
+		KDF      string `json:"kdf"`
+		Type     string `json:"type,omitempty"`
+
+		Capabilities []string `json:"capabilities,omitempty"`
 	}
 
 	var keys []keyInfo
@@ -71,6 +113,10 @@ func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions
 			UserName: k.Username,
 			HostName: k.Hostname,
 			Created:  k.Created.Local().Format(TimeFormat),
+			KDF:      k.KDF,
+			Type:     k.Type,
+
+			Capabilities: k.Capabilities,
 		}
 
 		keys = append(keys, key)
@@ -90,6 +136,9 @@ func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions
 	tab.AddColumn("User", "{{ .UserName }}")
 	tab.AddColumn("Host", "{{ .HostName }}")
 	tab.AddColumn("Created", "{{ .Created }}")
+	tab.AddColumn("KDF", "{{ .KDF }}")
+	tab.AddColumn("Type", "{{ .Type }}")
+	tab.AddColumn("Capabilities", `{{ if .Capabilities }}{{ join .Capabilities "," }}{{ else }}all{{ end }}`)
 
 	for _, key := range keys {
 		tab.AddRow(key)
@@ -121,12 +170,22 @@ func getNewPassword(gopts GlobalOptions) (string, error) {
 }
 
 func addKey(gopts GlobalOptions, repo *repository.Repository) error {
+	capabilities, err := repository.ParseCapabilities(keyCapabilities)
+	if err != nil {
+		return err
+	}
+
 	pw, err := getNewPassword(gopts)
 	if err != nil {
 		return err
 	}
 
-	id, err := repository.AddKey(gopts.ctx, repo, pw, keyUsername, keyHostname, repo.Key())
+	var id *repository.Key
+	if keyWriteOnly {
+		id, err = repository.AddWriteOnlyKey(gopts.ctx, repo, pw, keyUsername, keyHostname, keyKDF, capabilities)
+	} else {
+		id, err = repository.AddKey(gopts.ctx, repo, pw, keyUsername, keyHostname, keyKDF, repo.Key(), capabilities)
+	}
 	if err != nil {
 		return errors.Fatalf("creating new key failed: %v\n", err)
 	}
@@ -157,7 +216,8 @@ func changePassword(gopts GlobalOptions, repo *repository.Repository) error {
 		return err
 	}
 
-	id, err := repository.AddKey(gopts.ctx, repo, pw, "", "", repo.Key())
+	// the new key keeps the capabilities of the current one
+	id, err := repository.AddKey(gopts.ctx, repo, pw, "", "", keyKDF, repo.Key(), repo.Capabilities())
 	if err != nil {
 		return errors.Fatalf("creating new key failed: %v\n", err)
 	}
@@ -173,19 +233,60 @@ func changePassword(gopts GlobalOptions, repo *repository.Repository) error {
 	return nil
 }
 
-func runKey(gopts GlobalOptions, args []string) error {
-	if len(args) < 1 || (args[0] == "remove" && len(args) != 2) || (args[0] != "remove" && len(args) != 1) {
+// checkKeyArgs tests whether the number of arguments matches the sub-command.
+func checkKeyArgs(args []string) error {
+	if len(args) < 1 {
+		return errors.Fatal("wrong number of arguments")
+	}
+
+	var ok bool
+	switch args[0] {
+	case "remove":
+		ok = len(args) == 2
+	case "escrow":
+		ok = len(args) >= 2 && (args[1] == "recover" || (args[1] == "split" && len(args) == 2))
+	default:
+		ok = len(args) == 1
+	}
+
+	if !ok {
 		return errors.Fatal("wrong number of arguments")
 	}
+	return nil
+}
+
+func runKey(gopts GlobalOptions, args []string) error {
+	if err := checkKeyArgs(args); err != nil {
+		return err
+	}
+
+	if err := crypto.ValidKDF(keyKDF); err != nil {
+		return errors.Fatalf("invalid value for --kdf: %v", err)
+	}
 
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
 
+	// recovering the master key does not require a password
+	if args[0] == "escrow" && args[1] == "recover" {
+		return recoverMasterKey(ctx, gopts, args[2:])
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
 	}
 
+	if repo.WriteOnly() && args[0] != "list" {
+		return errors.Fatal("write-only keys can only list keys")
+	}
+	// changing the password of the current key grants no new capabilities
+	if args[0] != "list" && args[0] != "passwd" {
+		if err = repo.CheckCapabilities(repository.CapabilityKeyAdmin); err != nil {
+			return err
+		}
+	}
+
 	switch args[0] {
 	case "list":
 		lock, err := lockRepo(ctx, repo)
@@ -224,6 +325,22 @@ func runKey(gopts GlobalOptions, args []string) error {
 		}
 
 		return changePassword(gopts, repo)
+	case "rotate-master":
+		lock, err := lockRepoExclusive(ctx, repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+
+		return rotateMasterKey(gopts, repo)
+	case "escrow":
+		lock, err := lockRepo(ctx, repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+
+		return splitMasterKey(gopts, repo)
 	}
 
 	return nil
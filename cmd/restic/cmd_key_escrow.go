@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/shamir"
+)
+
+// A share is encoded as version, threshold, x, the first bytes of the
+// repository ID and the share of the master key, followed by a checksum. It is
+// printed in base32 in blocks of escrowBlockSize characters.
+const (
+	escrowShareVersion = 1
+	escrowRepoIDSize   = 8
+	escrowChecksumSize = 4
+	escrowBlockSize    = 8
+	escrowLineBlocks   = 4
+)
+
+var escrowEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// escrowShare is a share of the master key of a repository.
+type escrowShare struct {
+	Threshold int
+	RepoID    []byte
+	shamir.Share
+}
+
+// masterKeyBytes returns the raw bytes of the master key k.
+func masterKeyBytes(k *crypto.Key) []byte {
+	buf := make([]byte, 0, len(k.EncryptionKey)+len(k.MACKey.K)+len(k.MACKey.R))
+	buf = append(buf, k.EncryptionKey[:]...)
+	buf = append(buf, k.MACKey.K[:]...)
+	buf = append(buf, k.MACKey.R[:]...)
+	return buf
+}
+
+// masterKeyFromBytes is the inverse of masterKeyBytes.
+func masterKeyFromBytes(buf []byte) (*crypto.Key, error) {
+	k := &crypto.Key{}
+	if len(buf) != len(k.EncryptionKey)+len(k.MACKey.K)+len(k.MACKey.R) {
+		return nil, errors.Errorf("invalid master key length %d", len(buf))
+	}
+
+	buf = buf[copy(k.EncryptionKey[:], buf):]
+	buf = buf[copy(k.MACKey.K[:], buf):]
+	copy(k.MACKey.R[:], buf)
+	return k, nil
+}
+
+func escrowChecksum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:escrowChecksumSize]
+}
+
+// String encodes the share as text.
+func (s escrowShare) String() string {
+	buf := []byte{escrowShareVersion, byte(s.Threshold), s.X}
+	buf = append(buf, s.RepoID...)
+	buf = append(buf, s.Y...)
+	buf = append(buf, escrowChecksum(buf)...)
+
+	enc := escrowEncoding.EncodeToString(buf)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "# restic master key share %d, %d shares are required\n", s.X, s.Threshold)
+	fmt.Fprintf(&out, "# repository %x\n", s.RepoID[:4])
+	for i := 0; i < len(enc); i += escrowBlockSize {
+		end := i + escrowBlockSize
+		if end > len(enc) {
+			end = len(enc)
+		}
+		out.WriteString(enc[i:end])
+
+		if (i/escrowBlockSize+1)%escrowLineBlocks == 0 || end == len(enc) {
+			out.WriteString("\n")
+		} else {
+			out.WriteString(" ")
+		}
+	}
+	return out.String()
+}
+
+// parseEscrowShare decodes a share from its base32 representation.
+func parseEscrowShare(text string) (escrowShare, error) {
+	buf, err := escrowEncoding.DecodeString(text)
+	if err != nil {
+		return escrowShare{}, errors.Fatalf("invalid share: %v", err)
+	}
+
+	if len(buf) < 3+escrowRepoIDSize+escrowChecksumSize+1 {
+		return escrowShare{}, errors.Fatal("invalid share: too short")
+	}
+
+	data, sum := buf[:len(buf)-escrowChecksumSize], buf[len(buf)-escrowChecksumSize:]
+	if !bytes.Equal(sum, escrowChecksum(data)) {
+		return escrowShare{}, errors.Fatal("invalid share: checksum mismatch, check for typos")
+	}
+
+	if data[0] != escrowShareVersion {
+		return escrowShare{}, errors.Fatalf("invalid share: unsupported version %d", data[0])
+	}
+
+	return escrowShare{
+		Threshold: int(data[1]),
+		RepoID:    data[3 : 3+escrowRepoIDSize],
+		Share: shamir.Share{
+			X: data[2],
+			Y: data[3+escrowRepoIDSize:],
+		},
+	}, nil
+}
+
+// readEscrowShares reads all shares from rd. Lines starting with '#' are
+// ignored, shares are separated by empty lines or comments.
+func readEscrowShares(rd io.Reader) ([]escrowShare, error) {
+	var shares []escrowShare
+	var current strings.Builder
+
+	finish := func() error {
+		if current.Len() == 0 {
+			return nil
+		}
+		s, err := parseEscrowShare(current.String())
+		if err != nil {
+			return err
+		}
+		shares = append(shares, s)
+		current.Reset()
+		return nil
+	}
+
+	sc := bufio.NewScanner(rd)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		current.WriteString(strings.ToUpper(strings.Join(strings.Fields(line), "")))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrap(err, "read shares")
+	}
+
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// splitMasterKey splits the master key of repo into shares and prints them or
+// writes them to files in keyEscrowOutputDir.
+func splitMasterKey(gopts GlobalOptions, repo *repository.Repository) error {
+	if repo.WriteOnly() {
+		return errors.Fatal("write-only keys do not contain the master key")
+	}
+
+	repoID, err := hex.DecodeString(repo.Config().ID)
+	if err != nil || len(repoID) < escrowRepoIDSize {
+		return errors.Fatalf("invalid repository ID %q", repo.Config().ID)
+	}
+
+	parts, err := shamir.Split(masterKeyBytes(repo.Key()), keyEscrowShares, keyEscrowThreshold)
+	if err != nil {
+		return errors.Fatalf("unable to split master key: %v", err)
+	}
+
+	for _, p := range parts {
+		share := escrowShare{
+			Threshold: keyEscrowThreshold,
+			RepoID:    repoID[:escrowRepoIDSize],
+			Share:     p,
+		}
+
+		if keyEscrowOutputDir == "" {
+			Printf("%v\n", share)
+			continue
+		}
+
+		filename := filepath.Join(keyEscrowOutputDir, fmt.Sprintf("share-%d.txt", p.X))
+		err := ioutil.WriteFile(filename, []byte(share.String()), 0600)
+		if err != nil {
+			return errors.Wrap(err, "WriteFile")
+		}
+		Verbosef("wrote share %d to %v\n", p.X, filename)
+	}
+
+	Verbosef("any %d of the %d shares can recover the master key, store them separately\n",
+		keyEscrowThreshold, keyEscrowShares)
+	return nil
+}
+
+// recoverMasterKey reads shares from the files or from stdin and recovers the
+// master key. Afterwards, a new key is added to the repository.
+func recoverMasterKey(ctx context.Context, gopts GlobalOptions, files []string) error {
+	var shares []escrowShare
+	if len(files) == 0 {
+		Verbosef("reading shares from stdin\n")
+		s, err := readEscrowShares(os.Stdin)
+		if err != nil {
+			return err
+		}
+		shares = s
+	}
+
+	for _, filename := range files {
+		f, err := os.Open(filename)
+		if err != nil {
+			return errors.Fatalf("unable to open share: %v", err)
+		}
+		s, err := readEscrowShares(f)
+		_ = f.Close()
+		if err != nil {
+			return errors.Fatalf("%v: %v", filename, err)
+		}
+		shares = append(shares, s...)
+	}
+
+	if len(shares) == 0 {
+		return errors.Fatal("no shares found")
+	}
+
+	parts := make([]shamir.Share, 0, len(shares))
+	for _, s := range shares {
+		if !bytes.Equal(s.RepoID, shares[0].RepoID) {
+			return errors.Fatal("shares belong to different repositories")
+		}
+		if s.Threshold != shares[0].Threshold {
+			return errors.Fatal("shares have different thresholds")
+		}
+		parts = append(parts, s.Share)
+	}
+
+	if len(shares) < shares[0].Threshold {
+		return errors.Fatalf("%d shares are required, but only %d were given", shares[0].Threshold, len(shares))
+	}
+
+	buf, err := shamir.Combine(parts)
+	if err != nil {
+		return errors.Fatalf("unable to recover master key: %v", err)
+	}
+
+	master, err := masterKeyFromBytes(buf)
+	if err != nil {
+		return err
+	}
+
+	repo, err := newRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	err = repo.UseMasterKey(ctx, master)
+	if errors.Cause(err) == crypto.ErrUnauthenticated {
+		return errors.Fatal("the shares do not contain the master key of this repository")
+	}
+	if err != nil {
+		return errors.Fatalf("config cannot be loaded: %v", err)
+	}
+	Verbosef("recovered master key for repository %v\n", repo.Config().ID[:8])
+
+	lock, err := lockRepo(ctx, repo)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	return addKey(gopts, repo)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/shamir"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestEscrowShareEncoding(t *testing.T) {
+	master := crypto.NewRandomKey()
+	parts, err := shamir.Split(masterKeyBytes(master), 3, 2)
+	rtest.OK(t, err)
+
+	var text strings.Builder
+	for _, p := range parts {
+		s := escrowShare{Threshold: 2, RepoID: []byte("12345678"), Share: p}
+		text.WriteString(s.String())
+	}
+
+	shares, err := readEscrowShares(strings.NewReader(strings.ToLower(text.String())))
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, len(shares))
+
+	for i, s := range shares {
+		rtest.Equals(t, 2, s.Threshold)
+		rtest.Equals(t, []byte("12345678"), s.RepoID)
+		rtest.Equals(t, parts[i], s.Share)
+	}
+
+	buf, err := shamir.Combine([]shamir.Share{shares[2].Share, shares[0].Share})
+	rtest.OK(t, err)
+	k, err := masterKeyFromBytes(buf)
+	rtest.OK(t, err)
+	rtest.Equals(t, master.EncryptionKey, k.EncryptionKey)
+	rtest.Equals(t, master.MACKey.K, k.MACKey.K)
+	rtest.Equals(t, master.MACKey.R, k.MACKey.R)
+}
+
+func TestEscrowShareTypo(t *testing.T) {
+	parts, err := shamir.Split([]byte("secret"), 2, 2)
+	rtest.OK(t, err)
+
+	s := escrowShare{Threshold: 2, RepoID: []byte("12345678"), Share: parts[0]}
+	text := []byte(s.String())
+
+	// replace a character in the last line of the encoded share
+	pos := strings.LastIndex(string(text), "\n") - 3
+	if text[pos] == 'A' {
+		text[pos] = 'B'
+	} else {
+		text[pos] = 'A'
+	}
+
+	_, err = readEscrowShares(strings.NewReader(string(text)))
+	rtest.Assert(t, err != nil, "share with typo was accepted")
+}
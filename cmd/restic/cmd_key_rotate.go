@@ -0,0 +1,624 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/migrations"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// rotateMasterKeyName identifies the checkpoint of a master key rotation.
+const rotateMasterKeyName = "rotate_master_key"
+
+// rotateBatchSize is the number of packs which are rewritten between two
+// checkpoints.
+const rotateBatchSize = 100
+
+// rotateState is stored in the checkpoint of a master key rotation. The
+// checkpoint is encrypted with the new master key, which is never stored
+// anywhere the old master key can decrypt.
+type rotateState struct {
+	// NewKey is the key for the new master key. It is created before any
+	// data is rewritten and contains a copy of the config until
+	// ConfigReplaced is set, then it is replaced by a regular key.
+	NewKey         string `json:"new_key"`
+	ConfigReplaced bool   `json:"config_replaced,omitempty"`
+
+	// packs which have been rewritten, and the packs created for them
+	DonePacks restic.IDs `json:"done_packs,omitempty"`
+	NewPacks  restic.IDs `json:"new_packs,omitempty"`
+
+	NewIndexes restic.IDs `json:"new_indexes,omitempty"`
+
+	// maps old to new snapshot IDs
+	Snapshots map[string]restic.ID `json:"snapshots,omitempty"`
+}
+
+// sameKey returns true if a and b contain the same key material.
+func sameKey(a, b *crypto.Key) bool {
+	return a.EncryptionKey == b.EncryptionKey &&
+		a.MACKey.K == b.MACKey.K && a.MACKey.R == b.MACKey.R
+}
+
+// rotateMasterKey replaces the master key of the repository. All files are
+// rewritten with a new master key, afterwards the old files and all keys are
+// removed and a single new key is kept. Progress is stored in a checkpoint,
+// so that an interrupted rotation can be resumed.
+//
+// The password for the new master key is asked for first, and the key for it
+// is saved before any data is rewritten. The checkpoint is encrypted with the
+// new master key, so an interrupted rotation is resumed by entering the same
+// new password again. Once the config has been encrypted with the new master
+// key, the repository can only be opened with the new password.
+func rotateMasterKey(gopts GlobalOptions, repo *repository.Repository) error {
+	ctx := gopts.ctx
+
+	pending, err := migrations.Pending(ctx, repo)
+	if err != nil {
+		return err
+	}
+	for _, name := range pending {
+		if name != rotateMasterKeyName {
+			return errors.Fatalf("migration %v has been interrupted, please complete it first", name)
+		}
+	}
+
+	// the checkpoint can only be read if the repository has been opened with
+	// the new password, after the config has been replaced
+	cp, err := migrations.LoadCheckpoint(ctx, repo, rotateMasterKeyName, false)
+	if err != nil {
+		return err
+	}
+
+	dst := repo
+	password := gopts.password
+	newKeyName := repo.KeyName()
+	if !cp.Resumed() {
+		password, err = getNewPassword(gopts)
+		if err != nil {
+			return err
+		}
+
+		var newKey *repository.Key
+		newKey, err = openRotationKey(ctx, repo, password)
+		if err != nil {
+			return err
+		}
+
+		newKeyName = newKey.Name()
+		dst = repo.WithKey(newKey.Master())
+		cp, err = migrations.LoadCheckpoint(ctx, dst, rotateMasterKeyName, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	state := rotateState{Snapshots: make(map[string]restic.ID)}
+	if err = cp.LoadState(&state); err != nil {
+		return err
+	}
+
+	if cp.Resumed() {
+		Verbosef("resuming interrupted master key rotation after %d completed steps\n", len(cp.Done))
+	} else {
+		state.NewKey = newKeyName
+		if err = saveRotateState(ctx, cp, &state); err != nil {
+			return err
+		}
+	}
+
+	dst.DisableAutoIndexUpdate()
+
+	if !cp.IsDone("packs") {
+		if err = rotatePacks(ctx, gopts, repo, dst, cp, &state); err != nil {
+			return err
+		}
+	}
+
+	if !cp.IsDone("index") {
+		if err = rotateIndex(ctx, repo, dst, cp, &state); err != nil {
+			return err
+		}
+	}
+
+	if !cp.IsDone("snapshots") {
+		if err = rotateSnapshots(ctx, repo, dst, cp, &state); err != nil {
+			return err
+		}
+	}
+
+	if !cp.IsDone("cleanup") {
+		if err = rotateCleanup(gopts, repo, dst, cp, &state); err != nil {
+			return err
+		}
+	}
+
+	return rotateKeys(ctx, repo, dst, cp, &state, password)
+}
+
+// openRotationKey returns the key for the new master key which can be opened
+// with password. If an interrupted rotation is found, its key is returned,
+// otherwise a new master key is created and saved in a new key.
+func openRotationKey(ctx context.Context, repo *repository.Repository, password string) (*repository.Key, error) {
+	var found *repository.Key
+	err := repo.List(ctx, restic.KeyFile, func(id restic.ID, size int64) error {
+		if found != nil {
+			return nil
+		}
+
+		key, err := repository.OpenKey(ctx, repo, id.String(), password)
+		if errors.Cause(err) == crypto.ErrUnauthenticated {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// all keys protect the same master key, except for the one of an
+		// interrupted rotation
+		if !key.WriteOnly() && !sameKey(key.Master(), repo.Key()) {
+			found = key
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if found != nil {
+		debug.Log("found key %v of an interrupted rotation", found.Name())
+		return found, nil
+	}
+
+	// the checkpoint of an interrupted rotation cannot be decrypted with the
+	// old master key, the new password must be entered to resume it
+	interrupted := false
+	err = repo.List(ctx, restic.MigrationFile, func(id restic.ID, size int64) error {
+		_, err := repo.LoadAndDecrypt(ctx, nil, restic.MigrationFile, id)
+		if errors.Cause(err) == crypto.ErrUnauthenticated {
+			interrupted = true
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if interrupted {
+		return nil, errors.Fatal("a master key rotation has been interrupted, enter the new password used for it to resume")
+	}
+
+	cur, err := repository.LoadKey(ctx, repo, repo.KeyName())
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := repository.AddRotationKey(ctx, repo, password, cur.Username, cur.Hostname, cur.KDF, crypto.NewRandomKey(), cur.Capabilities, repo.Config())
+	if err != nil {
+		return nil, errors.Fatalf("creating new key failed: %v\n", err)
+	}
+	Verbosef("created new master key, saved new key as %s\n", key)
+	return key, nil
+}
+
+// saveRotateState stores state in the checkpoint.
+func saveRotateState(ctx context.Context, cp *migrations.Checkpoint, state *rotateState) error {
+	if err := cp.SetState(state); err != nil {
+		return err
+	}
+	return cp.Save(ctx)
+}
+
+// loadNewPacks adds the packs created by an interrupted run to the index of
+// dst.
+func loadNewPacks(ctx context.Context, repo restic.Repository, dst *repository.Repository, state *rotateState) error {
+	if len(state.NewPacks) == 0 || len(dst.Index().(*repository.MasterIndex).Packs(nil)) > 0 {
+		return nil
+	}
+
+	newPacks := restic.NewIDSet(state.NewPacks...)
+	packSizes := make(map[restic.ID]int64)
+	err := repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		if newPacks.Has(id) {
+			packSizes[id] = size
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(packSizes) != len(newPacks) {
+		return errors.Fatalf("%d rewritten packs are missing", len(newPacks)-len(packSizes))
+	}
+
+	invalid, err := dst.CreateIndexFromPacks(ctx, packSizes, nil)
+	if err != nil {
+		return err
+	}
+	if len(invalid) > 0 {
+		return errors.Fatalf("unable to read rewritten packs %v", invalid)
+	}
+	return nil
+}
+
+// rotatePacks rewrites all packs with the new master key.
+func rotatePacks(ctx context.Context, gopts GlobalOptions, repo *repository.Repository, dst *repository.Repository, cp *migrations.Checkpoint, state *rotateState) error {
+	Verbosef("load index\n")
+	if err := repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	if err := loadNewPacks(ctx, repo, dst, state); err != nil {
+		return err
+	}
+
+	done := restic.NewIDSet(state.DonePacks...)
+	packBlobs := make(map[restic.ID][]restic.BlobHandle)
+	for pb := range repo.Index().Each(ctx) {
+		if !done.Has(pb.PackID) {
+			packBlobs[pb.PackID] = append(packBlobs[pb.PackID], pb.BlobHandle)
+		}
+	}
+
+	packs := make(restic.IDs, 0, len(packBlobs))
+	for id := range packBlobs {
+		packs = append(packs, id)
+	}
+	sort.Sort(packs)
+
+	Verbosef("rewrite %d packs\n", len(packs))
+	bar := newProgressMax(!gopts.Quiet, uint64(len(packs)), "packs rewritten")
+	defer bar.Done()
+
+	for len(packs) > 0 {
+		batch := packs
+		if len(batch) > rotateBatchSize {
+			batch = batch[:rotateBatchSize]
+		}
+		packs = packs[len(batch):]
+
+		keepBlobs := restic.NewBlobSet()
+		for _, id := range batch {
+			for _, h := range packBlobs[id] {
+				if !dst.Index().Has(h) {
+					keepBlobs.Insert(h)
+				}
+			}
+		}
+
+		_, err := repository.Repack(ctx, repo, dst, restic.NewIDSet(batch...), keepBlobs, bar)
+		if err != nil {
+			return err
+		}
+
+		if len(keepBlobs) != 0 {
+			return errors.Fatalf("%d blobs could not be rewritten", len(keepBlobs))
+		}
+
+		state.DonePacks = append(state.DonePacks, batch...)
+		state.NewPacks = dst.Index().(*repository.MasterIndex).Packs(nil).List()
+		if err = saveRotateState(ctx, cp, state); err != nil {
+			return err
+		}
+	}
+
+	return cp.Complete(ctx, "packs")
+}
+
+// rotateIndex saves the index for the rewritten packs.
+func rotateIndex(ctx context.Context, repo *repository.Repository, dst *repository.Repository, cp *migrations.Checkpoint, state *rotateState) error {
+	if err := loadNewPacks(ctx, repo, dst, state); err != nil {
+		return err
+	}
+
+	// index files left over by an interrupted run are removed with the
+	// old index files later
+	before := restic.NewIDSet()
+	err := repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+		before.Insert(id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	Verbosef("save new index\n")
+	_, err = dst.Index().(*repository.MasterIndex).Save(ctx, dst, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	state.NewIndexes = nil
+	err = repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+		if !before.Has(id) {
+			state.NewIndexes = append(state.NewIndexes, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = cp.SetState(state); err != nil {
+		return err
+	}
+	return cp.Complete(ctx, "index")
+}
+
+// rotateSnapshots rewrites all snapshots with the new master key. As the IDs
+// of the snapshots change, the parent and original snapshots are updated.
+func rotateSnapshots(ctx context.Context, repo *repository.Repository, dst *repository.Repository, cp *migrations.Checkpoint, state *rotateState) error {
+	rewritten := restic.NewIDSet()
+	for _, id := range state.Snapshots {
+		rewritten.Insert(id)
+	}
+
+	var snapshots []*restic.Snapshot
+	err := repo.List(ctx, restic.SnapshotFile, func(id restic.ID, size int64) error {
+		if _, ok := state.Snapshots[id.String()]; ok || rewritten.Has(id) {
+			return nil
+		}
+
+		sn, err := restic.LoadSnapshot(ctx, repo, id)
+		if errors.Cause(err) == crypto.ErrUnauthenticated {
+			// a snapshot which was saved by an interrupted run, but not
+			// recorded in the checkpoint
+			if _, serr := restic.LoadSnapshot(ctx, dst, id); serr == nil {
+				debug.Log("removing unrecorded new snapshot %v", id)
+				return repo.Backend().Remove(ctx, restic.Handle{Type: restic.SnapshotFile, Name: id.String()})
+			}
+		}
+		if err != nil {
+			return errors.Wrapf(err, "load snapshot %v", id.Str())
+		}
+
+		snapshots = append(snapshots, sn)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// parents are usually older, rewrite them first
+	sort.Sort(sort.Reverse(restic.Snapshots(snapshots)))
+
+	mapID := func(id *restic.ID) *restic.ID {
+		if id == nil {
+			return nil
+		}
+		if newID, ok := state.Snapshots[id.String()]; ok {
+			return &newID
+		}
+		return id
+	}
+
+	// the new IDs of the parent and original snapshots change the content
+	// hashes of chained snapshots, the rewrites are recorded before the
+	// snapshots are marked as done in the checkpoint
+	var rewrites []restic.ChainRewrite
+	saveRewrites := func() error {
+		err := restic.SaveChainRewrites(ctx, dst, "key rotate", rewrites)
+		rewrites = rewrites[:0]
+		return err
+	}
+
+	Verbosef("rewrite %d snapshots\n", len(snapshots))
+	for i, sn := range snapshots {
+		hash := sn.ContentHash()
+		sn.Parent = mapID(sn.Parent)
+		sn.Original = mapID(sn.Original)
+		if newHash := sn.ContentHash(); sn.ChainDigest != nil && newHash != hash {
+			rewrites = append(rewrites, restic.ChainRewrite{Hash: hash, NewHash: newHash})
+		}
+
+		id, err := dst.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+		if err != nil {
+			return err
+		}
+		state.Snapshots[sn.ID().String()] = id
+
+		if (i+1)%rotateBatchSize == 0 {
+			if err = saveRewrites(); err != nil {
+				return err
+			}
+			if err = saveRotateState(ctx, cp, state); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = saveRewrites(); err != nil {
+		return err
+	}
+
+	if err = cp.SetState(state); err != nil {
+		return err
+	}
+
+	if err = rotateTombstones(ctx, repo, dst); err != nil {
+		return err
+	}
+	return cp.Complete(ctx, "snapshots")
+}
+
+// rotateTombstones re-encrypts all tombstones with the new master key. The
+// snapshot chain does not depend on the storage IDs of the snapshots, so the
+// tombstones are not modified otherwise.
+func rotateTombstones(ctx context.Context, repo *repository.Repository, dst *repository.Repository) error {
+	var ids restic.IDs
+	err := repo.List(ctx, restic.TombstoneFile, func(id restic.ID, size int64) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		// tombstones rewritten by an interrupted run are skipped
+		_, err := dst.LoadAndDecrypt(ctx, nil, restic.TombstoneFile, id)
+		if err == nil {
+			continue
+		}
+		if errors.Cause(err) != crypto.ErrUnauthenticated {
+			return err
+		}
+
+		buf, err := repo.LoadAndDecrypt(ctx, nil, restic.TombstoneFile, id)
+		if err != nil {
+			return err
+		}
+		if _, err = dst.SaveUnpacked(ctx, restic.TombstoneFile, buf); err != nil {
+			return err
+		}
+		if err = repo.Backend().Remove(ctx, restic.Handle{Type: restic.TombstoneFile, Name: id.String()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotateCleanup removes all files which are still encrypted with the old
+// master key, except for the keys and the config.
+func rotateCleanup(gopts GlobalOptions, repo *repository.Repository, dst *repository.Repository, cp *migrations.Checkpoint, state *rotateState) error {
+	ctx := gopts.ctx
+
+	obsolete := func(t restic.FileType, keep restic.IDSet) (restic.IDSet, error) {
+		ids := restic.NewIDSet()
+		err := repo.List(ctx, t, func(id restic.ID, size int64) error {
+			if !keep.Has(id) {
+				ids.Insert(id)
+			}
+			return nil
+		})
+		return ids, err
+	}
+
+	oldPacks, err := obsolete(restic.PackFile, restic.NewIDSet(state.NewPacks...))
+	if err != nil {
+		return err
+	}
+	oldIndexes, err := obsolete(restic.IndexFile, restic.NewIDSet(state.NewIndexes...))
+	if err != nil {
+		return err
+	}
+	oldSnapshots := restic.NewIDSet()
+	for s := range state.Snapshots {
+		id, err := restic.ParseID(s)
+		if err != nil {
+			return err
+		}
+		oldSnapshots.Insert(id)
+	}
+
+	// parity files of the rewritten packs are encrypted with the new master
+	// key, all others cover old packs
+	oldParity := restic.NewIDSet()
+	err = repo.List(ctx, restic.ParityFile, func(id restic.ID, size int64) error {
+		_, err := repository.LoadParityHeader(ctx, dst, id, size)
+		if errors.Cause(err) == crypto.ErrUnauthenticated {
+			oldParity.Insert(id)
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	Verbosef("remove %d old snapshots, %d old index files, %d old parity files and %d old packs\n",
+		len(oldSnapshots), len(oldIndexes), len(oldParity), len(oldPacks))
+
+	for _, files := range []struct {
+		t   restic.FileType
+		ids restic.IDSet
+	}{
+		{restic.SnapshotFile, oldSnapshots},
+		{restic.IndexFile, oldIndexes},
+		{restic.ParityFile, oldParity},
+		{restic.PackFile, oldPacks},
+	} {
+		if err := DeleteFilesChecked(gopts, repo, files.ids, files.t); err != nil {
+			return err
+		}
+	}
+
+	return cp.Complete(ctx, "cleanup")
+}
+
+// rotateKeys encrypts the config with the new master key and replaces all
+// keys by a single key for the new master key.
+func rotateKeys(ctx context.Context, repo *repository.Repository, dst *repository.Repository, cp *migrations.Checkpoint, state *rotateState, password string) error {
+	if !state.ConfigReplaced {
+		// backends refuse to overwrite files, so the old config must be
+		// removed before the new one is saved. The key for the new master
+		// key contains a copy of the config, which is saved again when the
+		// repository is opened with the new password if this is interrupted.
+		_, err := dst.LoadAndDecrypt(ctx, nil, restic.ConfigFile, restic.ID{})
+		if errors.Cause(err) == crypto.ErrUnauthenticated {
+			Verbosef("encrypt config with new master key\n")
+			if err = repo.Backend().Remove(ctx, restic.Handle{Type: restic.ConfigFile}); err != nil {
+				return err
+			}
+			if _, err = dst.SaveJSONUnpacked(ctx, restic.ConfigFile, repo.Config()); err != nil {
+				return errors.Fatalf("unable to save new config, open the repository with the new password to restore it: %v", err)
+			}
+		} else if err != nil {
+			return err
+		}
+
+		// replace the key containing the copy of the config
+		cur, err := repository.LoadKey(ctx, dst, state.NewKey)
+		if err != nil {
+			return err
+		}
+
+		key, err := repository.AddKey(ctx, dst, password, cur.Username, cur.Hostname, cur.KDF, dst.Key(), cur.Capabilities)
+		if err != nil {
+			return errors.Fatalf("creating new key failed: %v\n", err)
+		}
+		Verbosef("saved new key as %s\n", key)
+
+		state.NewKey = key.Name()
+		state.ConfigReplaced = true
+		if err = saveRotateState(ctx, cp, state); err != nil {
+			return err
+		}
+	}
+
+	err := repo.List(ctx, restic.KeyFile, func(id restic.ID, size int64) error {
+		if id.String() == state.NewKey {
+			return nil
+		}
+		Verbosef("remove old key %v\n", id.Str())
+		return repo.Backend().Remove(ctx, restic.Handle{Type: restic.KeyFile, Name: id.String()})
+	})
+	if err != nil {
+		return err
+	}
+
+	// checkpoints encrypted with the old master key cannot be read anymore
+	err = repo.List(ctx, restic.MigrationFile, func(id restic.ID, size int64) error {
+		_, err := dst.LoadAndDecrypt(ctx, nil, restic.MigrationFile, id)
+		if errors.Cause(err) == crypto.ErrUnauthenticated {
+			return repo.Backend().Remove(ctx, restic.Handle{Type: restic.MigrationFile, Name: id.String()})
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = cp.Remove(ctx); err != nil {
+		return err
+	}
+
+	Verbosef("master key rotation complete, all other keys have been removed\n")
+	return nil
+}
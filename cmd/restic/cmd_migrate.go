@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/restic/restic/internal/migrations"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 
 	"github.com/spf13/cobra"
@@ -14,6 +15,10 @@ var cmdMigrate = &cobra.Command{
 The "migrate" command applies migrations to a repository. When no migration
 name is explicitly given, a list of migrations that can be applied is printed.
 
+The progress of a migration is stored in the repository. If a migration is
+interrupted, running it again resumes it where it stopped. With --dry-run, the
+migration only prints what it would do without modifying the repository.
+
 EXIT STATUS
 ===========
 
@@ -27,7 +32,8 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 
 // MigrateOptions bundles all options for the 'check' command.
 type MigrateOptions struct {
-	Force bool
+	Force  bool
+	DryRun bool
 }
 
 var migrateOptions MigrateOptions
@@ -36,12 +42,23 @@ func init() {
 	cmdRoot.AddCommand(cmdMigrate)
 	f := cmdMigrate.Flags()
 	f.BoolVarP(&migrateOptions.Force, "force", "f", false, `apply a migration a second time`)
+	f.BoolVarP(&migrateOptions.DryRun, "dry-run", "n", false, `do not modify the repository, just print what would be done`)
 }
 
 func checkMigrations(opts MigrateOptions, gopts GlobalOptions, repo restic.Repository) error {
 	ctx := gopts.ctx
 	Printf("available migrations:\n")
 	for _, m := range migrations.All {
+		cp, err := migrations.LoadCheckpoint(ctx, repo, m.Name(), true)
+		if err != nil {
+			return err
+		}
+
+		if cp.Resumed() {
+			Printf("  %v: %v (interrupted, %d steps done)\n", m.Name(), m.Desc(), len(cp.Done))
+			continue
+		}
+
 		ok, err := m.Check(ctx, repo)
 		if err != nil {
 			return err
@@ -62,11 +79,20 @@ func applyMigrations(opts MigrateOptions, gopts GlobalOptions, repo restic.Repos
 	for _, name := range args {
 		for _, m := range migrations.All {
 			if m.Name() == name {
-				ok, err := m.Check(ctx, repo)
+				cp, err := migrations.LoadCheckpoint(ctx, repo, m.Name(), opts.DryRun)
 				if err != nil {
 					return err
 				}
 
+				// the check may fail for a partially applied migration
+				ok := true
+				if !cp.Resumed() {
+					ok, err = m.Check(ctx, repo)
+					if err != nil {
+						return err
+					}
+				}
+
 				if !ok {
 					if !opts.Force {
 						Warnf("migration %v cannot be applied: check failed\nIf you want to apply this migration anyway, re-run with option --force\n", m.Name())
@@ -76,8 +102,16 @@ func applyMigrations(opts MigrateOptions, gopts GlobalOptions, repo restic.Repos
 					Warnf("check for migration %v failed, continuing anyway\n", m.Name())
 				}
 
-				Printf("applying migration %v...\n", m.Name())
-				if err = m.Apply(ctx, repo); err != nil {
+				switch {
+				case opts.DryRun:
+					Printf("would apply migration %v\n", m.Name())
+				case cp.Resumed():
+					Printf("resuming migration %v after %d completed steps...\n", m.Name(), len(cp.Done))
+				default:
+					Printf("applying migration %v...\n", m.Name())
+				}
+
+				if err = migrations.Apply(ctx, repo, m, cp); err != nil {
 					Warnf("migration %v failed: %v\n", m.Name(), err)
 					if firsterr == nil {
 						firsterr = err
@@ -85,7 +119,9 @@ func applyMigrations(opts MigrateOptions, gopts GlobalOptions, repo restic.Repos
 					continue
 				}
 
-				Printf("migration %v: success\n", m.Name())
+				if !opts.DryRun {
+					Printf("migration %v: success\n", m.Name())
+				}
 			}
 		}
 	}
@@ -99,7 +135,17 @@ func runMigrate(opts MigrateOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
-	lock, err := lockRepoExclusive(gopts.ctx, repo)
+	if err = repo.CheckCapabilities(repository.CapabilityPrune); err != nil {
+		return err
+	}
+
+	// a dry run only reads from the repository
+	lockFn := lockRepoExclusive
+	if opts.DryRun {
+		lockFn = lockRepo
+	}
+
+	lock, err := lockFn(gopts.ctx, repo)
 	defer unlockRepo(lock)
 	if err != nil {
 		return err
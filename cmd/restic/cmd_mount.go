@@ -11,6 +11,7 @@ import (
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 
 	resticfs "github.com/restic/restic/internal/fs"
@@ -61,6 +62,7 @@ type MountOptions struct {
 	Hosts                []string
 	Tags                 restic.TagLists
 	Paths                []string
+	Labels               []string
 	SnapshotTemplate     string
 }
 
@@ -77,6 +79,7 @@ func init() {
 	mountFlags.StringArrayVarP(&mountOptions.Hosts, "host", "H", nil, `only consider snapshots for this host (can be specified multiple times)`)
 	mountFlags.Var(&mountOptions.Tags, "tag", "only consider snapshots which include this `taglist`")
 	mountFlags.StringArrayVar(&mountOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`")
+	mountFlags.StringArrayVar(&mountOptions.Labels, "label", nil, "only consider snapshots which have this `label` in the format `key[=value]` (can be specified multiple times)")
 
 	mountFlags.StringVar(&mountOptions.SnapshotTemplate, "snapshot-template", time.RFC3339, "set `template` to use for snapshot dirs")
 }
@@ -100,6 +103,10 @@ func runMount(opts MountOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	if err = repo.CheckCapabilities(repository.CapabilityRead); err != nil {
+		return err
+	}
+
 	if !gopts.NoLock {
 		lock, err := lockRepo(gopts.ctx, repo)
 		defer unlockRepo(lock)
@@ -156,6 +163,7 @@ func runMount(opts MountOptions, gopts GlobalOptions, args []string) error {
 		Hosts:            opts.Hosts,
 		Tags:             opts.Tags,
 		Paths:            opts.Paths,
+		Labels:           opts.Labels,
 		SnapshotTemplate: opts.SnapshotTemplate,
 	}
 	root := fuse.NewRoot(repo, cfg)
@@ -47,6 +47,7 @@ type PruneOptions struct {
 	MaxRepackBytes uint64
 
 	RepackCachableOnly bool
+	RepackSmall        bool
 }
 
 var pruneOptions PruneOptions
@@ -63,6 +64,7 @@ func addPruneOptions(c *cobra.Command) {
 	f.StringVar(&pruneOptions.MaxUnused, "max-unused", "5%", "tolerate given `limit` of unused data (absolute value in bytes with suffixes k/K, m/M, g/G, t/T, a value in % or the word 'unlimited')")
 	f.StringVar(&pruneOptions.MaxRepackSize, "max-repack-size", "", "maximum `size` to repack (allowed suffixes: k/K, m/M, g/G, t/T)")
 	f.BoolVar(&pruneOptions.RepackCachableOnly, "repack-cacheable-only", false, "only repack packs which are cacheable")
+	f.BoolVar(&pruneOptions.RepackSmall, "repack-small", false, "also repack packs which are smaller than half the target pack size")
 }
 
 func verifyPruneOptions(opts *PruneOptions) error {
@@ -142,6 +144,14 @@ func runPrune(opts PruneOptions, gopts GlobalOptions) error {
 		return err
 	}
 
+	if err = repo.CheckCapabilities(repository.CapabilityPrune); err != nil {
+		return err
+	}
+
+	if repo.WriteOnly() {
+		return errors.Fatal("prune cannot be used with a write-only key")
+	}
+
 	lock, err := lockRepoExclusive(gopts.ctx, repo)
 	defer unlockRepo(lock)
 	if err != nil {
@@ -298,8 +308,15 @@ func prune(opts PruneOptions, gopts GlobalOptions, repo restic.Repository, usedB
 	repackPacks := restic.NewIDSet()
 
 	var repackCandidates []packInfoWithID
+	var repackSmallCandidates []packInfoWithID
+	var repackSmallSize uint64
 	repackAllPacksWithDuplicates := true
 
+	// with --repack-small, packs below half of the target pack size are
+	// considered too small, this includes packs created with a smaller pack size
+	targetPackSize := uint64(repo.PackSize())
+	minPackSize := int64(targetPackSize / 2)
+
 	keep := func(p packInfo) {
 		stats.packs.keep++
 		if p.duplicateBlobs > 0 {
@@ -351,10 +368,15 @@ func prune(opts PruneOptions, gopts GlobalOptions, repo restic.Repository, usedB
 			// if this is a data pack and --repack-cacheable-only is set => keep pack!
 			keep(p)
 
-		case p.unusedBlobs == 0 && p.duplicateBlobs == 0 && p.tpe != restic.InvalidBlob:
+		case p.unusedBlobs == 0 && p.duplicateBlobs == 0 && p.tpe != restic.InvalidBlob && (!opts.RepackSmall || packSize >= minPackSize):
 			// All blobs in pack are used and not duplicates/mixed => keep pack!
 			keep(p)
 
+		case p.unusedBlobs == 0 && p.duplicateBlobs == 0 && p.tpe != restic.InvalidBlob:
+			// All blobs in pack are used, but the pack is too small => candidate for repacking
+			repackSmallCandidates = append(repackSmallCandidates, packInfoWithID{ID: id, packInfo: p})
+			repackSmallSize += uint64(packSize)
+
 		default:
 			// all other packs are candidates for repacking
 			repackCandidates = append(repackCandidates, packInfoWithID{ID: id, packInfo: p})
@@ -396,6 +418,16 @@ func prune(opts PruneOptions, gopts GlobalOptions, repo restic.Repository, usedB
 		}
 	}
 
+	// only repack small packs if they can be combined into at least one pack
+	// of the target size, otherwise the result would be a small pack again
+	if repackSmallSize >= targetPackSize {
+		repackCandidates = append(repackCandidates, repackSmallCandidates...)
+	} else {
+		for _, p := range repackSmallCandidates {
+			keep(p.packInfo)
+		}
+	}
+
 	// calculate limit for number of unused bytes in the repo after repacking
 	maxUnusedSizeAfter := opts.maxUnusedBytes(stats.size.used)
 
@@ -444,6 +476,11 @@ func prune(opts PruneOptions, gopts GlobalOptions, repo restic.Repository, usedB
 			// repacking duplicates/non-data is only limited by repackSize
 			repack(p.ID, p.packInfo)
 
+		case p.unusedBlobs == 0:
+			// the pack is fully used and was selected because it is too
+			// small, this is also only limited by repackSize
+			repack(p.ID, p.packInfo)
+
 		case reachedUnusedSizeAfter:
 			// for all other packs stop repacking if tolerated unused size is reached.
 			keep(p.packInfo)
@@ -508,6 +545,9 @@ func prune(opts PruneOptions, gopts GlobalOptions, repo restic.Repository, usedB
 
 	// unreferenced packs can be safely deleted first
 	if len(removePacksFirst) != 0 {
+		if err = removeFromParity(gopts, repo, removePacksFirst); err != nil {
+			return err
+		}
 		Verbosef("deleting unreferenced packs\n")
 		DeleteFiles(gopts, repo, removePacksFirst, restic.PackFile)
 	}
@@ -515,7 +555,7 @@ func prune(opts PruneOptions, gopts GlobalOptions, repo restic.Repository, usedB
 	if len(repackPacks) != 0 {
 		Verbosef("repacking packs\n")
 		bar := newProgressMax(!gopts.Quiet, uint64(len(repackPacks)), "packs repacked")
-		_, err := repository.Repack(ctx, repo, repackPacks, keepBlobs, bar)
+		_, err := repository.Repack(ctx, repo, repo, repackPacks, keepBlobs, bar)
 		bar.Done()
 		if err != nil {
 			return errors.Fatalf("%s", err)
@@ -539,6 +579,9 @@ func prune(opts PruneOptions, gopts GlobalOptions, repo restic.Repository, usedB
 	}
 
 	if len(removePacks) != 0 {
+		if err = removeFromParity(gopts, repo, removePacks); err != nil {
+			return err
+		}
 		Verbosef("removing %d old packs\n", len(removePacks))
 		DeleteFiles(gopts, repo, removePacks, restic.PackFile)
 	}
@@ -547,6 +590,27 @@ func prune(opts PruneOptions, gopts GlobalOptions, repo restic.Repository, usedB
 	return nil
 }
 
+// removeFromParity removes the packs from the parity files which cover them,
+// so that the parity files stay usable after the packs have been deleted.
+func removeFromParity(gopts GlobalOptions, repo restic.Repository, packs restic.IDSet) error {
+	if !repo.Config().HasFeature(restic.FeatureParity) {
+		return nil
+	}
+
+	Verbosef("updating parity files\n")
+	bar := newProgressMax(!gopts.Quiet, 0, "parity files updated")
+	dropped, err := repository.RemoveFromParity(gopts.ctx, repo, packs, bar)
+	bar.Done()
+	if err != nil {
+		return errors.Fatalf("unable to update parity files: %v", err)
+	}
+
+	if len(dropped) > 0 {
+		Warnf("removed %d parity files which could not be updated, run `restic repair parity` to recreate them\n", len(dropped))
+	}
+	return nil
+}
+
 func rebuildIndexFiles(gopts GlobalOptions, repo restic.Repository, removePacks restic.IDSet, extraObsolete restic.IDs) error {
 	Verbosef("rebuilding index\n")
 
@@ -45,6 +45,10 @@ func runRebuildIndex(opts RebuildIndexOptions, gopts GlobalOptions) error {
 		return err
 	}
 
+	if err = repo.CheckCapabilities(repository.CapabilityPrune); err != nil {
+		return err
+	}
+
 	lock, err := lockRepoExclusive(gopts.ctx, repo)
 	defer unlockRepo(lock)
 	if err != nil {
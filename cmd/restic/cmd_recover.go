@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"github.com/spf13/cobra"
 )
@@ -43,6 +44,10 @@ func runRecover(gopts GlobalOptions) error {
 		return err
 	}
 
+	if err = repo.CheckCapabilities(repository.CapabilityRead, repository.CapabilityAppend); err != nil {
+		return err
+	}
+
 	lock, err := lockRepo(gopts.ctx, repo)
 	defer unlockRepo(lock)
 	if err != nil {
@@ -140,11 +145,22 @@ func runRecover(gopts GlobalOptions) error {
 
 	sn.Tree = &treeID
 
+	chain, err := restic.LoadSnapshotChain(gopts.ctx, repo)
+	if err != nil {
+		return err
+	}
+	chain.Link(sn)
+
 	id, err := repo.SaveJSONUnpacked(gopts.ctx, restic.SnapshotFile, sn)
 	if err != nil {
 		return errors.Fatalf("unable to save snapshot: %v", err)
 	}
 
+	err = chain.SaveHead(gopts.ctx, repo, id, sn)
+	if err != nil {
+		return err
+	}
+
 	Printf("saved new snapshot %v\n", id.Str())
 
 	return nil
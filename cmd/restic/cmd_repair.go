@@ -0,0 +1,19 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var cmdRepair = &cobra.Command{
+	Use:   "repair",
+	Short: "Repair the repository",
+	Long: `
+The "repair" command groups commands which repair damaged parts of the
+repository.
+`,
+	DisableAutoGenTag: true,
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdRepair)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdRepairPacks = &cobra.Command{
+	Use:   "packs [flags] [pack ID...]",
+	Short: "Restore damaged pack files from parity files",
+	Long: `
+The "repair packs" command restores damaged or missing pack files from the
+parity files of the repository. The IDs of damaged pack files are reported by
+"restic check --read-data". When no IDs are given, all pack files which are
+referenced by the index but missing in the repository are restored.
+
+Each parity file covers a group of pack files and can restore as many damaged
+pack files of its group as it contains parity shards.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRepairPacks(globalOptions, args)
+	},
+}
+
+func init() {
+	cmdRepair.AddCommand(cmdRepairPacks)
+}
+
+func runRepairPacks(gopts GlobalOptions, args []string) error {
+	damaged := restic.NewIDSet()
+	for _, arg := range args {
+		id, err := restic.ParseID(arg)
+		if err != nil {
+			return errors.Fatalf("invalid pack ID %q: %v", arg, err)
+		}
+		damaged.Insert(id)
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if err = repo.CheckCapabilities(repository.CapabilityPrune); err != nil {
+		return err
+	}
+
+	if repo.WriteOnly() {
+		return errors.Fatal("repair packs cannot be used with a write-only key")
+	}
+	if !repo.Config().HasFeature(restic.FeatureParity) {
+		return errors.Fatal("the repository does not contain parity files, run `restic repair parity` to create them")
+	}
+
+	lock, err := lockRepoExclusive(gopts.ctx, repo)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	if len(damaged) == 0 {
+		damaged, err = findMissingPacks(gopts, repo)
+		if err != nil {
+			return err
+		}
+		if len(damaged) == 0 {
+			Verbosef("no missing packs found\n")
+			return nil
+		}
+	}
+
+	return repairPacks(gopts, repo, damaged)
+}
+
+// findMissingPacks returns the packs which are referenced by the index but
+// do not exist in the repository.
+func findMissingPacks(gopts GlobalOptions, repo restic.Repository) (restic.IDSet, error) {
+	Verbosef("loading indexes...\n")
+	err := repo.LoadIndex(gopts.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := restic.NewIDSet()
+	for id := range repo.Index().PackSize(gopts.ctx, true) {
+		missing.Insert(id)
+	}
+
+	err = repo.List(gopts.ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		missing.Delete(id)
+		return nil
+	})
+	return missing, err
+}
+
+func repairPacks(gopts GlobalOptions, repo restic.Repository, damaged restic.IDSet) error {
+	ctx := gopts.ctx
+
+	Verbosef("searching parity files for %d packs\n", len(damaged))
+	groups := make(map[restic.ID]restic.IDSet)
+	uncovered := restic.NewIDSet(damaged.List()...)
+	err := repository.ForAllParity(ctx, repo, func(id restic.ID, hdr *repository.ParityHeader) error {
+		for packID := range damaged {
+			if hdr.Find(packID) < 0 {
+				continue
+			}
+			if groups[id] == nil {
+				groups[id] = restic.NewIDSet()
+			}
+			groups[id].Insert(packID)
+			uncovered.Delete(packID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	for id := range uncovered {
+		Warnf("pack %v is not covered by any parity file\n", id)
+		failed = true
+	}
+
+	restored := restic.NewIDSet()
+	for id, packs := range groups {
+		// a pack may be covered by more than one parity file
+		packs = packs.Sub(restored)
+		if len(packs) == 0 {
+			continue
+		}
+
+		Verbosef("restoring %d packs from parity file %v\n", len(packs), id.Str())
+		ids, err := repository.RepairPacks(ctx, repo, id, packs)
+		for _, packID := range ids {
+			Printf("restored pack %v\n", packID)
+			restored.Insert(packID)
+		}
+		if err != nil {
+			Warnf("unable to restore packs from parity file %v: %v\n", id.Str(), err)
+			failed = true
+		}
+	}
+
+	for id := range damaged {
+		if !restored.Has(id) {
+			failed = true
+		}
+	}
+	if failed {
+		return errors.Fatal("some packs could not be restored")
+	}
+
+	Verbosef("all packs have been restored\n")
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"reflect"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/reedsolomon"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdRepairParity = &cobra.Command{
+	Use:   "parity [flags]",
+	Short: "Create parity files for pack files",
+	Long: `
+The "repair parity" command creates parity files for all pack files which are
+not yet covered by a parity file. When the repository does not use parity
+files yet, they are enabled, afterwards all commands which add pack files also
+create the parity files for them.
+
+Each group of --group-size pack files is covered by a parity file with
+--shards parity shards. Up to --shards damaged pack files of a group can be
+restored with "restic repair packs". The parity files need about
+shards/group-size of additional space. Changing the redundancy only affects
+new parity files.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRepairParity(repairParityOptions, globalOptions, args)
+	},
+}
+
+// RepairParityOptions collects all options for the repair parity command.
+type RepairParityOptions struct {
+	GroupSize uint
+	Shards    uint
+}
+
+var repairParityOptions RepairParityOptions
+
+// defaultParityConfig is used when parity files are enabled without
+// specifying the redundancy.
+var defaultParityConfig = restic.ParityConfig{GroupSize: 10, Shards: 2}
+
+func init() {
+	cmdRepair.AddCommand(cmdRepairParity)
+	f := cmdRepairParity.Flags()
+	f.UintVar(&repairParityOptions.GroupSize, "group-size", 0, "number of pack files covered by each parity file (default: current or 10)")
+	f.UintVar(&repairParityOptions.Shards, "shards", 0, "number of parity shards per parity file (default: current or 2)")
+}
+
+func runRepairParity(opts RepairParityOptions, gopts GlobalOptions, args []string) error {
+	if len(args) > 0 {
+		return errors.Fatal("repair parity does not accept arguments")
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if err = repo.CheckCapabilities(repository.CapabilityPrune); err != nil {
+		return err
+	}
+
+	if repo.WriteOnly() {
+		return errors.Fatal("repair parity cannot be used with a write-only key")
+	}
+
+	lock, err := lockRepoExclusive(gopts.ctx, repo)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	cfg := repo.Config()
+	parityCfg := defaultParityConfig
+	if cfg.Parity != nil {
+		parityCfg = *cfg.Parity
+	}
+	if opts.GroupSize != 0 {
+		parityCfg.GroupSize = opts.GroupSize
+	}
+	if opts.Shards != 0 {
+		parityCfg.Shards = opts.Shards
+	}
+
+	if parityCfg.GroupSize < 1 || parityCfg.GroupSize > reedsolomon.MaxDataShards {
+		return errors.Fatalf("group size must be between 1 and %d", reedsolomon.MaxDataShards)
+	}
+	if parityCfg.Shards < 1 || parityCfg.Shards > reedsolomon.MaxParityShards {
+		return errors.Fatalf("number of shards must be between 1 and %d", reedsolomon.MaxParityShards)
+	}
+
+	if !cfg.HasFeature(restic.FeatureParity) || !reflect.DeepEqual(cfg.Parity, &parityCfg) {
+		// copy the list so that the config of repo is not modified
+		cfg.Features = append([]string(nil), cfg.Features...)
+		cfg.AddFeatures(restic.FeatureParity)
+		cfg.Parity = &parityCfg
+
+		err = restic.ReplaceConfig(gopts.ctx, repo, cfg)
+		if err != nil {
+			return err
+		}
+		Verbosef("parity files cover groups of %d packs with %d parity shards\n", parityCfg.GroupSize, parityCfg.Shards)
+	}
+
+	return createMissingParity(gopts, repo, parityCfg)
+}
+
+// createMissingParity creates parity files for all packs which are not covered
+// by a parity file.
+func createMissingParity(gopts GlobalOptions, repo restic.Repository, cfg restic.ParityConfig) error {
+	ctx := gopts.ctx
+
+	Verbosef("loading parity files\n")
+	covered := restic.NewIDSet()
+	err := repository.ForAllParity(ctx, repo, func(id restic.ID, hdr *repository.ParityHeader) error {
+		for _, pack := range hdr.Packs {
+			if !pack.Removed {
+				covered.Insert(pack.ID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var packs restic.IDs
+	err = repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		if !covered.Has(id) {
+			packs = append(packs, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(packs) == 0 {
+		Verbosef("all packs are covered by parity files\n")
+		return nil
+	}
+
+	Verbosef("creating parity files for %d packs\n", len(packs))
+	bar := newProgressMax(!gopts.Quiet, uint64(len(packs)), "packs processed")
+	err = repository.CreateParity(ctx, repo, packs, cfg, bar)
+	bar.Done()
+	return err
+}
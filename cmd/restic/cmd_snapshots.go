@@ -8,6 +8,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/ui/table"
 	"github.com/spf13/cobra"
@@ -35,7 +36,10 @@ type SnapshotOptions struct {
 	Hosts   []string
 	Tags    restic.TagLists
 	Paths   []string
+	Labels  []string
 	Compact bool
+	Long    bool
+	Summary bool
 	Last    bool // This option should be removed in favour of Latest.
 	Latest  int
 	GroupBy string
@@ -50,7 +54,10 @@ func init() {
 	f.StringArrayVarP(&snapshotOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host` (can be specified multiple times)")
 	f.Var(&snapshotOptions.Tags, "tag", "only consider snapshots which include this `taglist` in the format `tag[,tag,...]` (can be specified multiple times)")
 	f.StringArrayVar(&snapshotOptions.Paths, "path", nil, "only consider snapshots for this `path` (can be specified multiple times)")
+	f.StringArrayVar(&snapshotOptions.Labels, "label", nil, "only consider snapshots which have this `label` in the format `key[=value]` (can be specified multiple times)")
 	f.BoolVarP(&snapshotOptions.Compact, "compact", "c", false, "use compact output format")
+	f.BoolVarP(&snapshotOptions.Long, "long", "l", false, "show the full description of the snapshots instead of the first line")
+	f.BoolVar(&snapshotOptions.Summary, "summary", false, "show the statistics of the backup which created each snapshot")
 	f.BoolVar(&snapshotOptions.Last, "last", false, "only show the last snapshot for each host and path")
 	err := f.MarkDeprecated("last", "use --latest 1")
 	if err != nil {
@@ -58,7 +65,7 @@ func init() {
 		panic(err)
 	}
 	f.IntVar(&snapshotOptions.Latest, "latest", 0, "only show the last `n` snapshots for each host and path")
-	f.StringVarP(&snapshotOptions.GroupBy, "group-by", "g", "", "string for grouping snapshots by host,paths,tags")
+	f.StringVarP(&snapshotOptions.GroupBy, "group-by", "g", "", "string for grouping snapshots by host,paths,tags,label:key")
 }
 
 func runSnapshots(opts SnapshotOptions, gopts GlobalOptions, args []string) error {
@@ -67,6 +74,10 @@ func runSnapshots(opts SnapshotOptions, gopts GlobalOptions, args []string) erro
 		return err
 	}
 
+	if err = repo.CheckCapabilities(repository.CapabilityRead); err != nil {
+		return err
+	}
+
 	if !gopts.NoLock {
 		lock, err := lockRepo(gopts.ctx, repo)
 		defer unlockRepo(lock)
@@ -79,7 +90,7 @@ func runSnapshots(opts SnapshotOptions, gopts GlobalOptions, args []string) erro
 	defer cancel()
 
 	var snapshots restic.Snapshots
-	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, opts.Labels, args) {
 		snapshots = append(snapshots, sn)
 	}
 	snapshotGroups, grouped, err := restic.GroupSnapshots(snapshots, opts.GroupBy)
@@ -115,7 +126,7 @@ func runSnapshots(opts SnapshotOptions, gopts GlobalOptions, args []string) erro
 				return nil
 			}
 		}
-		PrintSnapshots(gopts.stdout, list, nil, opts.Compact)
+		PrintSnapshots(gopts.stdout, list, nil, opts.Compact, opts.Long, opts.Summary)
 	}
 
 	return nil
@@ -158,8 +169,10 @@ func FilterLastestSnapshots(list restic.Snapshots, limit int) restic.Snapshots {
 	return results
 }
 
-// PrintSnapshots prints a text table of the snapshots in list to stdout.
-func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.KeepReason, compact bool) {
+// PrintSnapshots prints a text table of the snapshots in list to stdout. The
+// descriptions are shortened to their first line unless long is set. If
+// summary is set, the statistics of the backups are printed as well.
+func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.KeepReason, compact, long, summary bool) {
 	// keep the reasons a snasphot is being kept in a map, so that it doesn't
 	// get lost when the list of snapshots is sorted
 	keepReasons := make(map[restic.ID]restic.KeepReason, len(reasons))
@@ -177,7 +190,14 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 
 	// Determine the max widths for host and tag.
 	maxHost, maxTag := 10, 6
+	var hasLabels, hasDescription bool
 	for _, sn := range list {
+		if len(sn.Labels) > 0 {
+			hasLabels = true
+		}
+		if sn.Description != "" {
+			hasDescription = true
+		}
 		if len(sn.Hostname) > maxHost {
 			maxHost = len(sn.Hostname)
 		}
@@ -203,26 +223,45 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 		if len(reasons) > 0 {
 			tab.AddColumn("Reasons", `{{ join .Reasons "\n" }}`)
 		}
+		if hasLabels {
+			tab.AddColumn("Labels", `{{ join .Labels "\n" }}`)
+		}
 		tab.AddColumn("Paths", `{{ join .Paths "\n" }}`)
+		if hasDescription {
+			tab.AddColumn("Description", "{{ .Description }}")
+		}
+	}
+	if summary {
+		tab.AddColumn("Files", "{{ .Files }}")
+		tab.AddColumn("     Added", "{{ .Added }}")
+		tab.AddColumn("Duration", "{{ .Duration }}")
 	}
 
 	type snapshot struct {
-		ID        string
-		Timestamp string
-		Hostname  string
-		Tags      []string
-		Reasons   []string
-		Paths     []string
+		ID          string
+		Timestamp   string
+		Hostname    string
+		Tags        []string
+		Reasons     []string
+		Labels      []string
+		Paths       []string
+		Description string
+		Files       string
+		Added       string
+		Duration    string
 	}
 
 	var multiline bool
+	var incomplete int
 	for _, sn := range list {
 		data := snapshot{
-			ID:        sn.ID().Str(),
-			Timestamp: sn.Time.Local().Format(TimeFormat),
-			Hostname:  sn.Hostname,
-			Tags:      sn.Tags,
-			Paths:     sn.Paths,
+			ID:          sn.ID().Str(),
+			Timestamp:   sn.Time.Local().Format(TimeFormat),
+			Hostname:    sn.Hostname,
+			Tags:        sn.Tags,
+			Paths:       sn.Paths,
+			Labels:      formatLabels(sn.Labels),
+			Description: formatDescription(sn.Description, long),
 		}
 
 		if len(reasons) > 0 {
@@ -230,7 +269,18 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 			data.Reasons = keepReasons[*id].Matches
 		}
 
-		if len(sn.Paths) > 1 && !compact {
+		if sn.Incomplete {
+			data.ID += "*"
+			incomplete++
+		}
+
+		if sn.Summary != nil {
+			data.Files = fmt.Sprintf("%d", sn.Summary.FilesProcessed)
+			data.Added = fmt.Sprintf("%10s", formatBytes(sn.Summary.DataAdded))
+			data.Duration = formatDuration(sn.Summary.Duration())
+		}
+
+		if (len(sn.Paths) > 1 || len(sn.Labels) > 1 || strings.Contains(data.Description, "\n")) && !compact {
 			multiline = true
 		}
 
@@ -238,6 +288,9 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 	}
 
 	tab.AddFooter(fmt.Sprintf("%d snapshots", len(list)))
+	if incomplete > 0 {
+		tab.AddFooter(fmt.Sprintf("%d incomplete snapshots marked with *", incomplete))
+	}
 
 	if multiline {
 		// print an additional blank line between snapshots
@@ -261,6 +314,44 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 	}
 }
 
+// maxDescriptionWidth is the width up to which the description is shown in
+// the list of snapshots, unless --long is given.
+const maxDescriptionWidth = 40
+
+// formatDescription returns the description for the list of snapshots. Unless
+// long is set, only the first line is shown, shortened to
+// maxDescriptionWidth characters.
+func formatDescription(description string, long bool) string {
+	if long {
+		return description
+	}
+
+	lines := strings.SplitN(description, "\n", 2)
+	res := []rune(lines[0])
+	if len(res) > maxDescriptionWidth {
+		return string(res[:maxDescriptionWidth-3]) + "..."
+	}
+	if len(lines) > 1 {
+		return string(res) + " ..."
+	}
+	return string(res)
+}
+
+// formatLabels returns the labels in the format key=value, sorted by key.
+func formatLabels(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	res := make([]string, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, key+"="+labels[key])
+	}
+	return res
+}
+
 // PrintSnapshotGroupHeader prints which group of the group-by option the
 // following snapshots belong to.
 // Prints nothing, if we did not group at all.
@@ -272,7 +363,7 @@ func PrintSnapshotGroupHeader(stdout io.Writer, groupKeyJSON string) error {
 		return err
 	}
 
-	if key.Hostname == "" && key.Tags == nil && key.Paths == nil {
+	if key.Hostname == "" && key.Tags == nil && key.Paths == nil && key.Labels == nil {
 		return nil
 	}
 
@@ -288,6 +379,9 @@ func PrintSnapshotGroupHeader(stdout io.Writer, groupKeyJSON string) error {
 	if key.Paths != nil {
 		infoStrings = append(infoStrings, "paths ["+strings.Join(key.Paths, ", ")+"]")
 	}
+	if key.Labels != nil {
+		infoStrings = append(infoStrings, "labels ["+strings.Join(formatLabels(key.Labels), ", ")+"]")
+	}
 	if infoStrings != nil {
 		fmt.Fprintf(stdout, " for (%s)", strings.Join(infoStrings, ", "))
 	}
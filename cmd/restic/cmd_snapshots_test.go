@@ -17,3 +17,22 @@ func TestEmptySnapshotGroupJSON(t *testing.T) {
 		rtest.Equals(t, "[]", strings.TrimSpace(w.String()))
 	}
 }
+
+func TestFormatDescription(t *testing.T) {
+	long := strings.Repeat("x", 50)
+
+	for _, test := range []struct {
+		description string
+		long        bool
+		result      string
+	}{
+		{"", false, ""},
+		{"before the upgrade", false, "before the upgrade"},
+		{"before the upgrade\nof the database", false, "before the upgrade ..."},
+		{"before the upgrade\nof the database", true, "before the upgrade\nof the database"},
+		{long, false, strings.Repeat("x", 37) + "..."},
+		{long, true, long},
+	} {
+		rtest.Equals(t, test.result, formatDescription(test.description, test.long))
+	}
+}
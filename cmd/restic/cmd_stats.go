@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"path/filepath"
 
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/walker"
 
@@ -34,7 +35,8 @@ The modes are:
 * files-by-contents: Counts total size of files, where a file is
    considered unique if it has unique contents.
 * raw-data: Counts the size of blobs in the repository, regardless of
-  how many files reference them.
+  how many files reference them. For repositories using compression,
+  the compressed size is reported in addition to the uncompressed size.
 * blobs-per-file: A combination of files-by-contents and raw-data.
 
 Refer to the online manual for more details about each mode.
@@ -56,9 +58,10 @@ type StatsOptions struct {
 	countMode string
 
 	// filter snapshots by, if given by user
-	Hosts []string
-	Tags  restic.TagLists
-	Paths []string
+	Hosts  []string
+	Tags   restic.TagLists
+	Paths  []string
+	Labels []string
 }
 
 var statsOptions StatsOptions
@@ -70,6 +73,7 @@ func init() {
 	f.StringArrayVarP(&statsOptions.Hosts, "host", "H", nil, "only consider snapshots with the given `host` (can be specified multiple times)")
 	f.Var(&statsOptions.Tags, "tag", "only consider snapshots which include this `taglist` in the format `tag[,tag,...]` (can be specified multiple times)")
 	f.StringArrayVar(&statsOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` (can be specified multiple times)")
+	f.StringArrayVar(&statsOptions.Labels, "label", nil, "only consider snapshots which have this `label` in the format `key[=value]` (can be specified multiple times)")
 }
 
 func runStats(gopts GlobalOptions, args []string) error {
@@ -86,6 +90,10 @@ func runStats(gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	if err = repo.CheckCapabilities(repository.CapabilityRead); err != nil {
+		return err
+	}
+
 	if err = repo.LoadIndex(ctx); err != nil {
 		return err
 	}
@@ -111,7 +119,7 @@ func runStats(gopts GlobalOptions, args []string) error {
 		snapshotsCount: 0,
 	}
 
-	for sn := range FindFilteredSnapshots(ctx, repo, statsOptions.Hosts, statsOptions.Tags, statsOptions.Paths, args) {
+	for sn := range FindFilteredSnapshots(ctx, repo, statsOptions.Hosts, statsOptions.Tags, statsOptions.Paths, statsOptions.Labels, args) {
 		err = statsWalkSnapshot(ctx, sn, repo, stats)
 		if err != nil {
 			return fmt.Errorf("error walking snapshot: %v", err)
@@ -124,14 +132,33 @@ func runStats(gopts GlobalOptions, args []string) error {
 
 	if statsOptions.countMode == countModeRawData {
 		// the blob handles have been collected, but not yet counted
+		var compressedSize, compressedBlobsSize uint64
 		for blobHandle := range stats.blobs {
-			blobSize, found := repo.LookupBlobSize(blobHandle.ID, blobHandle.Type)
-			if !found {
+			pbs := repo.Index().Lookup(blobHandle)
+			if len(pbs) == 0 {
 				return fmt.Errorf("blob %v not found", blobHandle)
 			}
-			stats.TotalSize += uint64(blobSize)
+			pb := pbs[0]
+			// the total size is the size of the plaintext, as for
+			// repositories without compression
+			stats.TotalSize += uint64(pb.DataLength())
+			compressedSize += uint64(restic.PlaintextLength(int(pb.Length)))
+			if pb.IsCompressed() {
+				compressedBlobsSize += uint64(pb.DataLength())
+			}
 			stats.TotalBlobCount++
 		}
+
+		if repo.Config().Version >= 2 {
+			stats.TotalCompressedSize = compressedSize
+			if compressedSize > 0 {
+				stats.CompressionRatio = float64(stats.TotalSize) / float64(compressedSize)
+			}
+			if stats.TotalSize > 0 {
+				stats.CompressionSpaceSaving = (1 - float64(compressedSize)/float64(stats.TotalSize)) * 100
+				stats.CompressionProgress = float64(compressedBlobsSize) / float64(stats.TotalSize) * 100
+			}
+		}
 	}
 
 	if gopts.JSON {
@@ -152,6 +179,18 @@ func runStats(gopts GlobalOptions, args []string) error {
 		Printf("   Total File Count:   %d\n", stats.TotalFileCount)
 	}
 	Printf("         Total Size:   %-5s\n", formatBytes(stats.TotalSize))
+	if stats.TotalCompressedSize > 0 {
+		Printf("    Compressed Size:   %-5s\n", formatBytes(stats.TotalCompressedSize))
+	}
+	if stats.CompressionProgress > 0 {
+		Printf("   Compressed Blobs:   %.2f%% of uncompressed data\n", stats.CompressionProgress)
+	}
+	if stats.CompressionRatio > 0 {
+		Printf("  Compression Ratio:   %.2fx\n", stats.CompressionRatio)
+	}
+	if stats.CompressionSpaceSaving > 0 {
+		Printf("      Space Savings:   %.2f%%\n", stats.CompressionSpaceSaving)
+	}
 
 	return nil
 }
@@ -276,9 +315,13 @@ func verifyStatsInput(gopts GlobalOptions, args []string) error {
 // to collect information about it, as well as state needed
 // for a successful and efficient walk.
 type statsContainer struct {
-	TotalSize      uint64 `json:"total_size"`
-	TotalFileCount uint64 `json:"total_file_count"`
-	TotalBlobCount uint64 `json:"total_blob_count,omitempty"`
+	TotalSize              uint64  `json:"total_size"`
+	TotalCompressedSize    uint64  `json:"total_compressed_size,omitempty"`
+	CompressionRatio       float64 `json:"compression_ratio,omitempty"`
+	CompressionProgress    float64 `json:"compression_progress,omitempty"`
+	CompressionSpaceSaving float64 `json:"compression_space_saving,omitempty"`
+	TotalFileCount         uint64  `json:"total_file_count"`
+	TotalBlobCount         uint64  `json:"total_blob_count,omitempty"`
 
 	// uniqueFiles marks visited files according to their
 	// contents (hashed sequence of content blob IDs)
@@ -13,12 +13,14 @@ import (
 
 var cmdTag = &cobra.Command{
 	Use:   "tag [flags] [snapshot-ID ...]",
-	Short: "Modify tags on snapshots",
+	Short: "Modify tags and labels on snapshots",
 	Long: `
-The "tag" command allows you to modify tags on exiting snapshots.
+The "tag" command allows you to modify tags and labels on exiting snapshots.
 
 You can either set/replace the entire set of tags on a snapshot, or
-add tags to/remove tags from the existing set.
+add tags to/remove tags from the existing set. Labels in the format key=value
+are set with --set-label, replacing the value of an existing label with the
+same key, and removed by key with --remove-label.
 
 When no snapshot-ID is given, all snapshots matching the host, tag and path filter criteria are modified.
 
@@ -37,10 +39,14 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 type TagOptions struct {
 	Hosts      []string
 	Paths      []string
+	Labels     []string
 	Tags       restic.TagLists
 	SetTags    restic.TagLists
 	AddTags    restic.TagLists
 	RemoveTags restic.TagLists
+
+	SetLabels    []string
+	RemoveLabels []string
 }
 
 var tagOptions TagOptions
@@ -52,13 +58,16 @@ func init() {
 	tagFlags.Var(&tagOptions.SetTags, "set", "`tags` which will replace the existing tags in the format `tag[,tag,...]` (can be given multiple times)")
 	tagFlags.Var(&tagOptions.AddTags, "add", "`tags` which will be added to the existing tags in the format `tag[,tag,...]` (can be given multiple times)")
 	tagFlags.Var(&tagOptions.RemoveTags, "remove", "`tags` which will be removed from the existing tags in the format `tag[,tag,...]` (can be given multiple times)")
+	tagFlags.StringArrayVar(&tagOptions.SetLabels, "set-label", nil, "`label` in the format key=value which will be set, replacing an existing label with the same key (can be given multiple times)")
+	tagFlags.StringArrayVar(&tagOptions.RemoveLabels, "remove-label", nil, "`key` of a label which will be removed (can be given multiple times)")
 
 	tagFlags.StringArrayVarP(&tagOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
 	tagFlags.Var(&tagOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot-ID is given")
 	tagFlags.StringArrayVar(&tagOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot-ID is given")
+	tagFlags.StringArrayVar(&tagOptions.Labels, "label", nil, "only consider snapshots which have this `label` in the format `key[=value]`, when no snapshot-ID is given")
 }
 
-func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, setTags, addTags, removeTags []string) (bool, error) {
+func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, setTags, addTags, removeTags []string, setLabels map[string]string, removeLabels []string) (bool, error) {
 	var changed bool
 
 	if len(setTags) != 0 {
@@ -75,10 +84,27 @@ func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Sna
 		}
 	}
 
+	if sn.SetLabels(setLabels) {
+		changed = true
+	}
+	if sn.RemoveLabels(removeLabels) {
+		changed = true
+	}
+
 	if changed {
 		// Retain the original snapshot id over all tag changes.
 		if sn.Original == nil {
+			hash := sn.ContentHash()
 			sn.Original = sn.ID()
+
+			// setting the original snapshot id changes the content hash
+			// of a chained snapshot
+			if sn.ChainDigest != nil {
+				rewrite := restic.ChainRewrite{Hash: hash, NewHash: sn.ContentHash()}
+				if err := restic.SaveChainRewrites(ctx, repo, "tag", []restic.ChainRewrite{rewrite}); err != nil {
+					return false, err
+				}
+			}
 		}
 
 		// Save the new snapshot.
@@ -105,18 +131,33 @@ func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Sna
 }
 
 func runTag(opts TagOptions, gopts GlobalOptions, args []string) error {
-	if len(opts.SetTags) == 0 && len(opts.AddTags) == 0 && len(opts.RemoveTags) == 0 {
+	if len(opts.SetTags) == 0 && len(opts.AddTags) == 0 && len(opts.RemoveTags) == 0 &&
+		len(opts.SetLabels) == 0 && len(opts.RemoveLabels) == 0 {
 		return errors.Fatal("nothing to do!")
 	}
 	if len(opts.SetTags) != 0 && (len(opts.AddTags) != 0 || len(opts.RemoveTags) != 0) {
 		return errors.Fatal("--set and --add/--remove cannot be given at the same time")
 	}
 
+	setLabels, err := restic.ParseLabels(opts.SetLabels)
+	if err != nil {
+		return errors.Fatalf("invalid --set-label: %v", err)
+	}
+	for _, key := range opts.RemoveLabels {
+		if _, ok := setLabels[key]; ok {
+			return errors.Fatalf("label %q cannot be set and removed at the same time", key)
+		}
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
 	}
 
+	if err = repo.CheckCapabilities(repository.CapabilityForget); err != nil {
+		return err
+	}
+
 	if !gopts.NoLock {
 		Verbosef("create exclusive lock for repository\n")
 		lock, err := lockRepoExclusive(gopts.ctx, repo)
@@ -129,8 +170,8 @@ func runTag(opts TagOptions, gopts GlobalOptions, args []string) error {
 	changeCnt := 0
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
-	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
-		changed, err := changeTags(ctx, repo, sn, opts.SetTags.Flatten(), opts.AddTags.Flatten(), opts.RemoveTags.Flatten())
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, opts.Labels, args) {
+		changed, err := changeTags(ctx, repo, sn, opts.SetTags.Flatten(), opts.AddTags.Flatten(), opts.RemoveTags.Flatten(), setLabels, opts.RemoveLabels)
 		if err != nil {
 			Warnf("unable to modify the tags for snapshot ID %q, ignoring: %v\n", sn.ID(), err)
 			continue
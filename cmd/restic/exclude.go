@@ -1,20 +1,25 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"os/user"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/textfile"
 )
 
 type rejectionCache struct {
@@ -267,6 +272,146 @@ func (m DeviceMap) IsAllowed(item string, deviceID uint64) (bool, error) {
 	return false, fmt.Errorf("item %v (device ID %v) not found, deviceMap: %v", item, deviceID, m)
 }
 
+// ignoreRule is a single pattern read from an ignore file.
+type ignoreRule struct {
+	pattern []filter.Pattern
+	negate  bool // re-include matching items
+	dirOnly bool // only match directories
+}
+
+// parseIgnoreFile parses the gitignore-style patterns in data. Empty lines and
+// lines starting with a # are ignored, a leading ! negates a pattern and a
+// trailing slash restricts it to directories. Patterns which contain a slash
+// except at the end are anchored at the directory of the ignore file, others
+// match at any depth below it.
+func parseIgnoreFile(data []byte) (rules []ignoreRule, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+
+		if strings.Contains(line, "/") && !strings.HasPrefix(line, "/") {
+			line = "/" + line
+		}
+
+		if line == "" || line == "/" {
+			continue
+		}
+
+		rule.pattern = filter.ParsePatterns([]string{line})
+		rules = append(rules, rule)
+	}
+
+	return rules, scanner.Err()
+}
+
+// ignoreFileCache stores the rules of the ignore files per directory.
+type ignoreFileCache struct {
+	filename string
+
+	m     sync.Mutex
+	rules map[string][]ignoreRule
+}
+
+// Get returns the rules of the ignore file in dir, which are loaded on first
+// access. Errors are reported as warnings.
+func (c *ignoreFileCache) Get(dir string) []ignoreRule {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if rules, ok := c.rules[dir]; ok {
+		return rules
+	}
+
+	var rules []ignoreRule
+	filename := filepath.Join(dir, c.filename)
+	data, err := textfile.Read(filename)
+	if err == nil {
+		debug.Log("using ignore file %v", filename)
+		rules, err = parseIgnoreFile(data)
+	}
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		Warnf("could not read ignore file: %v\n", err)
+	}
+
+	c.rules[dir] = rules
+	return rules
+}
+
+// rejectByIgnoreFile returns a RejectFunc which rejects items that are
+// excluded by the ignore files called filename in the directories above them.
+// The rules of all ignore files are evaluated from the root directory down to
+// the directory of the item, the last matching rule decides.
+func rejectByIgnoreFile(filename string) (RejectFunc, error) {
+	if filename == "" || strings.ContainsAny(filename, `/\`) {
+		return nil, errors.Errorf("invalid name for ignore file: %q", filename)
+	}
+
+	cache := &ignoreFileCache{
+		filename: filename,
+		rules:    make(map[string][]ignoreRule),
+	}
+
+	return func(item string, fi os.FileInfo) bool {
+		var dirs []string
+		for dir := filepath.Dir(item); ; dir = filepath.Dir(dir) {
+			dirs = append(dirs, dir)
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+
+		rejected := false
+		for i := len(dirs) - 1; i >= 0; i-- {
+			rules := cache.Get(dirs[i])
+			if len(rules) == 0 {
+				continue
+			}
+
+			rel, err := filepath.Rel(dirs[i], item)
+			if err != nil {
+				continue
+			}
+			rel = "/" + filepath.ToSlash(rel)
+
+			for _, rule := range rules {
+				if rule.dirOnly && !fi.IsDir() {
+					continue
+				}
+
+				matched, err := filter.List(rule.pattern, rel)
+				if err != nil {
+					Warnf("error for ignore pattern in %v: %v\n", filepath.Join(dirs[i], filename), err)
+					continue
+				}
+				if matched {
+					rejected = !rule.negate
+				}
+			}
+		}
+
+		if rejected {
+			debug.Log("path %q excluded by an ignore file", item)
+		}
+		return rejected
+	}, nil
+}
+
 // rejectByDevice returns a RejectFunc that rejects files which are on a
 // different file systems than the files/dirs in samples.
 func rejectByDevice(samples []string) (RejectFunc, error) {
@@ -360,6 +505,172 @@ func rejectResticCache(repo *repository.Repository) (RejectByNameFunc, error) {
 	}, nil
 }
 
+// rejectByIncludePattern returns a RejectFunc which rejects items that match
+// none of the patterns. The patterns in insensitivePatterns ignore the case of
+// filenames. Directories are only rejected if no item below them can match, so
+// that the parent directories of included items are kept.
+func rejectByIncludePattern(patterns, insensitivePatterns []string) RejectFunc {
+	for index, path := range insensitivePatterns {
+		insensitivePatterns[index] = strings.ToLower(path)
+	}
+
+	parsedPatterns := filter.ParsePatterns(patterns)
+	parsedInsensitivePatterns := filter.ParsePatterns(insensitivePatterns)
+
+	return func(item string, fi os.FileInfo) bool {
+		matched, childMayMatch, err := filter.ListWithChild(parsedPatterns, item)
+		if err != nil {
+			Warnf("error for include pattern: %v", err)
+		}
+
+		if !matched {
+			m, c, err := filter.ListWithChild(parsedInsensitivePatterns, strings.ToLower(item))
+			if err != nil {
+				Warnf("error for include pattern: %v", err)
+			}
+			matched = m
+			childMayMatch = childMayMatch || c
+		}
+
+		if matched || (fi.IsDir() && childMayMatch) {
+			return false
+		}
+
+		debug.Log("path %q is not included by an include pattern", item)
+		return true
+	}
+}
+
+// rejectByAge returns a RejectFunc which rejects files that were modified
+// before now minus olderThan or after now minus newerThan. The durations are
+// parsed with restic.ParseDuration, empty strings disable the checks.
+// Directories are never rejected.
+func rejectByAge(olderThan, newerThan string, now time.Time) (RejectFunc, error) {
+	limit := func(s string) (time.Time, error) {
+		if s == "" {
+			return time.Time{}, nil
+		}
+
+		d, err := restic.ParseDuration(s)
+		if err != nil {
+			return time.Time{}, errors.Fatalf("invalid duration %q: %v", s, err)
+		}
+
+		return now.AddDate(-d.Years, -d.Months, -d.Days).Add(time.Duration(-d.Hours) * time.Hour), nil
+	}
+
+	oldest, err := limit(olderThan)
+	if err != nil {
+		return nil, err
+	}
+	newest, err := limit(newerThan)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(item string, fi os.FileInfo) bool {
+		// directory will be ignored
+		if fi.IsDir() {
+			return false
+		}
+
+		if !oldest.IsZero() && fi.ModTime().Before(oldest) {
+			debug.Log("file %s is older than %v", item, oldest)
+			return true
+		}
+		if !newest.IsZero() && fi.ModTime().After(newest) {
+			debug.Log("file %s is newer than %v", item, newest)
+			return true
+		}
+
+		return false
+	}, nil
+}
+
+// rejectByOwner returns a RejectFunc which rejects files and directories
+// owned by one of the users, which are given as names or numeric IDs.
+func rejectByOwner(owners []string) (RejectFunc, error) {
+	uids := make(map[uint32]struct{}, len(owners))
+	for _, owner := range owners {
+		id := owner
+		if _, err := strconv.ParseUint(owner, 10, 32); err != nil {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return nil, errors.Fatalf("unknown owner %q: %v", owner, err)
+			}
+			id = u.Uid
+		}
+
+		uid, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, errors.Fatalf("invalid uid %q for owner %q", id, owner)
+		}
+		uids[uint32(uid)] = struct{}{}
+	}
+
+	return func(item string, fi os.FileInfo) bool {
+		if _, ok := uids[fs.ExtendedStat(fi).UID]; ok {
+			debug.Log("path %q excluded by its owner", item)
+			return true
+		}
+		return false
+	}, nil
+}
+
+// fileTypes maps the names of the node types to functions which test whether
+// a file mode belongs to that type.
+var fileTypes = map[string]func(os.FileMode) bool{
+	"file":    os.FileMode.IsRegular,
+	"symlink": func(m os.FileMode) bool { return m&os.ModeSymlink != 0 },
+	"dev":     func(m os.FileMode) bool { return m&os.ModeDevice != 0 && m&os.ModeCharDevice == 0 },
+	"chardev": func(m os.FileMode) bool { return m&os.ModeDevice != 0 && m&os.ModeCharDevice != 0 },
+	"fifo":    func(m os.FileMode) bool { return m&os.ModeNamedPipe != 0 },
+	"socket":  func(m os.FileMode) bool { return m&os.ModeSocket != 0 },
+}
+
+// rejectByType returns a RejectFunc which rejects items of the given types.
+// Directories cannot be rejected by their type.
+func rejectByType(types []string) (RejectFunc, error) {
+	var tests []func(os.FileMode) bool
+	for _, tpe := range types {
+		test, ok := fileTypes[tpe]
+		if !ok {
+			return nil, errors.Fatalf("invalid file type %q, must be one of file, symlink, dev, chardev, fifo or socket", tpe)
+		}
+		tests = append(tests, test)
+	}
+
+	return func(item string, fi os.FileInfo) bool {
+		for _, test := range tests {
+			if test(fi.Mode()) {
+				debug.Log("path %q excluded by its type", item)
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// rejectNoDump returns a RejectFunc which rejects files and directories with
+// the nodump attribute.
+func rejectNoDump() RejectFunc {
+	return func(item string, fi os.FileInfo) bool {
+		if !fi.Mode().IsRegular() && !fi.IsDir() {
+			return false
+		}
+
+		nodump, err := fs.HasNoDumpFlag(item)
+		if err != nil {
+			debug.Log("unable to read flags of %v: %v", item, err)
+			return false
+		}
+		if nodump {
+			debug.Log("path %q excluded by the nodump attribute", item)
+		}
+		return nodump
+	}
+}
+
 func rejectBySize(maxSizeStr string) (RejectFunc, error) {
 	maxSize, err := parseSizeStr(maxSizeStr)
 	if err != nil {
@@ -4,7 +4,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/restic/restic/internal/test"
 )
@@ -190,6 +193,73 @@ func TestMultipleIsExcludedByFile(t *testing.T) {
 	}
 }
 
+func TestRejectByIgnoreFile(t *testing.T) {
+	tempDir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	ignoreFiles := map[string]string{
+		".resticignore":         "# comment\n*.log\n!keep.log\nbuild/\n/top\n",
+		"project/.resticignore": "node_modules/\n!debug.log\ndocs/*.tmp\n",
+	}
+
+	files := []struct {
+		path string
+		incl bool
+	}{
+		{".resticignore", true},
+		{"a.txt", true},
+		{"x.log", false},
+		{"keep.log", true},
+		{"top", false},
+		{"sub/top", true},
+		{"sub/build", true},
+		{"build/out", false},
+		{"buildfile", true},
+		{"project/.resticignore", true},
+		{"project/node_modules/pkg/index.js", false},
+		{"project/debug.log", true},
+		{"project/other.log", false},
+		{"project/docs/a.tmp", false},
+		{"project/docs/sub/b.tmp", true},
+	}
+	var errs []error
+	for _, f := range files {
+		p := filepath.Join(tempDir, filepath.FromSlash(f.path))
+		errs = append(errs, os.MkdirAll(filepath.Dir(p), 0700))
+		errs = append(errs, ioutil.WriteFile(p, []byte(ignoreFiles[f.path]), 0600))
+	}
+	test.OKs(t, errs)
+
+	reject, err := rejectByIgnoreFile(".resticignore")
+	test.OK(t, err)
+
+	m := make(map[string]bool)
+	walk := func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		excluded := reject(p, fi)
+		m[p] = !excluded
+		if excluded && fi.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+	test.OK(t, filepath.Walk(tempDir, walk))
+
+	for _, f := range files {
+		p := filepath.Join(tempDir, filepath.FromSlash(f.path))
+		if m[p] != f.incl {
+			t.Errorf("inclusion status of %s is wrong: want %v, got %v", f.path, f.incl, m[p])
+		}
+	}
+
+	for _, name := range []string{"", "dir/.resticignore"} {
+		_, err := rejectByIgnoreFile(name)
+		test.Assert(t, err != nil, "invalid name %q was accepted", name)
+	}
+}
+
 func TestParseSizeStr(t *testing.T) {
 	sizeStrTests := []struct {
 		in       string
@@ -319,6 +389,175 @@ func TestIsExcludedByFileSize(t *testing.T) {
 	}
 }
 
+func TestRejectByIncludePattern(t *testing.T) {
+	tempDir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	files := []struct {
+		path string
+		incl bool
+	}{
+		{"main.go", true},
+		{"README", false},
+		{"src/foo.go", true},
+		{"src/foo.c", false},
+		{"src/FOO.GO", true},
+		{"docs/index.md", true},
+		{"docs/sub/other.txt", true},
+		{"other/file.txt", false},
+	}
+	var errs []error
+	for _, f := range files {
+		p := filepath.Join(tempDir, filepath.FromSlash(f.path))
+		errs = append(errs, os.MkdirAll(filepath.Dir(p), 0700))
+		errs = append(errs, ioutil.WriteFile(p, []byte(f.path), 0600))
+	}
+	test.OKs(t, errs)
+
+	reject := rejectByIncludePattern([]string{"*.go", filepath.Join(tempDir, "docs")}, []string{"*.GO"})
+
+	m := make(map[string]bool)
+	walk := func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		excluded := reject(p, fi)
+		m[p] = !excluded
+		if excluded && fi.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+	test.OK(t, filepath.Walk(tempDir, walk))
+
+	for _, f := range files {
+		p := filepath.Join(tempDir, filepath.FromSlash(f.path))
+		if m[p] != f.incl {
+			t.Errorf("inclusion status of %s is wrong: want %v, got %v", f.path, f.incl, m[p])
+		}
+	}
+
+	// parent directories of included files are kept
+	test.Assert(t, m[filepath.Join(tempDir, "src")], "parent directory src was rejected")
+
+	// directories below which nothing can match are rejected as a whole
+	reject = rejectByIncludePattern([]string{filepath.Join(tempDir, "docs")}, nil)
+	fi, err := os.Lstat(filepath.Join(tempDir, "other"))
+	test.OK(t, err)
+	test.Assert(t, reject(filepath.Join(tempDir, "other"), fi), "directory other was not rejected")
+}
+
+func TestRejectByAge(t *testing.T) {
+	tempDir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	now := time.Now()
+	files := []struct {
+		path  string
+		mtime time.Time
+	}{
+		{"old", now.Add(-72 * time.Hour)},
+		{"recent", now.Add(-30 * time.Hour)},
+		{"new", now.Add(-1 * time.Hour)},
+	}
+	for _, f := range files {
+		p := filepath.Join(tempDir, f.path)
+		test.OK(t, ioutil.WriteFile(p, []byte(f.path), 0600))
+		test.OK(t, os.Chtimes(p, f.mtime, f.mtime))
+	}
+	test.OK(t, os.Chtimes(tempDir, files[0].mtime, files[0].mtime))
+
+	var tests = []struct {
+		olderThan, newerThan string
+		want                 []string
+	}{
+		{"2d", "", []string{"recent", "new"}},
+		{"", "1d", []string{"old", "recent"}},
+		{"2d", "1d", []string{"recent"}},
+		{"1d5h", "2h", []string{}},
+	}
+
+	for _, tc := range tests {
+		reject, err := rejectByAge(tc.olderThan, tc.newerThan, now)
+		test.OK(t, err)
+
+		fi, err := os.Lstat(tempDir)
+		test.OK(t, err)
+		test.Assert(t, !reject(tempDir, fi), "directory was rejected")
+
+		included := []string{}
+		for _, f := range files {
+			p := filepath.Join(tempDir, f.path)
+			fi, err := os.Lstat(p)
+			test.OK(t, err)
+			if !reject(p, fi) {
+				included = append(included, f.path)
+			}
+		}
+		test.Equals(t, tc.want, included)
+	}
+
+	_, err := rejectByAge("foo", "", now)
+	test.Assert(t, err != nil, "invalid duration was accepted")
+}
+
+func TestRejectByType(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks are not supported on Windows")
+	}
+
+	tempDir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	file := filepath.Join(tempDir, "file")
+	link := filepath.Join(tempDir, "link")
+	test.OK(t, ioutil.WriteFile(file, []byte("foo"), 0600))
+	test.OK(t, os.Symlink("file", link))
+
+	reject, err := rejectByType([]string{"symlink", "fifo"})
+	test.OK(t, err)
+
+	for _, item := range []struct {
+		path   string
+		reject bool
+	}{
+		{tempDir, false},
+		{file, false},
+		{link, true},
+	} {
+		fi, err := os.Lstat(item.path)
+		test.OK(t, err)
+		test.Equals(t, item.reject, reject(item.path, fi))
+	}
+
+	_, err = rejectByType([]string{"dir"})
+	test.Assert(t, err != nil, "type dir was accepted")
+}
+
+func TestRejectByOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("owners are not supported on Windows")
+	}
+
+	tempDir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	fi, err := os.Lstat(tempDir)
+	test.OK(t, err)
+	uid := strconv.Itoa(os.Getuid())
+
+	reject, err := rejectByOwner([]string{uid})
+	test.OK(t, err)
+	test.Assert(t, reject(tempDir, fi), "directory owned by %v was not rejected", uid)
+
+	reject, err = rejectByOwner([]string{strconv.Itoa(os.Getuid() + 1)})
+	test.OK(t, err)
+	test.Assert(t, !reject(tempDir, fi), "directory owned by %v was rejected", uid)
+
+	_, err = rejectByOwner([]string{"restic-test-user-does-not-exist"})
+	test.Assert(t, err != nil, "unknown user was accepted")
+}
+
 func TestDeviceMap(t *testing.T) {
 	deviceMap := DeviceMap{
 		filepath.FromSlash("/"):          1,
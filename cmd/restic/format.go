@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/restic/restic/internal/restic"
@@ -82,6 +83,18 @@ func formatNode(path string, n *restic.Node, long bool) string {
 		mode = os.ModeSocket
 	}
 
+	// the creation time and the inode flags are only known for some items
+	var extra []string
+	if !n.BirthTime.IsZero() {
+		extra = append(extra, "created "+n.BirthTime.Local().Format(TimeFormat))
+	}
+	if n.InodeFlags != 0 {
+		extra = append(extra, "flags "+restic.InodeFlagsString(n.InodeFlags))
+	}
+	if len(extra) > 0 {
+		target += " [" + strings.Join(extra, ", ") + "]"
+	}
+
 	return fmt.Sprintf("%s %5d %5d %6d %s %s%s",
 		mode|n.Mode, n.UID, n.GID, n.Size,
 		n.ModTime.Local().Format(TimeFormat), path,
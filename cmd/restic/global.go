@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -67,6 +68,9 @@ type GlobalOptions struct {
 	LimitUploadKb   int
 	LimitDownloadKb int
 
+	Compression repository.CompressionMode
+	PackSize    uint
+
 	ctx      context.Context
 	password string
 	stdout   io.Writer
@@ -119,6 +123,20 @@ func init() {
 	f.IntVar(&globalOptions.LimitUploadKb, "limit-upload", 0, "limits uploads to a maximum rate in KiB/s. (default: unlimited)")
 	f.IntVar(&globalOptions.LimitDownloadKb, "limit-download", 0, "limits downloads to a maximum rate in KiB/s. (default: unlimited)")
 	f.StringSliceVarP(&globalOptions.Options, "option", "o", []string{}, "set extended option (`key=value`, can be specified multiple times)")
+	f.Var(&globalOptions.Compression, "compression", "compression mode (only available for repository format version 2), one of (auto|off|max) (default: $RESTIC_COMPRESSION)")
+
+	f.UintVar(&globalOptions.PackSize, "pack-size", 0, "set target pack `size` in MiB, between 4 and 128 (default: $RESTIC_PACK_SIZE or the repository default)")
+
+	comp := os.Getenv("RESTIC_COMPRESSION")
+	if comp != "" {
+		// ignore error as there's no good way to handle it, invalid values
+		// are reported when the repository is opened
+		_ = globalOptions.Compression.Set(comp)
+	}
+
+	// parse target pack size from env, on error the default value will be used
+	targetPackSize, _ := strconv.ParseUint(os.Getenv("RESTIC_PACK_SIZE"), 10, 32)
+	globalOptions.PackSize = uint(targetPackSize)
 
 	restoreTerminal()
 }
@@ -425,8 +443,9 @@ func ReadRepo(opts GlobalOptions) (string, error) {
 
 const maxKeys = 20
 
-// OpenRepository reads the password and opens the repository.
-func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
+// newRepository returns a repository for the backend configured in opts. No
+// key has been loaded yet.
+func newRepository(opts GlobalOptions) (*repository.Repository, error) {
 	repo, err := ReadRepo(opts)
 	if err != nil {
 		return nil, err
@@ -449,7 +468,39 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 		}
 	}
 
-	s := repository.New(be)
+	repoOpts, err := repositoryOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return repository.New(be, repoOpts), nil
+}
+
+// repositoryOptions validates the global options which configure how data is
+// written to the repository.
+func repositoryOptions(opts GlobalOptions) (repository.Options, error) {
+	if opts.Compression == repository.CompressionInvalid {
+		return repository.Options{}, errors.Fatalf("invalid compression mode, must be one of (auto|off|max)")
+	}
+
+	packSize := opts.PackSize * 1024 * 1024
+	if opts.PackSize != 0 && (packSize < repository.MinPackSize || packSize > repository.MaxPackSize) {
+		return repository.Options{}, errors.Fatalf("pack size must be between %v and %v MiB",
+			repository.MinPackSize/(1024*1024), repository.MaxPackSize/(1024*1024))
+	}
+
+	return repository.Options{
+		Compression: opts.Compression,
+		PackSize:    packSize,
+	}, nil
+}
+
+// OpenRepository reads the password and opens the repository.
+func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
+	s, err := newRepository(opts)
+	if err != nil {
+		return nil, err
+	}
 
 	passwordTriesLeft := 1
 	if stdinIsTerminal() && opts.password == "" {
@@ -489,6 +540,10 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 		}
 	}
 
+	if s.Config().Version < 2 && opts.Compression != repository.CompressionAuto {
+		Warnf("repository format version %v does not support compression, ignoring --compression\n", s.Config().Version)
+	}
+
 	if opts.NoCache {
 		return s, nil
 	}
@@ -742,6 +797,12 @@ func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend,
 
 	// check if config is there
 	fi, err := be.Stat(globalOptions.ctx, restic.Handle{Type: restic.ConfigFile})
+	if err != nil && be.IsNotExist(err) && hasKeys(globalOptions.ctx, be) {
+		// the config is restored by the key of an interrupted master key
+		// rotation when the repository is opened
+		debug.Log("config is missing, but the repository contains keys")
+		return be, nil
+	}
 	if err != nil {
 		return nil, errors.Fatalf("unable to open config file: %v\nIs there a repository at the following location?\n%v", err, location.StripPassword(s))
 	}
@@ -753,6 +814,20 @@ func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend,
 	return be, nil
 }
 
+// hasKeys returns true if the backend contains at least one key file.
+func hasKeys(ctx context.Context, be restic.Backend) bool {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := false
+	_ = be.List(ctx, restic.KeyFile, func(fi restic.FileInfo) error {
+		found = true
+		cancel()
+		return nil
+	})
+	return found
+}
+
 // Create the backend specified by URI.
 func create(s string, opts options.Options) (restic.Backend, error) {
 	debug.Log("parsing location %v", s)
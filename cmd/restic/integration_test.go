@@ -1,6 +1,7 @@
 package main
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
 	"context"
@@ -19,6 +20,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/fs"
@@ -345,6 +347,138 @@ func testBackup(t *testing.T, useFsSnapshot bool) {
 	testRunCheck(t, env.gopts)
 }
 
+func TestBackupDryRun(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{DryRun: true}
+
+	countFiles := func() (packs, indexes, snapshots int) {
+		return len(testRunList(t, "packs", env.gopts)),
+			len(testRunList(t, "index", env.gopts)),
+			len(testRunList(t, "snapshots", env.gopts))
+	}
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	packs, indexes, snapshots := countFiles()
+	rtest.Assert(t, packs == 0 && indexes == 0 && snapshots == 0,
+		"dry run wrote %d packs, %d indexes and %d snapshots", packs, indexes, snapshots)
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	packs, indexes, snapshots = countFiles()
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	packs2, indexes2, snapshots2 := countFiles()
+	rtest.Assert(t, packs == packs2 && indexes == indexes2 && snapshots == snapshots2,
+		"dry run modified the repository")
+	testRunCheck(t, env.gopts)
+}
+
+func TestBackupStdinFromCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires sh")
+	}
+
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	globalOptions.stderr = ioutil.Discard
+	defer func() {
+		globalOptions.stderr = os.Stderr
+	}()
+
+	opts := BackupOptions{StdinCommand: true, StdinFilename: "stdin"}
+	command := []string{"sh", "-c", "echo foo; echo bar"}
+	testRunBackup(t, "", command, opts, env.gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	sn, err := restic.LoadSnapshot(env.gopts.ctx, repo, snapshotIDs[0])
+	rtest.OK(t, err)
+	rtest.Equals(t, command, sn.Command)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+	buf, err := ioutil.ReadFile(filepath.Join(restoredir, "stdin"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "foo\nbar\n", string(buf))
+
+	// a failing command must not create a snapshot
+	err = testRunBackupAssumeFailure(t, "", []string{"sh", "-c", "echo foo; exit 1"}, opts, env.gopts)
+	rtest.Assert(t, err != nil, "backup of a failing command did not return an error")
+	rtest.Equals(t, 1, len(testRunList(t, "snapshots", env.gopts)))
+}
+
+func TestBackupFromTar(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	mtime := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+	files := []struct {
+		hdr  tar.Header
+		data string
+	}{
+		{tar.Header{Name: "dir/file1", Typeflag: tar.TypeReg, Mode: 0644, ModTime: mtime.Add(-time.Hour)}, "content of file1"},
+		{tar.Header{Name: "dir/sub/file2", Typeflag: tar.TypeReg, Mode: 0600, ModTime: mtime}, "file2"},
+		{tar.Header{Name: "dir/hardlink", Typeflag: tar.TypeLink, Linkname: "dir/file1", ModTime: mtime}, ""},
+		{tar.Header{Name: "dir/symlink", Typeflag: tar.TypeSymlink, Linkname: "file1", Mode: 0777, ModTime: mtime}, ""},
+	}
+
+	tarfile := filepath.Join(env.base, "test.tar")
+	f, err := os.Create(tarfile)
+	rtest.OK(t, err)
+	tw := tar.NewWriter(f)
+	for _, file := range files {
+		hdr := file.hdr
+		hdr.Size = int64(len(file.data))
+		rtest.OK(t, tw.WriteHeader(&hdr))
+		_, err = tw.Write([]byte(file.data))
+		rtest.OK(t, err)
+	}
+	rtest.OK(t, tw.Close())
+	rtest.OK(t, f.Close())
+
+	testRunBackup(t, "", nil, BackupOptions{FromTar: tarfile}, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	sn, err := restic.LoadSnapshot(env.gopts.ctx, repo, snapshotIDs[0])
+	rtest.OK(t, err)
+	rtest.Assert(t, sn.Time.Equal(mtime), "snapshot time %v is not the newest mtime %v", sn.Time, mtime)
+	rtest.Equals(t, []string{"/"}, sn.Paths)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+
+	for _, name := range []string{"dir/file1", "dir/hardlink"} {
+		buf, err := ioutil.ReadFile(filepath.Join(restoredir, name))
+		rtest.OK(t, err)
+		rtest.Equals(t, "content of file1", string(buf))
+	}
+
+	fi, err := os.Stat(filepath.Join(restoredir, "dir", "sub", "file2"))
+	rtest.OK(t, err)
+	rtest.Assert(t, fi.ModTime().Equal(mtime), "wrong mtime %v for file2", fi.ModTime())
+	if runtime.GOOS != "windows" {
+		rtest.Equals(t, os.FileMode(0600), fi.Mode())
+
+		target, err := os.Readlink(filepath.Join(restoredir, "dir", "symlink"))
+		rtest.OK(t, err)
+		rtest.Equals(t, "file1", target)
+	}
+}
+
 func TestBackupNonExistingFile(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -848,6 +982,193 @@ func TestInitCopyChunkerParams(t *testing.T) {
 		otherRepo.Config().ChunkerPolynomial)
 }
 
+func TestBackupCheckpoints(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+	firstID := snapshotIDs[0]
+
+	// save a checkpoint, as if a later backup had been interrupted
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, firstID)
+	rtest.OK(t, err)
+	sn.Time = sn.Time.Add(time.Nanosecond)
+	sn.Parent = &firstID
+	sn.ChainPrev, sn.ChainDigest = nil, nil
+	sn.Incomplete = true
+	checkpointID, err := repo.SaveJSONUnpacked(context.TODO(), restic.SnapshotFile, sn)
+	rtest.OK(t, err)
+
+	// the newest checkpoint is not subject to the policy
+	rtest.OK(t, runForget(ForgetOptions{Last: 1}, env.gopts, nil))
+	_, snapshots := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, len(snapshots) == 2, "expected two snapshots, got %v", snapshots)
+	rtest.Assert(t, snapshots[checkpointID].Incomplete, "checkpoint %v is not marked as incomplete", checkpointID.Str())
+
+	// the checkpoint is used as the parent and removed afterwards, also by
+	// a backup which does not save checkpoints itself
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	newest, snapshots := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, len(snapshots) == 2, "expected two snapshots, got %v", snapshots)
+	_, ok := snapshots[checkpointID]
+	rtest.Assert(t, !ok, "checkpoint %v has not been removed", checkpointID.Str())
+	rtest.Assert(t, !newest.Incomplete, "new snapshot is marked as incomplete")
+	rtest.Assert(t, newest.Parent != nil && newest.Parent.Equal(firstID),
+		"expected parent %v, got %v", firstID.Str(), newest.Parent)
+
+	testRunCheck(t, env.gopts)
+}
+
+func TestBackupSkipIfUnchanged(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{SkipIfUnchanged: true}
+
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.JSON = true
+	gopts.stdout = buf
+	testRunBackup(t, "", []string{env.testdata}, opts, gopts)
+	snapshotIDs = testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+	rtest.Assert(t, strings.Contains(buf.String(), `"skipped":true`),
+		"summary does not report the skipped snapshot: %v", buf.String())
+
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(env.testdata, "0", "new"), []byte("new file"), 0644))
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	snapshotIDs = testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots, got %v", snapshotIDs)
+}
+
+func TestBackupSecondaryRepo(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+	env3, cleanup3 := withTestEnvironment(t)
+	defer cleanup3()
+
+	testSetupBackupData(t, env)
+
+	// the second repository uses other chunker parameters, the third one
+	// the parameters of the primary repository
+	testRunInit(t, env2.gopts)
+	initOpts := InitOptions{
+		secondaryRepoOptions: secondaryRepoOptions{
+			Repo:     env.gopts.Repo,
+			password: env.gopts.password,
+		},
+		CopyChunkerParameters: true,
+	}
+	rtest.OK(t, runInit(initOpts, env3.gopts, nil))
+
+	opts := BackupOptions{
+		secondaryRepoOptions: secondaryRepoOptions{
+			password: env2.gopts.password,
+		},
+		Repos2:    []string{env2.gopts.Repo},
+		AlsoRepos: []string{env3.gopts.Repo},
+	}
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(env.testdata, "0", "new"), []byte("new file"), 0644))
+
+	buf := bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.JSON = true
+	gopts.stdout = buf
+	testRunBackup(t, "", []string{env.testdata}, opts, gopts)
+
+	newest, snapshots := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, len(snapshots) == 2, "expected two snapshots, got %v", snapshots)
+
+	for _, menv := range []*testEnvironment{env2, env3} {
+		newest2, snapshots2 := testRunSnapshots(t, menv.gopts)
+		rtest.Assert(t, len(snapshots2) == 2, "expected two snapshots in the secondary repository, got %v", snapshots2)
+
+		// each repository uses its own parent snapshot
+		_, ok := snapshots2[*newest2.Parent]
+		rtest.Assert(t, ok, "parent %v is not contained in the secondary repository", newest2.Parent.Str())
+
+		rtest.Assert(t, strings.Contains(buf.String(), fmt.Sprintf(`"snapshot_id":"%v"`, newest2.ID.Str())),
+			"summary does not contain snapshot %v: %v", newest2.ID.Str(), buf.String())
+
+		testRunCheck(t, menv.gopts)
+	}
+
+	// the data of the repositories with the same chunker parameters is
+	// split the same way
+	newest3, _ := testRunSnapshots(t, env3.gopts)
+	rtest.Equals(t, *newest.Tree, *newest3.Tree)
+
+	testRunCheck(t, env.gopts)
+}
+
+func TestBackupHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hooks are shell scripts")
+	}
+
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+
+	preEnv := filepath.Join(env.base, "pre.env")
+	postEnv := filepath.Join(env.base, "post.env")
+	readEnv := func(filename string) string {
+		buf, err := ioutil.ReadFile(filename)
+		rtest.OK(t, err)
+		return string(buf)
+	}
+
+	opts := BackupOptions{
+		PreHook:  fmt.Sprintf("sh -c 'env > %q'", preEnv),
+		PostHook: fmt.Sprintf("sh -c 'env > %q'", postEnv),
+	}
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	pre := readEnv(preEnv)
+	rtest.Assert(t, strings.Contains(pre, "RESTIC_HOOK=pre\n"), "RESTIC_HOOK missing in %q", pre)
+	rtest.Assert(t, strings.Contains(pre, "RESTIC_REPOSITORY="+env.gopts.Repo+"\n"), "RESTIC_REPOSITORY missing in %q", pre)
+
+	post := readEnv(postEnv)
+	for _, s := range []string{
+		"RESTIC_HOOK=post\n",
+		"RESTIC_EXIT_STATUS=0\n",
+		"RESTIC_SNAPSHOT_ID=" + snapshotIDs[0].String() + "\n",
+		"RESTIC_FILES_NEW=",
+		"RESTIC_DATA_ADDED=",
+	} {
+		rtest.Assert(t, strings.Contains(post, s), "%q missing in %q", s, post)
+	}
+
+	// a failing pre-hook aborts the backup, the post-hook is run anyway
+	opts.PreHook = "false"
+	err := testRunBackupAssumeFailure(t, "", []string{env.testdata}, opts, env.gopts)
+	rtest.Assert(t, err != nil, "backup with failing pre-hook did not fail")
+	rtest.Equals(t, 1, len(testRunList(t, "snapshots", env.gopts)))
+	post = readEnv(postEnv)
+	rtest.Assert(t, strings.Contains(post, "RESTIC_EXIT_STATUS=1\n"), "RESTIC_EXIT_STATUS missing in %q", post)
+	rtest.Assert(t, !strings.Contains(post, "RESTIC_SNAPSHOT_ID="), "unexpected RESTIC_SNAPSHOT_ID in %q", post)
+
+	opts.PreHookFailure = hookFailureContinue
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	rtest.Equals(t, 2, len(testRunList(t, "snapshots", env.gopts)))
+}
+
 func testRunTag(t testing.TB, opts TagOptions, gopts GlobalOptions) {
 	rtest.OK(t, runTag(opts, gopts, []string{}))
 }
@@ -933,6 +1254,73 @@ func TestTag(t *testing.T) {
 		"expected original ID to be set to the first snapshot id")
 }
 
+func TestLabels(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{Labels: []string{"ticket=OPS-123"}, Description: "before the upgrade"}
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	_, snapmap := testRunSnapshots(t, env.gopts)
+	var labeled Snapshot
+	for _, sn := range snapmap {
+		if sn.Labels != nil {
+			labeled = sn
+		}
+	}
+	rtest.Equals(t, map[string]string{"ticket": "OPS-123"}, labeled.Labels)
+	rtest.Equals(t, "before the upgrade", labeled.Description)
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+
+	findLabeled := func(labels ...string) restic.IDs {
+		var ids restic.IDs
+		for sn := range FindFilteredSnapshots(env.gopts.ctx, repo, nil, nil, nil, labels, nil) {
+			ids = append(ids, *sn.ID())
+		}
+		return ids
+	}
+	rtest.Equals(t, restic.IDs{*labeled.ID}, findLabeled("ticket"))
+	rtest.Equals(t, restic.IDs{*labeled.ID}, findLabeled("ticket=OPS-123"))
+	rtest.Equals(t, 0, len(findLabeled("ticket=OPS-124")))
+
+	// the labels can be grouped by
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	globalOptions.JSON = true
+	err = runSnapshots(SnapshotOptions{GroupBy: "label:ticket"}, globalOptions, nil)
+	globalOptions.stdout = os.Stdout
+	globalOptions.JSON = env.gopts.JSON
+	rtest.OK(t, err)
+
+	var groups []SnapshotGroup
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &groups))
+	rtest.Equals(t, 2, len(groups))
+
+	// the description is shown in the list of snapshots
+	buf.Reset()
+	globalOptions.stdout = buf
+	err = runSnapshots(SnapshotOptions{}, globalOptions, nil)
+	globalOptions.stdout = os.Stdout
+	rtest.OK(t, err)
+	rtest.Assert(t, strings.Contains(buf.String(), "Description") && strings.Contains(buf.String(), "before the upgrade"),
+		"description not shown by snapshots: %v", buf.String())
+
+	testRunTag(t, TagOptions{SetLabels: []string{"ticket=OPS-124", "env=prod"}}, env.gopts)
+	testRunCheck(t, env.gopts)
+	rtest.Equals(t, 2, len(findLabeled("ticket=OPS-124", "env=prod")))
+
+	testRunTag(t, TagOptions{RemoveLabels: []string{"ticket", "env"}}, env.gopts)
+	testRunCheck(t, env.gopts)
+	newest, _ := testRunSnapshots(t, env.gopts)
+	rtest.Assert(t, newest.Labels == nil, "expected no labels, got %v", newest.Labels)
+	rtest.Equals(t, 0, len(findLabeled("ticket")))
+}
+
 func testRunKeyListOtherIDs(t testing.TB, gopts GlobalOptions) []string {
 	buf := bytes.NewBuffer(nil)
 
@@ -987,6 +1375,26 @@ func testRunKeyAddNewKeyUserHost(t testing.TB, gopts GlobalOptions) {
 	rtest.Equals(t, "example.com", key.Hostname)
 }
 
+func testRunKeyAddNewKeyArgon2id(t testing.TB, gopts GlobalOptions) {
+	testKeyNewPassword = "argon2id geheimnis"
+	defer func() {
+		testKeyNewPassword = ""
+		keyKDF = crypto.DefaultKDF
+	}()
+
+	rtest.OK(t, cmdKey.Flags().Parse([]string{"--kdf=argon2id"}))
+
+	t.Log("adding key with argon2id")
+	rtest.OK(t, runKey(gopts, []string{"add"}))
+
+	repo, err := OpenRepository(gopts)
+	rtest.OK(t, err)
+	key, err := repository.SearchKey(gopts.ctx, repo, testKeyNewPassword, 0, "")
+	rtest.OK(t, err)
+
+	rtest.Equals(t, crypto.KDFArgon2id, key.KDF)
+}
+
 func testRunKeyPasswd(t testing.TB, newPassword string, gopts GlobalOptions) {
 	testKeyNewPassword = newPassword
 	defer func() {
@@ -1031,6 +1439,301 @@ func TestKeyAddRemove(t *testing.T) {
 	testRunCheck(t, env.gopts)
 
 	testRunKeyAddNewKeyUserHost(t, env.gopts)
+	testRunKeyAddNewKeyArgon2id(t, env.gopts)
+}
+
+func testRunKeyAddCapabilities(t testing.TB, newPassword string, capabilities string, gopts GlobalOptions) {
+	testKeyNewPassword = newPassword
+	defer func() {
+		testKeyNewPassword = ""
+		keyCapabilities = "all"
+	}()
+
+	rtest.OK(t, cmdKey.Flags().Parse([]string{"--capabilities=" + capabilities}))
+	rtest.OK(t, runKey(gopts, []string{"add"}))
+}
+
+func TestKeyCapabilities(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	snapshotID := testRunList(t, "snapshots", env.gopts)[0]
+
+	testRunKeyAddCapabilities(t, "monitoring", "read", env.gopts)
+	testRunKeyAddCapabilities(t, "append", "append,read", env.gopts)
+
+	// a read-only key can inspect the repository, but not modify it
+	gopts := env.gopts
+	gopts.password = "monitoring"
+	testRunSnapshots(t, gopts)
+	testRunCheck(t, gopts)
+	rtest.Assert(t, testRunBackupAssumeFailure(t, "", []string{env.testdata}, BackupOptions{}, gopts) != nil,
+		"backup with a read-only key did not fail")
+	rtest.Assert(t, runKey(gopts, []string{"add"}) != nil,
+		"adding a key with a read-only key did not fail")
+
+	// an append-only key can add snapshots, but not remove them
+	gopts.password = "append"
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, gopts)
+	rtest.Assert(t, runForget(ForgetOptions{}, gopts, []string{snapshotID.String()}) != nil,
+		"forget with an append-only key did not fail")
+	rtest.Assert(t, runPrune(PruneOptions{MaxUnused: "5%"}, gopts) != nil,
+		"prune with an append-only key did not fail")
+
+	// changing the password keeps the capabilities
+	testRunKeyPasswd(t, "append2", gopts)
+	gopts.password = "append2"
+	repo, err := OpenRepository(gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{repository.CapabilityAppend, repository.CapabilityRead}, repo.Capabilities())
+}
+
+// failOnceBackend fails the first operation for which fail returns true.
+type failOnceBackend struct {
+	restic.Backend
+	fail   func(op string, h restic.Handle) bool
+	failed bool
+}
+
+func (be *failOnceBackend) check(op string, h restic.Handle) error {
+	if !be.failed && be.fail(op, h) {
+		be.failed = true
+		return errors.Errorf("%v %v failed", op, h)
+	}
+	return nil
+}
+
+func (be *failOnceBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if err := be.check("save", h); err != nil {
+		return err
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func (be *failOnceBackend) Remove(ctx context.Context, h restic.Handle) error {
+	if err := be.check("remove", h); err != nil {
+		return err
+	}
+	return be.Backend.Remove(ctx, h)
+}
+
+func TestKeyRotateMaster(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		fail func(op string, h restic.Handle) bool
+		// password to resume the rotation with
+		resumeWithNew bool
+	}{
+		{name: "complete"},
+		{
+			name: "interrupt-index",
+			fail: func(op string, h restic.Handle) bool {
+				return op == "save" && h.Type == restic.IndexFile
+			},
+		},
+		{
+			name: "interrupt-config",
+			fail: func(op string, h restic.Handle) bool {
+				return op == "save" && h.Type == restic.ConfigFile
+			},
+			resumeWithNew: true,
+		},
+		{
+			name: "interrupt-keys",
+			fail: func(op string, h restic.Handle) bool {
+				return op == "remove" && h.Type == restic.KeyFile
+			},
+			resumeWithNew: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			testKeyRotateMaster(t, test.fail, test.resumeWithNew)
+		})
+	}
+}
+
+func testKeyRotateMaster(t *testing.T, fail func(op string, h restic.Handle) bool, resumeWithNew bool) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	for i := 0; i < 3; i++ {
+		testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	}
+	// the tombstone of the removed snapshot is rewritten as well
+	testRunForget(t, env.gopts, testRunList(t, "snapshots", env.gopts)[0].String())
+	oldIDs := testRunList(t, "snapshots", env.gopts)
+	testRunKeyAddNewKey(t, "other password", env.gopts)
+
+	testKeyNewPassword = "rotated password"
+	defer func() {
+		testKeyNewPassword = ""
+	}()
+
+	oldPassword := env.gopts.password
+	if fail != nil {
+		gopts := env.gopts
+		gopts.backendTestHook = func(r restic.Backend) (restic.Backend, error) {
+			return &failOnceBackend{Backend: r, fail: fail}, nil
+		}
+		err := runKey(gopts, []string{"rotate-master"})
+		rtest.Assert(t, err != nil, "interrupted rotation did not return an error")
+		t.Log(err)
+
+		if resumeWithNew {
+			env.gopts.password = "rotated password"
+		} else {
+			// nothing readable with the old master key reveals the new one
+			repo, err := OpenRepository(env.gopts)
+			rtest.OK(t, err)
+			err = repo.List(env.gopts.ctx, restic.MigrationFile, func(id restic.ID, size int64) error {
+				_, err := repo.LoadAndDecrypt(env.gopts.ctx, nil, restic.MigrationFile, id)
+				rtest.Assert(t, errors.Cause(err) == crypto.ErrUnauthenticated,
+					"checkpoint %v can be decrypted with the old master key", id.Str())
+				return nil
+			})
+			rtest.OK(t, err)
+
+			testKeyNewPassword = "wrong password"
+			rtest.Assert(t, runKey(env.gopts, []string{"rotate-master"}) != nil,
+				"rotation was resumed with a different new password")
+			testKeyNewPassword = "rotated password"
+		}
+	}
+
+	rtest.OK(t, runKey(env.gopts, []string{"rotate-master"}))
+
+	env.gopts.password = "rotated password"
+	testRunCheck(t, env.gopts)
+	rtest.OK(t, runCheck(CheckOptions{Chain: true}, env.gopts, nil))
+
+	newIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Equals(t, len(oldIDs), len(newIDs))
+	for _, id := range newIDs {
+		rtest.Assert(t, !restic.NewIDSet(oldIDs...).Has(id), "snapshot %v was not rewritten", id.Str())
+	}
+	rtest.Equals(t, 1, len(testRunList(t, "keys", env.gopts)))
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, newIDs[0])
+	diff := directoriesContentsDiff(env.testdata, filepath.Join(restoredir, "testdata"))
+	rtest.Assert(t, diff == "", "directories are not equal: %v", diff)
+
+	for _, pw := range []string{oldPassword, "other password"} {
+		gopts := env.gopts
+		gopts.password = pw
+		_, err := OpenRepository(gopts)
+		rtest.Assert(t, err != nil, "repository can still be opened with old password %q", pw)
+	}
+}
+
+func TestWriteOnlyKey(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+
+	testKeyNewPassword = "write-only password"
+	defer func() {
+		testKeyNewPassword = ""
+		keyWriteOnly = false
+	}()
+	rtest.OK(t, cmdKey.Flags().Parse([]string{"--write-only"}))
+
+	err := runKey(env.gopts, []string{"add"})
+	rtest.Assert(t, err != nil, "write-only key added to repository without migration")
+
+	rtest.OK(t, runMigrate(MigrateOptions{}, env.gopts, []string{"write_only_keys"}))
+	rtest.OK(t, runKey(env.gopts, []string{"add"}))
+	keyWriteOnly = false
+
+	wgopts := env.gopts
+	wgopts.password = "write-only password"
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, wgopts)
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(env.testdata, "write-only"), []byte("new data\n"), 0644))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, wgopts)
+	rtest.Equals(t, 3, len(testRunList(t, "snapshots", env.gopts)))
+
+	// existing data cannot be read with the write-only key
+	err = runRestore(RestoreOptions{Target: filepath.Join(env.base, "restore-write-only")}, wgopts, []string{"latest"})
+	rtest.Assert(t, err != nil, "restore with write-only key did not fail")
+	err = runCheck(CheckOptions{ReadData: true}, wgopts, nil)
+	rtest.Assert(t, err != nil, "check with write-only key did not fail")
+	err = runKey(wgopts, []string{"add"})
+	rtest.Assert(t, err != nil, "write-only key added a new key")
+
+	// files only holders of the master key may write are not accepted if
+	// they were sealed with a write-only key
+	wrepo, err := OpenRepository(wgopts)
+	rtest.OK(t, err)
+	forged, err := restic.SaveTombstone(env.gopts.ctx, wrepo, &restic.Tombstone{Reason: "forget"})
+	rtest.OK(t, err)
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	var tombstone restic.Tombstone
+	err = repo.LoadJSONUnpacked(env.gopts.ctx, restic.TombstoneFile, forged, &tombstone)
+	rtest.Assert(t, err != nil, "tombstone sealed with a write-only key was accepted")
+	h := restic.Handle{Type: restic.TombstoneFile, Name: forged.String()}
+	rtest.OK(t, repo.Backend().Remove(env.gopts.ctx, h))
+
+	// the regular key can read everything
+	testRunCheck(t, env.gopts)
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestoreLatest(t, env.gopts, restoredir, nil, nil)
+	diff := directoriesContentsDiff(env.testdata, filepath.Join(restoredir, "testdata"))
+	rtest.Assert(t, diff == "", "directories are not equal: %v", diff)
+
+	rtest.OK(t, runForget(ForgetOptions{Last: 1}, env.gopts, nil))
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0"})
+	testRunCheck(t, env.gopts)
+
+	// write-only keys can still add data after prune
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, wgopts)
+	testRunCheck(t, env.gopts)
+}
+
+func TestKeyEscrow(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+
+	sharedir := filepath.Join(env.base, "shares")
+	rtest.OK(t, os.Mkdir(sharedir, 0700))
+
+	defer func() {
+		keyEscrowOutputDir = ""
+		testKeyNewPassword = ""
+	}()
+	keyEscrowOutputDir = sharedir
+	rtest.OK(t, runKey(env.gopts, []string{"escrow", "split"}))
+
+	shares, err := filepath.Glob(filepath.Join(sharedir, "share-*.txt"))
+	rtest.OK(t, err)
+	rtest.Equals(t, 5, len(shares))
+
+	// the password is not needed for recovering the master key
+	gopts := env.gopts
+	gopts.password = "recovered password"
+	testKeyNewPassword = "recovered password"
+
+	err = runKey(gopts, []string{"escrow", "recover", shares[0], shares[3]})
+	rtest.Assert(t, err != nil, "master key recovered from two shares")
+
+	rtest.OK(t, runKey(gopts, []string{"escrow", "recover", shares[1], shares[2], shares[4]}))
+	rtest.Equals(t, 2, len(testRunList(t, "keys", gopts)))
+
+	// the old key is not needed anymore
+	oldKey := testRunKeyListOtherIDs(t, gopts)
+	rtest.Equals(t, 1, len(oldKey))
+	rtest.OK(t, runKey(gopts, []string{"remove", oldKey[0]}))
+
+	testRunCheck(t, gopts)
 }
 
 func testFileSize(filename string, size int64) error {
@@ -1121,6 +1824,42 @@ func TestRestore(t *testing.T) {
 	rtest.Assert(t, diff == "", "directories are not equal %v", diff)
 }
 
+func TestRestoreSparse(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	// the runs of zeros are saved as blobs which only contain zeros
+	data := append([]byte("start"), make([]byte, 10*1024*1024)...)
+	data = append(data, "end"...)
+	p := filepath.Join(env.testdata, "sparse")
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	rtest.OK(t, ioutil.WriteFile(p, data, 0644))
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{filepath.Base(env.testdata)}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	restoredir := filepath.Join(env.base, "restore")
+	opts := RestoreOptions{
+		Target: restoredir,
+		Sparse: true,
+	}
+	rtest.OK(t, runRestore(opts, env.gopts, []string{snapshotIDs[0].String()}))
+
+	filename := filepath.Join(restoredir, filepath.Base(env.testdata), "sparse")
+	restored, err := ioutil.ReadFile(filename)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(data, restored), "restored file has wrong content")
+
+	if runtime.GOOS == "linux" {
+		fi, err := os.Stat(filename)
+		rtest.OK(t, err)
+		rtest.Assert(t, fs.IsSparse(fi), "restored file is not sparse")
+	}
+}
+
 func TestRestoreLatest(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -1367,6 +2106,118 @@ func TestRebuildIndexAlwaysFull(t *testing.T) {
 	TestRebuildIndex(t)
 }
 
+func TestSnapshotChain(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	for i := 0; i < 4; i++ {
+		testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata/0/0/9"}, BackupOptions{}, env.gopts)
+	}
+
+	checkChain := func() error {
+		globalOptions.stdout = ioutil.Discard
+		defer func() {
+			globalOptions.stdout = os.Stdout
+		}()
+		return runCheck(CheckOptions{Chain: true}, env.gopts, nil)
+	}
+	rtest.OK(t, checkChain())
+
+	// changing the tags and removing snapshots with forget keeps the chain intact
+	testRunTag(t, TagOptions{AddTags: restic.TagLists{[]string{"foo"}}}, env.gopts)
+	rtest.OK(t, checkChain())
+
+	newest, snapmap := testRunSnapshots(t, env.gopts)
+	var removed []string
+	for id := range snapmap {
+		if id != *newest.ID {
+			removed = append(removed, id.String())
+		}
+		if len(removed) == 2 {
+			break
+		}
+	}
+	tombstones := len(testRunList(t, "tombstones", env.gopts))
+	testRunForget(t, env.gopts, removed[0])
+	rtest.Equals(t, tombstones+1, len(testRunList(t, "tombstones", env.gopts)))
+	rtest.OK(t, checkChain())
+
+	// removing the newest snapshot without forget is detected
+	newestFile := filepath.Join(env.repo, "snapshots", newest.ID.String())
+	buf, err := ioutil.ReadFile(newestFile)
+	rtest.OK(t, err)
+	rtest.OK(t, os.Remove(newestFile))
+	rtest.Assert(t, checkChain() != nil, "check did not detect the removed newest snapshot")
+	rtest.OK(t, ioutil.WriteFile(newestFile, buf, 0600))
+	rtest.OK(t, checkChain())
+
+	// removing a snapshot without forget is detected
+	removedFile := filepath.Join(env.repo, "snapshots", removed[1])
+	buf, err = ioutil.ReadFile(removedFile)
+	rtest.OK(t, err)
+	rtest.OK(t, os.Remove(removedFile))
+	rtest.Assert(t, checkChain() != nil, "check did not detect the removed snapshot")
+	rtest.OK(t, ioutil.WriteFile(removedFile, buf, 0600))
+	rtest.OK(t, checkChain())
+
+	// also if it was saved after the last forget
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata/0/0/9"}, BackupOptions{}, env.gopts)
+	newest, _ = testRunSnapshots(t, env.gopts)
+	rtest.OK(t, os.Remove(filepath.Join(env.repo, "snapshots", newest.ID.String())))
+	rtest.Assert(t, checkChain() != nil, "check did not detect the removed newest snapshot")
+
+	// a later backup keeps the record of the removed snapshot, but replaces
+	// the records saved before: only the tombstone of forget and the new
+	// record remain
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata/0/0/9"}, BackupOptions{}, env.gopts)
+	rtest.Equals(t, 2, len(testRunList(t, "tombstones", env.gopts)))
+	rtest.Assert(t, checkChain() != nil, "backup hid the removed newest snapshot")
+}
+
+func TestBinaryIndex(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{}
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata/0/0/9"}, opts, env.gopts)
+
+	rtest.OK(t, runMigrate(MigrateOptions{}, env.gopts, []string{"binary_index"}))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata/0/0/9/3"}, opts, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	countFormats := func() (binary, json int) {
+		repo, err := OpenRepository(env.gopts)
+		rtest.OK(t, err)
+		rtest.OK(t, repo.List(env.gopts.ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+			buf, err := repo.LoadAndDecrypt(env.gopts.ctx, nil, restic.IndexFile, id)
+			if err != nil {
+				return err
+			}
+			if bytes.HasPrefix(buf, []byte("RIDX")) {
+				binary++
+			} else {
+				json++
+			}
+			return nil
+		}))
+		return binary, json
+	}
+
+	// depending on repository.IndexFull, several indexes may be written
+	binary, json := countFormats()
+	rtest.Assert(t, binary >= 1 && json >= 1, "expected indexes in both formats, got %d binary and %d JSON", binary, json)
+
+	testRunRebuildIndex(t, env.gopts)
+	binary, json = countFormats()
+	rtest.Assert(t, binary >= 1 && json == 0, "expected only binary indexes, got %d binary and %d JSON", binary, json)
+
+	testRunCheck(t, env.gopts)
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestoreLatest(t, env.gopts, restoredir, nil, nil)
+}
+
 type appendOnlyBackend struct {
 	restic.Backend
 }
@@ -1491,6 +2342,58 @@ func listPacks(gopts GlobalOptions, t *testing.T) restic.IDSet {
 	return packs
 }
 
+func TestPruneSmallPacks(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	// each backup creates a small data pack
+	for i := 0; i < 5; i++ {
+		p := filepath.Join(env.testdata, fmt.Sprintf("file-%d", i))
+		rtest.OK(t, appendRandomData(p, 1024*1024))
+		testRunBackup(t, "", []string{p}, BackupOptions{}, env.gopts)
+	}
+
+	// small packs are only repacked with --repack-small
+	packs := listPacks(env.gopts, t)
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "unlimited"})
+	newPacks := listPacks(env.gopts, t)
+	rtest.Equals(t, packs, newPacks)
+
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "unlimited", RepackSmall: true})
+	newPacks = listPacks(env.gopts, t)
+	rtest.Assert(t, len(newPacks) < len(packs),
+		"small packs were not repacked, got %d packs before and %d after prune", len(packs), len(newPacks))
+
+	testRunCheck(t, env.gopts)
+}
+
+func TestPackSize(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	env.gopts.PackSize = 16
+	testSetupBackupData(t, env)
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(16), repo.Config().PackSize)
+
+	// the pack size from the config is used by default
+	env.gopts.PackSize = 0
+	repo, err = OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(16*1024*1024), repo.PackSize())
+
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	env.gopts.PackSize = 1024
+	_, err = OpenRepository(env.gopts)
+	rtest.Assert(t, err != nil, "opening the repository with an invalid pack size did not fail")
+}
+
 func TestPruneWithDamagedRepository(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -1938,3 +2841,42 @@ func TestBackendLoadWriteTo(t *testing.T) {
 	// test readData using the hashing.Reader
 	testRunCheck(t, env.gopts)
 }
+
+func TestRepairPacks(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	rtest.OK(t, runRepairParity(RepairParityOptions{GroupSize: 3, Shards: 1}, env.gopts, nil))
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	// remove a pack and restore it from the parity files
+	packs := listPacks(env.gopts, t)
+	var damaged restic.ID
+	for id := range packs {
+		damaged = id
+		break
+	}
+	r, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, r.Backend().Remove(env.gopts.ctx, restic.Handle{Type: restic.PackFile, Name: damaged.String()}))
+
+	rtest.OK(t, runRepairPacks(env.gopts, nil))
+	rtest.Equals(t, packs, listPacks(env.gopts, t))
+	testRunCheck(t, env.gopts)
+
+	// prune keeps the parity files usable
+	snapshotID := testRunList(t, "snapshots", env.gopts)[0]
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, BackupOptions{}, env.gopts)
+	testRunForget(t, env.gopts, snapshotID.String())
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0"})
+	packs = listPacks(env.gopts, t)
+	rtest.Assert(t, len(packs) > 0, "no packs left after prune")
+	for id := range packs {
+		rtest.OK(t, r.Backend().Remove(env.gopts.ctx, restic.Handle{Type: restic.PackFile, Name: id.String()}))
+		rtest.OK(t, runRepairPacks(env.gopts, []string{id.String()}))
+		break
+	}
+	rtest.Equals(t, packs, listPacks(env.gopts, t))
+	testRunCheck(t, env.gopts)
+}
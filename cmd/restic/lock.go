@@ -22,7 +22,12 @@ func lockRepo(ctx context.Context, repo *repository.Repository) (*restic.Lock, e
 	return lockRepository(ctx, repo, false)
 }
 
+// lockRepoExclusive creates an exclusive lock, which is required to modify the
+// repository. It refuses to do so if the repository uses unknown features.
 func lockRepoExclusive(ctx context.Context, repo *repository.Repository) (*restic.Lock, error) {
+	if err := repo.Config().CheckWritable(); err != nil {
+		return nil, err
+	}
 	return lockRepository(ctx, repo, true)
 }
 
@@ -116,14 +116,17 @@ func main() {
 		}
 	}
 
-	var exitCode int
+	Exit(exitCode(err))
+}
+
+// exitCode returns the exit status for the error returned by a command.
+func exitCode(err error) int {
 	switch err {
 	case nil:
-		exitCode = 0
+		return 0
 	case ErrInvalidSourceData:
-		exitCode = 3
+		return 3
 	default:
-		exitCode = 1
+		return 1
 	}
-	Exit(exitCode)
 }
@@ -7,6 +7,8 @@ import (
 	"path"
 	"runtime"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/restic/restic/internal/debug"
@@ -45,6 +47,20 @@ func (s *ItemStats) Add(other ItemStats) {
 	s.TreeSize += other.TreeSize
 }
 
+// summary collects the statistics of a backup which are stored in the
+// snapshot.
+type summary struct {
+	sync.Mutex
+	Files, Dirs struct {
+		New       uint
+		Changed   uint
+		Unchanged uint
+	}
+	ItemStats
+	ProcessedFiles uint
+	ProcessedBytes uint64
+}
+
 // Archiver saves a directory structure to the repo.
 type Archiver struct {
 	Repo         restic.Repository
@@ -53,9 +69,16 @@ type Archiver struct {
 	FS           fs.FS
 	Options      Options
 
-	blobSaver *BlobSaver
-	fileSaver *FileSaver
-	treeSaver *TreeSaver
+	blobSaver  *BlobSaver
+	fileSaver  *FileSaver
+	treeSaver  *TreeSaver
+	summary    *summary
+	checkpoint *checkpoint
+
+	// stop is closed once the deadline for the backup has been reached,
+	// partial is set when an item has been skipped because of it.
+	stop    chan struct{}
+	partial int32
 
 	// Error is called for all errors that occur during backup.
 	Error ErrorFunc
@@ -82,6 +105,10 @@ type Archiver struct {
 
 	// Flags controlling change detection. See doc/040_backup.rst for details.
 	ChangeIgnoreFlags uint
+
+	// DryRun configures the archiver to only determine which blobs would be
+	// added to the repo. No blobs, indexes or snapshots are saved.
+	DryRun bool
 }
 
 // Flags for the ChangeIgnoreFlags bitfield.
@@ -142,12 +169,81 @@ func New(repo restic.Repository, fs fs.FS, opts Options) *Archiver {
 		CompleteItem: func(string, *restic.Node, *restic.Node, ItemStats, time.Duration) {},
 		StartFile:    func(string) {},
 		CompleteBlob: func(string, uint64) {},
+
+		summary: &summary{},
 	}
 
 	return arch
 }
 
-// error calls arch.Error if it is set and the error is different from context.Canceled.
+// snapshotSummary returns the statistics of the backup started at start.
+func (arch *Archiver) snapshotSummary(start time.Time) *restic.SnapshotSummary {
+	arch.summary.Lock()
+	defer arch.summary.Unlock()
+
+	return &restic.SnapshotSummary{
+		BackupStart: start,
+		BackupEnd:   time.Now(),
+
+		FilesNew:        arch.summary.Files.New,
+		FilesChanged:    arch.summary.Files.Changed,
+		FilesUnmodified: arch.summary.Files.Unchanged,
+		DirsNew:         arch.summary.Dirs.New,
+		DirsChanged:     arch.summary.Dirs.Changed,
+		DirsUnmodified:  arch.summary.Dirs.Unchanged,
+
+		DataBlobs:      arch.summary.DataBlobs,
+		TreeBlobs:      arch.summary.TreeBlobs,
+		DataAdded:      arch.summary.DataSize + arch.summary.TreeSize,
+		FilesProcessed: arch.summary.ProcessedFiles,
+		BytesProcessed: arch.summary.ProcessedBytes,
+	}
+}
+
+// trackItem updates the summary and calls CompleteItem.
+func (arch *Archiver) trackItem(item string, previous, current *restic.Node, s ItemStats, d time.Duration) {
+	arch.CompleteItem(item, previous, current, s, d)
+
+	arch.summary.Lock()
+	defer arch.summary.Unlock()
+
+	arch.summary.ItemStats.Add(s)
+
+	// for the last item "/", current is nil
+	if current == nil {
+		return
+	}
+
+	if arch.checkpoint != nil {
+		arch.checkpoint.add(item, current)
+	}
+
+	arch.summary.ProcessedBytes += current.Size
+
+	var counts *struct{ New, Changed, Unchanged uint }
+	switch current.Type {
+	case "file":
+		arch.summary.ProcessedFiles++
+		counts = &arch.summary.Files
+	case "dir":
+		counts = &arch.summary.Dirs
+	default:
+		return
+	}
+
+	switch {
+	case previous == nil:
+		counts.New++
+	case previous.Equals(*current):
+		counts.Unchanged++
+	default:
+		counts.Changed++
+	}
+}
+
+// error calls arch.Error if it is set and the error is different from
+// context.Canceled. The error of a failed command whose output is saved is
+// not passed to arch.Error, it always aborts the backup.
 func (arch *Archiver) error(item string, fi os.FileInfo, err error) error {
 	if arch.Error == nil || err == nil {
 		return err
@@ -157,6 +253,10 @@ func (arch *Archiver) error(item string, fi os.FileInfo, err error) error {
 		return err
 	}
 
+	if _, ok := errors.Cause(err).(*fs.CommandError); ok {
+		return err
+	}
+
 	errf := arch.Error(item, fi, err)
 	if err != errf {
 		debug.Log("item %v: error was filtered by handler, before: %q, after: %v", item, err, errf)
@@ -237,6 +337,10 @@ func (arch *Archiver) SaveDir(ctx context.Context, snPath string, fi os.FileInfo
 		return FutureTree{}, err
 	}
 
+	if arch.checkpoint != nil {
+		arch.checkpoint.startDir(snPath, treeNode)
+	}
+
 	names, err := readdirnames(arch.FS, dir, fs.O_NOFOLLOW)
 	if err != nil {
 		return FutureTree{}, err
@@ -350,6 +454,13 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		target: target,
 	}
 
+	// no new items are started once the deadline has been reached
+	if arch.deadlineReached() {
+		debug.Log("deadline reached, skipping %v", target)
+		atomic.StoreInt32(&arch.partial, 1)
+		return FutureNode{}, true, nil
+	}
+
 	debug.Log("%v target %q, previous %v", snPath, target, previous)
 	abstarget, err := arch.FS.Abs(target)
 	if err != nil {
@@ -389,7 +500,7 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		if previous != nil && !fileChanged(fi, previous, arch.ChangeIgnoreFlags) {
 			if arch.allBlobsPresent(previous) {
 				debug.Log("%v hasn't changed, using old list of blobs", target)
-				arch.CompleteItem(snPath, previous, previous, ItemStats{}, time.Since(start))
+				arch.trackItem(snPath, previous, previous, ItemStats{}, time.Since(start))
 				arch.CompleteBlob(snPath, previous.Size)
 				fn.node, err = arch.nodeFromFileInfo(target, fi)
 				if err != nil {
@@ -399,6 +510,12 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 				// copy list of blobs
 				fn.node.Content = previous.Content
 
+				// mirrors which do not contain the data yet get it from
+				// the primary repository
+				if mirror, ok := arch.Repo.(*MirrorRepository); ok && !arch.DryRun {
+					mirror.CopyBlobs(ctx, restic.DataBlob, previous.Content)
+				}
+
 				return fn, false, nil
 			}
 
@@ -450,7 +567,7 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		fn.file = arch.fileSaver.Save(ctx, snPath, file, fi, func() {
 			arch.StartFile(snPath)
 		}, func(node *restic.Node, stats ItemStats) {
-			arch.CompleteItem(snPath, previous, node, stats, time.Since(start))
+			arch.trackItem(snPath, previous, node, stats, time.Since(start))
 		})
 
 	case fi.IsDir():
@@ -469,7 +586,7 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		fn.isTree = true
 		fn.tree, err = arch.SaveDir(ctx, snPath, fi, target, oldSubtree,
 			func(node *restic.Node, stats ItemStats) {
-				arch.CompleteItem(snItem, previous, node, stats, time.Since(start))
+				arch.trackItem(snItem, previous, node, stats, time.Since(start))
 			})
 		if err != nil {
 			debug.Log("SaveDir for %v returned error: %v", snPath, err)
@@ -635,7 +752,7 @@ func (arch *Archiver) SaveTree(ctx context.Context, snPath string, atree *Tree,
 			return nil, err
 		}
 
-		arch.CompleteItem(snItem, oldNode, node, nodeStats, time.Since(start))
+		arch.trackItem(snItem, oldNode, node, nodeStats, time.Since(start))
 	}
 
 	debug.Log("waiting on %d nodes", len(futureNodes))
@@ -730,10 +847,49 @@ type SnapshotOptions struct {
 	Excludes       []string
 	Time           time.Time
 	ParentSnapshot restic.ID
+	// Description and Labels are recorded in the snapshot.
+	Description string
+	Labels      map[string]string
+	// Command is recorded in the snapshot, it is set when the data has been
+	// read from the standard output of a command.
+	Command []string
+	// Chain, if set, links the snapshot to the previous snapshot of the
+	// same host and paths.
+	Chain *restic.SnapshotChain
+	// CheckpointInterval, if set, configures how often an incomplete
+	// snapshot with the files and directories completed so far is saved.
+	CheckpointInterval time.Duration
+	// SkipIfUnchanged, if set, does not save a new snapshot if its tree is
+	// the same as the tree of the parent snapshot.
+	SkipIfUnchanged bool
+	// Deadline, if set, is the time after which no new files and
+	// directories are started. The items completed until then are saved
+	// as an incomplete snapshot, tagged with PartialTag.
+	Deadline time.Time
 }
 
-// loadParentTree loads a tree referenced by snapshot id. If id is null, nil is returned.
-func (arch *Archiver) loadParentTree(ctx context.Context, snapshotID restic.ID) *restic.Tree {
+// PartialTag is added to snapshots which are incomplete because the deadline
+// has been reached.
+const PartialTag = "partial"
+
+// ErrDeadlineReached is returned by Snapshot if the deadline has been reached
+// before any file has been saved, no snapshot is saved then.
+var ErrDeadlineReached = errors.New("deadline reached before any file has been saved")
+
+// deadlineReached returns true if the deadline of the current backup has
+// been reached.
+func (arch *Archiver) deadlineReached() bool {
+	select {
+	case <-arch.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// loadParentSnapshot loads the snapshot referenced by id. If id is null or the
+// snapshot cannot be loaded, nil is returned.
+func (arch *Archiver) loadParentSnapshot(ctx context.Context, snapshotID restic.ID) *restic.Snapshot {
 	if snapshotID.IsNull() {
 		return nil
 	}
@@ -745,8 +901,32 @@ func (arch *Archiver) loadParentTree(ctx context.Context, snapshotID restic.ID)
 		return nil
 	}
 
+	return sn
+}
+
+// parentID returns the parent recorded in the new snapshot. A checkpoint is
+// removed once the backup is complete, so its parent is used instead.
+func parentID(parent *restic.Snapshot, snapshotID restic.ID) *restic.ID {
+	if snapshotID.IsNull() {
+		return nil
+	}
+
+	if parent != nil && parent.Incomplete {
+		return parent.Parent
+	}
+
+	return &snapshotID
+}
+
+// loadParentTree loads the tree of the parent snapshot sn. If sn is nil, nil
+// is returned.
+func (arch *Archiver) loadParentTree(ctx context.Context, sn *restic.Snapshot) *restic.Tree {
+	if sn == nil {
+		return nil
+	}
+
 	if sn.Tree == nil {
-		debug.Log("snapshot %v has empty tree %v", snapshotID)
+		debug.Log("snapshot %v has empty tree", sn.ID())
 		return nil
 	}
 
@@ -762,7 +942,11 @@ func (arch *Archiver) loadParentTree(ctx context.Context, snapshotID restic.ID)
 
 // runWorkers starts the worker pools, which are stopped when the context is cancelled.
 func (arch *Archiver) runWorkers(ctx context.Context, t *tomb.Tomb) {
-	arch.blobSaver = NewBlobSaver(ctx, t, arch.Repo, arch.Options.SaveBlobConcurrency)
+	var saver Saver = arch.Repo
+	if arch.DryRun {
+		saver = newDryRunSaver(arch.Repo)
+	}
+	arch.blobSaver = NewBlobSaver(ctx, t, saver, arch.Options.SaveBlobConcurrency)
 
 	arch.fileSaver = NewFileSaver(ctx, t,
 		arch.blobSaver.Save,
@@ -771,10 +955,13 @@ func (arch *Archiver) runWorkers(ctx context.Context, t *tomb.Tomb) {
 	arch.fileSaver.CompleteBlob = arch.CompleteBlob
 	arch.fileSaver.NodeFromFileInfo = arch.nodeFromFileInfo
 
-	arch.treeSaver = NewTreeSaver(ctx, t, arch.Options.SaveTreeConcurrency, arch.saveTree, arch.Error)
+	arch.treeSaver = NewTreeSaver(ctx, t, arch.Options.SaveTreeConcurrency, arch.saveTree, arch.error)
 }
 
-// Snapshot saves several targets and returns a snapshot.
+// Snapshot saves several targets and returns a snapshot. In dry-run mode,
+// the snapshot is not saved and the returned ID is null. The same applies
+// when opts.SkipIfUnchanged is set and nothing has changed since the parent
+// snapshot.
 func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts SnapshotOptions) (*restic.Snapshot, restic.ID, error) {
 	cleanTargets, err := resolveRelativeTargets(arch.FS, targets)
 	if err != nil {
@@ -789,19 +976,49 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 	var t tomb.Tomb
 	wctx := t.Context(ctx)
 	start := time.Now()
+	arch.summary = &summary{}
+
+	parent := arch.loadParentSnapshot(ctx, opts.ParentSnapshot)
+
+	arch.checkpoint = nil
+	if opts.CheckpointInterval > 0 && !arch.DryRun {
+		arch.checkpoint = newCheckpoint()
+	}
+
+	stop := make(chan struct{})
+	arch.stop = stop
+	atomic.StoreInt32(&arch.partial, 0)
+	if !opts.Deadline.IsZero() {
+		timer := time.AfterFunc(time.Until(opts.Deadline), func() {
+			debug.Log("deadline reached")
+			close(stop)
+		})
+		defer timer.Stop()
+	}
 
 	var rootTreeID restic.ID
 	var stats ItemStats
 	t.Go(func() error {
 		arch.runWorkers(wctx, &t)
 
+		if arch.checkpoint != nil {
+			// the checkpoints are saved with ctx, so that a checkpoint is not
+			// interrupted when the backup is complete
+			t.Go(func() error {
+				return arch.runCheckpoints(ctx, t.Dying(), targets, opts, parentID(parent, opts.ParentSnapshot), start)
+			})
+		}
+
 		debug.Log("starting snapshot")
-		tree, err := arch.SaveTree(wctx, "/", atree, arch.loadParentTree(wctx, opts.ParentSnapshot))
+		tree, err := arch.SaveTree(wctx, "/", atree, arch.loadParentTree(wctx, parent))
 		if err != nil {
 			return err
 		}
 
 		if len(tree.Nodes) == 0 {
+			if atomic.LoadInt32(&arch.partial) != 0 {
+				return ErrDeadlineReached
+			}
 			return errors.New("snapshot is empty")
 		}
 
@@ -819,11 +1036,13 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		return nil, restic.ID{}, err
 	}
 
-	arch.CompleteItem("/", nil, nil, stats, time.Since(start))
+	arch.trackItem("/", nil, nil, stats, time.Since(start))
 
-	err = arch.Repo.Flush(ctx)
-	if err != nil {
-		return nil, restic.ID{}, err
+	if !arch.DryRun {
+		err = arch.Repo.Flush(ctx)
+		if err != nil {
+			return nil, restic.ID{}, err
+		}
 	}
 
 	sn, err := restic.NewSnapshot(targets, opts.Tags, opts.Hostname, opts.Time)
@@ -832,16 +1051,64 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 	}
 
 	sn.Excludes = opts.Excludes
-	if !opts.ParentSnapshot.IsNull() {
-		id := opts.ParentSnapshot
-		sn.Parent = &id
-	}
+	sn.Command = opts.Command
+	sn.Description = opts.Description
+	sn.Labels = opts.Labels
+	sn.Parent = parentID(parent, opts.ParentSnapshot)
 	sn.Tree = &rootTreeID
+	sn.Summary = arch.snapshotSummary(start)
+
+	// the snapshot can be used as the parent for the next backup, which
+	// then only reads the items skipped here
+	if atomic.LoadInt32(&arch.partial) != 0 {
+		sn.Incomplete = true
+		sn.AddTags([]string{PartialTag})
+	}
+
+	if arch.DryRun {
+		return sn, restic.ID{}, nil
+	}
+
+	if opts.SkipIfUnchanged && !sn.Incomplete && parent != nil && !parent.Incomplete &&
+		parent.Tree != nil && parent.Tree.Equal(rootTreeID) {
+		debug.Log("tree %v is unchanged, not saving a snapshot", rootTreeID.Str())
+		err = arch.removeCheckpoint(ctx)
+		if err != nil {
+			err = arch.error("/", nil, err)
+			if err != nil {
+				return nil, restic.ID{}, err
+			}
+		}
+		return sn, restic.ID{}, nil
+	}
+
+	// incomplete snapshots are not linked into the snapshot chain, as
+	// they are removed once a later backup is complete
+	if opts.Chain != nil && !sn.Incomplete {
+		opts.Chain.Link(sn)
+	}
 
 	id, err := arch.Repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
 	if err != nil {
 		return nil, restic.ID{}, err
 	}
 
+	if opts.Chain != nil && !sn.Incomplete {
+		err = opts.Chain.SaveHead(ctx, arch.Repo, id, sn)
+		if err != nil {
+			return nil, restic.ID{}, err
+		}
+	}
+
+	// the checkpoint is not needed anymore, failing to remove it is not
+	// fatal as the snapshot has already been saved
+	err = arch.removeCheckpoint(ctx)
+	if err != nil {
+		err = arch.error("/", nil, err)
+		if err != nil {
+			return nil, restic.ID{}, err
+		}
+	}
+
 	return sn, id, nil
 }
@@ -0,0 +1,65 @@
+package archiver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/chunker"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	restictest "github.com/restic/restic/internal/test"
+)
+
+func TestArchiverSaveSparseFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, TestDir{})
+	defer cleanup()
+
+	// data at the start, a small hole, more data, and two large holes
+	// separated by data and at the end of the file
+	const size = 3*chunker.MaxSize + 12345
+	content := make([]byte, size)
+	regions := []struct {
+		offset int64
+		length int
+	}{
+		{0, 100 * 1024},
+		{200 * 1024, 300 * 1024},
+		{chunker.MaxSize + 17, 1024 * 1024},
+	}
+
+	filename := filepath.Join(tempdir, "file")
+	f, err := os.Create(filename)
+	restictest.OK(t, err)
+	for i, r := range regions {
+		data := restictest.Random(i, r.length)
+		copy(content[r.offset:], data)
+		_, err = f.WriteAt(data, r.offset)
+		restictest.OK(t, err)
+	}
+	restictest.OK(t, f.Truncate(size))
+	restictest.OK(t, f.Close())
+
+	if !fs.IsSparse(lstat(t, filename)) {
+		t.Skip("file system does not support sparse files")
+	}
+
+	node, _ := saveFile(t, repo, filename, fs.Track{FS: fs.Local{}})
+	TestEnsureFileContent(ctx, t, repo, "file", node, TestFile{Content: string(content)})
+
+	// the large holes are saved as blobs which only contain zeros
+	zeroID := restic.Hash(make([]byte, chunker.MaxSize))
+	var zeroBlobs int
+	for _, id := range node.Content {
+		if id.Equal(zeroID) {
+			zeroBlobs++
+		}
+	}
+	if zeroBlobs != 1 {
+		t.Errorf("wrong number of zero blobs, want 1, got %d", zeroBlobs)
+	}
+}
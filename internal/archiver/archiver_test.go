@@ -1717,6 +1717,233 @@ func TestArchiverParent(t *testing.T) {
 	}
 }
 
+func TestArchiverDryRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := TestDir{
+		"subdir": TestDir{
+			"foo": TestFile{Content: "foo"},
+			"bar": TestFile{Content: "foo"},
+		},
+		"large": TestFile{Content: string(restictest.Random(23, 3*1024*1024))},
+	}
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	countFiles := func() (n int) {
+		for _, tpe := range []restic.FileType{restic.PackFile, restic.IndexFile, restic.SnapshotFile} {
+			err := repo.List(ctx, tpe, func(restic.ID, int64) error {
+				n++
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return n
+	}
+
+	dryRun := func() ItemStats {
+		var m sync.Mutex
+		var stats ItemStats
+		arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+		arch.DryRun = true
+		arch.CompleteItem = func(item string, previous, current *restic.Node, s ItemStats, d time.Duration) {
+			m.Lock()
+			stats.Add(s)
+			m.Unlock()
+		}
+
+		sn, id, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !id.IsNull() || sn == nil {
+			t.Fatalf("dry run returned snapshot ID %v", id)
+		}
+		return stats
+	}
+
+	stats := dryRun()
+	if n := countFiles(); n != 0 {
+		t.Fatalf("dry run saved %d files", n)
+	}
+	// the duplicate file content is only counted once
+	if stats.DataBlobs < 3 || stats.DataSize != 3*1024*1024+3 || stats.TreeBlobs != 2 {
+		t.Fatalf("unexpected stats for dry run: %+v", stats)
+	}
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	_, _, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := countFiles()
+
+	stats = dryRun()
+	if countFiles() != n {
+		t.Fatal("dry run saved files")
+	}
+	if stats != (ItemStats{}) {
+		t.Fatalf("dry run after backup reports new blobs: %+v", stats)
+	}
+}
+
+func TestArchiverSkipIfUnchanged(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := TestDir{
+		"subdir": TestDir{
+			"foo": TestFile{Content: "foo"},
+		},
+		"bar": TestFile{Content: "bar"},
+	}
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	countSnapshots := func() (n int) {
+		err := repo.List(ctx, restic.SnapshotFile, func(restic.ID, int64) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	backup := func(parent restic.ID) (*restic.Snapshot, restic.ID) {
+		arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+		sn, id, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{
+			Time:            time.Now(),
+			ParentSnapshot:  parent,
+			SkipIfUnchanged: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sn, id
+	}
+
+	// without a parent, the snapshot is always saved
+	_, firstID := backup(restic.ID{})
+	if firstID.IsNull() {
+		t.Fatal("first snapshot was not saved")
+	}
+
+	sn, id := backup(firstID)
+	if !id.IsNull() {
+		t.Fatalf("unchanged snapshot was saved as %v", id.Str())
+	}
+	if sn == nil || sn.Parent == nil || !sn.Parent.Equal(firstID) {
+		t.Fatalf("unexpected snapshot returned: %v", sn)
+	}
+	if n := countSnapshots(); n != 1 {
+		t.Fatalf("expected 1 snapshot, found %d", n)
+	}
+
+	err := ioutil.WriteFile(filepath.Join(tempdir, "bar"), []byte("changed"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, id = backup(firstID)
+	if id.IsNull() {
+		t.Fatal("changed snapshot was not saved")
+	}
+	if n := countSnapshots(); n != 2 {
+		t.Fatalf("expected 2 snapshots, found %d", n)
+	}
+}
+
+func TestArchiverSnapshotSummary(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := TestDir{
+		"subdir": TestDir{
+			"foo": TestFile{Content: "foo"},
+			"bar": TestFile{Content: "bar"},
+		},
+		"baz": TestFile{Content: "bazbaz"},
+	}
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	start := time.Now()
+	sn, id, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary := sn.Summary
+	if summary == nil {
+		t.Fatal("snapshot has no summary")
+	}
+	if summary.BackupStart.Before(start) || summary.BackupEnd.Before(summary.BackupStart) {
+		t.Errorf("invalid backup duration from %v to %v", summary.BackupStart, summary.BackupEnd)
+	}
+	want := restic.SnapshotSummary{
+		FilesNew:       3,
+		DirsNew:        1,
+		DataBlobs:      3,
+		TreeBlobs:      2,
+		FilesProcessed: 3,
+		BytesProcessed: 12,
+	}
+	got := *summary
+	got.BackupStart, got.BackupEnd, got.DataAdded = time.Time{}, time.Time{}, 0
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if summary.DataAdded <= 12 {
+		t.Errorf("DataAdded %d does not include the trees", summary.DataAdded)
+	}
+
+	// the summary is saved in the repository
+	loaded, err := restic.LoadSnapshot(ctx, repo, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Summary == nil || loaded.Summary.FilesNew != summary.FilesNew || !loaded.Summary.BackupEnd.Equal(summary.BackupEnd) {
+		t.Errorf("wrong summary loaded: %+v", loaded.Summary)
+	}
+
+	// modify one file, the second snapshot only counts the changes
+	restictest.OK(t, ioutil.WriteFile(filepath.Join("subdir", "foo"), []byte("foofoo"), 0644))
+	sn, _, err = arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now(), ParentSnapshot: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = restic.SnapshotSummary{
+		FilesChanged:    1,
+		FilesUnmodified: 2,
+		DirsChanged:     1,
+		DataBlobs:       1,
+		TreeBlobs:       2,
+		FilesProcessed:  3,
+		BytesProcessed:  15,
+	}
+	got = *sn.Summary
+	got.BackupStart, got.BackupEnd, got.DataAdded = time.Time{}, time.Time{}, 0
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
 func TestArchiverErrorReporting(t *testing.T) {
 	ignoreErrorForBasename := func(basename string) ErrorFunc {
 		return func(item string, fi os.FileInfo, err error) error {
@@ -1894,7 +2121,7 @@ func TestArchiverContextCanceled(t *testing.T) {
 	defer removeTempdir()
 
 	// Ensure that the archiver itself reports the canceled context and not just the backend
-	repo, _ := repository.TestRepositoryWithBackend(t, &noCancelBackend{mem.New()})
+	repo, _ := repository.TestRepositoryWithBackend(t, &noCancelBackend{mem.New()}, 0)
 
 	back := restictest.Chdir(t, tempdir)
 	defer back()
@@ -2260,3 +2487,29 @@ func TestRacyFileSwap(t *testing.T) {
 		t.Errorf("Save() excluded the node, that's unexpected")
 	}
 }
+
+func TestArchiverErrorCommand(t *testing.T) {
+	var reported []error
+	arch := New(nil, fs.Local{}, Options{})
+	arch.Error = func(item string, fi os.FileInfo, err error) error {
+		reported = append(reported, err)
+		return nil
+	}
+
+	// the error of a failed command always aborts the backup
+	cmdErr := &fs.CommandError{Args: []string{"false"}, Err: errors.New("exit status 1")}
+	err := arch.error("/stdin", nil, errors.Wrap(cmdErr, "Read"))
+	if errors.Cause(err) != cmdErr {
+		t.Errorf("wrong error returned, want %v, got %v", cmdErr, err)
+	}
+
+	// other fatal errors are passed to the error callback
+	err = arch.error("/foo", nil, errors.Fatal("foo"))
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if len(reported) != 1 {
+		t.Errorf("wrong number of reported errors, want 1, got %v", reported)
+	}
+}
@@ -2,6 +2,7 @@ package archiver
 
 import (
 	"context"
+	"sync"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/restic"
@@ -42,6 +43,8 @@ func NewBlobSaver(ctx context.Context, t *tomb.Tomb, repo Saver, workers uint) *
 // before saving anything. It takes ownership of the buffer passed in.
 func (s *BlobSaver) Save(ctx context.Context, t restic.BlobType, buf *Buffer) FutureBlob {
 	ch := make(chan saveBlobResponse, 1)
+	// the buffer may be released and reused as soon as it has been sent
+	length := len(buf.Data)
 	select {
 	case s.ch <- saveBlobJob{BlobType: t, buf: buf, ch: ch}:
 	case <-ctx.Done():
@@ -50,7 +53,7 @@ func (s *BlobSaver) Save(ctx context.Context, t restic.BlobType, buf *Buffer) Fu
 		return FutureBlob{ch: ch}
 	}
 
-	return FutureBlob{ch: ch, length: len(buf.Data)}
+	return FutureBlob{ch: ch, length: length}
 }
 
 // FutureBlob is returned by SaveBlob and will return the data once it has been processed.
@@ -131,3 +134,41 @@ func (s *BlobSaver) worker(ctx context.Context, jobs <-chan saveBlobJob) error {
 		job.buf.Release()
 	}
 }
+
+// dryRunSaver determines whether blobs are already contained in the repo or
+// have been seen before, without saving anything.
+type dryRunSaver struct {
+	repo Saver
+
+	m    sync.Mutex
+	seen restic.BlobSet
+}
+
+func newDryRunSaver(repo Saver) *dryRunSaver {
+	return &dryRunSaver{
+		repo: repo,
+		seen: restic.NewBlobSet(),
+	}
+}
+
+// SaveBlob returns the ID of the blob and whether it is already known.
+func (s *dryRunSaver) SaveBlob(ctx context.Context, t restic.BlobType, buf []byte, id restic.ID, storeDuplicate bool) (restic.ID, bool, error) {
+	if id.IsNull() {
+		id = restic.Hash(buf)
+	}
+	h := restic.BlobHandle{ID: id, Type: t}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.seen.Has(h) || s.repo.Index().Has(h) {
+		return id, true, nil
+	}
+	s.seen.Insert(h)
+	return id, false, nil
+}
+
+// Index returns the index of the repo.
+func (s *dryRunSaver) Index() restic.MasterIndex {
+	return s.repo.Index()
+}
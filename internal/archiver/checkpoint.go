@@ -0,0 +1,300 @@
+package archiver
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// checkpoint records the files and directories completed so far during a
+// backup, so that the archiver can periodically save an incomplete snapshot.
+// A backup which is interrupted can use the latest checkpoint as its parent,
+// so the completed parts are not read again.
+type checkpoint struct {
+	m    sync.Mutex
+	root *checkpointDir
+
+	// id is the ID of the last checkpoint snapshot, it is null if no
+	// checkpoint has been saved yet.
+	id restic.ID
+}
+
+// checkpointDir is a directory which has not been completed yet. items
+// contains the nodes of all completed entries, dirs the entries which are
+// still being processed.
+type checkpointDir struct {
+	node  *restic.Node
+	items map[string]*restic.Node
+	dirs  map[string]*checkpointDir
+}
+
+func newCheckpointDir() *checkpointDir {
+	return &checkpointDir{
+		items: make(map[string]*restic.Node),
+		dirs:  make(map[string]*checkpointDir),
+	}
+}
+
+func newCheckpoint() *checkpoint {
+	return &checkpoint{root: newCheckpointDir()}
+}
+
+// dir returns the directory for snPath, intermediate directories are
+// created. The caller must hold the lock.
+func (c *checkpoint) dir(snPath string) *checkpointDir {
+	d := c.root
+	for _, name := range strings.Split(strings.Trim(snPath, "/"), "/") {
+		if name == "" {
+			continue
+		}
+
+		sub, ok := d.dirs[name]
+		if !ok {
+			sub = newCheckpointDir()
+			d.dirs[name] = sub
+		}
+		d = sub
+	}
+	return d
+}
+
+// startDir records the node for the directory snPath, which is used when the
+// directory is saved before it is complete.
+func (c *checkpoint) startDir(snPath string, node *restic.Node) {
+	n := *node
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.dir(snPath).node = &n
+}
+
+// add records that item has been completed. For directories, node
+// references the complete subtree, so the entries collected for the
+// directory are dropped.
+func (c *checkpoint) add(item string, node *restic.Node) {
+	item = strings.TrimSuffix(item, "/")
+	if item == "" {
+		return
+	}
+
+	n := *node
+	n.Name = path.Base(item)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	parent := c.dir(path.Dir(item))
+	parent.items[n.Name] = &n
+	delete(parent.dirs, n.Name)
+}
+
+// copy returns a copy of d. The nodes are shared, they are never modified
+// after they have been added.
+func (d *checkpointDir) copy() *checkpointDir {
+	res := newCheckpointDir()
+	res.node = d.node
+	for name, node := range d.items {
+		res.items[name] = node
+	}
+	for name, sub := range d.dirs {
+		res.dirs[name] = sub.copy()
+	}
+	return res
+}
+
+// checkpointNodePresent returns true if all blobs directly referenced by node
+// are contained in the index. Blobs may be missing if the pack they are
+// contained in is still being uploaded.
+func (arch *Archiver) checkpointNodePresent(node *restic.Node) bool {
+	switch node.Type {
+	case "file":
+		return arch.allBlobsPresent(node)
+	case "dir":
+		return node.Subtree != nil && arch.Repo.Index().Has(restic.BlobHandle{ID: *node.Subtree, Type: restic.TreeBlob})
+	}
+	return true
+}
+
+// saveCheckpointTree saves the trees for all incomplete subdirectories of d
+// and returns the tree for d. Directories without any completed entries are
+// left out.
+func (arch *Archiver) saveCheckpointTree(ctx context.Context, d *checkpointDir) (*restic.Tree, error) {
+	tree := restic.NewTree()
+
+	for _, node := range d.items {
+		if !arch.checkpointNodePresent(node) {
+			debug.Log("blobs for %v not in the index yet, skipping", node.Name)
+			continue
+		}
+
+		err := tree.Insert(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for name, sub := range d.dirs {
+		subtree, err := arch.saveCheckpointTree(ctx, sub)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(subtree.Nodes) == 0 {
+			continue
+		}
+
+		id, err := arch.Repo.SaveTree(ctx, subtree)
+		if err != nil {
+			return nil, err
+		}
+
+		var node restic.Node
+		if sub.node != nil {
+			node = *sub.node
+		} else {
+			// intermediate directories of the targets are only read once
+			// they are complete
+			node = restic.Node{
+				Type: "dir",
+				Mode: os.ModeDir | 0755,
+			}
+		}
+		node.Name = name
+		node.Subtree = &id
+
+		err = tree.Insert(&node)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tree, nil
+}
+
+// saveCheckpoint saves an incomplete snapshot which contains all files and
+// directories completed so far. The previous checkpoint of this backup is
+// removed afterwards.
+func (arch *Archiver) saveCheckpoint(ctx context.Context, targets []string, opts SnapshotOptions, parent *restic.ID, start time.Time) error {
+	arch.checkpoint.m.Lock()
+	root := arch.checkpoint.root.copy()
+	arch.checkpoint.m.Unlock()
+
+	// make sure all blobs completed so far are stored in the repo and
+	// contained in the index. The savers keep running meanwhile, which is
+	// safe: a packer is removed from the packer manager while a blob is
+	// added to it, so Flush only saves packers which are not in use, and
+	// the index and the parity writer are protected by their own locks.
+	// Blobs in packers which are still in use are not in the index yet,
+	// saveCheckpointTree leaves out the nodes referencing them.
+	err := arch.Repo.Flush(ctx)
+	if err != nil {
+		return err
+	}
+
+	tree, err := arch.saveCheckpointTree(ctx, root)
+	if err != nil {
+		return err
+	}
+
+	if len(tree.Nodes) == 0 {
+		debug.Log("nothing completed yet, not saving a checkpoint")
+		return nil
+	}
+
+	rootTreeID, err := arch.Repo.SaveTree(ctx, tree)
+	if err != nil {
+		return err
+	}
+
+	err = arch.Repo.Flush(ctx)
+	if err != nil {
+		return err
+	}
+
+	sn, err := restic.NewSnapshot(targets, opts.Tags, opts.Hostname, opts.Time)
+	if err != nil {
+		return err
+	}
+
+	sn.Excludes = opts.Excludes
+	sn.Command = opts.Command
+	sn.Description = opts.Description
+	sn.Labels = opts.Labels
+	sn.Parent = parent
+	sn.Tree = &rootTreeID
+	sn.Incomplete = true
+	sn.Summary = arch.snapshotSummary(start)
+
+	// checkpoints are not linked into the snapshot chain, they are removed
+	// without leaving a tombstone
+	id, err := arch.Repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+	if err != nil {
+		return err
+	}
+
+	debug.Log("saved checkpoint %v", id)
+
+	err = arch.removeCheckpoint(ctx)
+	arch.checkpoint.id = id
+	return err
+}
+
+// removeCheckpoint removes the last checkpoint snapshot saved during this
+// backup.
+func (arch *Archiver) removeCheckpoint(ctx context.Context) error {
+	if arch.checkpoint == nil || arch.checkpoint.id.IsNull() {
+		return nil
+	}
+
+	h := restic.Handle{Type: restic.SnapshotFile, Name: arch.checkpoint.id.String()}
+	err := arch.Repo.Backend().Remove(ctx, h)
+	if err != nil {
+		return errors.Wrapf(err, "remove checkpoint %v", arch.checkpoint.id.Str())
+	}
+
+	arch.checkpoint.id = restic.ID{}
+	return nil
+}
+
+// runCheckpoints saves a checkpoint every interval until done is closed. A
+// checkpoint which is being saved is always completed, so that it can be
+// removed afterwards.
+func (arch *Archiver) runCheckpoints(ctx context.Context, done <-chan struct{}, targets []string, opts SnapshotOptions, parent *restic.ID, start time.Time) error {
+	ticker := time.NewTicker(opts.CheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+		}
+
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+
+		err := arch.saveCheckpoint(ctx, targets, opts, parent, start)
+		if ctx.Err() != nil {
+			// the backup has been aborted
+			return nil
+		}
+		if err != nil {
+			debug.Log("saving checkpoint failed: %v", err)
+			err = arch.error("/", nil, errors.Wrap(err, "checkpoint"))
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
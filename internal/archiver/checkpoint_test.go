@@ -0,0 +1,222 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/checker"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	restictest "github.com/restic/restic/internal/test"
+)
+
+func nodeNames(tree *restic.Tree) []string {
+	var names []string
+	for _, node := range tree.Nodes {
+		names = append(names, node.Name)
+	}
+	return names
+}
+
+func TestArchiverCheckpoint(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := TestDir{
+		"done": TestDir{
+			"foo": TestFile{Content: "foo"},
+			"bar": TestFile{Content: "bar"},
+		},
+		"partial": TestDir{
+			"baz": TestFile{Content: "baz"},
+			"qux": TestFile{Content: "qux"},
+		},
+		"large": TestFile{Content: string(restictest.Random(23, 3*1024*1024))},
+	}
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	listSnapshots := func() restic.IDs {
+		var ids restic.IDs
+		err := repo.List(ctx, restic.SnapshotFile, func(id restic.ID, size int64) error {
+			ids = append(ids, id)
+			return nil
+		})
+		restictest.OK(t, err)
+		return ids
+	}
+
+	// the checkpoints are removed once the backup is complete
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	opts := SnapshotOptions{Time: time.Now(), CheckpointInterval: time.Millisecond}
+	sn, id, err := arch.Snapshot(ctx, []string{"."}, opts)
+	restictest.OK(t, err)
+	restictest.Equals(t, restic.IDs{id}, listSnapshots())
+
+	// all items are complete now, so the checkpoint contains the same tree
+	restictest.OK(t, arch.saveCheckpoint(ctx, []string{"."}, opts, nil, time.Now()))
+	cp, err := restic.LoadSnapshot(ctx, repo, arch.checkpoint.id)
+	restictest.OK(t, err)
+	restictest.Assert(t, cp.Incomplete, "checkpoint is not marked as incomplete")
+	restictest.Equals(t, *sn.Tree, *cp.Tree)
+
+	// simulate an interrupted backup, for which only some items are complete
+	tree, err := repo.LoadTree(ctx, *sn.Tree)
+	restictest.OK(t, err)
+	partial := tree.Find("partial")
+	subtree, err := repo.LoadTree(ctx, *partial.Subtree)
+	restictest.OK(t, err)
+
+	arch = New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	arch.checkpoint = newCheckpoint()
+	arch.checkpoint.add("/done/", tree.Find("done"))
+	arch.checkpoint.startDir("/partial", partial)
+	arch.checkpoint.add("/partial/baz", subtree.Find("baz"))
+	restictest.OK(t, arch.saveCheckpoint(ctx, []string{"."}, opts, nil, time.Now()))
+	checkpointID := arch.checkpoint.id
+
+	cp, err = restic.LoadSnapshot(ctx, repo, checkpointID)
+	restictest.OK(t, err)
+	cpTree, err := repo.LoadTree(ctx, *cp.Tree)
+	restictest.OK(t, err)
+	restictest.Equals(t, []string{"done", "partial"}, nodeNames(cpTree))
+	restictest.Equals(t, *tree.Find("done").Subtree, *cpTree.Find("done").Subtree)
+	cpSubtree, err := repo.LoadTree(ctx, *cpTree.Find("partial").Subtree)
+	restictest.OK(t, err)
+	restictest.Equals(t, []string{"baz"}, nodeNames(cpSubtree))
+
+	// a backup with the checkpoint as the parent only reads the missing files
+	testFS := &MockFS{
+		FS:        fs.Track{FS: fs.Local{}},
+		bytesRead: make(map[string]int),
+	}
+	arch = New(repo, testFS, Options{})
+	opts = SnapshotOptions{Time: time.Now(), ParentSnapshot: checkpointID}
+	sn2, _, err := arch.Snapshot(ctx, []string{"."}, opts)
+	restictest.OK(t, err)
+
+	for _, name := range []string{"done/foo", "done/bar", "partial/baz"} {
+		if n := testFS.bytesRead[name]; n != 0 {
+			t.Errorf("file %v was read again (%d bytes)", name, n)
+		}
+	}
+	for _, name := range []string{"partial/qux", "large"} {
+		if testFS.bytesRead[name] == 0 {
+			t.Errorf("file %v was not read", name)
+		}
+	}
+
+	restictest.Equals(t, *sn.Tree, *sn2.Tree)
+	restictest.Assert(t, sn2.Parent == nil, "parent %v of the checkpoint was not used", sn2.Parent)
+}
+
+// TestArchiverCheckpointConcurrent saves checkpoints while the workers are
+// still saving blobs, run it with -race.
+func TestArchiverCheckpointConcurrent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := TestDir{}
+	for i := 0; i < 10; i++ {
+		dir := TestDir{}
+		for j := 0; j < 10; j++ {
+			dir[fmt.Sprintf("file%d", j)] = TestFile{Content: string(restictest.Random(i*10+j, 64*1024))}
+		}
+		src[fmt.Sprintf("dir%d", i)] = dir
+	}
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	arch.Select = func(item string, fi os.FileInfo) bool {
+		// give the checkpoints a chance to run while files are saved
+		time.Sleep(time.Millisecond)
+		return true
+	}
+
+	opts := SnapshotOptions{Time: time.Now(), CheckpointInterval: time.Millisecond}
+	_, id, err := arch.Snapshot(ctx, []string{"."}, opts)
+	restictest.OK(t, err)
+
+	TestEnsureSnapshot(t, repo, id, src)
+
+	// the trees of the removed checkpoints are unused, all other checks
+	// must pass
+	chkr := checker.New(repo, true)
+	hints, errs := chkr.LoadIndex(ctx)
+	restictest.Equals(t, 0, len(hints)+len(errs))
+
+	errChan := make(chan error)
+	go chkr.Packs(ctx, errChan)
+	for err := range errChan {
+		t.Error(err)
+	}
+
+	errChan = make(chan error)
+	go chkr.Structure(ctx, nil, errChan)
+	for err := range errChan {
+		t.Error(err)
+	}
+}
+
+func TestArchiverDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := TestDir{
+		"a": TestFile{Content: "foo"},
+		"b": TestFile{Content: "bar"},
+		"c": TestFile{Content: "baz"},
+	}
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	// the deadline is reached once a has been started, so b and c are
+	// skipped. The deadline itself is never reached during the test.
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	arch.Select = func(item string, fi os.FileInfo) bool {
+		if filepath.Base(item) == "a" {
+			close(arch.stop)
+		}
+		return true
+	}
+
+	opts := SnapshotOptions{Time: time.Now(), Tags: restic.TagList{"foo"}, Deadline: time.Now().Add(time.Hour)}
+	sn, partialID, err := arch.Snapshot(ctx, []string{"."}, opts)
+	restictest.OK(t, err)
+	restictest.Assert(t, sn.Incomplete, "partial snapshot is not marked as incomplete")
+	restictest.Equals(t, []string{"foo", PartialTag}, sn.Tags)
+
+	tree, err := repo.LoadTree(ctx, *sn.Tree)
+	restictest.OK(t, err)
+	restictest.Equals(t, []string{"a"}, nodeNames(tree))
+
+	// the next backup only reads the skipped files
+	testFS := &MockFS{
+		FS:        fs.Track{FS: fs.Local{}},
+		bytesRead: make(map[string]int),
+	}
+	arch = New(repo, testFS, Options{})
+	opts = SnapshotOptions{Time: time.Now(), ParentSnapshot: partialID}
+	sn, _, err = arch.Snapshot(ctx, []string{"."}, opts)
+	restictest.OK(t, err)
+	restictest.Assert(t, !sn.Incomplete, "snapshot is marked as incomplete")
+	restictest.Assert(t, sn.Parent == nil, "parent %v of the partial snapshot was not used", sn.Parent)
+
+	for name, n := range map[string]int{"a": 0, "b": 3, "c": 3} {
+		restictest.Equals(t, n, testFS.bytesRead[name])
+	}
+}
@@ -155,34 +155,17 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 		return saveFileResponse{err: errors.Errorf("node type %q is wrong", node.Type)}
 	}
 
-	// reuse the chunker
-	chnker.Reset(f, s.pol)
-
 	var results []FutureBlob
-
-	node.Content = []restic.ID{}
 	var size uint64
-	for {
-		buf := s.saveFilePool.Get()
-		chunk, err := chnker.Next(buf.Data)
-		if errors.Cause(err) == io.EOF {
-			buf.Release()
-			break
-		}
 
-		buf.Data = chunk.Data
-
-		size += uint64(chunk.Length)
-
-		if err != nil {
-			_ = f.Close()
-			return saveFileResponse{err: err}
-		}
+	// save stores the data in buf, the buffer must not be used afterwards
+	save := func(buf *Buffer) error {
+		length := uint64(len(buf.Data))
+		size += length
 
 		// test if the context has been cancelled, return the error
 		if ctx.Err() != nil {
-			_ = f.Close()
-			return saveFileResponse{err: ctx.Err()}
+			return ctx.Err()
 		}
 
 		res := s.saveBlob(ctx, restic.DataBlob, buf)
@@ -190,11 +173,73 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 
 		// test if the context has been cancelled, return the error
 		if ctx.Err() != nil {
-			_ = f.Close()
-			return saveFileResponse{err: ctx.Err()}
+			return ctx.Err()
+		}
+
+		s.CompleteBlob(f.Name(), length)
+		return nil
+	}
+
+	// saveChunks splits the data read from rd into chunks and saves them
+	saveChunks := func(rd io.Reader) error {
+		// reuse the chunker
+		chnker.Reset(rd, s.pol)
+
+		for {
+			buf := s.saveFilePool.Get()
+			chunk, err := chnker.Next(buf.Data)
+			if errors.Cause(err) == io.EOF {
+				buf.Release()
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			buf.Data = chunk.Data
+
+			err = save(buf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// saveZeros saves a hole of n bytes as blobs containing only zeros
+	saveZeros := func(n int64) error {
+		for n > 0 {
+			l := n
+			if l > chunker.MaxSize {
+				l = chunker.MaxSize
+			}
+			n -= l
+
+			buf := s.saveFilePool.Get()
+			buf.Data = buf.Data[:l]
+			for i := range buf.Data {
+				buf.Data[i] = 0
+			}
+
+			err := save(buf)
+			if err != nil {
+				return err
+			}
 		}
 
-		s.CompleteBlob(f.Name(), uint64(len(chunk.Data)))
+		return nil
+	}
+
+	node.Content = []restic.ID{}
+	if fs.IsSparse(fi) {
+		err = forEachRegion(f, fi.Size(), saveChunks, saveZeros)
+	} else {
+		err = saveChunks(f)
+	}
+
+	if err != nil {
+		_ = f.Close()
+		return saveFileResponse{err: err}
 	}
 
 	err = f.Close()
@@ -220,6 +265,88 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 	}
 }
 
+// minHoleSize is the minimal size of holes in sparse files which are not read.
+// Smaller holes are read and chunked like the surrounding data.
+const minHoleSize = chunker.MinSize
+
+// forEachRegion calls data with a reader for each region of the sparse file f
+// which contains data, and hole with the length of each hole in between.
+func forEachRegion(f fs.File, size int64, data func(rd io.Reader) error, hole func(n int64) error) error {
+	var pos int64
+	for pos < size {
+		start, end, err := nextDataRegion(f, pos, size)
+		if err != nil {
+			return err
+		}
+
+		if start > pos {
+			err = hole(start - pos)
+			if err != nil {
+				return err
+			}
+		}
+
+		if end > start {
+			_, err = f.Seek(start, io.SeekStart)
+			if err != nil {
+				return err
+			}
+
+			err = data(io.LimitReader(f, end-start))
+			if err != nil {
+				return err
+			}
+		}
+
+		pos = end
+	}
+
+	return nil
+}
+
+// nextDataRegion returns the start and end of the next region at or after pos
+// which contains data. Holes smaller than minHoleSize are part of the region.
+func nextDataRegion(f fs.File, pos, size int64) (start, end int64, err error) {
+	start, err = fs.SeekData(f, pos)
+	if err == io.EOF || start > size {
+		return size, size, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if start-pos < minHoleSize {
+		start = pos
+	}
+
+	end = start
+	for {
+		end, err = fs.SeekHole(f, end)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		next, err := fs.SeekData(f, end)
+		if err == io.EOF || next > size {
+			next = size
+		} else if err != nil {
+			return 0, 0, err
+		}
+
+		if end >= size || next-end >= minHoleSize {
+			break
+		}
+
+		end = next
+	}
+
+	if end > size || size-end < minHoleSize {
+		end = size
+	}
+
+	return start, end, nil
+}
+
 func (s *FileSaver) worker(ctx context.Context, jobs <-chan saveFileJob) {
 	// a worker has one chunker which is reused for each file (because it contains a rather large buffer)
 	chnker := chunker.New(nil, s.pol)
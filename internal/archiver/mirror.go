@@ -0,0 +1,150 @@
+package archiver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// MirrorRepository is a repository which saves all blobs to additional
+// repositories, the mirrors, so that the files are only read once when they
+// are backed up to several repositories. Everything else, e.g. loading trees
+// or saving the snapshot, uses the primary repository only.
+//
+// An error in a mirror does not abort the backup: the mirror is disabled and
+// ignored afterwards, Err returns the error. Errors in the primary repository
+// are returned as usual.
+//
+// All repositories must use the same chunker polynomial, as the blobs are only
+// split once.
+type MirrorRepository struct {
+	restic.Repository
+
+	m       sync.Mutex
+	mirrors []restic.Repository
+	errs    map[restic.Repository]error
+}
+
+// NewMirrorRepository returns a repository which saves all blobs to primary
+// and mirrors.
+func NewMirrorRepository(primary restic.Repository, mirrors ...restic.Repository) *MirrorRepository {
+	return &MirrorRepository{
+		Repository: primary,
+		mirrors:    mirrors,
+		errs:       make(map[restic.Repository]error),
+	}
+}
+
+// Err returns the error which disabled mirror, or nil if mirror is still
+// usable.
+func (r *MirrorRepository) Err(mirror restic.Repository) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.errs[mirror]
+}
+
+// active returns the mirrors which have not been disabled yet.
+func (r *MirrorRepository) active() []restic.Repository {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	res := make([]restic.Repository, 0, len(r.mirrors))
+	for _, mirror := range r.mirrors {
+		if r.errs[mirror] == nil {
+			res = append(res, mirror)
+		}
+	}
+	return res
+}
+
+// disable records err for mirror, the mirror is not used any more.
+func (r *MirrorRepository) disable(mirror restic.Repository, err error) {
+	debug.Log("disabling mirror %v: %v", mirror.Backend().Location(), err)
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.errs[mirror] == nil {
+		r.errs[mirror] = err
+	}
+}
+
+// SaveBlob saves the blob to the primary repository and all mirrors. Each
+// repository deduplicates the blob against its own index, known is reported
+// for the primary repository.
+func (r *MirrorRepository) SaveBlob(ctx context.Context, t restic.BlobType, buf []byte, id restic.ID, storeDuplicate bool) (restic.ID, bool, error) {
+	newID, known, err := r.Repository.SaveBlob(ctx, t, buf, id, storeDuplicate)
+	if err != nil {
+		return newID, known, err
+	}
+
+	for _, mirror := range r.active() {
+		_, _, err := mirror.SaveBlob(ctx, t, buf, newID, storeDuplicate)
+		if err != nil {
+			r.disable(mirror, err)
+		}
+	}
+
+	return newID, known, nil
+}
+
+// Flush saves all remaining packs and the indexes of the primary repository
+// and all mirrors.
+func (r *MirrorRepository) Flush(ctx context.Context) error {
+	err := r.Repository.Flush(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, mirror := range r.active() {
+		err := mirror.Flush(ctx)
+		if err != nil {
+			r.disable(mirror, err)
+		}
+	}
+
+	return nil
+}
+
+// CopyBlobs copies the blobs with the given IDs from the primary repository
+// to all mirrors which do not contain them yet. The archiver uses this for
+// files which are unchanged since the parent snapshot in the primary
+// repository, so they are not read again. Each mirror only receives the
+// blobs it is missing.
+func (r *MirrorRepository) CopyBlobs(ctx context.Context, t restic.BlobType, ids restic.IDs) {
+	var buf []byte
+	for _, id := range ids {
+		h := restic.BlobHandle{ID: id, Type: t}
+
+		var missing []restic.Repository
+		for _, mirror := range r.active() {
+			if !mirror.Index().Has(h) {
+				missing = append(missing, mirror)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		var err error
+		buf, err = r.Repository.LoadBlob(ctx, t, id, buf)
+		if err != nil {
+			err = errors.Wrapf(err, "unable to load blob %v to copy it", id.Str())
+			for _, mirror := range missing {
+				r.disable(mirror, err)
+			}
+			continue
+		}
+
+		for _, mirror := range missing {
+			_, _, err := mirror.SaveBlob(ctx, t, buf, id, false)
+			if err != nil {
+				r.disable(mirror, err)
+			}
+		}
+	}
+}
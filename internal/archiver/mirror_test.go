@@ -0,0 +1,76 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	restictest "github.com/restic/restic/internal/test"
+)
+
+// failingSaveRepo is a repository for which saving blobs always fails.
+type failingSaveRepo struct {
+	restic.Repository
+}
+
+func (r failingSaveRepo) SaveBlob(context.Context, restic.BlobType, []byte, restic.ID, bool) (restic.ID, bool, error) {
+	return restic.ID{}, false, errors.New("SaveBlob failed")
+}
+
+func TestArchiverMirror(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := TestDir{
+		"subdir": TestDir{
+			"foo": TestFile{Content: "foo"},
+			"bar": TestFile{Content: "bar"},
+		},
+		"large": TestFile{Content: string(restictest.Random(23, 3*1024*1024))},
+	}
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	_, parentID, err := New(repo, fs.Track{FS: fs.Local{}}, Options{}).Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	restictest.OK(t, err)
+
+	// the files are unchanged since the parent snapshot, the data the
+	// mirrors do not contain yet is copied from the primary repository
+	mirror, removeMirror := repository.TestRepository(t)
+	defer removeMirror()
+	mirror2, removeMirror2 := repository.TestRepository(t)
+	defer removeMirror2()
+
+	mirrorRepo := NewMirrorRepository(repo, mirror, mirror2)
+	arch := New(mirrorRepo, fs.Track{FS: fs.Local{}}, Options{})
+	arch.StartFile = func(filename string) {
+		t.Errorf("unchanged file %v was read again", filename)
+	}
+	sn, _, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now(), ParentSnapshot: parentID})
+	restictest.OK(t, err)
+	restictest.OK(t, mirrorRepo.Err(mirror))
+	restictest.OK(t, mirrorRepo.Err(mirror2))
+
+	TestEnsureTree(ctx, t, "/", mirror, *sn.Tree, src)
+	TestEnsureTree(ctx, t, "/", mirror2, *sn.Tree, src)
+
+	// a failing mirror does not affect the primary repository
+	other, removeOther := repository.TestRepository(t)
+	defer removeOther()
+
+	failing := failingSaveRepo{Repository: other}
+	mirrorRepo = NewMirrorRepository(repo, failing)
+	arch = New(mirrorRepo, fs.Track{FS: fs.Local{}}, Options{})
+	_, id, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	restictest.OK(t, err)
+	restictest.Assert(t, mirrorRepo.Err(failing) != nil, "error in the mirror was not recorded")
+
+	TestEnsureSnapshot(t, repo, id, src)
+}
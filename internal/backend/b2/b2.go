@@ -317,7 +317,9 @@ func (be *b2Backend) Delete(ctx context.Context) error {
 		restic.KeyFile,
 		restic.LockFile,
 		restic.SnapshotFile,
-		restic.IndexFile}
+		restic.IndexFile,
+		restic.ParityFile,
+		restic.TombstoneFile}
 
 	for _, t := range alltypes {
 		err := be.removeKeys(ctx, t)
@@ -426,7 +426,9 @@ func (be *Backend) Delete(ctx context.Context) error {
 		restic.KeyFile,
 		restic.LockFile,
 		restic.SnapshotFile,
-		restic.IndexFile}
+		restic.IndexFile,
+		restic.ParityFile,
+		restic.TombstoneFile}
 
 	for _, t := range alltypes {
 		err := be.removeKeys(ctx, t)
@@ -16,11 +16,14 @@ type DefaultLayout struct {
 }
 
 var defaultLayoutPaths = map[restic.FileType]string{
-	restic.PackFile:     "data",
-	restic.SnapshotFile: "snapshots",
-	restic.IndexFile:    "index",
-	restic.LockFile:     "locks",
-	restic.KeyFile:      "keys",
+	restic.PackFile:      "data",
+	restic.SnapshotFile:  "snapshots",
+	restic.IndexFile:     "index",
+	restic.LockFile:      "locks",
+	restic.KeyFile:       "keys",
+	restic.MigrationFile: "migrations",
+	restic.ParityFile:    "parity",
+	restic.TombstoneFile: "tombstones",
 }
 
 func (l *DefaultLayout) String() string {
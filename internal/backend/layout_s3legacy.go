@@ -16,6 +16,11 @@ var s3LayoutPaths = map[restic.FileType]string{
 	restic.IndexFile:    "index",
 	restic.LockFile:     "lock",
 	restic.KeyFile:      "key",
+	// migrations use the same path in all layouts, so an interrupted
+	// migration of the layout can be resumed
+	restic.MigrationFile: "migrations",
+	restic.ParityFile:    "parity",
+	restic.TombstoneFile: "tombstones",
 }
 
 func (l *S3LegacyLayout) String() string {
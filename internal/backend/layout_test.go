@@ -111,6 +111,9 @@ func TestDefaultLayout(t *testing.T) {
 			filepath.Join(tempdir, "index"),
 			filepath.Join(tempdir, "locks"),
 			filepath.Join(tempdir, "keys"),
+			filepath.Join(tempdir, "migrations"),
+			filepath.Join(tempdir, "parity"),
+			filepath.Join(tempdir, "tombstones"),
 		}
 
 		for i := 0; i < 256; i++ {
@@ -188,6 +191,9 @@ func TestRESTLayout(t *testing.T) {
 			filepath.Join(path, "index"),
 			filepath.Join(path, "locks"),
 			filepath.Join(path, "keys"),
+			filepath.Join(path, "migrations"),
+			filepath.Join(path, "parity"),
+			filepath.Join(path, "tombstones"),
 		}
 
 		sort.Strings(want)
@@ -334,6 +340,9 @@ func TestS3LegacyLayout(t *testing.T) {
 			filepath.Join(path, "index"),
 			filepath.Join(path, "lock"),
 			filepath.Join(path, "key"),
+			filepath.Join(path, "migrations"),
+			filepath.Join(path, "parity"),
+			filepath.Join(path, "tombstones"),
 		}
 
 		sort.Strings(want)
@@ -467,7 +467,9 @@ func (b *Backend) Delete(ctx context.Context) error {
 		restic.KeyFile,
 		restic.LockFile,
 		restic.SnapshotFile,
-		restic.IndexFile}
+		restic.IndexFile,
+		restic.ParityFile,
+		restic.TombstoneFile}
 
 	for _, t := range alltypes {
 		err := b.removeKeys(ctx, t)
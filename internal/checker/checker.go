@@ -13,6 +13,8 @@ import (
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/ui/progress"
+
+	"github.com/klauspost/compress/zstd"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -435,8 +437,9 @@ func (c *Checker) GetPacks() map[restic.ID]int64 {
 	return c.packs
 }
 
-// checkPack reads a pack and checks the integrity of all blobs.
-func checkPack(ctx context.Context, r restic.Repository, id restic.ID, size int64) error {
+// checkPack reads a pack and checks the integrity of all blobs. Compressed
+// blobs are decompressed with dec.
+func checkPack(ctx context.Context, r restic.Repository, id restic.ID, size int64, dec *zstd.Decoder) error {
 	debug.Log("checking pack %v", id)
 	h := restic.Handle{Type: restic.PackFile, Name: id.String()}
 
@@ -493,14 +496,24 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID, size int6
 			continue
 		}
 
-		nonce, ciphertext := buf[:r.Key().NonceSize()], buf[r.Key().NonceSize():]
-		plaintext, err := r.Key().Open(ciphertext[:0], nonce, ciphertext, nil)
+		key := r.Key().WithSealedFallback()
+		nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+		plaintext, err := key.Open(ciphertext[:0], nonce, ciphertext, nil)
 		if err != nil {
 			debug.Log("  error decrypting blob %v: %v", blob.ID, err)
 			errs = append(errs, errors.Errorf("blob %v: %v", i, err))
 			continue
 		}
 
+		if blob.IsCompressed() {
+			plaintext, err = dec.DecodeAll(plaintext, make([]byte, 0, blob.DataLength()))
+			if err != nil {
+				debug.Log("  error decompressing blob %v: %v", blob.ID, err)
+				errs = append(errs, errors.Errorf("blob %v: %v", i, err))
+				continue
+			}
+		}
+
 		hash := restic.Hash(plaintext)
 		if !hash.Equal(blob.ID) {
 			debug.Log("  Blob ID does not match, want %v, got %v", blob.ID, hash)
@@ -511,7 +524,8 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID, size int6
 		// Check if blob is contained in index and position is correct
 		idxHas := false
 		for _, pb := range idx.Lookup(blob.BlobHandle) {
-			if pb.PackID == id && pb.Offset == blob.Offset && pb.Length == blob.Length {
+			if pb.PackID == id && pb.Offset == blob.Offset && pb.Length == blob.Length &&
+				pb.UncompressedLength == blob.UncompressedLength {
 				idxHas = true
 				break
 			}
@@ -528,7 +542,7 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID, size int6
 	}
 
 	if len(errs) > 0 {
-		return errors.Errorf("pack %v contains %v errors: %v", id.Str(), len(errs), errs)
+		return errors.Errorf("contains %v errors: %v", len(errs), errs)
 	}
 
 	return nil
@@ -553,6 +567,13 @@ func (c *Checker) ReadPacks(ctx context.Context, packs map[restic.ID]int64, p *p
 	// run workers
 	for i := 0; i < defaultParallelism; i++ {
 		g.Go(func() error {
+			// the decoder is reused for all packs checked by this worker
+			dec, err := zstd.NewReader(nil)
+			if err != nil {
+				panic(err)
+			}
+			defer dec.Close()
+
 			for {
 				var ps packsize
 				var ok bool
@@ -565,7 +586,7 @@ func (c *Checker) ReadPacks(ctx context.Context, packs map[restic.ID]int64, p *p
 						return nil
 					}
 				}
-				err := checkPack(ctx, c.repo, ps.id, ps.size)
+				err := checkPack(ctx, c.repo, ps.id, ps.size, dec)
 				p.Add(1)
 				if err == nil {
 					continue
@@ -574,7 +595,7 @@ func (c *Checker) ReadPacks(ctx context.Context, packs map[restic.ID]int64, p *p
 				select {
 				case <-ctx.Done():
 					return nil
-				case errChan <- err:
+				case errChan <- PackError{ID: ps.id, Err: err}:
 				}
 			}
 		})
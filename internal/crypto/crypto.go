@@ -36,6 +36,19 @@ var (
 type Key struct {
 	MACKey        `json:"mac"`
 	EncryptionKey `json:"encrypt"`
+
+	// sealPub is the ephemeral public key of a session key, which is used
+	// for sealing data with a write-only key.
+	sealPub *PublicKey
+
+	// derived is set for keys derived from the master key, which do not
+	// try to decrypt sealed data and metadata.
+	derived bool
+
+	// metadataFallback is set for keys returned by WithMetadataFallback,
+	// sealedFallback for keys returned by WithSealedFallback.
+	metadataFallback bool
+	sealedFallback   bool
 }
 
 // EncryptionKey is key used for encryption
@@ -252,6 +265,9 @@ func (k *Key) NonceSize() int {
 // Overhead returns the maximum difference between the lengths of a
 // plaintext and its ciphertext.
 func (k *Key) Overhead() int {
+	if k.sealPub != nil {
+		return PublicKeySize + macSize
+	}
 	return macSize
 }
 
@@ -296,6 +312,10 @@ func (k *Key) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
 		panic("nonce is invalid")
 	}
 
+	if k.sealPub != nil {
+		return k.sealSession(dst, nonce, plaintext)
+	}
+
 	ret, out := sliceForAppend(dst, len(plaintext)+k.Overhead())
 
 	c, err := aes.NewCipher(k.EncryptionKey[:])
@@ -322,6 +342,10 @@ func (k *Key) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
 //
 // Even if the function fails, the contents of dst, up to its capacity,
 // may be overwritten.
+//
+// Only keys returned by WithSealedFallback also try to decrypt data sealed
+// with a write-only key if the ciphertext cannot be authenticated with the
+// master key, only keys returned by WithMetadataFallback try the metadata key.
 func (k *Key) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
 	if !k.Valid() {
 		return nil, errors.New("invalid key")
@@ -341,12 +365,19 @@ func (k *Key) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error
 		return nil, errors.Errorf("trying to decrypt invalid data: ciphertext too small")
 	}
 
+	if k.sealPub != nil {
+		return k.openSession(dst, nonce, ciphertext)
+	}
+
 	l := len(ciphertext) - macSize
 	ct, mac := ciphertext[:l], ciphertext[l:]
 
 	// verify mac
 	if !poly1305Verify(ct, nonce, &k.MACKey, mac) {
-		return nil, ErrUnauthenticated
+		if k.derived {
+			return nil, ErrUnauthenticated
+		}
+		return k.openFallback(dst, nonce, ciphertext)
 	}
 
 	ret, out := sliceForAppend(dst, len(ct))
@@ -7,27 +7,82 @@ import (
 	"github.com/restic/restic/internal/errors"
 
 	sscrypt "github.com/elithrar/simple-scrypt"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/scrypt"
 )
 
 const saltLength = 64
 
-// Params are the default parameters used for the key derivation function KDF().
+// Names of the supported key derivation functions.
+const (
+	KDFScrypt   = "scrypt"
+	KDFArgon2id = "argon2id"
+)
+
+// DefaultKDF is the key derivation function used for new keys.
+const DefaultKDF = KDFScrypt
+
+// Params are the parameters used for the key derivation function KDF().
 type Params struct {
+	// KDF is the name of the key derivation function, an empty string
+	// selects scrypt.
+	KDF string
+
+	// parameters for scrypt
 	N int
 	R int
 	P int
+
+	// parameters for argon2id, Memory is measured in KiB
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// argon2Threads is the degree of parallelism used for new argon2id keys.
+const argon2Threads = 4
+
+// The parameters of argon2id are read from the key files, which are not
+// authenticated before the key has been derived. Keys with parameters above
+// these limits are rejected, so that a crafted key file cannot make restic
+// exhaust the memory or run for a very long time. The number of threads is
+// limited to 255 by its type.
+const (
+	argon2MaxMemory = 4 * 1024 * 1024 // KiB, 4 GiB
+	argon2MaxTime   = 100
+)
+
+// ValidKDF returns an error if kdf is not the name of a supported key
+// derivation function.
+func ValidKDF(kdf string) error {
+	switch kdf {
+	case KDFScrypt, KDFArgon2id:
+		return nil
+	}
+	return errors.Errorf("unsupported KDF %q", kdf)
 }
 
 // DefaultKDFParams are the default parameters used for Calibrate and KDF().
 var DefaultKDFParams = Params{
-	N: sscrypt.DefaultParams.N,
-	R: sscrypt.DefaultParams.R,
-	P: sscrypt.DefaultParams.P,
+	KDF: KDFScrypt,
+	N:   sscrypt.DefaultParams.N,
+	R:   sscrypt.DefaultParams.R,
+	P:   sscrypt.DefaultParams.P,
+}
+
+// Calibrate determines new parameters for the key derivation function kdf on
+// the current hardware. The memory is given in MiB.
+func Calibrate(kdf string, timeout time.Duration, memory int) (Params, error) {
+	switch kdf {
+	case KDFScrypt:
+		return calibrateScrypt(timeout, memory)
+	case KDFArgon2id:
+		return calibrateArgon2id(timeout, memory)
+	}
+	return Params{}, ValidKDF(kdf)
 }
 
-// Calibrate determines new KDF parameters for the current hardware.
-func Calibrate(timeout time.Duration, memory int) (Params, error) {
+func calibrateScrypt(timeout time.Duration, memory int) (Params, error) {
 	defaultParams := sscrypt.Params{
 		N:       DefaultKDFParams.N,
 		R:       DefaultKDFParams.R,
@@ -42,19 +97,89 @@ func Calibrate(timeout time.Duration, memory int) (Params, error) {
 	}
 
 	return Params{
-		N: params.N,
-		R: params.R,
-		P: params.P,
+		KDF: KDFScrypt,
+		N:   params.N,
+		R:   params.R,
+		P:   params.P,
 	}, nil
 }
 
+// calibrateArgon2id uses all of the memory and increases the number of passes
+// until the derivation takes about as long as timeout.
+func calibrateArgon2id(timeout time.Duration, memory int) (Params, error) {
+	if memory <= 0 || memory*1024 > argon2MaxMemory {
+		return Params{}, errors.Errorf("invalid memory limit %d for argon2id", memory)
+	}
+
+	p := Params{
+		KDF:     KDFArgon2id,
+		Time:    1,
+		Memory:  uint32(memory) * 1024,
+		Threads: argon2Threads,
+	}
+
+	salt, err := NewSalt()
+	if err != nil {
+		return Params{}, err
+	}
+
+	start := time.Now()
+	argon2.IDKey([]byte("password"), salt, p.Time, p.Memory, p.Threads, macKeySize+aesKeySize)
+	elapsed := time.Since(start)
+
+	if elapsed > 0 && elapsed < timeout {
+		p.Time = uint32(timeout / elapsed)
+	}
+	if p.Time > argon2MaxTime {
+		p.Time = argon2MaxTime
+	}
+
+	return p, nil
+}
+
 // KDF derives encryption and message authentication keys from the password
-// using the supplied parameters N, R and P and the Salt.
+// using the supplied parameters and the Salt.
 func KDF(p Params, salt []byte, password string) (*Key, error) {
 	if len(salt) != saltLength {
-		return nil, errors.Errorf("scrypt() called with invalid salt bytes (len %d)", len(salt))
+		return nil, errors.Errorf("KDF called with invalid salt bytes (len %d)", len(salt))
+	}
+
+	keybytes := macKeySize + aesKeySize
+
+	var (
+		keys []byte
+		err  error
+	)
+
+	switch p.KDF {
+	case "", KDFScrypt:
+		keys, err = scryptKey(p, salt, password, keybytes)
+	case KDFArgon2id:
+		keys, err = argon2idKey(p, salt, password, keybytes)
+	default:
+		err = ValidKDF(p.KDF)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) != keybytes {
+		return nil, errors.Errorf("invalid numbers of bytes expanded from KDF: %d", len(keys))
+	}
+
+	derKeys := &Key{}
 
+	// first 32 byte of KDF output is the encryption key
+	copy(derKeys.EncryptionKey[:], keys[:aesKeySize])
+
+	// next 32 byte of KDF output is the mac key, in the form k||r
+	macKeyFromSlice(&derKeys.MACKey, keys[aesKeySize:])
+
+	return derKeys, nil
+}
+
+// scryptKey derives keybytes bytes with scrypt and the parameters N, R and P.
+func scryptKey(p Params, salt []byte, password string, keybytes int) ([]byte, error) {
 	// make sure we have valid parameters
 	params := sscrypt.Params{
 		N:       p.N,
@@ -68,25 +193,26 @@ func KDF(p Params, salt []byte, password string) (*Key, error) {
 		return nil, errors.Wrap(err, "Check")
 	}
 
-	derKeys := &Key{}
-
-	keybytes := macKeySize + aesKeySize
-	scryptKeys, err := scrypt.Key([]byte(password), salt, p.N, p.R, p.P, keybytes)
+	keys, err := scrypt.Key([]byte(password), salt, p.N, p.R, p.P, keybytes)
 	if err != nil {
 		return nil, errors.Wrap(err, "scrypt.Key")
 	}
+	return keys, nil
+}
 
-	if len(scryptKeys) != keybytes {
-		return nil, errors.Errorf("invalid numbers of bytes expanded from scrypt(): %d", len(scryptKeys))
+// argon2idKey derives keybytes bytes with argon2id and the parameters Time,
+// Memory and Threads.
+func argon2idKey(p Params, salt []byte, password string, keybytes int) ([]byte, error) {
+	// argon2.IDKey panics on invalid parameters
+	if p.Time < 1 || p.Threads < 1 || p.Memory < 8*uint32(p.Threads) {
+		return nil, errors.Errorf("invalid argon2id parameters t=%d m=%d p=%d", p.Time, p.Memory, p.Threads)
 	}
 
-	// first 32 byte of scrypt output is the encryption key
-	copy(derKeys.EncryptionKey[:], scryptKeys[:aesKeySize])
-
-	// next 32 byte of scrypt output is the mac key, in the form k||r
-	macKeyFromSlice(&derKeys.MACKey, scryptKeys[aesKeySize:])
+	if p.Time > argon2MaxTime || p.Memory > argon2MaxMemory {
+		return nil, errors.Errorf("argon2id parameters t=%d m=%d exceed the limits t=%d m=%d", p.Time, p.Memory, argon2MaxTime, argon2MaxMemory)
+	}
 
-	return derKeys, nil
+	return argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(keybytes)), nil
 }
 
 // NewSalt returns new random salt bytes to use with KDF(). If NewSalt returns
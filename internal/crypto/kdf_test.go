@@ -6,9 +6,73 @@ import (
 )
 
 func TestCalibrate(t *testing.T) {
-	params, err := Calibrate(100*time.Millisecond, 50)
+	for _, kdf := range []string{KDFScrypt, KDFArgon2id} {
+		params, err := Calibrate(kdf, 100*time.Millisecond, 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("testing calibrate, params after: %v", params)
+
+		if params.KDF != kdf {
+			t.Fatalf("wrong KDF in calibrated params, want %v, got %v", kdf, params.KDF)
+		}
+	}
+}
+
+func TestKDFArgon2id(t *testing.T) {
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := Params{KDF: KDFArgon2id, Time: 1, Memory: 64, Threads: 1}
+	k1, err := KDF(p, salt, "foobar")
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Logf("testing calibrate, params after: %v", params)
+
+	k2, err := KDF(p, salt, "foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k1.EncryptionKey != k2.EncryptionKey || k1.MACKey != k2.MACKey {
+		t.Fatal("KDF returned different keys for the same password")
+	}
+
+	k3, err := KDF(p, salt, "foobaz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k1.EncryptionKey == k3.EncryptionKey {
+		t.Fatal("KDF returned the same key for different passwords")
+	}
+
+	p.Threads = 0
+	if _, err = KDF(p, salt, "foobar"); err == nil {
+		t.Fatal("KDF accepted invalid argon2id parameters")
+	}
+}
+
+func TestKDFArgon2idLimits(t *testing.T) {
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the parameters are rejected before any memory is allocated
+	for _, p := range []Params{
+		{KDF: KDFArgon2id, Time: 1, Memory: argon2MaxMemory + 1, Threads: 1},
+		{KDF: KDFArgon2id, Time: argon2MaxTime + 1, Memory: 64, Threads: 1},
+		{KDF: KDFArgon2id, Time: 1 << 31, Memory: 1 << 31, Threads: 255},
+	} {
+		if _, err = KDF(p, salt, "foobar"); err == nil {
+			t.Errorf("KDF accepted argon2id parameters above the limits: %+v", p)
+		}
+	}
+
+	if _, err = Calibrate(KDFArgon2id, time.Second, argon2MaxMemory/1024+1); err == nil {
+		t.Error("Calibrate accepted a memory limit above the maximum")
+	}
 }
@@ -0,0 +1,281 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"sync"
+
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+	"golang.org/x/crypto/curve25519"
+)
+
+// Data written with a write-only key is sealed to the public key of the
+// repository. For each session an ephemeral X25519 key pair is generated, the
+// session key is derived from the shared secret of the ephemeral private key
+// and the public key of the repository. The ephemeral public key is stored in
+// each ciphertext between the IV and the encrypted data, the MAC covers both:
+//
+//   IV || ephemeral public key || ciphertext || MAC
+//
+// The private key of the repository is derived from the master key, so
+// everybody who has access to the master key can decrypt sealed data.
+
+// PublicKeySize is the size of a public key in bytes.
+const PublicKeySize = curve25519.PointSize
+
+// PublicKey is used to seal data so that only holders of the master key can
+// decrypt it.
+type PublicKey [PublicKeySize]byte
+
+const (
+	privateKeyLabel  = "restic write-only private key"
+	metadataKeyLabel = "restic metadata key"
+	sessionKeyLabel  = "restic sealed session key"
+)
+
+// keyMaterial returns the raw key material of k.
+func (k *Key) keyMaterial() []byte {
+	buf := make([]byte, 0, aesKeySize+macKeySize)
+	buf = append(buf, k.EncryptionKey[:]...)
+	buf = append(buf, k.MACKey.K[:]...)
+	// the key for Poly1305 is masked on first use, make sure to always use
+	// the masked value
+	for i, b := range k.MACKey.R {
+		buf = append(buf, b&poly1305KeyMask[i])
+	}
+	return buf
+}
+
+// deriveKey returns a new key derived from secret and label.
+func deriveKey(secret []byte, label string, data ...[]byte) *Key {
+	mac := hmac.New(sha512.New, secret)
+	_, _ = mac.Write([]byte(label))
+	for _, d := range data {
+		_, _ = mac.Write(d)
+	}
+	buf := mac.Sum(nil)
+
+	k := &Key{derived: true}
+	copy(k.EncryptionKey[:], buf[:aesKeySize])
+	macKeyFromSlice(&k.MACKey, buf[aesKeySize:])
+	return k
+}
+
+// privateKey returns the private key of the repository, which is derived
+// from the master key k.
+func (k *Key) privateKey() []byte {
+	mac := hmac.New(sha256.New, k.keyMaterial())
+	_, _ = mac.Write([]byte(privateKeyLabel))
+	return mac.Sum(nil)
+}
+
+// PublicKey returns the public key which belongs to the master key k. Data
+// sealed to it can be decrypted with k.
+func (k *Key) PublicKey() PublicKey {
+	buf, err := curve25519.X25519(k.privateKey(), curve25519.Basepoint)
+	if err != nil {
+		panic(fmt.Sprintf("unable to compute public key: %v", err))
+	}
+
+	var pub PublicKey
+	copy(pub[:], buf)
+	return pub
+}
+
+// MetadataKey returns the key derived from the master key k which is used to
+// encrypt metadata that must be readable with write-only keys.
+func (k *Key) MetadataKey() *Key {
+	return deriveKey(k.keyMaterial(), metadataKeyLabel)
+}
+
+// WithMetadataFallback returns a copy of the master key k which also decrypts
+// data encrypted with the metadata key. Write-only keys contain the metadata
+// key, so data which can be decrypted with it is not authenticated by the
+// master key: it must only be used for index files, lock files and pack
+// headers, never for snapshots, trees or other data.
+func (k *Key) WithMetadataFallback() *Key {
+	if k.derived || k.sealPub != nil {
+		return k
+	}
+
+	c := *k
+	c.metadataFallback = true
+	return &c
+}
+
+// WithSealedFallback returns a copy of the master key k which also decrypts
+// data sealed with a write-only key. Every write-only key can seal data, so
+// it must only be used for the files write-only keys write: snapshots, blobs
+// in pack files, index and lock files. Never for the config, tombstones or
+// other files which must only be written by holders of the master key.
+func (k *Key) WithSealedFallback() *Key {
+	if k.derived || k.sealPub != nil {
+		return k
+	}
+
+	c := *k
+	c.sealedFallback = true
+	return &c
+}
+
+// newSessionKey derives the session key from the shared secret.
+func newSessionKey(shared []byte, ephemeral, pub PublicKey) *Key {
+	k := deriveKey(shared, sessionKeyLabel, ephemeral[:], pub[:])
+	k.sealPub = &ephemeral
+	return k
+}
+
+// NewSealingKey returns a new key which seals data to the public key pub.
+// The returned key can only decrypt data sealed by itself.
+func NewSealingKey(pub PublicKey) (*Key, error) {
+	priv := make([]byte, curve25519.ScalarSize)
+	_, err := rand.Read(priv)
+	if err != nil {
+		panic("unable to read enough random bytes for new key")
+	}
+
+	buf, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "X25519")
+	}
+	var ephemeral PublicKey
+	copy(ephemeral[:], buf)
+
+	shared, err := curve25519.X25519(priv, pub[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public key")
+	}
+
+	return newSessionKey(shared, ephemeral, pub), nil
+}
+
+// sessionKeyCacheSize is the maximum number of session keys kept in the
+// cache. Each backup run with a write-only key uses one session, the least
+// recently used keys are derived again when needed.
+const sessionKeyCacheSize = 1024
+
+// sessionKeys caches the session keys which have been derived for opening
+// sealed data, the key is the hash of the private and the ephemeral public
+// key.
+var sessionKeys = struct {
+	sync.Mutex
+	c *simplelru.LRU
+}{c: newSessionKeyCache()}
+
+func newSessionKeyCache() *simplelru.LRU {
+	c, err := simplelru.NewLRU(sessionKeyCacheSize, nil)
+	if err != nil {
+		panic(err) // only for a size <= 0
+	}
+	return c
+}
+
+// sessionKey returns the session key for data sealed with the ephemeral
+// public key to the public key of the master key k.
+func (k *Key) sessionKey(ephemeral PublicKey) (*Key, error) {
+	priv := k.privateKey()
+	id := sha256.Sum256(append(priv, ephemeral[:]...))
+
+	sessionKeys.Lock()
+	sk, ok := sessionKeys.c.Get(id)
+	sessionKeys.Unlock()
+	if ok {
+		return sk.(*Key), nil
+	}
+
+	shared, err := curve25519.X25519(priv, ephemeral[:])
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+
+	key := newSessionKey(shared, ephemeral, k.PublicKey())
+	sessionKeys.Lock()
+	sessionKeys.c.Add(id, key)
+	sessionKeys.Unlock()
+	return key, nil
+}
+
+// sealSession encrypts plaintext with the session key k and appends the
+// ephemeral public key, the ciphertext and the MAC to dst.
+func (k *Key) sealSession(dst, nonce, plaintext []byte) []byte {
+	ret, out := sliceForAppend(dst, PublicKeySize+len(plaintext)+macSize)
+	copy(out, k.sealPub[:])
+
+	c, err := aes.NewCipher(k.EncryptionKey[:])
+	if err != nil {
+		panic(fmt.Sprintf("unable to create cipher: %v", err))
+	}
+	e := cipher.NewCTR(c, nonce)
+	e.XORKeyStream(out[PublicKeySize:], plaintext)
+
+	l := PublicKeySize + len(plaintext)
+	mac := poly1305MAC(out[:l], nonce, &k.MACKey)
+	copy(out[l:], mac)
+
+	return ret
+}
+
+// openSession decrypts data sealed with the session key k.
+func (k *Key) openSession(dst, nonce, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < PublicKeySize+macSize {
+		return nil, errors.Errorf("trying to decrypt invalid data: ciphertext too small")
+	}
+
+	if !bytes.Equal(ciphertext[:PublicKeySize], k.sealPub[:]) {
+		return nil, ErrUnauthenticated
+	}
+
+	l := len(ciphertext) - macSize
+	msg, mac := ciphertext[:l], ciphertext[l:]
+	if !poly1305Verify(msg, nonce, &k.MACKey, mac) {
+		return nil, ErrUnauthenticated
+	}
+
+	c, err := aes.NewCipher(k.EncryptionKey[:])
+	if err != nil {
+		panic(fmt.Sprintf("unable to create cipher: %v", err))
+	}
+	e := cipher.NewCTR(c, nonce)
+
+	// dst usually aliases the ciphertext with an offset, which is not
+	// supported by XORKeyStream, so decrypt to a new buffer first
+	ct := msg[PublicKeySize:]
+	plaintext := make([]byte, len(ct))
+	e.XORKeyStream(plaintext, ct)
+
+	ret, out := sliceForAppend(dst, len(plaintext))
+	copy(out, plaintext)
+	return ret, nil
+}
+
+// openFallback tries to decrypt data which could not be authenticated with
+// the master key k: If the sealed fallback is enabled for k, as data sealed
+// with a write-only key, and if the metadata fallback is enabled, as metadata
+// which was encrypted with the metadata key.
+func (k *Key) openFallback(dst, nonce, ciphertext []byte) ([]byte, error) {
+	if k.sealedFallback && len(ciphertext) >= PublicKeySize+macSize {
+		var ephemeral PublicKey
+		copy(ephemeral[:], ciphertext)
+
+		sk, err := k.sessionKey(ephemeral)
+		if err == nil {
+			plaintext, err := sk.openSession(dst, nonce, ciphertext)
+			if err == nil {
+				return plaintext, nil
+			}
+		}
+	}
+
+	if !k.metadataFallback {
+		return nil, ErrUnauthenticated
+	}
+	return k.MetadataKey().Open(dst, nonce, ciphertext, nil)
+}
@@ -0,0 +1,98 @@
+package crypto_test
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/crypto"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func sealInPlace(k *crypto.Key, data []byte) []byte {
+	nonce := crypto.NewRandomNonce()
+	buf := append([]byte(nil), nonce...)
+	return k.Seal(buf, nonce, data, nil)
+}
+
+func openInPlace(k *crypto.Key, buf []byte) ([]byte, error) {
+	nonce, ciphertext := buf[:k.NonceSize()], buf[k.NonceSize():]
+	return k.Open(ciphertext[:0], nonce, ciphertext, nil)
+}
+
+func TestSealedRoundTrip(t *testing.T) {
+	master := crypto.NewRandomKey()
+
+	sk, err := crypto.NewSealingKey(master.PublicKey())
+	rtest.OK(t, err)
+
+	for _, size := range []int{0, 5, 23, 2<<18 + 23} {
+		data := rtest.Random(23, size)
+		buf := sealInPlace(sk, data)
+		rtest.Equals(t, len(data)+crypto.Extension+crypto.PublicKeySize, len(buf))
+
+		// the master key only opens sealed data if the fallback is enabled
+		// explicitly
+		_, err = openInPlace(master, append([]byte(nil), buf...))
+		rtest.Assert(t, err == crypto.ErrUnauthenticated, "master key opened sealed data without fallback: %v", err)
+		_, err = openInPlace(master.WithMetadataFallback(), append([]byte(nil), buf...))
+		rtest.Assert(t, err == crypto.ErrUnauthenticated, "metadata fallback opened sealed data: %v", err)
+
+		plaintext, err := openInPlace(master.WithSealedFallback(), append([]byte(nil), buf...))
+		rtest.OK(t, err)
+		rtest.Equals(t, data, plaintext)
+
+		// the sealing key can decrypt its own data
+		plaintext, err = openInPlace(sk, append([]byte(nil), buf...))
+		rtest.OK(t, err)
+		rtest.Equals(t, data, plaintext)
+
+		// but no other master key
+		_, err = openInPlace(crypto.NewRandomKey().WithSealedFallback(), append([]byte(nil), buf...))
+		rtest.Assert(t, err == crypto.ErrUnauthenticated, "wrong error returned: %v", err)
+
+		// modified ciphertexts are rejected
+		buf[crypto.Extension] ^= 0x01
+		_, err = openInPlace(master.WithSealedFallback(), buf)
+		rtest.Assert(t, err == crypto.ErrUnauthenticated, "wrong error returned: %v", err)
+	}
+}
+
+func TestSealingKeyCannotOpen(t *testing.T) {
+	master := crypto.NewRandomKey()
+
+	sk, err := crypto.NewSealingKey(master.PublicKey())
+	rtest.OK(t, err)
+	other, err := crypto.NewSealingKey(master.PublicKey())
+	rtest.OK(t, err)
+
+	data := rtest.Random(42, 1000)
+
+	_, err = openInPlace(sk, sealInPlace(master, data))
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "sealing key opened data of the master key: %v", err)
+
+	_, err = openInPlace(sk, sealInPlace(other, data))
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "sealing key opened data of another session: %v", err)
+}
+
+func TestMetadataKey(t *testing.T) {
+	master := crypto.NewRandomKey()
+	meta := master.MetadataKey()
+
+	data := rtest.Random(42, 1000)
+
+	// the master key only opens data encrypted with the metadata key if the
+	// fallback is enabled explicitly
+	_, err := openInPlace(master, sealInPlace(meta, data))
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "master key opened metadata without fallback: %v", err)
+
+	plaintext, err := openInPlace(master.WithMetadataFallback(), sealInPlace(meta, data))
+	rtest.OK(t, err)
+	rtest.Equals(t, data, plaintext)
+
+	// the metadata key cannot open data encrypted with the master key
+	_, err = openInPlace(meta, sealInPlace(master, data))
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "wrong error returned: %v", err)
+
+	// the keys are derived deterministically
+	rtest.Equals(t, meta.EncryptionKey, master.MetadataKey().EncryptionKey)
+	rtest.Equals(t, master.PublicKey(), master.PublicKey())
+}
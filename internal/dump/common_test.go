@@ -3,6 +3,7 @@ package dump
 import (
 	"bytes"
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/restic/restic/internal/archiver"
@@ -26,6 +27,10 @@ func prepareTempdirRepoSrc(t testing.TB, src archiver.TestDir) (tempdir string,
 	return tempdir, repo, cleanup
 }
 
+// sparseTestContent contains large runs of zeros, which are saved as blobs
+// only containing zeros.
+var sparseTestContent = "start" + strings.Repeat("\x00", 5*1024*1024) + "middle" + strings.Repeat("\x00", 3*1024*1024)
+
 type CheckDump func(t *testing.T, testDir string, testDump *bytes.Buffer) error
 
 func WriteTest(t *testing.T, wd WriteDump, cd CheckDump) {
@@ -63,6 +68,13 @@ func WriteTest(t *testing.T, wd WriteDump, cd CheckDump) {
 			},
 			target: "/",
 		},
+		{
+			name: "file with holes",
+			args: archiver.TestDir{
+				"file": archiver.TestFile{Content: sparseTestContent},
+			},
+			target: "/",
+		},
 		{
 			name: "file and symlink in root",
 			args: archiver.TestDir{
@@ -3,17 +3,27 @@ package dump
 import (
 	"archive/tar"
 	"context"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 )
 
 type tarDumper struct {
-	w *tar.Writer
+	w   *tar.Writer
+	dst io.Writer
+
+	// zeroIDs caches the IDs of blobs which only contain zeros by length
+	zeroIDs map[uint]restic.ID
 }
 
 // Statically ensure that tarDumper implements dumper.
@@ -21,7 +31,11 @@ var _ dumper = tarDumper{}
 
 // WriteTar will write the contents of the given tree, encoded as a tar to the given destination.
 func WriteTar(ctx context.Context, repo restic.Repository, tree *restic.Tree, rootPath string, dst io.Writer) error {
-	dmp := tarDumper{w: tar.NewWriter(dst)}
+	dmp := tarDumper{
+		w:       tar.NewWriter(dst),
+		dst:     dst,
+		zeroIDs: make(map[uint]restic.ID),
+	}
 
 	return writeDump(ctx, repo, tree, rootPath, dmp, dst)
 }
@@ -72,6 +86,12 @@ func (dmp tarDumper) dumpNode(ctx context.Context, node *restic.Node, repo resti
 
 	if IsFile(node) {
 		header.Typeflag = tar.TypeReg
+
+		if regions, ok := dmp.dataRegions(node, repo); ok {
+			if sparseHeader, ok := newSparseHeader(header, regions); ok {
+				return dmp.dumpSparseFile(ctx, header, sparseHeader, regions, repo)
+			}
+		}
 	}
 
 	if IsLink(node) {
@@ -93,6 +113,229 @@ func (dmp tarDumper) dumpNode(ctx context.Context, node *restic.Node, repo resti
 	return GetNodeData(ctx, dmp.w, repo, node)
 }
 
+// sparseRegion is a part of a sparse file which contains data.
+type sparseRegion struct {
+	offset, length int64
+	blobs          restic.IDs
+}
+
+// isZeroBlob returns true if the blob id of the given size only contains zeros.
+func (dmp tarDumper) isZeroBlob(id restic.ID, size uint) bool {
+	zeroID, ok := dmp.zeroIDs[size]
+	if !ok {
+		zeroID = restic.Hash(make([]byte, size))
+		dmp.zeroIDs[size] = zeroID
+	}
+
+	return id.Equal(zeroID)
+}
+
+// dataRegions returns the regions of the file which contain data, blobs which
+// only contain zeros are considered holes. If the file has no holes or the
+// size of a blob is unknown, ok is false.
+func (dmp tarDumper) dataRegions(node *restic.Node, repo restic.Repository) (regions []sparseRegion, ok bool) {
+	var offset int64
+	var holes bool
+
+	for _, id := range node.Content {
+		size, found := repo.LookupBlobSize(id, restic.DataBlob)
+		if !found {
+			return nil, false
+		}
+
+		switch {
+		case dmp.isZeroBlob(id, size):
+			holes = true
+		case len(regions) > 0 && regions[len(regions)-1].offset+regions[len(regions)-1].length == offset:
+			last := &regions[len(regions)-1]
+			last.length += int64(size)
+			last.blobs = append(last.blobs, id)
+		default:
+			regions = append(regions, sparseRegion{offset: offset, length: int64(size), blobs: restic.IDs{id}})
+		}
+
+		offset += int64(size)
+	}
+
+	if !holes || offset != int64(node.Size) {
+		return nil, false
+	}
+
+	return regions, true
+}
+
+const tarBlockSize = 512
+
+// sparseMap returns the sparse map for the regions of a file of realSize
+// bytes, padded to a multiple of the block size.
+func sparseMap(regions []sparseRegion, realSize int64) string {
+	// a trailing hole is recorded as an empty region at the end of the file
+	entries := regions
+	if len(regions) == 0 || regions[len(regions)-1].offset+regions[len(regions)-1].length < realSize {
+		entries = append(entries[:len(entries):len(entries)], sparseRegion{offset: realSize})
+	}
+
+	m := fmt.Sprintf("%d\n", len(entries))
+	for _, r := range entries {
+		m += fmt.Sprintf("%d\n%d\n", r.offset, r.length)
+	}
+	if pad := len(m) % tarBlockSize; pad != 0 {
+		m += strings.Repeat("\x00", tarBlockSize-pad)
+	}
+
+	return m
+}
+
+// newSparseHeader returns the header which follows the extended header of a
+// sparse file. The header must be encodable in the USTAR format, otherwise ok
+// is false.
+func newSparseHeader(header *tar.Header, regions []sparseRegion) (sparseHeader *tar.Header, ok bool) {
+	size := int64(len(sparseMap(regions, header.Size)))
+	for _, r := range regions {
+		size += r.length
+	}
+
+	dir, name := path.Split(header.Name)
+	h := *header
+	h.Name = path.Join(dir, "GNUSparseFile.0", name)
+	h.Size = size
+	h.PAXRecords = nil
+	h.Format = tar.FormatUSTAR
+	// like tar.Writer does for headers without a format
+	h.ModTime = header.ModTime.Round(time.Second)
+	h.AccessTime = time.Time{}
+	h.ChangeTime = time.Time{}
+
+	// test if the header can be encoded
+	if err := tar.NewWriter(ioutil.Discard).WriteHeader(&h); err != nil {
+		return nil, false
+	}
+
+	return &h, true
+}
+
+// dumpSparseFile writes a file in the PAX format 1.0 for sparse files defined
+// by GNU tar: the sparse map is stored at the start of the data, followed by
+// the regions which contain data. The archive/tar package cannot write sparse
+// files, so the extended header is written directly.
+func (dmp tarDumper) dumpSparseFile(ctx context.Context, header, sparseHeader *tar.Header, regions []sparseRegion, repo restic.Repository) error {
+	records := make(map[string]string, len(header.PAXRecords)+4)
+	for k, v := range header.PAXRecords {
+		records[k] = v
+	}
+	records["GNU.sparse.major"] = "1"
+	records["GNU.sparse.minor"] = "0"
+	records["GNU.sparse.name"] = header.Name
+	records["GNU.sparse.realsize"] = strconv.FormatInt(header.Size, 10)
+
+	// pad the previous file before writing to dst directly
+	err := dmp.w.Flush()
+	if err != nil {
+		return errors.Wrap(err, "TarHeader")
+	}
+
+	dir, name := path.Split(header.Name)
+	err = writePAXHeader(dmp.dst, path.Join(dir, "PaxHeaders.0", name), header.ModTime, records)
+	if err != nil {
+		return errors.Wrap(err, "TarHeader")
+	}
+
+	err = dmp.w.WriteHeader(sparseHeader)
+	if err != nil {
+		return errors.Wrap(err, "TarHeader")
+	}
+
+	_, err = io.WriteString(dmp.w, sparseMap(regions, header.Size))
+	if err != nil {
+		return errors.Wrap(err, "Write")
+	}
+
+	var buf []byte
+	for _, r := range regions {
+		for _, id := range r.blobs {
+			buf, err = repo.LoadBlob(ctx, restic.DataBlob, id, buf)
+			if err != nil {
+				return err
+			}
+
+			_, err = dmp.w.Write(buf)
+			if err != nil {
+				return errors.Wrap(err, "Write")
+			}
+		}
+	}
+
+	return nil
+}
+
+// formatPAXRecord formats a single PAX record, prefixed by its length.
+func formatPAXRecord(k, v string) string {
+	const padding = 3 // ' ', '=' and '\n'
+	size := len(k) + len(v) + padding
+	size += len(strconv.Itoa(size))
+	record := strconv.Itoa(size) + " " + k + "=" + v + "\n"
+
+	// adding the length may have increased the length
+	if len(record) != size {
+		record = strconv.Itoa(len(record)) + " " + k + "=" + v + "\n"
+	}
+
+	return record
+}
+
+// writePAXHeader writes an extended header with the records to w.
+func writePAXHeader(w io.Writer, name string, modTime time.Time, records map[string]string) error {
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var data string
+	for _, k := range keys {
+		data += formatPAXRecord(k, records[k])
+	}
+
+	if len(name) > 99 {
+		name = name[:99]
+	}
+
+	mtime := modTime.Unix()
+	if mtime < 0 {
+		mtime = 0
+	}
+
+	var block [tarBlockSize]byte
+	copy(block[0:100], name)
+	copy(block[100:108], "0000644\x00")
+	copy(block[108:116], "0000000\x00")
+	copy(block[116:124], "0000000\x00")
+	copy(block[124:136], fmt.Sprintf("%011o\x00", len(data)))
+	copy(block[136:148], fmt.Sprintf("%011o\x00", mtime))
+	block[156] = tar.TypeXHeader
+	copy(block[257:265], "ustar\x0000")
+
+	// the checksum is computed with the checksum field set to spaces
+	copy(block[148:156], "        ")
+	var sum int64
+	for _, b := range block {
+		sum += int64(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	if pad := len(data) % tarBlockSize; pad != 0 {
+		data += strings.Repeat("\x00", tarBlockSize-pad)
+	}
+
+	_, err := w.Write(block[:])
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, data)
+	return err
+}
+
 func parseXattrs(xattrs []restic.ExtendedAttribute) map[string]string {
 	tmpMap := make(map[string]string)
 
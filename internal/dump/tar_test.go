@@ -3,6 +3,7 @@ package dump
 import (
 	"archive/tar"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,13 +13,55 @@ import (
 	"testing"
 	"time"
 
+	"github.com/restic/restic/internal/archiver"
 	"github.com/restic/restic/internal/fs"
+	rtest "github.com/restic/restic/internal/test"
 )
 
 func TestWriteTar(t *testing.T) {
 	WriteTest(t, WriteTar, checkTar)
 }
 
+func TestWriteTarSparse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tmpdir, repo, cleanup := prepareTempdirRepoSrc(t, archiver.TestDir{
+		"file": archiver.TestFile{Content: sparseTestContent},
+	})
+	defer cleanup()
+
+	arch := archiver.New(repo, fs.Track{FS: fs.Local{}}, archiver.Options{})
+	back := rtest.Chdir(t, tmpdir)
+	defer back()
+
+	sn, _, err := arch.Snapshot(ctx, []string{"."}, archiver.SnapshotOptions{})
+	rtest.OK(t, err)
+
+	tree, err := repo.LoadTree(ctx, *sn.Tree)
+	rtest.OK(t, err)
+
+	dst := &bytes.Buffer{}
+	rtest.OK(t, WriteTar(ctx, repo, tree, "/", dst))
+
+	if dst.Len() >= len(sparseTestContent) {
+		t.Errorf("holes were written to the archive, size %d", dst.Len())
+	}
+
+	tr := tar.NewReader(dst)
+	hdr, err := tr.Next()
+	rtest.OK(t, err)
+	rtest.Equals(t, "file", hdr.Name)
+	rtest.Equals(t, int64(len(sparseTestContent)), hdr.Size)
+
+	data, err := ioutil.ReadAll(tr)
+	rtest.OK(t, err)
+	rtest.Assert(t, string(data) == sparseTestContent, "wrong content for sparse file")
+
+	_, err = tr.Next()
+	rtest.Equals(t, io.EOF, err)
+}
+
 func checkTar(t *testing.T, testDir string, srcTar *bytes.Buffer) error {
 	tr := tar.NewReader(srcTar)
 
@@ -0,0 +1,112 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// CommandError is returned by CommandReader if the command failed. It is a
+// fatal error, which aborts the backup instead of being reported as an error
+// for the file.
+type CommandError struct {
+	Args []string
+	Err  error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command %q failed: %v", strings.Join(e.Args, " "), e.Err)
+}
+
+// Fatal returns true, the backup must not succeed if the command failed.
+func (e *CommandError) Fatal() bool {
+	return true
+}
+
+// CommandReader runs a command and passes through its standard output. Once
+// the output has been read completely, Read returns a CommandError instead
+// of io.EOF if the command exited with a non-zero status.
+type CommandReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+
+	wait     sync.Once
+	waitErr  error
+	finished bool
+}
+
+// NewCommandReader starts the command args. The standard error of the command
+// is written to stderr.
+func NewCommandReader(ctx context.Context, args []string, stderr io.Writer) (*CommandReader, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no command specified")
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "StdoutPipe")
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, errors.Fatalf("unable to start command %q: %v", strings.Join(args, " "), err)
+	}
+
+	return &CommandReader{cmd: cmd, stdout: stdout}, nil
+}
+
+// finish waits for the command to exit and returns a CommandError if it
+// failed.
+func (r *CommandReader) finish() error {
+	r.wait.Do(func() {
+		err := r.cmd.Wait()
+		if err != nil {
+			r.waitErr = &CommandError{Args: r.cmd.Args, Err: err}
+		}
+	})
+	return r.waitErr
+}
+
+// Read reads from the standard output of the command.
+func (r *CommandReader) Read(p []byte) (int, error) {
+	if r.finished {
+		if r.waitErr != nil {
+			return 0, r.waitErr
+		}
+		return 0, io.EOF
+	}
+
+	n, err := r.stdout.Read(p)
+	if err == io.EOF {
+		r.finished = true
+		if werr := r.finish(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Close kills the command if it is still running and waits for it to exit.
+// It returns an error if the command failed and its output has not been
+// read completely.
+func (r *CommandReader) Close() error {
+	if !r.finished && r.cmd.ProcessState == nil {
+		// the output is not needed anymore
+		_ = r.cmd.Process.Kill()
+	}
+
+	err := r.finish()
+	if r.finished {
+		// the error has already been returned by Read
+		return nil
+	}
+	return err
+}
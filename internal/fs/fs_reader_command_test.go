@@ -0,0 +1,77 @@
+package fs
+
+import (
+	"context"
+	"io/ioutil"
+	"runtime"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/test"
+)
+
+func TestCommandReader(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires sh")
+	}
+
+	var tests = []struct {
+		script string
+		output string
+		fail   bool
+	}{
+		{"echo foo", "foo\n", false},
+		{"echo foo; exit 1", "foo\n", true},
+		{"exit 2", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			rd, err := NewCommandReader(context.TODO(), []string{"sh", "-c", test.script}, ioutil.Discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			buf, err := ioutil.ReadAll(rd)
+			if string(buf) != test.output {
+				t.Errorf("wrong output, want %q, got %q", test.output, buf)
+			}
+			if test.fail && (err == nil || !errors.IsFatal(errors.Cause(err))) {
+				t.Errorf("expected a fatal error, got %v", err)
+			}
+			if !test.fail && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+
+			err = rd.Close()
+			if err != nil {
+				t.Errorf("Close returned error %v", err)
+			}
+		})
+	}
+}
+
+func TestCommandReaderClose(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires sh")
+	}
+
+	rd, err := NewCommandReader(context.TODO(), []string{"sh", "-c", "echo foo; exec sleep 60"}, ioutil.Discard)
+	test.OK(t, err)
+
+	buf := make([]byte, 4)
+	_, err = rd.Read(buf)
+	test.OK(t, err)
+
+	// closing before the output was read completely kills the command
+	err = rd.Close()
+	test.Assert(t, err != nil, "expected an error for the killed command")
+}
+
+func TestCommandReaderInvalid(t *testing.T) {
+	_, err := NewCommandReader(context.TODO(), []string{"/nonexistent/command"}, ioutil.Discard)
+	test.Assert(t, err != nil, "starting a nonexistent command did not return an error")
+
+	_, err = NewCommandReader(context.TODO(), nil, ioutil.Discard)
+	test.Assert(t, err != nil, "an empty command did not return an error")
+}
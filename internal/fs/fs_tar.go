@@ -0,0 +1,472 @@
+package fs
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// Tar is a read-only file system which contains the entries of a tar archive,
+// rooted at "/". The archive is read completely when the file system is
+// created. The contents of the files are read directly from the archive if it
+// is an uncompressed regular file, otherwise they are buffered in a temporary
+// file until the file system is closed.
+type Tar struct {
+	root   *tarNode
+	newest time.Time
+
+	src   *os.File
+	spool *os.File
+}
+
+// statically ensure that Tar implements FS.
+var _ FS = &Tar{}
+
+// TarEntry is returned by the Sys() method of the os.FileInfo of the files in
+// a Tar file system. Directories which are not contained in the archive
+// themselves have a synthetic header.
+type TarEntry struct {
+	Header *tar.Header
+
+	// Inode is a synthetic inode number, which is the same for all hard
+	// links to a file. Links is the number of hard links to the file.
+	Inode uint64
+	Links uint64
+
+	// Device is the device number of a device node.
+	Device uint64
+}
+
+type tarNode struct {
+	entry    *TarEntry
+	children map[string]*tarNode
+
+	// the contents of a regular file
+	data   io.ReaderAt
+	offset int64
+}
+
+func newTarDir(modTime time.Time) *tarNode {
+	return &tarNode{
+		entry: &TarEntry{Header: &tar.Header{
+			Typeflag: tar.TypeDir,
+			Mode:     0755,
+			ModTime:  modTime,
+		}},
+		children: make(map[string]*tarNode),
+	}
+}
+
+// countingReader counts the number of bytes read from rd.
+type countingReader struct {
+	rd io.Reader
+	n  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// decompress returns a reader for the tar stream in rd, which may be
+// compressed with gzip, bzip2 or zstd.
+func decompress(rd *bufio.Reader) (io.Reader, bool, error) {
+	magic, err := rd.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		r, err := gzip.NewReader(rd)
+		return r, true, err
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return bzip2.NewReader(rd), true, nil
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		r, err := zstd.NewReader(rd)
+		if err != nil {
+			return nil, false, err
+		}
+		return r.IOReadCloser(), true, nil
+	}
+	return rd, false, nil
+}
+
+// isSparse returns true if the data of the entry is not stored contiguously.
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// NewTar reads the tar archive from rd and returns a file system with its
+// entries. Hard links must refer to a file earlier in the archive.
+func NewTar(rd io.Reader) (*Tar, error) {
+	fs := &Tar{}
+
+	// the contents of regular files in an uncompressed archive are read
+	// directly from the file
+	var direct bool
+	if f, ok := rd.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			fs.src = f
+			direct = true
+		}
+	}
+
+	stream, compressed, err := decompress(bufio.NewReader(rd))
+	if err != nil {
+		return nil, errors.Wrap(err, "decompress")
+	}
+	if compressed {
+		direct = false
+	}
+
+	err = fs.read(stream, direct)
+	if err != nil {
+		_ = fs.Close()
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+func (fs *Tar) read(stream io.Reader, direct bool) error {
+	cr := &countingReader{rd: stream}
+	tr := tar.NewReader(cr)
+
+	var implicitDirs []*tarNode
+	files := make(map[string]*tarNode)
+	var inode uint64
+	var spoolOffset int64
+
+	fs.root = newTarDir(time.Time{})
+	implicitDirs = append(implicitDirs, fs.root)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "tar")
+		}
+
+		// leading "./" and "../" elements are removed
+		name := path.Clean("/" + hdr.Name)
+
+		if hdr.ModTime.After(fs.newest) {
+			fs.newest = hdr.ModTime
+		}
+
+		node := &tarNode{entry: &TarEntry{Header: hdr}}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			node.children = make(map[string]*tarNode)
+		case tar.TypeReg, tar.TypeGNUSparse, tar.TypeCont:
+			inode++
+			node.entry.Inode = inode
+			node.entry.Links = 1
+			if direct && !isSparse(hdr) {
+				node.data, node.offset = fs.src, cr.n
+				break
+			}
+
+			if fs.spool == nil {
+				fs.spool, err = TempFile("", "restic-tar-")
+				if err != nil {
+					return errors.Wrap(err, "TempFile")
+				}
+			}
+			n, err := io.Copy(fs.spool, tr)
+			if err != nil {
+				return errors.Wrap(err, "tar")
+			}
+			node.data, node.offset = fs.spool, spoolOffset
+			spoolOffset += n
+		case tar.TypeLink:
+			target, ok := files[path.Clean("/"+hdr.Linkname)]
+			if !ok {
+				return errors.Errorf("hard link %q refers to unknown file %q", hdr.Name, hdr.Linkname)
+			}
+			target.entry.Links++
+			// hard links share all metadata except the name
+			node.entry = target.entry
+			node.data, node.offset = target.data, target.offset
+		case tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			if hdr.Typeflag == tar.TypeChar || hdr.Typeflag == tar.TypeBlock {
+				node.entry.Device = mkdev(hdr.Devmajor, hdr.Devminor)
+			}
+		default:
+			debug.Log("ignoring tar entry %v with type %v", hdr.Name, hdr.Typeflag)
+			continue
+		}
+
+		if name == "/" {
+			if node.children == nil {
+				return errors.Errorf("tar entry %q is not a directory", hdr.Name)
+			}
+			fs.root.entry = node.entry
+			continue
+		}
+
+		dir, err := fs.mkdirAll(path.Dir(name), &implicitDirs)
+		if err != nil {
+			return err
+		}
+
+		base := path.Base(name)
+		if old, ok := dir.children[base]; ok && old.children != nil && node.children != nil {
+			// keep the contents of a directory listed twice
+			old.entry = node.entry
+			continue
+		}
+		dir.children[base] = node
+		if node.data != nil {
+			files[name] = node
+		}
+	}
+
+	// directories not contained in the archive use the newest timestamp
+	for _, dir := range implicitDirs {
+		if dir.entry.Header.ModTime.IsZero() {
+			dir.entry.Header.ModTime = fs.newest
+		}
+	}
+
+	return nil
+}
+
+// mkdirAll returns the directory dir, directories which do not exist yet are
+// created and added to implicitDirs.
+func (fs *Tar) mkdirAll(dir string, implicitDirs *[]*tarNode) (*tarNode, error) {
+	node := fs.root
+	if dir == "/" {
+		return node, nil
+	}
+
+	for _, name := range strings.Split(dir[1:], "/") {
+		next, ok := node.children[name]
+		if !ok {
+			next = newTarDir(time.Time{})
+			node.children[name] = next
+			*implicitDirs = append(*implicitDirs, next)
+		}
+		if next.children == nil {
+			return nil, errors.Errorf("tar entry %v is not a directory", dir)
+		}
+		node = next
+	}
+	return node, nil
+}
+
+// NewestModTime returns the newest modification time of all entries in the
+// archive.
+func (fs *Tar) NewestModTime() time.Time {
+	return fs.newest
+}
+
+// Close removes the temporary file. The archive passed to NewTar is not
+// closed.
+func (fs *Tar) Close() error {
+	if fs.spool != nil {
+		_ = fs.spool.Close()
+		// the file has already been removed on most platforms
+		_ = os.Remove(fs.spool.Name())
+		fs.spool = nil
+	}
+	return nil
+}
+
+func (fs *Tar) lookup(name string) (*tarNode, error) {
+	name = path.Clean("/" + name)
+	node := fs.root
+	if name == "/" {
+		return node, nil
+	}
+
+	for _, elem := range strings.Split(name[1:], "/") {
+		if node.children == nil {
+			return nil, syscall.ENOTDIR
+		}
+		next, ok := node.children[elem]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		node = next
+	}
+	return node, nil
+}
+
+// Open opens a file for reading.
+func (fs *Tar) Open(name string) (File, error) {
+	return fs.OpenFile(name, O_RDONLY, 0)
+}
+
+// OpenFile opens a file or directory for reading.
+func (fs *Tar) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag & ^(O_RDONLY|O_NOFOLLOW) != 0 {
+		return nil, errors.Errorf("invalid combination of flags 0x%x", flag)
+	}
+
+	node, err := fs.lookup(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	fi := tarFileInfo{name: fs.Base(name), node: node}
+	f := fakeFile{name: name, FileInfo: fi}
+	switch {
+	case node.children != nil:
+		names := make([]string, 0, len(node.children))
+		for name := range node.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		entries := make([]os.FileInfo, 0, len(names))
+		for _, name := range names {
+			entries = append(entries, tarFileInfo{name: name, node: node.children[name]})
+		}
+		return fakeDir{entries: entries, fakeFile: f}, nil
+	case node.data != nil:
+		return &tarFile{
+			SectionReader: io.NewSectionReader(node.data, node.offset, node.entry.Header.Size),
+			fakeFile:      f,
+		}, nil
+	}
+
+	return f, nil
+}
+
+// Stat returns a FileInfo describing the named file. Symbolic links are not
+// resolved.
+func (fs *Tar) Stat(name string) (os.FileInfo, error) {
+	return fs.Lstat(name)
+}
+
+// Lstat returns the FileInfo structure describing the named file.
+func (fs *Tar) Lstat(name string) (os.FileInfo, error) {
+	node, err := fs.lookup(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	return tarFileInfo{name: fs.Base(name), node: node}, nil
+}
+
+// Join joins any number of path elements into a single path.
+func (fs *Tar) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// Separator returns the separator for dirs/subdirs/files.
+func (fs *Tar) Separator() string {
+	return "/"
+}
+
+// IsAbs reports whether the path is absolute.
+func (fs *Tar) IsAbs(p string) bool {
+	return path.IsAbs(p)
+}
+
+// Abs returns an absolute representation of path, relative paths are
+// interpreted relative to the root of the archive.
+func (fs *Tar) Abs(p string) (string, error) {
+	return path.Clean("/" + p), nil
+}
+
+// Clean returns the cleaned path.
+func (fs *Tar) Clean(p string) string {
+	return path.Clean(p)
+}
+
+// VolumeName returns leading volume name, for the Tar file system it's
+// always the empty string.
+func (fs *Tar) VolumeName(path string) string {
+	return ""
+}
+
+// Base returns the last element of p.
+func (fs *Tar) Base(p string) string {
+	return path.Base(p)
+}
+
+// Dir returns p without the last element.
+func (fs *Tar) Dir(p string) string {
+	return path.Dir(p)
+}
+
+// tarFile is a regular file in the archive.
+type tarFile struct {
+	*io.SectionReader
+	fakeFile
+}
+
+func (f *tarFile) Read(p []byte) (int, error) {
+	return f.SectionReader.Read(p)
+}
+
+func (f *tarFile) Seek(offset int64, whence int) (int64, error) {
+	return f.SectionReader.Seek(offset, whence)
+}
+
+// ensure that tarFile implements File
+var _ File = &tarFile{}
+
+// tarFileInfo describes an entry of the archive.
+type tarFileInfo struct {
+	name string
+	node *tarNode
+}
+
+func (fi tarFileInfo) Name() string {
+	return fi.name
+}
+
+func (fi tarFileInfo) Size() int64 {
+	if fi.node.data == nil {
+		return 0
+	}
+	return fi.node.entry.Header.Size
+}
+
+func (fi tarFileInfo) Mode() os.FileMode {
+	mode := fi.node.entry.Header.FileInfo().Mode()
+	if fi.node.data != nil {
+		// hard links are regular files
+		mode &^= os.ModeType
+	}
+	return mode
+}
+
+func (fi tarFileInfo) ModTime() time.Time {
+	return fi.node.entry.Header.ModTime
+}
+
+func (fi tarFileInfo) IsDir() bool {
+	return fi.node.children != nil
+}
+
+func (fi tarFileInfo) Sys() interface{} {
+	return fi.node.entry
+}
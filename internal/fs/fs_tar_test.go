@@ -0,0 +1,160 @@
+package fs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/test"
+)
+
+var tarTestTime = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func createTestTar(t testing.TB) []byte {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+
+	entries := []struct {
+		hdr  tar.Header
+		data string
+	}{
+		{hdr: tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0700, ModTime: tarTestTime}},
+		{hdr: tar.Header{Name: "./dir/sub/file", Typeflag: tar.TypeReg, Mode: 0640, Uid: 1000, Gid: 100,
+			Uname: "user", Gname: "users", ModTime: tarTestTime.Add(time.Hour),
+			PAXRecords: map[string]string{"SCHILY.xattr.user.foo": "bar"}}, data: "content of file"},
+		{hdr: tar.Header{Name: "./dir/link", Typeflag: tar.TypeLink, Linkname: "./dir/sub/file", ModTime: tarTestTime}},
+		{hdr: tar.Header{Name: "./dir/symlink", Typeflag: tar.TypeSymlink, Linkname: "sub/file", Mode: 0777, ModTime: tarTestTime}},
+		{hdr: tar.Header{Name: "./dir", Typeflag: tar.TypeDir, Mode: 0750, ModTime: tarTestTime.Add(-time.Hour)}},
+		{hdr: tar.Header{Name: "./other", Typeflag: tar.TypeReg, Mode: 0644, ModTime: tarTestTime}, data: "other file"},
+		{hdr: tar.Header{Name: "./empty", Typeflag: tar.TypeReg, Mode: 0644, ModTime: tarTestTime}},
+	}
+
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.data))
+		if hdr.PAXRecords != nil {
+			hdr.Format = tar.FormatPAX
+		}
+		test.OK(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write([]byte(e.data))
+		test.OK(t, err)
+	}
+	test.OK(t, tw.Close())
+
+	return buf.Bytes()
+}
+
+func verifyTarFS(t testing.TB, fs *Tar) {
+	test.Equals(t, tarTestTime.Add(time.Hour), fs.NewestModTime().UTC())
+
+	verifyDirectoryContents(t, fs, "/", []string{"dir", "empty", "other"})
+	verifyDirectoryContents(t, fs, "/dir", []string{"link", "sub", "symlink"})
+	verifyFileContentOpen(t, fs, "/dir/sub/file", []byte("content of file"))
+	verifyFileContentOpenFile(t, fs, "/dir/link", []byte("content of file"))
+	verifyFileContentOpen(t, fs, "/other", []byte("other file"))
+	verifyFileContentOpen(t, fs, "/empty", []byte{})
+
+	fi, err := fs.Lstat("/")
+	test.OK(t, err)
+	test.Equals(t, os.ModeDir|0700, fi.Mode())
+
+	fi, err = fs.Lstat("/dir")
+	test.OK(t, err)
+	test.Equals(t, os.ModeDir|0750, fi.Mode())
+
+	// implicit directories use the newest timestamp
+	fi, err = fs.Lstat("/dir/sub")
+	test.OK(t, err)
+	test.Assert(t, fi.IsDir(), "implicit directory is not a directory")
+	test.Equals(t, fs.NewestModTime(), fi.ModTime())
+
+	fi, err = fs.Lstat("/dir/symlink")
+	test.OK(t, err)
+	test.Equals(t, os.ModeSymlink|0777, fi.Mode())
+	test.Equals(t, "sub/file", fi.Sys().(*TarEntry).Header.Linkname)
+
+	// hard links share the metadata
+	fi, err = fs.Lstat("/dir/sub/file")
+	test.OK(t, err)
+	test.Equals(t, os.FileMode(0640), fi.Mode())
+	test.Equals(t, int64(15), fi.Size())
+	entry := fi.Sys().(*TarEntry)
+	test.Equals(t, uint64(2), entry.Links)
+	test.Equals(t, "user", entry.Header.Uname)
+
+	fi, err = fs.Lstat("/dir/link")
+	test.OK(t, err)
+	test.Equals(t, "link", fi.Name())
+	test.Equals(t, os.FileMode(0640), fi.Mode())
+	test.Assert(t, fi.Sys().(*TarEntry) == entry, "hard link has a different entry")
+
+	_, err = fs.Lstat("/missing")
+	test.Assert(t, os.IsNotExist(err), "wrong error for missing file: %v", err)
+	_, err = fs.Lstat("/other/foo")
+	test.Assert(t, err != nil, "no error for file below a file")
+}
+
+func TestTarFS(t *testing.T) {
+	data := createTestTar(t)
+
+	t.Run("stream", func(t *testing.T) {
+		fs, err := NewTar(bytes.NewReader(data))
+		test.OK(t, err)
+		defer func() { test.OK(t, fs.Close()) }()
+		test.Assert(t, fs.spool != nil, "contents were not buffered")
+		verifyTarFS(t, fs)
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		buf := bytes.NewBuffer(nil)
+		gw := gzip.NewWriter(buf)
+		_, err := gw.Write(data)
+		test.OK(t, err)
+		test.OK(t, gw.Close())
+
+		fs, err := NewTar(buf)
+		test.OK(t, err)
+		defer func() { test.OK(t, fs.Close()) }()
+		verifyTarFS(t, fs)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		tempdir, cleanup := test.TempDir(t)
+		defer cleanup()
+
+		filename := filepath.Join(tempdir, "test.tar")
+		test.OK(t, ioutil.WriteFile(filename, data, 0600))
+		f, err := os.Open(filename)
+		test.OK(t, err)
+		defer func() { test.OK(t, f.Close()) }()
+
+		fs, err := NewTar(f)
+		test.OK(t, err)
+		defer func() { test.OK(t, fs.Close()) }()
+		test.Assert(t, fs.spool == nil, "contents of an uncompressed file were buffered")
+		verifyTarFS(t, fs)
+	})
+}
+
+func TestTarFSInvalid(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	test.OK(t, tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "missing"}))
+	test.OK(t, tw.Close())
+
+	_, err := NewTar(buf)
+	test.Assert(t, err != nil, "hard link to a missing file did not return an error")
+
+	data := createTestTar(t)
+	_, err = NewTar(bytes.NewReader(data[:len(data)/2]))
+	test.Assert(t, err != nil && err != io.EOF, "truncated archive did not return an error")
+
+	_, err = NewTar(bytes.NewReader(nil))
+	test.OK(t, err)
+}
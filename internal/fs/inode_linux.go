@@ -0,0 +1,94 @@
+package fs
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// InodeFlagsSupported is true if GetInodeFlags and SetInodeFlags are
+// implemented on this platform.
+const InodeFlagsSupported = true
+
+// fsNoDumpFlag is FS_NODUMP_FL from linux/fs.h.
+const fsNoDumpFlag = 0x00000040
+
+// openNoFollow opens name for an ioctl. Symbolic links are not followed.
+func openNoFollow(name string) (int, error) {
+	fd, err := unix.Open(fixpath(name), unix.O_RDONLY|unix.O_NONBLOCK|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, errors.Wrap(err, "Open")
+	}
+	return fd, nil
+}
+
+// GetInodeFlags returns the inode flags (see chattr(1)) of the file or
+// directory name.
+func GetInodeFlags(name string) (uint32, error) {
+	fd, err := openNoFollow(name)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = unix.Close(fd)
+	}()
+
+	flags, err := unix.IoctlGetUint32(fd, unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return 0, errors.Wrap(err, "IoctlGetUint32")
+	}
+	return flags, nil
+}
+
+// SetInodeFlags sets the inode flags in mask of the file or directory name to
+// their values in flags, other flags are left unchanged.
+func SetInodeFlags(name string, flags, mask uint32) error {
+	fd, err := openNoFollow(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = unix.Close(fd)
+	}()
+
+	current, err := unix.IoctlGetUint32(fd, unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return errors.Wrap(err, "IoctlGetUint32")
+	}
+
+	updated := current&^mask | flags&mask
+	if updated == current {
+		return nil
+	}
+
+	err = unix.IoctlSetPointerInt(fd, unix.FS_IOC_SETFLAGS, int(int32(updated)))
+	return errors.Wrap(err, "IoctlSetPointerInt")
+}
+
+// HasNoDumpFlag returns true if the nodump attribute (chattr +d) is set for
+// the file or directory name. Symbolic links are not followed.
+func HasNoDumpFlag(name string) (bool, error) {
+	flags, err := GetInodeFlags(name)
+	if err != nil {
+		return false, err
+	}
+	return flags&fsNoDumpFlag != 0, nil
+}
+
+// BirthTime returns the creation time of name as reported by statx(2). The
+// zero time is returned if the file system does not record it. Symbolic links
+// are not followed.
+func BirthTime(name string) (time.Time, error) {
+	var stx unix.Statx_t
+	err := unix.Statx(unix.AT_FDCWD, fixpath(name), unix.AT_SYMLINK_NOFOLLOW, unix.STATX_BTIME, &stx)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "Statx")
+	}
+
+	if stx.Mask&unix.STATX_BTIME == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec)), nil
+}
@@ -0,0 +1,41 @@
+// +build !linux
+
+package fs
+
+import (
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// InodeFlagsSupported is true if GetInodeFlags and SetInodeFlags are
+// implemented on this platform.
+const InodeFlagsSupported = false
+
+// GetInodeFlags returns the inode flags of the file or directory name. It is
+// only supported on Linux and always returns zero on other systems.
+func GetInodeFlags(name string) (uint32, error) {
+	return 0, nil
+}
+
+// SetInodeFlags sets the inode flags of the file or directory name. It is
+// only supported on Linux.
+func SetInodeFlags(name string, flags, mask uint32) error {
+	if flags&mask == 0 {
+		return nil
+	}
+	return errors.New("inode flags are not supported on this platform")
+}
+
+// HasNoDumpFlag returns true if the nodump attribute is set for the file or
+// directory name. It is only supported on Linux and always returns false on
+// other systems.
+func HasNoDumpFlag(name string) (bool, error) {
+	return false, nil
+}
+
+// BirthTime returns the creation time of name. It is only supported on Linux
+// and always returns the zero time on other systems.
+func BirthTime(name string) (time.Time, error) {
+	return time.Time{}, nil
+}
@@ -0,0 +1,10 @@
+// +build !windows
+
+package fs
+
+import "golang.org/x/sys/unix"
+
+// mkdev returns the device number for the major and minor numbers.
+func mkdev(major, minor int64) uint64 {
+	return unix.Mkdev(uint32(major), uint32(minor))
+}
@@ -0,0 +1,7 @@
+package fs
+
+// mkdev returns the device number for the major and minor numbers. Device
+// nodes are not supported on Windows.
+func mkdev(major, minor int64) uint64 {
+	return 0
+}
@@ -0,0 +1,46 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// whence values for lseek(2), see linux/fs.h.
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// IsSparse returns true if the file described by fi may contain holes, which
+// is the case if less blocks are allocated than needed for its size.
+func IsSparse(fi os.FileInfo) bool {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+
+	return stat.Blocks*512 < stat.Size
+}
+
+// SeekData moves the offset of f to the start of the next region containing
+// data at or after offset, and returns the new offset. If there is no more
+// data, io.EOF is returned.
+func SeekData(f File, offset int64) (int64, error) {
+	off, err := f.Seek(offset, seekData)
+	if errors.Is(err, unix.ENXIO) {
+		return 0, io.EOF
+	}
+
+	return off, err
+}
+
+// SeekHole moves the offset of f to the start of the next hole at or after
+// offset, and returns the new offset. The end of a file is considered a hole.
+func SeekHole(f File, offset int64) (int64, error) {
+	return f.Seek(offset, seekHole)
+}
@@ -0,0 +1,25 @@
+// +build !linux
+
+package fs
+
+import (
+	"os"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// IsSparse returns true if the file described by fi may contain holes. Holes
+// are only detected on Linux.
+func IsSparse(fi os.FileInfo) bool {
+	return false
+}
+
+// SeekData is not supported on this platform.
+func SeekData(f File, offset int64) (int64, error) {
+	return 0, errors.New("detecting holes is not supported on this platform")
+}
+
+// SeekHole is not supported on this platform.
+func SeekHole(f File, offset int64) (int64, error) {
+	return 0, errors.New("detecting holes is not supported on this platform")
+}
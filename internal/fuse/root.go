@@ -18,6 +18,7 @@ type Config struct {
 	Hosts            []string
 	Tags             []restic.TagList
 	Paths            []string
+	Labels           []string
 	SnapshotTemplate string
 }
 
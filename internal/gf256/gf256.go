@@ -0,0 +1,61 @@
+// Package gf256 implements arithmetic in the finite field GF(2^8) with the
+// reducing polynomial x^8 + x^4 + x^3 + x + 1. Addition and subtraction in
+// this field are both xor.
+package gf256
+
+// logTable and expTable are used for multiplication, using the generator 3.
+var logTable, expTable [256]byte
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		expTable[i] = x
+		logTable[x] = byte(i)
+
+		// multiply x by the generator 3
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+	expTable[255] = expTable[0]
+}
+
+// Mul returns the product of a and b.
+func Mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])+int(logTable[b]))%255]
+}
+
+// Div returns a divided by b. It panics if b is zero.
+func Div(a, b byte) byte {
+	if b == 0 {
+		panic("division by zero")
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])-int(logTable[b])+255)%255]
+}
+
+// MulAdd adds the product of c and each byte of src to the corresponding byte
+// of dst. dst must be at least as long as src.
+func MulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+
+	var table [256]byte
+	for i := range table {
+		table[i] = Mul(c, byte(i))
+	}
+
+	dst = dst[:len(src)]
+	for i, b := range src {
+		dst[i] ^= table[b]
+	}
+}
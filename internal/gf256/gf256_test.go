@@ -0,0 +1,29 @@
+package gf256
+
+import (
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestMulDiv(t *testing.T) {
+	for a := 0; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			p := Mul(byte(a), byte(b))
+			rtest.Equals(t, byte(a), Div(p, byte(b)))
+		}
+	}
+}
+
+func TestMulAdd(t *testing.T) {
+	src := rtest.Random(23, 1000)
+	dst := rtest.Random(42, 1000)
+
+	want := make([]byte, len(dst))
+	for i := range want {
+		want[i] = dst[i] ^ Mul(src[i], 0x53)
+	}
+
+	MulAdd(dst, src, 0x53)
+	rtest.Equals(t, want, dst)
+}
@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+func init() {
+	register(&BinaryIndex{})
+}
+
+// BinaryIndex enables the binary index format. Only new index files are
+// written in the binary format, existing index files are converted by the
+// next run of rebuild-index or prune.
+type BinaryIndex struct{}
+
+// Check tests whether the migration can be applied.
+func (m *BinaryIndex) Check(ctx context.Context, repo restic.Repository) (bool, error) {
+	cfg := repo.Config()
+	if cfg.Version < 2 {
+		debug.Log("repository version %v does not support the binary index format", cfg.Version)
+		return false, nil
+	}
+
+	return !cfg.HasFeature(restic.FeatureBinaryIndex), nil
+}
+
+// Apply runs the migration.
+func (m *BinaryIndex) Apply(ctx context.Context, repo restic.Repository, cp *Checkpoint) error {
+	// nothing to do besides setting the feature
+	return nil
+}
+
+// Features returns the repository features set by the migration.
+func (m *BinaryIndex) Features() []string {
+	return []string{restic.FeatureBinaryIndex}
+}
+
+// Name returns the name for this migration.
+func (m *BinaryIndex) Name() string {
+	return "binary_index"
+}
+
+// Desc returns a short description what the migration does.
+func (m *BinaryIndex) Desc() string {
+	return "write new index files in the binary format, run rebuild-index afterwards to convert existing files"
+}
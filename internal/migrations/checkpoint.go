@@ -0,0 +1,247 @@
+package migrations
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Checkpoint records the progress of a migration. It is stored in the
+// repository after each completed step, so that an interrupted migration can
+// be resumed.
+type Checkpoint struct {
+	Migration string    `json:"migration"`
+	Time      time.Time `json:"time"`
+	Done      []string  `json:"done,omitempty"`
+
+	// State holds additional data a migration needs to resume.
+	State json.RawMessage `json:"state,omitempty"`
+
+	// Config is the new config of the repository. It is stored before the
+	// config is replaced, so that it can be restored if saving it fails.
+	Config *restic.Config `json:"config,omitempty"`
+
+	repo   restic.Repository
+	ids    restic.IDs
+	dryRun bool
+}
+
+// LoadCheckpoint returns the checkpoint for the migration name. If the
+// migration has not been started before, an empty checkpoint is returned. In
+// dry-run mode, the checkpoint is never written to the repository.
+func LoadCheckpoint(ctx context.Context, repo restic.Repository, name string, dryRun bool) (*Checkpoint, error) {
+	cp := &Checkpoint{
+		Migration: name,
+		repo:      repo,
+		dryRun:    dryRun,
+	}
+
+	err := forAllCheckpoints(ctx, repo, func(id restic.ID, c *Checkpoint) {
+		if c.Migration != name {
+			return
+		}
+
+		// a run may have been interrupted after saving a new checkpoint but
+		// before removing the old one, use the newest one
+		cp.ids = append(cp.ids, id)
+		if c.Time.After(cp.Time) {
+			cp.Time = c.Time
+			cp.Done = c.Done
+			cp.State = c.State
+			cp.Config = c.Config
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	debug.Log("checkpoint for %v: %v steps done, ids %v", name, len(cp.Done), cp.ids)
+	return cp, nil
+}
+
+// forAllCheckpoints calls fn for all checkpoints stored in repo. Checkpoints
+// which are encrypted with a different master key are skipped.
+func forAllCheckpoints(ctx context.Context, repo restic.Repository, fn func(restic.ID, *Checkpoint)) error {
+	return repo.List(ctx, restic.MigrationFile, func(id restic.ID, size int64) error {
+		var c Checkpoint
+		err := repo.LoadJSONUnpacked(ctx, restic.MigrationFile, id, &c)
+		if errors.Cause(err) == crypto.ErrUnauthenticated {
+			debug.Log("skipping checkpoint %v, wrong key", id.Str())
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "load checkpoint %v", id.Str())
+		}
+
+		fn(id, &c)
+		return nil
+	})
+}
+
+// Pending returns the names of all migrations which have been interrupted.
+func Pending(ctx context.Context, repo restic.Repository) ([]string, error) {
+	var names []string
+	seen := make(map[string]struct{})
+	err := forAllCheckpoints(ctx, repo, func(id restic.ID, c *Checkpoint) {
+		if _, ok := seen[c.Migration]; !ok {
+			seen[c.Migration] = struct{}{}
+			names = append(names, c.Migration)
+		}
+	})
+	return names, err
+}
+
+// Resumed returns true if the checkpoint belongs to an interrupted migration.
+func (cp *Checkpoint) Resumed() bool {
+	return len(cp.ids) > 0
+}
+
+// DryRun returns true if the migration must not modify the repository.
+func (cp *Checkpoint) DryRun() bool {
+	return cp.dryRun
+}
+
+// IsDone returns true if step has already been completed.
+func (cp *Checkpoint) IsDone(step string) bool {
+	for _, s := range cp.Done {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadState decodes the state stored in the checkpoint into v. If no state
+// has been stored, v is left unchanged.
+func (cp *Checkpoint) LoadState(v interface{}) error {
+	if len(cp.State) == 0 {
+		return nil
+	}
+	return errors.Wrap(json.Unmarshal(cp.State, v), "Unmarshal")
+}
+
+// SetState stores v as the state of the migration. It is written to the
+// repository with the next call to Save or Complete.
+func (cp *Checkpoint) SetState(v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	cp.State = buf
+	return nil
+}
+
+// Complete records that step has been completed and stores the checkpoint in
+// the repository.
+func (cp *Checkpoint) Complete(ctx context.Context, step string) error {
+	if cp.IsDone(step) {
+		return nil
+	}
+
+	cp.Done = append(cp.Done, step)
+	return cp.Save(ctx)
+}
+
+// Save stores the checkpoint in the repository and removes the previous one.
+func (cp *Checkpoint) Save(ctx context.Context) error {
+	cp.Time = time.Now()
+	if cp.dryRun {
+		return nil
+	}
+
+	id, err := cp.repo.SaveJSONUnpacked(ctx, restic.MigrationFile, cp)
+	if err != nil {
+		return errors.Wrap(err, "save checkpoint")
+	}
+	debug.Log("saved checkpoint %v for %v, steps done: %v", id.Str(), cp.Migration, cp.Done)
+
+	old := cp.ids
+	cp.ids = restic.IDs{id}
+	return cp.remove(ctx, old)
+}
+
+// Remove deletes the checkpoint from the repository.
+func (cp *Checkpoint) Remove(ctx context.Context) error {
+	if cp.dryRun {
+		return nil
+	}
+
+	err := cp.remove(ctx, cp.ids)
+	if err != nil {
+		return err
+	}
+	cp.ids = nil
+	return nil
+}
+
+func (cp *Checkpoint) remove(ctx context.Context, ids restic.IDs) error {
+	for _, id := range ids {
+		h := restic.Handle{Type: restic.MigrationFile, Name: id.String()}
+		if err := cp.repo.Backend().Remove(ctx, h); err != nil {
+			return errors.Wrap(err, "remove checkpoint")
+		}
+	}
+	return nil
+}
+
+// Apply runs the migration m, which continues after the steps recorded in cp.
+// When the migration has been applied successfully, the features it declares
+// are added to the repository config and the checkpoint is removed.
+func Apply(ctx context.Context, repo restic.Repository, m Migration, cp *Checkpoint) error {
+	err := m.Apply(ctx, repo, cp)
+	if err != nil {
+		return err
+	}
+
+	if cp.DryRun() {
+		return nil
+	}
+
+	err = addFeatures(ctx, repo, cp, m.Features())
+	if err != nil {
+		return err
+	}
+
+	return cp.Remove(ctx)
+}
+
+// addFeatures adds features to the config of repo. Backends refuse to
+// overwrite files, so the old config is removed before the new one is saved.
+// The new config is stored in the checkpoint cp first: if it cannot be saved,
+// it is restored from the checkpoint when the repository is opened again.
+func addFeatures(ctx context.Context, repo restic.Repository, cp *Checkpoint, features []string) error {
+	cfg := repo.Config()
+	missing := false
+	for _, f := range features {
+		if !cfg.HasFeature(f) {
+			missing = true
+		}
+	}
+	if !missing {
+		return nil
+	}
+
+	// copy the list so that the config of repo is not modified
+	cfg.Features = append([]string(nil), cfg.Features...)
+	cfg.AddFeatures(features...)
+
+	cp.Config = &cfg
+	if err := cp.Save(ctx); err != nil {
+		return err
+	}
+
+	if err := restic.ReplaceConfig(ctx, repo, cfg); err != nil {
+		if errors.IsFatal(err) {
+			return errors.Fatalf("%v\nthe new config is stored in the checkpoint of the migration, it is restored when the repository is opened again", err)
+		}
+		return err
+	}
+
+	debug.Log("added features %v to config", features)
+	return nil
+}
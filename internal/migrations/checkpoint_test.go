@@ -0,0 +1,146 @@
+package migrations_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/migrations"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// testMigration runs the steps "a", "b" and "c" and fails after step failAfter.
+type testMigration struct {
+	failAfter string
+	ran       []string
+}
+
+func (m *testMigration) Check(ctx context.Context, repo restic.Repository) (bool, error) {
+	return true, nil
+}
+
+func (m *testMigration) Apply(ctx context.Context, repo restic.Repository, cp *migrations.Checkpoint) error {
+	for _, step := range []string{"a", "b", "c"} {
+		if cp.IsDone(step) {
+			continue
+		}
+
+		m.ran = append(m.ran, step)
+		if err := cp.Complete(ctx, step); err != nil {
+			return err
+		}
+
+		if step == m.failAfter {
+			return errors.New("interrupted")
+		}
+	}
+	return nil
+}
+
+func (m *testMigration) Features() []string { return []string{"test-feature"} }
+func (m *testMigration) Name() string       { return "test" }
+func (m *testMigration) Desc() string       { return "test migration" }
+
+func countCheckpoints(t testing.TB, repo restic.Repository) int {
+	n := 0
+	rtest.OK(t, repo.List(context.TODO(), restic.MigrationFile, func(restic.ID, int64) error {
+		n++
+		return nil
+	}))
+	return n
+}
+
+func TestCheckpointResume(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	ctx := context.TODO()
+	m := &testMigration{failAfter: "b"}
+
+	cp, err := migrations.LoadCheckpoint(ctx, repo, m.Name(), false)
+	rtest.OK(t, err)
+	rtest.Assert(t, !cp.Resumed(), "new checkpoint is marked as resumed")
+
+	err = migrations.Apply(ctx, repo, m, cp)
+	rtest.Assert(t, err != nil, "interrupted migration did not return an error")
+	rtest.Equals(t, 1, countCheckpoints(t, repo))
+
+	cp, err = migrations.LoadCheckpoint(ctx, repo, m.Name(), false)
+	rtest.OK(t, err)
+	rtest.Assert(t, cp.Resumed(), "checkpoint of interrupted migration not found")
+	rtest.Equals(t, []string{"a", "b"}, cp.Done)
+
+	m.failAfter = ""
+	rtest.OK(t, migrations.Apply(ctx, repo, m, cp))
+	rtest.Equals(t, []string{"a", "b", "c"}, m.ran)
+	rtest.Equals(t, 0, countCheckpoints(t, repo))
+
+	cfg, err := restic.LoadConfig(ctx, repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, cfg.HasFeature("test-feature"), "feature was not added to config: %v", cfg.Features)
+}
+
+func TestCheckpointDryRun(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	ctx := context.TODO()
+	m := &testMigration{}
+
+	cp, err := migrations.LoadCheckpoint(ctx, repo, m.Name(), true)
+	rtest.OK(t, err)
+	rtest.OK(t, migrations.Apply(ctx, repo, m, cp))
+	rtest.Equals(t, []string{"a", "b", "c"}, m.ran)
+	rtest.Equals(t, 0, countCheckpoints(t, repo))
+
+	cfg, err := restic.LoadConfig(ctx, repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, !cfg.HasFeature("test-feature"), "dry run modified the config")
+}
+
+// failConfigBackend refuses to save the config while fail is set.
+type failConfigBackend struct {
+	restic.Backend
+	fail bool
+}
+
+func (be *failConfigBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if be.fail && h.Type == restic.ConfigFile {
+		return errors.New("save config failed")
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func TestCheckpointRestoreConfig(t *testing.T) {
+	mem, cleanup := repository.TestBackend(t)
+	defer cleanup()
+
+	be := &failConfigBackend{Backend: mem}
+	repo, cleanup := repository.TestRepositoryWithBackend(t, be, 0)
+	defer cleanup()
+
+	ctx := context.TODO()
+	m := &testMigration{}
+
+	cp, err := migrations.LoadCheckpoint(ctx, repo, m.Name(), false)
+	rtest.OK(t, err)
+
+	be.fail = true
+	err = migrations.Apply(ctx, repo, m, cp)
+	rtest.Assert(t, err != nil, "failed config save did not return an error")
+	rtest.Equals(t, 1, countCheckpoints(t, repo))
+	be.fail = false
+
+	// opening the repository restores the config from the checkpoint
+	repo2 := repository.New(be, repository.Options{})
+	rtest.OK(t, repo2.SearchKey(ctx, rtest.TestPassword, 0, ""))
+	rtest.Assert(t, repo2.Config().HasFeature("test-feature"), "feature was not restored: %v", repo2.Config().Features)
+
+	cp, err = migrations.LoadCheckpoint(ctx, repo2, m.Name(), false)
+	rtest.OK(t, err)
+	rtest.Assert(t, cp.Resumed(), "checkpoint of interrupted migration not found")
+	rtest.OK(t, migrations.Apply(ctx, repo2, m, cp))
+	rtest.Equals(t, 0, countCheckpoints(t, repo2))
+}
@@ -11,8 +11,15 @@ type Migration interface {
 	// Check returns true if the migration can be applied to a repo.
 	Check(context.Context, restic.Repository) (bool, error)
 
-	// Apply runs the migration.
-	Apply(context.Context, restic.Repository) error
+	// Apply runs the migration. Each completed step must be recorded in the
+	// checkpoint, steps which were already completed by an interrupted run
+	// are skipped. The repository must not be modified when the checkpoint
+	// is in dry-run mode.
+	Apply(context.Context, restic.Repository, *Checkpoint) error
+
+	// Features returns the repository features which are set in the config
+	// after the migration has been applied successfully.
+	Features() []string
 
 	// Name returns a short name.
 	Name() string
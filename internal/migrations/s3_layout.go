@@ -54,23 +54,36 @@ func retry(max int, fail func(err error), f func() error) error {
 // maxErrors for retrying renames on s3.
 const maxErrors = 20
 
-func (m *S3Layout) moveFiles(ctx context.Context, be *s3.Backend, l backend.Layout, t restic.FileType) error {
+func (m *S3Layout) moveFiles(ctx context.Context, be *s3.Backend, l backend.Layout, t restic.FileType, dryRun bool) error {
 	printErr := func(err error) {
 		fmt.Fprintf(os.Stderr, "renaming file returned error: %v\n", err)
 	}
 
+	count := 0
+	defer func() {
+		if dryRun {
+			fmt.Printf("would move %d %v files\n", count, t)
+		}
+	}()
+
 	return be.List(ctx, t, func(fi restic.FileInfo) error {
 		h := restic.Handle{Type: t, Name: fi.Name}
 		debug.Log("move %v", h)
 
+		count++
+		if dryRun {
+			return nil
+		}
+
 		return retry(maxErrors, printErr, func() error {
 			return be.Rename(ctx, h, l)
 		})
 	})
 }
 
-// Apply runs the migration.
-func (m *S3Layout) Apply(ctx context.Context, repo restic.Repository) error {
+// Apply runs the migration. Files which have already been moved are not
+// listed in the old layout anymore, so an interrupted run can be resumed.
+func (m *S3Layout) Apply(ctx context.Context, repo restic.Repository, cp *Checkpoint) error {
 	be, ok := repo.Backend().(*s3.Backend)
 	if !ok {
 		debug.Log("backend is not s3")
@@ -87,25 +100,48 @@ func (m *S3Layout) Apply(ctx context.Context, repo restic.Repository) error {
 		Join: path.Join,
 	}
 
+	origLayout := be.Layout
 	be.Layout = oldLayout
 
+	// the layout is detected by looking for the key files, so they are moved
+	// last. Until then, an interrupted run is still opened with the old layout.
 	for _, t := range []restic.FileType{
 		restic.SnapshotFile,
 		restic.PackFile,
-		restic.KeyFile,
 		restic.LockFile,
+		restic.KeyFile,
 	} {
-		err := m.moveFiles(ctx, be, newLayout, t)
+		if cp.IsDone(string(t)) {
+			debug.Log("skip %v, already moved", t)
+			continue
+		}
+
+		err := m.moveFiles(ctx, be, newLayout, t, cp.DryRun())
+		if err != nil {
+			return err
+		}
+
+		err = cp.Complete(ctx, string(t))
 		if err != nil {
 			return err
 		}
 	}
 
+	if cp.DryRun() {
+		be.Layout = origLayout
+		return nil
+	}
+
 	be.Layout = newLayout
 
 	return nil
 }
 
+// Features returns the repository features set by this migration.
+func (m *S3Layout) Features() []string {
+	return nil
+}
+
 // Name returns the name for this migration.
 func (m *S3Layout) Name() string {
 	return "s3_layout"
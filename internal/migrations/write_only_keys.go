@@ -0,0 +1,107 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+func init() {
+	register(&WriteOnlyKeys{})
+}
+
+// WriteOnlyKeys prepares a repository for write-only keys. The index files
+// are encrypted with the metadata key, so that write-only keys can read them.
+type WriteOnlyKeys struct{}
+
+// Check tests whether the migration can be applied.
+func (m *WriteOnlyKeys) Check(ctx context.Context, repo restic.Repository) (bool, error) {
+	cfg := repo.Config()
+	if cfg.Version < 2 {
+		debug.Log("repository version %v does not support compression", cfg.Version)
+		return false, nil
+	}
+
+	return !cfg.HasFeature(restic.FeatureWriteOnlyKeys), nil
+}
+
+// Apply runs the migration.
+func (m *WriteOnlyKeys) Apply(ctx context.Context, repo restic.Repository, cp *Checkpoint) error {
+	if cp.IsDone("index") {
+		return nil
+	}
+
+	r, ok := repo.(*repository.Repository)
+	if !ok {
+		return errors.Errorf("unsupported repository type %T", repo)
+	}
+	metaRepo := r.WithKey(repo.Key().MetadataKey())
+
+	var ids restic.IDs
+	err := repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, id := range ids {
+		// index files re-encrypted by an interrupted run are skipped
+		_, err := metaRepo.LoadAndDecrypt(ctx, nil, restic.IndexFile, id)
+		if err == nil {
+			continue
+		}
+		if errors.Cause(err) != crypto.ErrUnauthenticated {
+			return err
+		}
+
+		count++
+		if cp.DryRun() {
+			continue
+		}
+
+		buf, err := repo.LoadAndDecrypt(ctx, nil, restic.IndexFile, id)
+		if err != nil {
+			return err
+		}
+
+		newID, err := metaRepo.SaveUnpacked(ctx, restic.IndexFile, buf)
+		if err != nil {
+			return err
+		}
+
+		err = repo.Backend().Remove(ctx, restic.Handle{Type: restic.IndexFile, Name: id.String()})
+		if err != nil {
+			return err
+		}
+		debug.Log("re-encrypted index %v as %v", id.Str(), newID.Str())
+	}
+
+	if cp.DryRun() {
+		fmt.Printf("would re-encrypt %d index files\n", count)
+	}
+
+	return cp.Complete(ctx, "index")
+}
+
+// Features returns the repository features set by the migration.
+func (m *WriteOnlyKeys) Features() []string {
+	return []string{restic.FeatureWriteOnlyKeys}
+}
+
+// Name returns the name for this migration.
+func (m *WriteOnlyKeys) Name() string {
+	return "write_only_keys"
+}
+
+// Desc returns a short description what the migration does.
+func (m *WriteOnlyKeys) Desc() string {
+	return "prepare the repository for write-only keys"
+}
@@ -17,9 +17,10 @@ import (
 type Packer struct {
 	blobs []restic.Blob
 
-	bytes uint
-	k     *crypto.Key
-	wr    io.Writer
+	bytes      uint
+	headerSize uint
+	k          *crypto.Key
+	wr         io.Writer
 
 	m sync.Mutex
 }
@@ -30,8 +31,10 @@ func NewPacker(k *crypto.Key, wr io.Writer) *Packer {
 }
 
 // Add saves the data read from rd as a new blob to the packer. Returned is the
-// number of bytes written to the pack.
-func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte) (int, error) {
+// number of bytes written to the pack. If the data is compressed,
+// uncompressedLength is the length of the plaintext before compression,
+// otherwise it must be zero.
+func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte, uncompressedLength int) (int, error) {
 	p.m.Lock()
 	defer p.m.Unlock()
 
@@ -40,13 +43,21 @@ func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte) (int, error)
 	n, err := p.wr.Write(data)
 	c.Length = uint(n)
 	c.Offset = p.bytes
+	c.UncompressedLength = uint(uncompressedLength)
 	p.bytes += uint(n)
+	p.headerSize += CalculateEntrySize(c)
 	p.blobs = append(p.blobs, c)
 
 	return n, errors.Wrap(err, "Write")
 }
 
-var EntrySize = uint(binary.Size(restic.BlobType(0)) + headerLengthSize + len(restic.ID{}))
+var (
+	// PlainEntrySize is the size of a header entry for an uncompressed blob.
+	PlainEntrySize = uint(binary.Size(restic.BlobType(0)) + headerLengthSize + len(restic.ID{}))
+	// CompressedEntrySize is the size of a header entry for a compressed
+	// blob, it additionally contains the uncompressed length.
+	CompressedEntrySize = PlainEntrySize + headerLengthSize
+)
 
 // headerEntry describes the format of header entries. It serves only as
 // documentation.
@@ -56,6 +67,15 @@ type headerEntry struct {
 	ID     restic.ID
 }
 
+// compressedHeaderEntry describes the format of header entries for
+// compressed blobs. It serves only as documentation.
+type compressedHeaderEntry struct {
+	Type               uint8
+	Length             uint32
+	UncompressedLength uint32
+	ID                 restic.ID
+}
+
 // Finalize writes the header for all added blobs and finalizes the pack.
 // Returned are the number of bytes written, including the header.
 func (p *Packer) Finalize() (uint, error) {
@@ -88,7 +108,7 @@ func (p *Packer) Finalize() (uint, error) {
 	bytesWritten += uint(hdrBytes)
 
 	// write length
-	err = binary.Write(p.wr, binary.LittleEndian, uint32(restic.CiphertextLength(len(p.blobs)*int(EntrySize))))
+	err = binary.Write(p.wr, binary.LittleEndian, uint32(hdrBytes))
 	if err != nil {
 		return 0, errors.Wrap(err, "binary.Write")
 	}
@@ -100,14 +120,18 @@ func (p *Packer) Finalize() (uint, error) {
 
 // makeHeader constructs the header for p.
 func (p *Packer) makeHeader() ([]byte, error) {
-	buf := make([]byte, 0, len(p.blobs)*int(EntrySize))
+	buf := make([]byte, 0, CalculateHeaderSize(p.blobs)-HeaderSize)
 
 	for _, b := range p.blobs {
-		switch b.Type {
-		case restic.DataBlob:
+		switch {
+		case b.Type == restic.DataBlob && !b.IsCompressed():
 			buf = append(buf, 0)
-		case restic.TreeBlob:
+		case b.Type == restic.TreeBlob && !b.IsCompressed():
 			buf = append(buf, 1)
+		case b.Type == restic.DataBlob && b.IsCompressed():
+			buf = append(buf, 2)
+		case b.Type == restic.TreeBlob && b.IsCompressed():
+			buf = append(buf, 3)
 		default:
 			return nil, errors.Errorf("invalid blob type %v", b.Type)
 		}
@@ -115,6 +139,10 @@ func (p *Packer) makeHeader() ([]byte, error) {
 		var lenLE [4]byte
 		binary.LittleEndian.PutUint32(lenLE[:], uint32(b.Length))
 		buf = append(buf, lenLE[:]...)
+		if b.IsCompressed() {
+			binary.LittleEndian.PutUint32(lenLE[:], uint32(b.UncompressedLength))
+			buf = append(buf, lenLE[:]...)
+		}
 		buf = append(buf, b.ID[:]...)
 	}
 
@@ -129,6 +157,15 @@ func (p *Packer) Size() uint {
 	return p.bytes
 }
 
+// HeaderFull returns true if the header of the pack cannot hold another blob.
+// This may happen for large packs which contain many small blobs.
+func (p *Packer) HeaderFull() bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return HeaderSize+p.headerSize+CompressedEntrySize > maxHeaderSize
+}
+
 // Count returns the number of blobs in this packer.
 func (p *Packer) Count() int {
 	p.m.Lock()
@@ -151,7 +188,7 @@ func (p *Packer) String() string {
 
 var (
 	// we require at least one entry in the header, and one blob for a pack file
-	minFileSize = EntrySize + crypto.Extension + uint(headerLengthSize)
+	minFileSize = PlainEntrySize + crypto.Extension + uint(headerLengthSize)
 )
 
 const (
@@ -168,10 +205,12 @@ const (
 // readRecords reads up to max records from the underlying ReaderAt, returning
 // the raw header, the total number of records in the header, and any error.
 // If the header contains fewer than max entries, the header is truncated to
-// the appropriate size.
+// the appropriate size. Records are counted in units of PlainEntrySize, as
+// header entries for compressed blobs are larger the returned number of
+// records is an upper bound for the number of blobs in the pack.
 func readRecords(rd io.ReaderAt, size int64, max int) ([]byte, int, error) {
 	var bufsize int
-	bufsize += max * int(EntrySize)
+	bufsize += max * int(PlainEntrySize)
 	bufsize += crypto.Extension
 	bufsize += headerLengthSize
 
@@ -195,8 +234,6 @@ func readRecords(rd io.ReaderAt, size int64, max int) ([]byte, int, error) {
 		err = InvalidFileError{Message: "header length is zero"}
 	case hlen < crypto.Extension:
 		err = InvalidFileError{Message: "header length is too small"}
-	case (hlen-crypto.Extension)%uint32(EntrySize) != 0:
-		err = InvalidFileError{Message: "header length is invalid"}
 	case int64(hlen) > size-int64(headerLengthSize):
 		err = InvalidFileError{Message: "header is larger than file"}
 	case int64(hlen) > maxHeaderSize:
@@ -206,8 +243,9 @@ func readRecords(rd io.ReaderAt, size int64, max int) ([]byte, int, error) {
 		return nil, 0, errors.Wrap(err, "readHeader")
 	}
 
-	total := (int(hlen) - crypto.Extension) / int(EntrySize)
-	if total < max {
+	// round up so that reading total records always covers the whole header
+	total := (int(hlen) - crypto.Extension + int(PlainEntrySize) - 1) / int(PlainEntrySize)
+	if int(hlen) <= len(b) {
 		// truncate to the beginning of the pack header
 		b = b[len(b)-int(hlen):]
 	}
@@ -266,17 +304,19 @@ func List(k *crypto.Key, rd io.ReaderAt, size int64) (entries []restic.Blob, hdr
 
 	hdrSize = headerLengthSize + uint32(len(buf))
 
+	// headers of packs written with a write-only key are encrypted with the
+	// metadata key, the blobs are still authenticated with the master key
 	nonce, buf := buf[:k.NonceSize()], buf[k.NonceSize():]
-	buf, err = k.Open(buf[:0], nonce, buf, nil)
+	buf, err = k.WithMetadataFallback().Open(buf[:0], nonce, buf, nil)
 	if err != nil {
 		return nil, 0, err
 	}
 
-	entries = make([]restic.Blob, 0, uint(len(buf))/EntrySize)
+	entries = make([]restic.Blob, 0, uint(len(buf))/PlainEntrySize)
 
 	pos := uint(0)
 	for len(buf) > 0 {
-		entry, err := parseHeaderEntry(buf)
+		entry, headerSize, err := parseHeaderEntry(buf)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -284,35 +324,67 @@ func List(k *crypto.Key, rd io.ReaderAt, size int64) (entries []restic.Blob, hdr
 
 		entries = append(entries, entry)
 		pos += entry.Length
-		buf = buf[EntrySize:]
+		buf = buf[headerSize:]
 	}
 
 	return entries, hdrSize, nil
 }
 
+// CalculateEntrySize returns the size of the header entry for the blob.
+func CalculateEntrySize(blob restic.Blob) uint {
+	if blob.IsCompressed() {
+		return CompressedEntrySize
+	}
+	return PlainEntrySize
+}
+
+// CalculateHeaderSize returns the size of the pack header for the given
+// blobs, including the crypto overhead and the header length field.
+func CalculateHeaderSize(blobs []restic.Blob) uint {
+	size := uint(HeaderSize)
+	for _, blob := range blobs {
+		size += CalculateEntrySize(blob)
+	}
+	return size
+}
+
 // PackedSizeOfBlob returns the size a blob actually uses when saved in a pack
-func PackedSizeOfBlob(blobLength uint) uint {
-	return blobLength + EntrySize
+func PackedSizeOfBlob(blob restic.Blob) uint {
+	return blob.Length + CalculateEntrySize(blob)
 }
 
-func parseHeaderEntry(p []byte) (b restic.Blob, err error) {
-	if uint(len(p)) < EntrySize {
+func parseHeaderEntry(p []byte) (b restic.Blob, size uint, err error) {
+	if uint(len(p)) < PlainEntrySize {
 		err = errors.Errorf("parseHeaderEntry: buffer of size %d too short", len(p))
-		return b, err
+		return b, size, err
 	}
-	p = p[:EntrySize]
 
-	switch p[0] {
-	case 0:
+	tpe := p[0]
+
+	switch tpe {
+	case 0, 2:
 		b.Type = restic.DataBlob
-	case 1:
+	case 1, 3:
 		b.Type = restic.TreeBlob
 	default:
-		return b, errors.Errorf("invalid type %d", p[0])
+		return b, size, errors.Errorf("invalid type %d", tpe)
 	}
 
 	b.Length = uint(binary.LittleEndian.Uint32(p[1:5]))
-	copy(b.ID[:], p[5:])
+	p = p[5:]
+	if tpe == 2 || tpe == 3 {
+		if uint(len(p)) < CompressedEntrySize-5 {
+			err = errors.Errorf("parseHeaderEntry: buffer of size %d too short", len(p)+5)
+			return b, size, err
+		}
+		b.UncompressedLength = uint(binary.LittleEndian.Uint32(p[0:4]))
+		p = p[4:]
+		size = CompressedEntrySize
+	} else {
+		size = PlainEntrySize
+	}
 
-	return b, nil
+	copy(b.ID[:], p[:len(restic.ID{})])
+
+	return b, size, nil
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
+	"io/ioutil"
 	"testing"
 
 	"github.com/restic/restic/internal/crypto"
@@ -23,9 +24,10 @@ func TestParseHeaderEntry(t *testing.T) {
 	buf := new(bytes.Buffer)
 	_ = binary.Write(buf, binary.LittleEndian, &h)
 
-	b, err := parseHeaderEntry(buf.Bytes())
+	b, size, err := parseHeaderEntry(buf.Bytes())
 	rtest.OK(t, err)
 	rtest.Equals(t, restic.DataBlob, b.Type)
+	rtest.Equals(t, PlainEntrySize, size)
 	t.Logf("%v %v", h.ID, b.ID)
 	rtest.Assert(t, bytes.Equal(h.ID[:], b.ID[:]), "id mismatch")
 	rtest.Equals(t, uint(h.Length), b.Length)
@@ -34,14 +36,34 @@ func TestParseHeaderEntry(t *testing.T) {
 	buf.Reset()
 	_ = binary.Write(buf, binary.LittleEndian, &h)
 
-	b, err = parseHeaderEntry(buf.Bytes())
+	_, _, err = parseHeaderEntry(buf.Bytes())
 	rtest.Assert(t, err != nil, "no error for invalid type")
 
 	h.Type = 0
 	buf.Reset()
 	_ = binary.Write(buf, binary.LittleEndian, &h)
 
-	b, err = parseHeaderEntry(buf.Bytes()[:EntrySize-1])
+	_, _, err = parseHeaderEntry(buf.Bytes()[:PlainEntrySize-1])
+	rtest.Assert(t, err != nil, "no error for short input")
+
+	ch := compressedHeaderEntry{
+		Type:               3, // compressed tree
+		Length:             100,
+		UncompressedLength: 200,
+		ID:                 h.ID,
+	}
+	buf.Reset()
+	_ = binary.Write(buf, binary.LittleEndian, &ch)
+
+	b, size, err = parseHeaderEntry(buf.Bytes())
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.TreeBlob, b.Type)
+	rtest.Equals(t, CompressedEntrySize, size)
+	rtest.Equals(t, uint(ch.Length), b.Length)
+	rtest.Equals(t, uint(ch.UncompressedLength), b.UncompressedLength)
+	rtest.Assert(t, bytes.Equal(ch.ID[:], b.ID[:]), "id mismatch")
+
+	_, _, err = parseHeaderEntry(buf.Bytes()[:CompressedEntrySize-1])
 	rtest.Assert(t, err != nil, "no error for short input")
 }
 
@@ -58,7 +80,7 @@ func (rd *countingReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
 func TestReadHeaderEagerLoad(t *testing.T) {
 
 	testReadHeader := func(dataSize, entryCount, expectedReadInvocationCount int) {
-		expectedHeader := rtest.Random(0, entryCount*int(EntrySize)+crypto.Extension)
+		expectedHeader := rtest.Random(0, entryCount*int(PlainEntrySize)+crypto.Extension)
 
 		buf := &bytes.Buffer{}
 		buf.Write(rtest.Random(0, dataSize))                                             // pack blobs data
@@ -83,8 +105,8 @@ func TestReadHeaderEagerLoad(t *testing.T) {
 	testReadHeader(100, eagerEntries+1, 2)
 
 	// file size == eager header load size
-	eagerLoadSize := int((eagerEntries * EntrySize) + crypto.Extension)
-	headerSize := int(1*EntrySize) + crypto.Extension
+	eagerLoadSize := int((eagerEntries * PlainEntrySize) + crypto.Extension)
+	headerSize := int(1*PlainEntrySize) + crypto.Extension
 	dataSize := eagerLoadSize - headerSize - binary.Size(uint32(0))
 	testReadHeader(dataSize-1, 1, 1)
 	testReadHeader(dataSize, 1, 1)
@@ -92,12 +114,35 @@ func TestReadHeaderEagerLoad(t *testing.T) {
 	testReadHeader(dataSize+2, 1, 1)
 	testReadHeader(dataSize+3, 1, 1)
 	testReadHeader(dataSize+4, 1, 1)
+
+	// headers with compressed entries are not a multiple of PlainEntrySize
+	testReadCompressedHeader := func(entryCount, expectedReadInvocationCount int) {
+		expectedHeader := rtest.Random(0, entryCount*int(CompressedEntrySize)+crypto.Extension)
+
+		buf := &bytes.Buffer{}
+		buf.Write(rtest.Random(0, 100))
+		buf.Write(expectedHeader)
+		rtest.OK(t, binary.Write(buf, binary.LittleEndian, uint32(len(expectedHeader))))
+
+		rd := &countingReaderAt{delegate: bytes.NewReader(buf.Bytes())}
+
+		header, err := readHeader(rd, int64(buf.Len()))
+		rtest.OK(t, err)
+
+		rtest.Equals(t, expectedHeader, header)
+		rtest.Equals(t, expectedReadInvocationCount, rd.invocationCount)
+	}
+
+	testReadCompressedHeader(1, 1)
+	testReadCompressedHeader(eagerEntries-2, 1)
+	testReadCompressedHeader(eagerEntries, 2)
+	testReadCompressedHeader(3*eagerEntries+1, 2)
 }
 
 func TestReadRecords(t *testing.T) {
 	testReadRecords := func(dataSize, entryCount, totalRecords int) {
-		totalHeader := rtest.Random(0, totalRecords*int(EntrySize)+crypto.Extension)
-		off := len(totalHeader) - (entryCount*int(EntrySize) + crypto.Extension)
+		totalHeader := rtest.Random(0, totalRecords*int(PlainEntrySize)+crypto.Extension)
+		off := len(totalHeader) - (entryCount*int(PlainEntrySize) + crypto.Extension)
 		if off < 0 {
 			off = 0
 		}
@@ -127,8 +172,8 @@ func TestReadRecords(t *testing.T) {
 	testReadRecords(100, eagerEntries, eagerEntries+1)
 
 	// file size == eager header load size
-	eagerLoadSize := int((eagerEntries * EntrySize) + crypto.Extension)
-	headerSize := int(1*EntrySize) + crypto.Extension
+	eagerLoadSize := int((eagerEntries * PlainEntrySize) + crypto.Extension)
+	headerSize := int(1*PlainEntrySize) + crypto.Extension
 	dataSize := eagerLoadSize - headerSize - binary.Size(uint32(0))
 	testReadRecords(dataSize-1, 1, 1)
 	testReadRecords(dataSize, 1, 1)
@@ -143,3 +188,19 @@ func TestReadRecords(t *testing.T) {
 		}
 	}
 }
+
+func TestHeaderFull(t *testing.T) {
+	p := NewPacker(crypto.NewRandomKey(), ioutil.Discard)
+	data := make([]byte, 16)
+
+	var n int
+	for !p.HeaderFull() {
+		_, err := p.Add(restic.TreeBlob, restic.NewRandomID(), data, len(data))
+		rtest.OK(t, err)
+		n++
+	}
+
+	hdrSize := CalculateHeaderSize(p.Blobs())
+	rtest.Assert(t, hdrSize <= maxHeaderSize, "header size %d exceeds maximum", hdrSize)
+	rtest.Assert(t, hdrSize+CompressedEntrySize > maxHeaderSize, "header is full too early after %d blobs", n)
+}
@@ -20,11 +20,16 @@ import (
 var testLens = []int{23, 31650, 25860, 10928, 13769, 19862, 5211, 127, 13690, 30231}
 
 type Buf struct {
-	data []byte
-	id   restic.ID
+	data               []byte
+	id                 restic.ID
+	uncompressedLength int
 }
 
 func newPack(t testing.TB, k *crypto.Key, lengths []int) ([]Buf, []byte, uint) {
+	return newPackWithCompression(t, k, lengths, false)
+}
+
+func newPackWithCompression(t testing.TB, k *crypto.Key, lengths []int, compressed bool) ([]Buf, []byte, uint) {
 	bufs := []Buf{}
 
 	for _, l := range lengths {
@@ -32,14 +37,19 @@ func newPack(t testing.TB, k *crypto.Key, lengths []int) ([]Buf, []byte, uint) {
 		_, err := io.ReadFull(rand.Reader, b)
 		rtest.OK(t, err)
 		h := sha256.Sum256(b)
-		bufs = append(bufs, Buf{data: b, id: h})
+		buf := Buf{data: b, id: h}
+		if compressed {
+			// the packer does not interpret the data, so any length will do
+			buf.uncompressedLength = 2 * l
+		}
+		bufs = append(bufs, buf)
 	}
 
 	// pack blobs
 	var buf bytes.Buffer
 	p := pack.NewPacker(k, &buf)
 	for _, b := range bufs {
-		_, err := p.Add(restic.TreeBlob, b.id, b.data)
+		_, err := p.Add(restic.TreeBlob, b.id, b.data, b.uncompressedLength)
 		rtest.OK(t, err)
 	}
 
@@ -51,11 +61,17 @@ func newPack(t testing.TB, k *crypto.Key, lengths []int) ([]Buf, []byte, uint) {
 
 func verifyBlobs(t testing.TB, bufs []Buf, k *crypto.Key, rd io.ReaderAt, packSize uint) {
 	written := 0
+	entrySize := 0
 	for _, buf := range bufs {
 		written += len(buf.data)
+		if buf.uncompressedLength != 0 {
+			entrySize += int(pack.CompressedEntrySize)
+		} else {
+			entrySize += int(pack.PlainEntrySize)
+		}
 	}
 	// header length + header + header crypto
-	headerSize := binary.Size(uint32(0)) + restic.CiphertextLength(len(bufs)*int(pack.EntrySize))
+	headerSize := binary.Size(uint32(0)) + restic.CiphertextLength(entrySize)
 	written += headerSize
 
 	// check length
@@ -71,6 +87,7 @@ func verifyBlobs(t testing.TB, bufs []Buf, k *crypto.Key, rd io.ReaderAt, packSi
 	for i, b := range bufs {
 		e := entries[i]
 		rtest.Equals(t, b.id, e.ID)
+		rtest.Equals(t, uint(b.uncompressedLength), e.UncompressedLength)
 
 		if len(buf) < int(e.Length) {
 			buf = make([]byte, int(e.Length))
@@ -94,6 +111,15 @@ func TestCreatePack(t *testing.T) {
 	verifyBlobs(t, bufs, k, bytes.NewReader(packData), packSize)
 }
 
+func TestCreateCompressedPack(t *testing.T) {
+	// create random keys
+	k := crypto.NewRandomKey()
+
+	bufs, packData, packSize := newPackWithCompression(t, k, testLens, true)
+	rtest.Equals(t, uint(len(packData)), packSize)
+	verifyBlobs(t, bufs, k, bytes.NewReader(packData), packSize)
+}
+
 var blobTypeJSON = []struct {
 	t   restic.BlobType
 	res string
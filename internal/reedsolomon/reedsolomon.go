@@ -0,0 +1,175 @@
+// Package reedsolomon implements a systematic Reed-Solomon erasure code over
+// GF(2^8) based on a Cauchy matrix. Any k of the k data shards and m parity
+// shards are sufficient to recover all data shards.
+//
+// The coefficient of a data shard only depends on its index, so the parity
+// can be computed incrementally: data shards are added in any order, and a
+// data shard can be removed from the parity again by adding it a second time.
+// Data shards of different lengths are padded with zeros.
+package reedsolomon
+
+import (
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/gf256"
+)
+
+const (
+	// MaxParityShards is the maximum number of parity shards.
+	MaxParityShards = 16
+	// MaxDataShards is the maximum number of data shards.
+	MaxDataShards = 256 - MaxParityShards
+)
+
+// ErrTooFewShards is returned if not enough shards are available to recover
+// the missing data shards.
+var ErrTooFewShards = errors.New("too few shards to recover the data")
+
+// coefficient returns the coefficient of data shard j in parity shard i, this
+// is the element (i, j) of the Cauchy matrix 1/(x_i + y_j) with x_i = i and
+// y_j = MaxParityShards + j. All square submatrices of a Cauchy matrix are
+// invertible.
+func coefficient(i, j int) byte {
+	return gf256.Div(1, byte(i)^byte(MaxParityShards+j))
+}
+
+// Update adds data shard j to the parity shards. Adding the same data shard
+// again removes it from the parity. All parity shards must be at least as
+// long as data.
+func Update(parity [][]byte, j int, data []byte) error {
+	if len(parity) > MaxParityShards {
+		return errors.Errorf("too many parity shards: %d", len(parity))
+	}
+	if j < 0 || j >= MaxDataShards {
+		return errors.Errorf("invalid data shard %d", j)
+	}
+
+	for i, p := range parity {
+		if len(p) < len(data) {
+			return errors.Errorf("parity shard %d is too short", i)
+		}
+		gf256.MulAdd(p, data, coefficient(i, j))
+	}
+	return nil
+}
+
+// Reconstruct recovers the missing data shards, which must be nil. Parity
+// shards which are not available must be nil as well. The recovered data
+// shards have the length of the parity shards, callers need to truncate them
+// to their original length.
+func Reconstruct(data, parity [][]byte) error {
+	if len(parity) > MaxParityShards || len(data) > MaxDataShards {
+		return errors.New("too many shards")
+	}
+
+	var missing, rows []int
+	for j, d := range data {
+		if d == nil {
+			missing = append(missing, j)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	size := -1
+	for i, p := range parity {
+		if p == nil {
+			continue
+		}
+		if size >= 0 && len(p) != size {
+			return errors.New("parity shards have different lengths")
+		}
+		size = len(p)
+
+		if len(rows) < len(missing) {
+			rows = append(rows, i)
+		}
+	}
+	if len(rows) < len(missing) {
+		return ErrTooFewShards
+	}
+
+	// subtract the available data shards from the parity, the remainder only
+	// depends on the missing data shards
+	remainder := make([][]byte, len(rows))
+	for r, i := range rows {
+		remainder[r] = make([]byte, size)
+		copy(remainder[r], parity[i])
+
+		for j, d := range data {
+			if d == nil {
+				continue
+			}
+			if len(d) > size {
+				return errors.Errorf("data shard %d is longer than the parity", j)
+			}
+			gf256.MulAdd(remainder[r], d, coefficient(i, j))
+		}
+	}
+
+	m := make([][]byte, len(rows))
+	for r, i := range rows {
+		m[r] = make([]byte, len(missing))
+		for t, j := range missing {
+			m[r][t] = coefficient(i, j)
+		}
+	}
+
+	inv, err := invert(m)
+	if err != nil {
+		return err
+	}
+
+	for t, j := range missing {
+		data[j] = make([]byte, size)
+		for r := range rows {
+			gf256.MulAdd(data[j], remainder[r], inv[t][r])
+		}
+	}
+	return nil
+}
+
+// invert returns the inverse of the square matrix m, m is modified.
+func invert(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	inv := make([][]byte, n)
+	for i := range inv {
+		inv[i] = make([]byte, n)
+		inv[i][i] = 1
+	}
+
+	// Gauss-Jordan elimination
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for row := col; row < n; row++ {
+			if m[row][col] != 0 {
+				pivot = row
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, errors.New("matrix is singular")
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+
+		// scale the pivot row so that the pivot is one
+		scale := gf256.Div(1, m[col][col])
+		for k := 0; k < n; k++ {
+			m[col][k] = gf256.Mul(m[col][k], scale)
+			inv[col][k] = gf256.Mul(inv[col][k], scale)
+		}
+
+		// eliminate the column from all other rows
+		for row := 0; row < n; row++ {
+			if row == col || m[row][col] == 0 {
+				continue
+			}
+			f := m[row][col]
+			gf256.MulAdd(m[row], m[col], f)
+			gf256.MulAdd(inv[row], inv[col], f)
+		}
+	}
+
+	return inv, nil
+}
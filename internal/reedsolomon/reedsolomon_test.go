@@ -0,0 +1,82 @@
+package reedsolomon
+
+import (
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func newParity(shards, size int) [][]byte {
+	parity := make([][]byte, shards)
+	for i := range parity {
+		parity[i] = make([]byte, size)
+	}
+	return parity
+}
+
+func TestReconstruct(t *testing.T) {
+	const size = 4000
+	var data [][]byte
+	for j := 0; j < 6; j++ {
+		data = append(data, rtest.Random(j, size-j*100))
+	}
+
+	parity := newParity(3, size)
+	for j, d := range data {
+		rtest.OK(t, Update(parity, j, d))
+	}
+
+	var tests = []struct {
+		missingData   []int
+		missingParity []int
+	}{
+		{nil, nil},
+		{[]int{0}, nil},
+		{[]int{5}, []int{0, 1}},
+		{[]int{1, 3}, []int{2}},
+		{[]int{0, 2, 4}, nil},
+	}
+
+	for _, test := range tests {
+		shards := make([][]byte, len(data))
+		copy(shards, data)
+		for _, j := range test.missingData {
+			shards[j] = nil
+		}
+		p := make([][]byte, len(parity))
+		copy(p, parity)
+		for _, i := range test.missingParity {
+			p[i] = nil
+		}
+
+		rtest.OK(t, Reconstruct(shards, p))
+		for j, d := range data {
+			rtest.Equals(t, d, shards[j][:len(d)])
+		}
+	}
+}
+
+func TestReconstructTooFew(t *testing.T) {
+	data := [][]byte{rtest.Random(1, 100), rtest.Random(2, 100), rtest.Random(3, 100)}
+	parity := newParity(2, 100)
+	for j, d := range data {
+		rtest.OK(t, Update(parity, j, d))
+	}
+
+	err := Reconstruct([][]byte{nil, nil, data[2]}, [][]byte{parity[0], nil})
+	rtest.Assert(t, err == ErrTooFewShards, "expected ErrTooFewShards, got %v", err)
+}
+
+func TestUpdateRemove(t *testing.T) {
+	data := [][]byte{rtest.Random(1, 100), rtest.Random(2, 80), rtest.Random(3, 90)}
+	parity := newParity(2, 100)
+	for j, d := range data {
+		rtest.OK(t, Update(parity, j, d))
+	}
+
+	// removing shard 1 leaves the parity of the other shards
+	rtest.OK(t, Update(parity, 1, data[1]))
+	shards := [][]byte{nil, {}, data[2]}
+	rtest.OK(t, Reconstruct(shards, parity))
+	rtest.Equals(t, data[0], shards[0])
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// Capabilities restrict the commands which can be run with a key. They are
+// checked by the client only: anyone who can decrypt a key has access to the
+// master key and can therefore do everything with the repository.
+const (
+	// CapabilityRead allows reading snapshots and data.
+	CapabilityRead = "read"
+	// CapabilityAppend allows adding new snapshots and data.
+	CapabilityAppend = "append"
+	// CapabilityForget allows removing and modifying snapshots.
+	CapabilityForget = "forget"
+	// CapabilityPrune allows removing data and rewriting the index.
+	CapabilityPrune = "prune"
+	// CapabilityKeyAdmin allows managing keys and the master key.
+	CapabilityKeyAdmin = "key-admin"
+)
+
+// AllCapabilities lists all known capabilities.
+var AllCapabilities = []string{
+	CapabilityRead,
+	CapabilityAppend,
+	CapabilityForget,
+	CapabilityPrune,
+	CapabilityKeyAdmin,
+}
+
+// ParseCapabilities parses a comma-separated list of capabilities. The
+// returned list is sorted and free of duplicates. The special value "all"
+// returns an empty list, which grants all capabilities.
+func ParseCapabilities(s string) ([]string, error) {
+	if s == "all" {
+		return nil, nil
+	}
+
+	seen := make(map[string]struct{})
+	var caps []string
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if !validCapability(c) {
+			return nil, errors.Fatalf("invalid capability %q, valid are %v", c, strings.Join(AllCapabilities, ", "))
+		}
+		if _, ok := seen[c]; ok {
+			continue
+		}
+		seen[c] = struct{}{}
+		caps = append(caps, c)
+	}
+
+	sort.Strings(caps)
+	return caps, nil
+}
+
+func validCapability(c string) bool {
+	for _, known := range AllCapabilities {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+// equalCapabilities returns true if both lists contain the same capabilities
+// in the same order.
+func equalCapabilities(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hasCapability returns true if caps grants the capability c. An empty list
+// grants all capabilities.
+func hasCapability(caps []string, c string) bool {
+	if len(caps) == 0 {
+		return true
+	}
+	for _, kc := range caps {
+		if kc == c {
+			return true
+		}
+	}
+	return false
+}
+
+// HasCapability returns true if the key grants the capability c. Keys without
+// a list of capabilities grant all capabilities.
+func (k *Key) HasCapability(c string) bool {
+	return hasCapability(k.Capabilities, c)
+}
+
+// CheckCapabilities returns an error if the key used to open the repository
+// lacks one of the capabilities.
+func (r *Repository) CheckCapabilities(caps ...string) error {
+	var missing []string
+	for _, c := range caps {
+		if !hasCapability(r.capabilities, c) {
+			missing = append(missing, c)
+		}
+	}
+
+	if len(missing) > 0 {
+		return errors.Fatalf("key %v lacks the capabilities %v required for this command", shortKeyName(r.keyName), strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Capabilities returns the capabilities of the key used to open the
+// repository. An empty list grants all capabilities.
+func (r *Repository) Capabilities() []string {
+	return r.capabilities
+}
+
+func shortKeyName(name string) string {
+	if len(name) > 8 {
+		return name[:8]
+	}
+	return name
+}
@@ -19,7 +19,7 @@ import (
 // Hence the index data structure defined here is one of the main contributions
 // to the total memory requirements of restic.
 //
-// We store the index entries in indexMaps. In these maps, entries take 56
+// We store the index entries in indexMaps. In these maps, entries take 64
 // bytes each, plus 8/4 = 2 bytes of unused pointers on average, not counting
 // malloc and header struct overhead and ignoring duplicates (those are only
 // present in edge cases and are also removed by prune runs).
@@ -33,17 +33,22 @@ import (
 // size is 1.5 MB and the minimum pack size is 4 MB)
 //
 // We have the following sizes:
-// indexEntry:  56 bytes  (on amd64)
+// indexEntry:  64 bytes  (on amd64)
 // each packID: 32 bytes
 //
 // To save N index entries, we therefore need:
-// N * (56 + 2) bytes + N * 32 bytes / BP = N * 62 bytes,
-// i.e., fewer than 64 bytes per blob in an index.
+// N * (64 + 2) bytes + N * 32 bytes / BP = N * 70 bytes,
+// i.e., fewer than 72 bytes per blob in an index.
+//
+// Indexes read from files in the binary format (see index_binary.go) are not
+// decoded into indexMaps. Lookups are done directly on the encoded data, which
+// needs about 40 bytes per blob.
 
 // Index holds lookup tables for id -> pack.
 type Index struct {
 	m         sync.Mutex
 	byType    [restic.NumBlobTypes]indexMap
+	bin       *binaryIndex // set for indexes decoded from the binary format
 	packs     restic.IDs
 	treePacks restic.IDs
 	// only used by Store, StorePacks does not check for already saved packIDs
@@ -74,12 +79,54 @@ const maxuint32 = 1<<32 - 1
 
 func (idx *Index) store(packIndex int, blob restic.Blob) {
 	// assert that offset and length fit into uint32!
-	if blob.Offset > maxuint32 || blob.Length > maxuint32 {
+	if blob.Offset > maxuint32 || blob.Length > maxuint32 || blob.UncompressedLength > maxuint32 {
 		panic("offset or length does not fit in uint32. You have packs > 4GB!")
 	}
 
 	m := &idx.byType[blob.Type]
-	m.add(blob.ID, packIndex, uint32(blob.Offset), uint32(blob.Length))
+	m.add(blob.ID, packIndex, uint32(blob.Offset), uint32(blob.Length), uint32(blob.UncompressedLength))
+}
+
+// foreach calls fn for all entries of type typ, until fn returns false.
+func (idx *Index) foreach(typ restic.BlobType, fn func(*indexEntry) bool) {
+	if idx.bin != nil {
+		idx.bin.byType[typ].foreach(fn)
+		return
+	}
+	idx.byType[typ].foreach(fn)
+}
+
+// foreachWithID calls fn for all entries of type typ with the given id.
+func (idx *Index) foreachWithID(typ restic.BlobType, id restic.ID, fn func(*indexEntry)) {
+	if idx.bin != nil {
+		idx.bin.byType[typ].foreachWithID(id, fn)
+		return
+	}
+	idx.byType[typ].foreachWithID(id, fn)
+}
+
+// get returns the first entry of type typ with the given id.
+func (idx *Index) get(typ restic.BlobType, id restic.ID) (e indexEntry, found bool) {
+	if idx.bin != nil {
+		idx.bin.byType[typ].foreachWithID(id, func(be *indexEntry) {
+			if !found {
+				e, found = *be, true
+			}
+		})
+		return e, found
+	}
+	if be := idx.byType[typ].get(id); be != nil {
+		return *be, true
+	}
+	return e, false
+}
+
+// count returns the number of entries of type typ.
+func (idx *Index) count(typ restic.BlobType) uint {
+	if idx.bin != nil {
+		return idx.bin.byType[typ].count
+	}
+	return idx.byType[typ].len()
 }
 
 // Final returns true iff the index is already written to the repository, it is
@@ -105,7 +152,7 @@ var IndexFull = func(idx *Index) bool {
 
 	var blobs uint
 	for typ := range idx.byType {
-		blobs += idx.byType[typ].len()
+		blobs += idx.count(restic.BlobType(typ))
 	}
 	age := time.Since(idx.created)
 
@@ -168,8 +215,9 @@ func (idx *Index) toPackedBlob(e *indexEntry, t restic.BlobType) restic.PackedBl
 			BlobHandle: restic.BlobHandle{
 				ID:   e.id,
 				Type: t},
-			Length: uint(e.length),
-			Offset: uint(e.offset),
+			Length:             uint(e.length),
+			Offset:             uint(e.offset),
+			UncompressedLength: uint(e.uncompressedLength),
 		},
 		PackID: idx.packs[e.packIndex],
 	}
@@ -181,7 +229,7 @@ func (idx *Index) Lookup(bh restic.BlobHandle, pbs []restic.PackedBlob) []restic
 	idx.m.Lock()
 	defer idx.m.Unlock()
 
-	idx.byType[bh.Type].foreachWithID(bh.ID, func(e *indexEntry) {
+	idx.foreachWithID(bh.Type, bh.ID, func(e *indexEntry) {
 		pbs = append(pbs, idx.toPackedBlob(e, bh.Type))
 	})
 
@@ -194,8 +242,7 @@ func (idx *Index) ListPack(id restic.ID) (pbs []restic.PackedBlob) {
 	defer idx.m.Unlock()
 
 	for typ := range idx.byType {
-		m := &idx.byType[typ]
-		m.foreach(func(e *indexEntry) bool {
+		idx.foreach(restic.BlobType(typ), func(e *indexEntry) bool {
 			if idx.packs[e.packIndex] == id {
 				pbs = append(pbs, idx.toPackedBlob(e, restic.BlobType(typ)))
 			}
@@ -211,7 +258,8 @@ func (idx *Index) Has(bh restic.BlobHandle) bool {
 	idx.m.Lock()
 	defer idx.m.Unlock()
 
-	return idx.byType[bh.Type].get(bh.ID) != nil
+	_, found := idx.get(bh.Type, bh.ID)
+	return found
 }
 
 // LookupSize returns the length of the plaintext content of the blob with the
@@ -220,10 +268,13 @@ func (idx *Index) LookupSize(bh restic.BlobHandle) (plaintextLength uint, found
 	idx.m.Lock()
 	defer idx.m.Unlock()
 
-	e := idx.byType[bh.Type].get(bh.ID)
-	if e == nil {
+	e, found := idx.get(bh.Type, bh.ID)
+	if !found {
 		return 0, false
 	}
+	if e.uncompressedLength != 0 {
+		return uint(e.uncompressedLength), true
+	}
 	return uint(restic.PlaintextLength(int(e.length))), true
 }
 
@@ -261,8 +312,7 @@ func (idx *Index) Each(ctx context.Context) <-chan restic.PackedBlob {
 		}()
 
 		for typ := range idx.byType {
-			m := &idx.byType[typ]
-			m.foreach(func(e *indexEntry) bool {
+			idx.foreach(restic.BlobType(typ), func(e *indexEntry) bool {
 				select {
 				case <-ctx.Done():
 					return false
@@ -300,22 +350,17 @@ func (idx *Index) EachByPack(ctx context.Context, packBlacklist restic.IDSet) <-
 		}()
 
 		for typ := range idx.byType {
-			byPack := make(map[restic.ID][]*indexEntry)
-			m := &idx.byType[typ]
-			m.foreach(func(e *indexEntry) bool {
+			byPack := make(map[restic.ID][]restic.Blob)
+			idx.foreach(restic.BlobType(typ), func(e *indexEntry) bool {
 				packID := idx.packs[e.packIndex]
 				if !idx.final || !packBlacklist.Has(packID) {
-					byPack[packID] = append(byPack[packID], e)
+					byPack[packID] = append(byPack[packID], idx.toPackedBlob(e, restic.BlobType(typ)).Blob)
 				}
 				return true
 			})
 
-			for packID, pack := range byPack {
-				var result EachByPackResult
-				result.packID = packID
-				for _, e := range pack {
-					result.blobs = append(result.blobs, idx.toPackedBlob(e, restic.BlobType(typ)).Blob)
-				}
+			for packID, blobs := range byPack {
+				result := EachByPackResult{packID: packID, blobs: blobs}
 				select {
 				case <-ctx.Done():
 					return
@@ -347,7 +392,7 @@ func (idx *Index) Count(t restic.BlobType) (n uint) {
 	idx.m.Lock()
 	defer idx.m.Unlock()
 
-	return idx.byType[t].len()
+	return idx.count(t)
 }
 
 type packJSON struct {
@@ -356,10 +401,11 @@ type packJSON struct {
 }
 
 type blobJSON struct {
-	ID     restic.ID       `json:"id"`
-	Type   restic.BlobType `json:"type"`
-	Offset uint            `json:"offset"`
-	Length uint            `json:"length"`
+	ID                 restic.ID       `json:"id"`
+	Type               restic.BlobType `json:"type"`
+	Offset             uint            `json:"offset"`
+	Length             uint            `json:"length"`
+	UncompressedLength uint            `json:"uncompressed_length,omitempty"`
 }
 
 // generatePackList returns a list of packs.
@@ -368,8 +414,7 @@ func (idx *Index) generatePackList() ([]*packJSON, error) {
 	packs := make(map[restic.ID]*packJSON)
 
 	for typ := range idx.byType {
-		m := &idx.byType[typ]
-		m.foreach(func(e *indexEntry) bool {
+		idx.foreach(restic.BlobType(typ), func(e *indexEntry) bool {
 			packID := idx.packs[e.packIndex]
 			if packID.IsNull() {
 				panic("null pack id")
@@ -390,10 +435,11 @@ func (idx *Index) generatePackList() ([]*packJSON, error) {
 
 			// add blob
 			p.Blobs = append(p.Blobs, blobJSON{
-				ID:     e.id,
-				Type:   restic.BlobType(typ),
-				Offset: uint(e.offset),
-				Length: uint(e.length),
+				ID:                 e.id,
+				Type:               restic.BlobType(typ),
+				Offset:             uint(e.offset),
+				Length:             uint(e.length),
+				UncompressedLength: uint(e.uncompressedLength),
 			})
 
 			return true
@@ -436,6 +482,14 @@ func (idx *Index) encode(w io.Writer) error {
 	return enc.Encode(idxJSON)
 }
 
+// EncodeBinary writes the index in the binary format to the writer w.
+func (idx *Index) EncodeBinary(w io.Writer) error {
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	return idx.encodeBinary(w)
+}
+
 // Finalize sets the index to final.
 func (idx *Index) Finalize() {
 	debug.Log("finalizing index")
@@ -527,6 +581,9 @@ func (idx *Index) merge(idx2 *Index) error {
 	if !idx2.final {
 		return errors.New("index to merge is not final")
 	}
+	if idx.bin != nil {
+		return errors.New("cannot merge into an index in the binary format")
+	}
 
 	packlen := len(idx.packs)
 	// first append packs as they might be accessed when looking for duplicates below
@@ -534,7 +591,6 @@ func (idx *Index) merge(idx2 *Index) error {
 
 	// copy all index entries of idx2 to idx
 	for typ := range idx2.byType {
-		m2 := &idx2.byType[typ]
 		m := &idx.byType[typ]
 
 		// helper func to test if identical entry is contained in idx
@@ -542,17 +598,18 @@ func (idx *Index) merge(idx2 *Index) error {
 			m.foreachWithID(e2.id, func(e *indexEntry) {
 				b := idx.toPackedBlob(e, restic.BlobType(typ))
 				b2 := idx2.toPackedBlob(e2, restic.BlobType(typ))
-				if b.Length == b2.Length && b.Offset == b2.Offset && b.PackID == b2.PackID {
+				if b.Length == b2.Length && b.Offset == b2.Offset && b.PackID == b2.PackID &&
+					b.UncompressedLength == b2.UncompressedLength {
 					found = true
 				}
 			})
 			return found
 		}
 
-		m2.foreach(func(e2 *indexEntry) bool {
+		idx2.foreach(restic.BlobType(typ), func(e2 *indexEntry) bool {
 			if !hasIdenticalEntry(e2) {
 				// packIndex needs to be changed as idx2.pack was appended to idx.pack, see above
-				m.add(e2.id, e2.packIndex+packlen, e2.offset, e2.length)
+				m.add(e2.id, e2.packIndex+packlen, e2.offset, e2.length, e2.uncompressedLength)
 			}
 			return true
 		})
@@ -575,6 +632,15 @@ func isErrOldIndex(err error) bool {
 // DecodeIndex unserializes an index from buf.
 func DecodeIndex(buf []byte, id restic.ID) (idx *Index, oldFormat bool, err error) {
 	debug.Log("Start decoding index")
+	if isBinaryIndex(buf) {
+		// the buffer may be reused by the caller
+		idx, err = decodeBinaryIndex(append([]byte(nil), buf...), id)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "DecodeIndex")
+		}
+		return idx, false, nil
+	}
+
 	idxJSON := &jsonIndex{}
 
 	err = json.Unmarshal(buf, idxJSON)
@@ -600,8 +666,9 @@ func DecodeIndex(buf []byte, id restic.ID) (idx *Index, oldFormat bool, err erro
 				BlobHandle: restic.BlobHandle{
 					Type: blob.Type,
 					ID:   blob.ID},
-				Offset: blob.Offset,
-				Length: blob.Length,
+				Offset:             blob.Offset,
+				Length:             blob.Length,
+				UncompressedLength: blob.UncompressedLength,
 			})
 
 			switch blob.Type {
@@ -0,0 +1,542 @@
+package repository
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// The binary index format stores the entries of an index sorted by blob type
+// and ID. Blobs are looked up by bisecting the encoded data, so that an index
+// can be used without decoding the entries into an indexMap. This reduces the
+// memory needed for each blob to about 40 bytes.
+//
+// All fixed-size integers are encoded in little endian, variable-size
+// integers as unsigned varints:
+//
+//   magic "RIDX" || version (1 byte)
+//   supersedes: uvarint count || count * 32 byte index ID
+//   packs:      uvarint count || count * 32 byte pack ID
+//   for each blob type (including the invalid type 0):
+//     uvarint entries || uvarint blocks
+//     blocks * (32 byte first ID || uint64 offset of the block in data)
+//     uvarint length of data || data
+//
+// The entries are grouped into blocks of up to binaryIndexBlockEntries. Each
+// entry is encoded as the number of leading bytes of the ID which are equal to
+// the ID of the previous entry in the block (for the first entry: the first
+// ID of the block), the remaining bytes of the ID and the uvarints pack index,
+// offset, length and uncompressed length.
+
+const (
+	binaryIndexMagic        = "RIDX"
+	binaryIndexVersion      = 1
+	binaryIndexBlockEntries = 32
+
+	binaryIndexBlockSize = len(restic.ID{}) + 8
+)
+
+// isBinaryIndex returns true if buf contains an index in the binary format.
+func isBinaryIndex(buf []byte) bool {
+	return bytes.HasPrefix(buf, []byte(binaryIndexMagic))
+}
+
+// binaryIndex holds the entries of an index in the binary format.
+type binaryIndex struct {
+	byType [restic.NumBlobTypes]binarySection
+}
+
+// binarySection contains the entries of one blob type.
+type binarySection struct {
+	count  uint
+	blocks []byte
+	data   []byte
+}
+
+func (s *binarySection) numBlocks() int {
+	return len(s.blocks) / binaryIndexBlockSize
+}
+
+// firstID returns the ID of the first entry in block i.
+func (s *binarySection) firstID(i int) []byte {
+	return s.blocks[i*binaryIndexBlockSize : i*binaryIndexBlockSize+len(restic.ID{})]
+}
+
+// blockRange returns the start and end of block i in data.
+func (s *binarySection) blockRange(i int) (start, end int) {
+	start = int(binary.LittleEndian.Uint64(s.blocks[i*binaryIndexBlockSize+len(restic.ID{}):]))
+	end = len(s.data)
+	if i+1 < s.numBlocks() {
+		end = int(binary.LittleEndian.Uint64(s.blocks[(i+1)*binaryIndexBlockSize+len(restic.ID{}):]))
+	}
+	return start, end
+}
+
+// checkBlocks verifies that the blocks are non-empty and cover data without
+// gaps, such that blockRange can be used without further checks.
+func (s *binarySection) checkBlocks() error {
+	if s.numBlocks() == 0 && len(s.data) != 0 {
+		return errors.New("data without blocks")
+	}
+
+	for i := 0; i < s.numBlocks(); i++ {
+		start := binary.LittleEndian.Uint64(s.blocks[i*binaryIndexBlockSize+len(restic.ID{}):])
+		if (i == 0 && start != 0) || start >= uint64(len(s.data)) {
+			return errors.New("invalid block offset")
+		}
+		if i > 0 {
+			prevStart, _ := s.blockRange(i - 1)
+			if start <= uint64(prevStart) {
+				return errors.New("invalid block offset")
+			}
+		}
+	}
+	return nil
+}
+
+// binarySectionIterator decodes the entries of a section in order.
+type binarySectionIterator struct {
+	s        *binarySection
+	block    int
+	pos, end int
+	e        indexEntry
+	first    bool // e is the first entry of the block
+	err      error
+}
+
+func newBinarySectionIterator(s *binarySection, block int) *binarySectionIterator {
+	return &binarySectionIterator{s: s, block: block - 1}
+}
+
+// next decodes the next entry into it.e, it returns false at the end of the
+// section or if an entry is invalid.
+func (it *binarySectionIterator) next() bool {
+	it.first = false
+	if it.pos >= it.end {
+		if it.block+1 >= it.s.numBlocks() {
+			return false
+		}
+		it.block++
+		it.pos, it.end = it.s.blockRange(it.block)
+		copy(it.e.id[:], it.s.firstID(it.block))
+		it.first = true
+	}
+
+	n, err := decodeBinaryEntry(it.s.data[it.pos:it.end], &it.e)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.pos += n
+	return true
+}
+
+// decodeBinaryEntry decodes the entry at the start of data into e, e.id must
+// contain the ID of the previous entry. Returned is the size of the entry.
+func decodeBinaryEntry(data []byte, e *indexEntry) (int, error) {
+	if len(data) < 1 {
+		return 0, errors.New("entry is truncated")
+	}
+	shared := int(data[0])
+	if shared > len(e.id) || len(data) < 1+len(e.id)-shared {
+		return 0, errors.New("invalid entry ID")
+	}
+	pos := 1 + copy(e.id[shared:], data[1:])
+
+	var values [4]uint64
+	for i := range values {
+		v, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			return 0, errors.New("invalid entry value")
+		}
+		values[i] = v
+		pos += n
+	}
+	if values[0] > maxuint32 || values[1] > maxuint32 || values[2] > maxuint32 || values[3] > maxuint32 {
+		return 0, errors.New("entry value out of range")
+	}
+
+	e.packIndex = int(values[0])
+	e.offset = uint32(values[1])
+	e.length = uint32(values[2])
+	e.uncompressedLength = uint32(values[3])
+	return pos, nil
+}
+
+// foreach calls fn for all entries in the section, until fn returns false.
+func (s *binarySection) foreach(fn func(*indexEntry) bool) {
+	it := newBinarySectionIterator(s, 0)
+	for it.next() {
+		if !fn(&it.e) {
+			return
+		}
+	}
+}
+
+// foreachWithID calls fn for all entries with the given id.
+func (s *binarySection) foreachWithID(id restic.ID, fn func(*indexEntry)) {
+	// find the first block starting with an ID >= id, the entries for id
+	// may also start at the end of the previous block
+	block := sort.Search(s.numBlocks(), func(i int) bool {
+		return bytes.Compare(s.firstID(i), id[:]) >= 0
+	})
+	if block > 0 {
+		block--
+	}
+
+	it := newBinarySectionIterator(s, block)
+	for it.next() {
+		switch bytes.Compare(it.e.id[:], id[:]) {
+		case 0:
+			fn(&it.e)
+		case 1:
+			return
+		}
+	}
+}
+
+// binarySectionWriter encodes sorted entries into a section.
+type binarySectionWriter struct {
+	binarySection
+	prev    restic.ID
+	entries int
+}
+
+// add appends e to the section. Entries must be added sorted by ID.
+func (w *binarySectionWriter) add(e *indexEntry) {
+	if w.entries%binaryIndexBlockEntries == 0 {
+		w.blocks = append(w.blocks, e.id[:]...)
+		var offset [8]byte
+		binary.LittleEndian.PutUint64(offset[:], uint64(len(w.data)))
+		w.blocks = append(w.blocks, offset[:]...)
+		w.prev = e.id
+	}
+
+	shared := 0
+	for shared < len(e.id) && e.id[shared] == w.prev[shared] {
+		shared++
+	}
+	w.data = append(w.data, byte(shared))
+	w.data = append(w.data, e.id[shared:]...)
+
+	var buf [binary.MaxVarintLen64]byte
+	for _, v := range []uint64{uint64(e.packIndex), uint64(e.offset), uint64(e.length), uint64(e.uncompressedLength)} {
+		n := binary.PutUvarint(buf[:], v)
+		w.data = append(w.data, buf[:n]...)
+	}
+
+	w.prev = e.id
+	w.entries++
+	w.count++
+}
+
+// writeBinaryIndex writes the binary encoding of an index to w.
+func writeBinaryIndex(w io.Writer, supersedes, packs restic.IDs, sections []binarySection) error {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(binaryIndexMagic)
+	buf.WriteByte(binaryIndexVersion)
+
+	var tmp [binary.MaxVarintLen64]byte
+	uvarint := func(v uint64) {
+		n := binary.PutUvarint(tmp[:], v)
+		buf.Write(tmp[:n])
+	}
+
+	for _, ids := range []restic.IDs{supersedes, packs} {
+		uvarint(uint64(len(ids)))
+		for _, id := range ids {
+			buf.Write(id[:])
+		}
+	}
+
+	for _, s := range sections {
+		uvarint(uint64(s.count))
+		uvarint(uint64(s.numBlocks()))
+		buf.Write(s.blocks)
+		uvarint(uint64(len(s.data)))
+		buf.Write(s.data)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// encodeBinary writes the index in the binary format to w.
+func (idx *Index) encodeBinary(w io.Writer) error {
+	debug.Log("encoding binary index")
+
+	// only packs which contain entries are stored, like in the JSON format
+	packIndex := make([]int, len(idx.packs))
+	for i := range packIndex {
+		packIndex[i] = -1
+	}
+	var packs restic.IDs
+
+	sections := make([]binarySection, restic.NumBlobTypes)
+	for typ := range sections {
+		entries := make([]indexEntry, 0, idx.count(restic.BlobType(typ)))
+		idx.foreach(restic.BlobType(typ), func(e *indexEntry) bool {
+			entries = append(entries, *e)
+			return true
+		})
+
+		sort.Slice(entries, func(i, j int) bool {
+			if c := bytes.Compare(entries[i].id[:], entries[j].id[:]); c != 0 {
+				return c < 0
+			}
+			if c := bytes.Compare(idx.packs[entries[i].packIndex][:], idx.packs[entries[j].packIndex][:]); c != 0 {
+				return c < 0
+			}
+			return entries[i].offset < entries[j].offset
+		})
+
+		var sw binarySectionWriter
+		for i := range entries {
+			e := &entries[i]
+			if packIndex[e.packIndex] < 0 {
+				packIndex[e.packIndex] = len(packs)
+				packs = append(packs, idx.packs[e.packIndex])
+			}
+			e.packIndex = packIndex[e.packIndex]
+			sw.add(e)
+		}
+		sections[typ] = sw.binarySection
+	}
+
+	return writeBinaryIndex(w, idx.supersedes, packs, sections)
+}
+
+// binaryReader decodes the fields of a binary index.
+type binaryReader struct {
+	buf []byte
+	err error
+}
+
+func (r *binaryReader) fail(msg string) {
+	if r.err == nil {
+		r.err = errors.New(msg)
+	}
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.fail("invalid varint")
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *binaryReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.buf)) {
+		r.fail("index is truncated")
+		return nil
+	}
+	b := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *binaryReader) ids() restic.IDs {
+	n := r.uvarint()
+	if n > uint64(len(r.buf))/uint64(len(restic.ID{})) {
+		r.fail("too many IDs")
+		return nil
+	}
+	b := r.bytes(n * uint64(len(restic.ID{})))
+	if r.err != nil || n == 0 {
+		return nil
+	}
+
+	ids := make(restic.IDs, n)
+	for i := range ids {
+		copy(ids[i][:], b[i*len(restic.ID{}):])
+	}
+	return ids
+}
+
+// decodeBinaryIndex decodes an index in the binary format. The index keeps a
+// reference to buf. All entries are checked, so that the index can be used
+// afterwards without checking for errors.
+func decodeBinaryIndex(buf []byte, id restic.ID) (*Index, error) {
+	r := &binaryReader{buf: buf}
+	if string(r.bytes(uint64(len(binaryIndexMagic)))) != binaryIndexMagic {
+		return nil, errors.New("invalid binary index magic")
+	}
+	if v := r.bytes(1); r.err == nil && v[0] != binaryIndexVersion {
+		return nil, errors.Errorf("unsupported binary index version %d", v[0])
+	}
+
+	supersedes := r.ids()
+	packs := r.ids()
+
+	bin := &binaryIndex{}
+	for typ := range bin.byType {
+		s := &bin.byType[typ]
+		s.count = uint(r.uvarint())
+		blocks := r.uvarint()
+		if blocks > uint64(len(r.buf))/uint64(binaryIndexBlockSize) {
+			r.fail("too many blocks")
+		}
+		s.blocks = r.bytes(blocks * uint64(binaryIndexBlockSize))
+		s.data = r.bytes(r.uvarint())
+	}
+	if r.err == nil && len(r.buf) != 0 {
+		r.fail("unexpected data at the end of the index")
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// check all entries and find the packs which only contain trees
+	const hasData, hasTree = 1, 2
+	packTypes := make([]byte, len(packs))
+	for typ := range bin.byType {
+		s := &bin.byType[typ]
+		if err := s.checkBlocks(); err != nil {
+			return nil, err
+		}
+
+		var count uint
+		var prev restic.ID
+		it := newBinarySectionIterator(s, 0)
+		for it.next() {
+			if it.first && !bytes.Equal(it.e.id[:], s.firstID(it.block)) {
+				return nil, errors.New("first ID of block does not match")
+			}
+			if it.e.packIndex >= len(packs) {
+				return nil, errors.New("invalid pack index")
+			}
+			if bytes.Compare(prev[:], it.e.id[:]) > 0 {
+				return nil, errors.New("entries are not sorted")
+			}
+			prev = it.e.id
+			count++
+
+			switch restic.BlobType(typ) {
+			case restic.DataBlob:
+				packTypes[it.e.packIndex] |= hasData
+			case restic.TreeBlob:
+				packTypes[it.e.packIndex] |= hasTree
+			}
+		}
+		if it.err != nil {
+			return nil, it.err
+		}
+		if count != s.count {
+			return nil, errors.New("wrong number of entries")
+		}
+	}
+
+	idx := NewIndex()
+	idx.bin = bin
+	idx.packs = packs
+	for i, t := range packTypes {
+		if t == hasTree {
+			idx.treePacks = append(idx.treePacks, packs[i])
+		}
+	}
+	idx.supersedes = supersedes
+	idx.ids = append(idx.ids, id)
+	idx.final = true
+	idx.packIDToIndex = nil
+	return idx, nil
+}
+
+// binaryMergeHeap orders section iterators by the ID of their current entry.
+type binaryMergeHeap []*binaryMergeIterator
+
+type binaryMergeIterator struct {
+	*binarySectionIterator
+	packBase int
+}
+
+func (h binaryMergeHeap) Len() int { return len(h) }
+func (h binaryMergeHeap) Less(i, j int) bool {
+	return bytes.Compare(h[i].e.id[:], h[j].e.id[:]) < 0
+}
+func (h binaryMergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *binaryMergeHeap) Push(x interface{}) { *h = append(*h, x.(*binaryMergeIterator)) }
+func (h *binaryMergeHeap) Pop() interface{} {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
+
+// mergeBinaryIndexes merges final indexes in the binary format into a single
+// index in the binary format. Exact duplicates are removed.
+func mergeBinaryIndexes(indexes []*Index) *Index {
+	merged := NewIndex()
+	merged.bin = &binaryIndex{}
+	merged.final = true
+	merged.packIDToIndex = nil
+
+	packBase := make([]int, len(indexes))
+	for i, idx := range indexes {
+		packBase[i] = len(merged.packs)
+		merged.packs = append(merged.packs, idx.packs...)
+		merged.treePacks = append(merged.treePacks, idx.treePacks...)
+		merged.ids = append(merged.ids, idx.ids...)
+		merged.supersedes = append(merged.supersedes, idx.supersedes...)
+	}
+
+	for typ := range merged.bin.byType {
+		h := make(binaryMergeHeap, 0, len(indexes))
+		for i, idx := range indexes {
+			it := &binaryMergeIterator{newBinarySectionIterator(&idx.bin.byType[typ], 0), packBase[i]}
+			if it.next() {
+				h = append(h, it)
+			}
+		}
+		heap.Init(&h)
+
+		var sw binarySectionWriter
+		// entries written for the current ID, used to detect duplicates
+		var current []indexEntry
+		for len(h) > 0 {
+			it := h[0]
+			e := it.e
+			e.packIndex += it.packBase
+
+			if len(current) > 0 && current[0].id != e.id {
+				current = current[:0]
+			}
+
+			duplicate := false
+			for _, c := range current {
+				if merged.packs[c.packIndex] == merged.packs[e.packIndex] && c.offset == e.offset &&
+					c.length == e.length && c.uncompressedLength == e.uncompressedLength {
+					duplicate = true
+					break
+				}
+			}
+			if !duplicate {
+				sw.add(&e)
+				current = append(current, e)
+			}
+
+			if it.next() {
+				heap.Fix(&h, 0)
+			} else {
+				heap.Pop(&h)
+			}
+		}
+		merged.bin.byType[typ] = sw.binarySection
+	}
+
+	return merged
+}
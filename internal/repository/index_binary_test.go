@@ -0,0 +1,229 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// createMixedIndex returns an index with data and tree packs, which also
+// contains a blob stored in two packs.
+func createMixedIndex(rng *rand.Rand, packfiles int) (*repository.Index, []restic.PackedBlob) {
+	idx := repository.NewIndex()
+	var blobs []restic.PackedBlob
+
+	for i := 0; i < packfiles; i++ {
+		packID := NewRandomTestID(rng)
+		tpe := restic.DataBlob
+		if i%3 == 0 {
+			tpe = restic.TreeBlob
+		}
+
+		var packBlobs []restic.Blob
+		offset := uint(0)
+		for j := 0; j < 1+rng.Intn(100); j++ {
+			blob := restic.Blob{
+				BlobHandle:         restic.BlobHandle{Type: tpe, ID: NewRandomTestID(rng)},
+				Length:             uint(100 + rng.Intn(100000)),
+				Offset:             offset,
+				UncompressedLength: uint(rng.Intn(2) * rng.Intn(200000)),
+			}
+			if i > 0 && j == 0 {
+				// duplicate of a blob in the previous pack
+				blob.BlobHandle = blobs[len(blobs)-1].BlobHandle
+			}
+			offset += blob.Length
+			packBlobs = append(packBlobs, blob)
+		}
+
+		idx.StorePack(packID, packBlobs)
+		for _, blob := range packBlobs {
+			blobs = append(blobs, restic.PackedBlob{Blob: blob, PackID: packID})
+		}
+	}
+
+	return idx, blobs
+}
+
+func encodeBinaryIndex(t testing.TB, idx *repository.Index) []byte {
+	buf := bytes.NewBuffer(nil)
+	rtest.OK(t, idx.EncodeBinary(buf))
+	return buf.Bytes()
+}
+
+func sortPackedBlobs(pbs []restic.PackedBlob) {
+	sort.Slice(pbs, func(i, j int) bool {
+		if pbs[i].PackID != pbs[j].PackID {
+			return pbs[i].PackID.String() < pbs[j].PackID.String()
+		}
+		return pbs[i].Offset < pbs[j].Offset
+	})
+}
+
+func sortedIDs(ids restic.IDs) restic.IDs {
+	ids = append(restic.IDs(nil), ids...)
+	sort.Sort(ids)
+	return ids
+}
+
+func TestIndexBinarySerialize(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	idx, blobs := createMixedIndex(rng, 100)
+	supersedes := restic.IDs{restic.NewRandomID(), restic.NewRandomID()}
+	rtest.OK(t, idx.AddToSupersedes(supersedes...))
+
+	id := restic.NewRandomID()
+	buf := encodeBinaryIndex(t, idx)
+	idx2, oldFormat, err := repository.DecodeIndex(buf, id)
+	rtest.OK(t, err)
+	rtest.Assert(t, !oldFormat, "binary index detected as old format")
+
+	// the decoded index must not depend on the buffer
+	for i := range buf {
+		buf[i] = 0
+	}
+
+	ids, err := idx2.IDs()
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.IDs{id}, ids)
+	rtest.Equals(t, supersedes, idx2.Supersedes())
+	rtest.Assert(t, idx.Packs().Equals(idx2.Packs()), "packs do not match")
+	rtest.Equals(t, idx.Count(restic.DataBlob), idx2.Count(restic.DataBlob))
+	rtest.Equals(t, idx.Count(restic.TreeBlob), idx2.Count(restic.TreeBlob))
+
+	// compare with the tree packs determined from the JSON format
+	jsonBuf := bytes.NewBuffer(nil)
+	rtest.OK(t, idx.Encode(jsonBuf))
+	idx3, _, err := repository.DecodeIndex(jsonBuf.Bytes(), id)
+	rtest.OK(t, err)
+	rtest.Equals(t, sortedIDs(idx3.TreePacks()), sortedIDs(idx2.TreePacks()))
+
+	for _, pb := range blobs {
+		expected := idx.Lookup(pb.BlobHandle, nil)
+		found := idx2.Lookup(pb.BlobHandle, nil)
+		sortPackedBlobs(expected)
+		sortPackedBlobs(found)
+		rtest.Equals(t, expected, found)
+		rtest.Assert(t, idx2.Has(pb.BlobHandle), "blob %v not found", pb.BlobHandle)
+
+		size, ok := idx2.LookupSize(pb.BlobHandle)
+		rtest.Assert(t, ok, "size of blob %v not found", pb.BlobHandle)
+		expectedSize, _ := idx.LookupSize(pb.BlobHandle)
+		if len(expected) == 1 {
+			rtest.Equals(t, expectedSize, size)
+		}
+
+		expected = idx.ListPack(pb.PackID)
+		found = idx2.ListPack(pb.PackID)
+		sortPackedBlobs(expected)
+		sortPackedBlobs(found)
+		rtest.Equals(t, expected, found)
+	}
+
+	var all []restic.PackedBlob
+	for pb := range idx2.Each(context.TODO()) {
+		all = append(all, pb)
+	}
+	sortPackedBlobs(all)
+	sortPackedBlobs(blobs)
+	rtest.Equals(t, blobs, all)
+
+	unknown := restic.NewRandomBlobHandle()
+	rtest.Assert(t, !idx2.Has(unknown), "unknown blob found")
+	rtest.Assert(t, idx2.Lookup(unknown, nil) == nil, "lookup of unknown blob returned entries")
+
+	// encoding a decoded index returns the same data
+	rtest.Equals(t, encodeBinaryIndex(t, idx), encodeBinaryIndex(t, idx2))
+}
+
+func TestIndexBinaryCorrupt(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	idx, _ := createMixedIndex(rng, 5)
+	buf := encodeBinaryIndex(t, idx)
+
+	for i := 5; i < len(buf); i++ {
+		_, _, err := repository.DecodeIndex(buf[:i], restic.NewRandomID())
+		rtest.Assert(t, err != nil, "truncated index with %d bytes decoded without error", i)
+	}
+
+	// modified data must not lead to a panic
+	for i := 5; i < len(buf); i++ {
+		modified := append([]byte(nil), buf...)
+		modified[i] ^= 0xff
+		idx2, _, err := repository.DecodeIndex(modified, restic.NewRandomID())
+		if err == nil {
+			for range idx2.Each(context.TODO()) {
+			}
+		}
+	}
+}
+
+func TestIndexBinaryMerge(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	mIdx := repository.NewMasterIndex()
+
+	var blobs []restic.PackedBlob
+	var ids restic.IDs
+	for i := 0; i < 3; i++ {
+		idx, idxBlobs := createMixedIndex(rng, 20)
+		if i == 2 {
+			// an index which duplicates all entries of the first one
+			idx = repository.NewIndex()
+			for _, pb := range blobs[:len(blobs)/2] {
+				idx.Store(pb)
+			}
+			idxBlobs = nil
+		}
+		blobs = append(blobs, idxBlobs...)
+
+		id := restic.NewRandomID()
+		ids = append(ids, id)
+		idx2, _, err := repository.DecodeIndex(encodeBinaryIndex(t, idx), id)
+		rtest.OK(t, err)
+		mIdx.Insert(idx2)
+	}
+
+	// an index in the JSON format is merged into the first index
+	jsonIdx := repository.NewIndex()
+	jsonBlob := restic.PackedBlob{
+		PackID: restic.NewRandomID(),
+		Blob:   restic.Blob{BlobHandle: restic.NewRandomBlobHandle(), Length: 42},
+	}
+	jsonIdx.Store(jsonBlob)
+	jsonIdx.Finalize()
+	mIdx.Insert(jsonIdx)
+
+	rtest.OK(t, mIdx.MergeFinalIndexes())
+	rtest.Equals(t, 2, len(mIdx.All()))
+
+	merged := mIdx.All()[1]
+	mergedIDs, err := merged.IDs()
+	rtest.OK(t, err)
+	rtest.Equals(t, ids, mergedIDs)
+
+	for _, pb := range blobs {
+		expected := []restic.PackedBlob{}
+		for _, other := range blobs {
+			if other.BlobHandle == pb.BlobHandle {
+				expected = append(expected, other)
+			}
+		}
+		found := mIdx.Lookup(pb.BlobHandle)
+		sortPackedBlobs(expected)
+		sortPackedBlobs(found)
+		rtest.Equals(t, expected, found)
+	}
+	rtest.Equals(t, []restic.PackedBlob{jsonBlob}, mIdx.Lookup(jsonBlob.BlobHandle))
+
+	count := 0
+	for range mIdx.Each(context.TODO()) {
+		count++
+	}
+	rtest.Equals(t, len(blobs)+1, count)
+}
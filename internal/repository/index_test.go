@@ -23,11 +23,17 @@ func TestIndexSerialize(t *testing.T) {
 		pos := uint(0)
 		for j := 0; j < 20; j++ {
 			length := uint(i*100 + j)
+			uncompressedLength := uint(0)
+			if i >= 25 {
+				// test a mix of compressed and uncompressed packs
+				uncompressedLength = 2 * length
+			}
 			pb := restic.PackedBlob{
 				Blob: restic.Blob{
-					BlobHandle: restic.NewRandomBlobHandle(),
-					Offset:     pos,
-					Length:     length,
+					BlobHandle:         restic.NewRandomBlobHandle(),
+					Offset:             pos,
+					Length:             length,
+					UncompressedLength: uncompressedLength,
 				},
 				PackID: packID,
 			}
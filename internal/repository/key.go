@@ -24,28 +24,78 @@ var (
 	ErrMaxKeysReached = errors.Fatal("maximum number of keys reached")
 )
 
+// KeyTypeWriteOnly is the type of keys which can only add data to the
+// repository.
+const KeyTypeWriteOnly = "write-only"
+
 // Key represents an encrypted master key for a repository.
 type Key struct {
 	Created  time.Time `json:"created"`
 	Username string    `json:"username"`
 	Hostname string    `json:"hostname"`
 
-	KDF  string `json:"kdf"`
-	N    int    `json:"N"`
-	R    int    `json:"r"`
-	P    int    `json:"p"`
+	// Type is empty for keys which contain the master key.
+	Type string `json:"type,omitempty"`
+
+	// Capabilities restricts the commands which can be run with the key, an
+	// empty list grants all capabilities. A copy is stored in the encrypted
+	// data, so that the list cannot be modified without the password.
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	KDF string `json:"kdf"`
+
+	// parameters for scrypt
+	N int `json:"N,omitempty"`
+	R int `json:"r,omitempty"`
+	P int `json:"p,omitempty"`
+
+	// parameters for argon2id
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+
 	Salt []byte `json:"salt"`
 	Data []byte `json:"data"`
 
 	user   *crypto.Key
 	master *crypto.Key
+	config *restic.Config
+
+	writeOnly *writeOnlyKey
 
 	name string
 }
 
-// Params tracks the parameters used for the KDF. If not set, it will be
-// calibrated on the first run of AddKey().
-var Params *crypto.Params
+// writeOnlyKey is stored encrypted in the Data field of write-only keys
+// instead of the master key. It contains the public key to which new data is
+// sealed, the metadata key for reading the index and a copy of the config,
+// which cannot be decrypted without the master key.
+type writeOnlyKey struct {
+	PublicKey    []byte        `json:"public_key"`
+	Metadata     *crypto.Key   `json:"metadata"`
+	Config       restic.Config `json:"config"`
+	Capabilities []string      `json:"capabilities,omitempty"`
+}
+
+// masterKeyData is stored encrypted in the Data field of keys which contain
+// the master key. Older versions of restic ignore the capabilities.
+//
+// Config is only set for the key of a master key rotation, see
+// AddRotationKey.
+type masterKeyData struct {
+	*crypto.Key
+	Capabilities []string       `json:"capabilities,omitempty"`
+	Config       *restic.Config `json:"config,omitempty"`
+}
+
+// valid tests whether the write-only key is complete.
+func (w *writeOnlyKey) valid() bool {
+	return len(w.PublicKey) == crypto.PublicKeySize && w.Metadata != nil && w.Metadata.Valid()
+}
+
+// Params tracks the parameters used for each KDF. If not set, they will be
+// calibrated on the first run of AddKey() with the KDF.
+var Params = make(map[string]crypto.Params)
 
 var (
 	// KDFTimeout specifies the maximum runtime for the KDF.
@@ -58,7 +108,7 @@ var (
 // createMasterKey creates a new master key in the given backend and encrypts
 // it with the password.
 func createMasterKey(ctx context.Context, s *Repository, password string) (*Key, error) {
-	return AddKey(ctx, s, password, "", "", nil)
+	return AddKey(ctx, s, password, "", "", crypto.DefaultKDF, nil, nil)
 }
 
 // OpenKey tries do decrypt the key specified by name with the given password.
@@ -70,17 +120,12 @@ func OpenKey(ctx context.Context, s *Repository, name string, password string) (
 	}
 
 	// check KDF
-	if k.KDF != "scrypt" {
-		return nil, errors.New("only supported KDF is scrypt()")
+	if err := crypto.ValidKDF(k.KDF); err != nil {
+		return nil, err
 	}
 
 	// derive user key
-	params := crypto.Params{
-		N: k.N,
-		R: k.R,
-		P: k.P,
-	}
-	k.user, err = crypto.KDF(params, k.Salt, password)
+	k.user, err = crypto.KDF(k.Params(), k.Salt, password)
 	if err != nil {
 		return nil, errors.Wrap(err, "crypto.KDF")
 	}
@@ -93,14 +138,31 @@ func OpenKey(ctx context.Context, s *Repository, name string, password string) (
 	}
 
 	// restore json
-	k.master = &crypto.Key{}
-	err = json.Unmarshal(buf, k.master)
+	var capabilities []string
+	switch k.Type {
+	case "":
+		data := masterKeyData{Key: &crypto.Key{}}
+		err = json.Unmarshal(buf, &data)
+		k.master = data.Key
+		k.config = data.Config
+		capabilities = data.Capabilities
+	case KeyTypeWriteOnly:
+		k.writeOnly = &writeOnlyKey{}
+		err = json.Unmarshal(buf, k.writeOnly)
+		capabilities = k.writeOnly.Capabilities
+	default:
+		return nil, errors.Fatalf("key %v has unsupported type %q", name, k.Type)
+	}
 	if err != nil {
 		debug.Log("Unmarshal() returned error %v", err)
 		return nil, errors.Wrap(err, "Unmarshal")
 	}
 	k.name = name
 
+	if !equalCapabilities(k.Capabilities, capabilities) {
+		return nil, errors.Fatalf("the capabilities of key %v have been modified", shortKeyName(name))
+	}
+
 	if !k.Valid() {
 		return nil, errors.New("Invalid key for repository")
 	}
@@ -120,6 +182,9 @@ func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int,
 
 		if err == nil {
 			key, err := OpenKey(ctx, s, id, password)
+			if err == nil {
+				err = checkConfigKey(ctx, s, key)
+			}
 
 			if err == nil {
 				debug.Log("successfully opened hinted key %v", id)
@@ -135,6 +200,9 @@ func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int,
 	listCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	// error returned if the config is missing and no key could restore it
+	var configErr error
+
 	// try at most maxKeys keys in repo
 	err = s.Backend().List(listCtx, restic.KeyFile, func(fi restic.FileInfo) error {
 		if maxKeys > 0 && checked > maxKeys {
@@ -149,6 +217,9 @@ func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int,
 
 		debug.Log("trying key %q", fi.Name)
 		key, err := OpenKey(ctx, s, fi.Name, password)
+		if err == nil {
+			err = checkConfigKey(ctx, s, key)
+		}
 		if err != nil {
 			debug.Log("key %v returned error %v", fi.Name, err)
 
@@ -157,6 +228,13 @@ func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int,
 				return nil
 			}
 
+			// the config may be restored by the key of an interrupted
+			// master key rotation
+			if s.Backend().IsNotExist(err) {
+				configErr = err
+				return nil
+			}
+
 			return err
 		}
 
@@ -174,6 +252,10 @@ func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int,
 		return nil, err
 	}
 
+	if k == nil && configErr != nil {
+		return nil, errors.Wrap(configErr, "load config")
+	}
+
 	if k == nil {
 		return nil, ErrNoKeyFound
 	}
@@ -181,6 +263,81 @@ func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int,
 	return k, nil
 }
 
+// checkConfigKey returns crypto.ErrUnauthenticated if the master key of k
+// cannot decrypt the config. This is the case for the key of the new master
+// key while a master key rotation is in progress, and for the old keys once
+// the config has been replaced. If the config is missing because a rotation
+// was interrupted while replacing it, it is restored from the copy in the key
+// of the new master key, or from a migration checkpoint if a migration was
+// interrupted while adding features to the config. Write-only keys cannot
+// decrypt the config and are not checked.
+func checkConfigKey(ctx context.Context, s *Repository, k *Key) error {
+	if k.WriteOnly() {
+		return nil
+	}
+
+	r := s.WithKey(k.master)
+	if k.config != nil {
+		found, err := s.Backend().Test(ctx, restic.Handle{Type: restic.ConfigFile})
+		if err != nil {
+			return err
+		}
+		if !found {
+			debug.Log("config is missing, restoring it from key %v", k.Name())
+			_, err = r.SaveJSONUnpacked(ctx, restic.ConfigFile, *k.config)
+			return err
+		}
+	}
+
+	_, err := r.LoadAndDecrypt(ctx, nil, restic.ConfigFile, restic.ID{})
+	if err != nil && s.Backend().IsNotExist(err) {
+		cfg, cerr := loadCheckpointConfig(ctx, r)
+		if cerr != nil {
+			return cerr
+		}
+		if cfg != nil {
+			debug.Log("config is missing, restoring it from a migration checkpoint")
+			_, err = r.SaveJSONUnpacked(ctx, restic.ConfigFile, *cfg)
+		}
+	}
+	return err
+}
+
+// loadCheckpointConfig returns the config stored in the newest migration
+// checkpoint of r which contains one, or nil if there is none. A migration
+// stores the new config in its checkpoint before it replaces the config.
+// Checkpoints encrypted with a different master key are skipped.
+func loadCheckpointConfig(ctx context.Context, r *Repository) (*restic.Config, error) {
+	// the repository version is unknown without the config, the checkpoints
+	// may be compressed
+	r = r.WithKey(r.key)
+	r.setConfig(restic.Config{Version: restic.MaxRepoVersion})
+
+	var (
+		cfg    *restic.Config
+		cfgAge time.Time
+	)
+	err := r.List(ctx, restic.MigrationFile, func(id restic.ID, size int64) error {
+		var cp struct {
+			Time   time.Time      `json:"time"`
+			Config *restic.Config `json:"config"`
+		}
+		err := r.LoadJSONUnpacked(ctx, restic.MigrationFile, id, &cp)
+		if errors.Cause(err) == crypto.ErrUnauthenticated {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "load checkpoint %v", id.Str())
+		}
+
+		if cp.Config != nil && (cfg == nil || cp.Time.After(cfgAge)) {
+			cfg, cfgAge = cp.Config, cp.Time
+		}
+		return nil
+	})
+	return cfg, err
+}
+
 // LoadKey loads a key from the backend.
 func LoadKey(ctx context.Context, s *Repository, name string) (k *Key, err error) {
 	h := restic.Handle{Type: restic.KeyFile, Name: name}
@@ -198,16 +355,119 @@ func LoadKey(ctx context.Context, s *Repository, name string) (k *Key, err error
 	return k, nil
 }
 
-// AddKey adds a new key to an already existing repository.
-func AddKey(ctx context.Context, s *Repository, password, username, hostname string, template *crypto.Key) (*Key, error) {
+// AddKey adds a new key to an already existing repository. The user key is
+// derived from the password with the key derivation function kdf. The key is
+// restricted to capabilities, unless the list is empty.
+func AddKey(ctx context.Context, s *Repository, password, username, hostname, kdf string, template *crypto.Key, capabilities []string) (*Key, error) {
+	newkey, err := newKey(password, username, hostname, kdf, capabilities)
+	if err != nil {
+		return nil, err
+	}
+
+	if template == nil {
+		// generate new random master keys
+		newkey.master = crypto.NewRandomKey()
+	} else {
+		// copy master keys from old key
+		newkey.master = template
+	}
+
+	err = saveMasterKey(ctx, s, newkey, masterKeyData{Key: newkey.master, Capabilities: capabilities})
+	if err != nil {
+		return nil, err
+	}
+
+	return newkey, nil
+}
+
+// AddRotationKey adds a key for master, the new master key of a master key
+// rotation. The key contains a copy of cfg: the config cannot be overwritten,
+// it must be removed before it is saved again encrypted with master. If this
+// is interrupted, the config is restored from the copy when the repository is
+// opened with the key. Until then, the key cannot be used to open the
+// repository.
+func AddRotationKey(ctx context.Context, s *Repository, password, username, hostname, kdf string, master *crypto.Key, capabilities []string, cfg restic.Config) (*Key, error) {
+	newkey, err := newKey(password, username, hostname, kdf, capabilities)
+	if err != nil {
+		return nil, err
+	}
+
+	newkey.master = master
+	newkey.config = &cfg
+	err = saveMasterKey(ctx, s, newkey, masterKeyData{Key: master, Capabilities: capabilities, Config: &cfg})
+	if err != nil {
+		return nil, err
+	}
+
+	return newkey, nil
+}
+
+// saveMasterKey encrypts data with the user key and saves newkey.
+func saveMasterKey(ctx context.Context, s *Repository, newkey *Key, data masterKeyData) error {
+	// encrypt master keys (as json) with user key
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+
+	return saveKey(ctx, s, newkey, buf)
+}
+
+// AddWriteOnlyKey adds a new write-only key to the repository. It can only be
+// called with a repository which has been opened with a key for the master
+// key and which supports write-only keys.
+func AddWriteOnlyKey(ctx context.Context, s *Repository, password, username, hostname, kdf string, capabilities []string) (*Key, error) {
+	if s.WriteOnly() {
+		return nil, errors.Fatal("write-only keys cannot add new keys")
+	}
+
+	if !s.Config().HasFeature(restic.FeatureWriteOnlyKeys) {
+		return nil, errors.Fatal("repository does not support write-only keys, run `restic migrate write_only_keys` first")
+	}
+
+	newkey, err := newKey(password, username, hostname, kdf, capabilities)
+	if err != nil {
+		return nil, err
+	}
+
+	pub := s.Key().PublicKey()
+	newkey.Type = KeyTypeWriteOnly
+	newkey.writeOnly = &writeOnlyKey{
+		PublicKey:    pub[:],
+		Metadata:     s.Key().MetadataKey(),
+		Config:       s.Config(),
+		Capabilities: capabilities,
+	}
+
+	buf, err := json.Marshal(newkey.writeOnly)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+
+	err = saveKey(ctx, s, newkey, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return newkey, nil
+}
+
+// newKey returns a new key with the user key derived from password.
+func newKey(password, username, hostname, kdf string, capabilities []string) (*Key, error) {
+	if err := crypto.ValidKDF(kdf); err != nil {
+		return nil, err
+	}
+
 	// make sure we have valid KDF parameters
-	if Params == nil {
-		p, err := crypto.Calibrate(KDFTimeout, KDFMemory)
+	params, ok := Params[kdf]
+	if !ok {
+		p, err := crypto.Calibrate(kdf, KDFTimeout, KDFMemory)
 		if err != nil {
 			return nil, errors.Wrap(err, "Calibrate")
 		}
 
-		Params = &p
+		params = p
+		Params[kdf] = p
 		debug.Log("calibrated KDF parameters are %v", p)
 	}
 
@@ -217,10 +477,15 @@ func AddKey(ctx context.Context, s *Repository, password, username, hostname str
 		Username: username,
 		Hostname: hostname,
 
-		KDF: "scrypt",
-		N:   Params.N,
-		R:   Params.R,
-		P:   Params.P,
+		Capabilities: capabilities,
+
+		KDF:     kdf,
+		N:       params.N,
+		R:       params.R,
+		P:       params.P,
+		Time:    params.Time,
+		Memory:  params.Memory,
+		Threads: params.Threads,
 	}
 
 	if newkey.Hostname == "" {
@@ -242,35 +507,27 @@ func AddKey(ctx context.Context, s *Repository, password, username, hostname str
 	}
 
 	// call KDF to derive user key
-	newkey.user, err = crypto.KDF(*Params, newkey.Salt, password)
+	newkey.user, err = crypto.KDF(params, newkey.Salt, password)
 	if err != nil {
 		return nil, err
 	}
 
-	if template == nil {
-		// generate new random master keys
-		newkey.master = crypto.NewRandomKey()
-	} else {
-		// copy master keys from old key
-		newkey.master = template
-	}
-
-	// encrypt master keys (as json) with user key
-	buf, err := json.Marshal(newkey.master)
-	if err != nil {
-		return nil, errors.Wrap(err, "Marshal")
-	}
+	return newkey, nil
+}
 
+// saveKey encrypts data with the user key of newkey and stores the key in the
+// repository.
+func saveKey(ctx context.Context, s *Repository, newkey *Key, data []byte) error {
 	nonce := crypto.NewRandomNonce()
-	ciphertext := make([]byte, 0, len(buf)+newkey.user.Overhead()+newkey.user.NonceSize())
+	ciphertext := make([]byte, 0, len(data)+newkey.user.Overhead()+newkey.user.NonceSize())
 	ciphertext = append(ciphertext, nonce...)
-	ciphertext = newkey.user.Seal(ciphertext, nonce, buf, nil)
+	ciphertext = newkey.user.Seal(ciphertext, nonce, data, nil)
 	newkey.Data = ciphertext
 
 	// dump as json
-	buf, err = json.Marshal(newkey)
+	buf, err := json.Marshal(newkey)
 	if err != nil {
-		return nil, errors.Wrap(err, "Marshal")
+		return errors.Wrap(err, "Marshal")
 	}
 
 	// store in repository and return
@@ -281,12 +538,11 @@ func AddKey(ctx context.Context, s *Repository, password, username, hostname str
 
 	err = s.be.Save(ctx, h, restic.NewByteReader(buf))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	newkey.name = h.Name
-
-	return newkey, nil
+	return nil
 }
 
 func (k *Key) String() string {
@@ -296,6 +552,19 @@ func (k *Key) String() string {
 	return fmt.Sprintf("<Key of %s@%s, created on %s>", k.Username, k.Hostname, k.Created)
 }
 
+// Params returns the parameters for the key derivation function of the key.
+func (k *Key) Params() crypto.Params {
+	return crypto.Params{
+		KDF:     k.KDF,
+		N:       k.N,
+		R:       k.R,
+		P:       k.P,
+		Time:    k.Time,
+		Memory:  k.Memory,
+		Threads: k.Threads,
+	}
+}
+
 // Name returns an identifier for the key.
 func (k Key) Name() string {
 	return k.name
@@ -303,5 +572,18 @@ func (k Key) Name() string {
 
 // Valid tests whether the mac and encryption keys are valid (i.e. not zero)
 func (k *Key) Valid() bool {
+	if k.writeOnly != nil {
+		return k.user.Valid() && k.writeOnly.valid()
+	}
 	return k.user.Valid() && k.master.Valid()
 }
+
+// Master returns the master key, or nil for write-only keys.
+func (k *Key) Master() *crypto.Key {
+	return k.master
+}
+
+// WriteOnly returns true if the key can only add data to the repository.
+func (k *Key) WriteOnly() bool {
+	return k.writeOnly != nil
+}
@@ -0,0 +1,74 @@
+package repository_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	resticbackend "github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestKeyCapabilities(t *testing.T) {
+	be, cleanup := repository.TestBackend(t)
+	defer cleanup()
+	repository.TestUseLowSecurityKDFParameters(t)
+
+	repo := repository.New(be, repository.Options{})
+	rtest.OK(t, repo.Init(context.TODO(), restic.StableRepoVersion, rtest.TestPassword, nil))
+	rtest.OK(t, repo.CheckCapabilities(repository.AllCapabilities...))
+
+	caps := []string{repository.CapabilityAppend, repository.CapabilityRead}
+	key, err := repository.AddKey(context.TODO(), repo, "restricted", "", "", crypto.DefaultKDF, repo.Key(), caps)
+	rtest.OK(t, err)
+
+	repo = repository.New(be, repository.Options{})
+	rtest.OK(t, repo.SearchKey(context.TODO(), "restricted", 0, ""))
+	rtest.Equals(t, caps, repo.Capabilities())
+	rtest.OK(t, repo.CheckCapabilities(repository.CapabilityRead))
+	rtest.Assert(t, repo.CheckCapabilities(repository.CapabilityRead, repository.CapabilityPrune) != nil,
+		"key without prune capability passed the check")
+
+	// remove the capabilities from the key file
+	h := restic.Handle{Type: restic.KeyFile, Name: key.Name()}
+	buf, err := resticbackend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	var raw map[string]interface{}
+	rtest.OK(t, json.Unmarshal(buf, &raw))
+	delete(raw, "capabilities")
+	buf, err = json.Marshal(raw)
+	rtest.OK(t, err)
+	rtest.OK(t, be.Remove(context.TODO(), h))
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(buf)))
+
+	_, err = repository.OpenKey(context.TODO(), repo, key.Name(), "restricted")
+	rtest.Assert(t, err != nil, "opening a key with modified capabilities did not fail")
+}
+
+func TestParseCapabilities(t *testing.T) {
+	var tests = []struct {
+		s    string
+		caps []string
+		ok   bool
+	}{
+		{"all", nil, true},
+		{"read", []string{"read"}, true},
+		{"read,append,read", []string{"append", "read"}, true},
+		{"prune, key-admin", []string{"key-admin", "prune"}, true},
+		{"write", nil, false},
+		{"", nil, false},
+	}
+
+	for _, test := range tests {
+		caps, err := repository.ParseCapabilities(test.s)
+		if !test.ok {
+			rtest.Assert(t, err != nil, "expected error for %q", test.s)
+			continue
+		}
+		rtest.OK(t, err)
+		rtest.Equals(t, test.caps, caps)
+	}
+}
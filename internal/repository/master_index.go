@@ -134,7 +134,7 @@ func (mi *MasterIndex) PackSize(ctx context.Context, onlyHdr bool) map[restic.ID
 		if !onlyHdr {
 			size += int64(blob.Length)
 		}
-		packSize[blob.PackID] = size + int64(pack.EntrySize)
+		packSize[blob.PackID] = size + int64(pack.CalculateEntrySize(blob.Blob))
 	}
 
 	return packSize
@@ -276,21 +276,34 @@ func (mi *MasterIndex) MergeFinalIndexes() error {
 	mi.idxMutex.Lock()
 	defer mi.idxMutex.Unlock()
 
-	// The first index is always final and the one to merge into
+	// The first index is always final and the one to merge into. Indexes in
+	// the binary format are merged separately, so that they are not decoded.
 	newIdx := mi.idx[:1]
+	var binIdx []*Index
 	for i := 1; i < len(mi.idx); i++ {
 		idx := mi.idx[i]
 		// clear reference in masterindex as it may become stale
 		mi.idx[i] = nil
-		if !idx.Final() {
+		switch {
+		case !idx.Final():
 			newIdx = append(newIdx, idx)
-		} else {
+		case idx.bin != nil:
+			binIdx = append(binIdx, idx)
+		default:
 			err := mi.idx[0].merge(idx)
 			if err != nil {
 				return fmt.Errorf("MergeFinalIndexes: %w", err)
 			}
 		}
 	}
+
+	switch len(binIdx) {
+	case 0:
+	case 1:
+		newIdx = append(newIdx, binIdx[0])
+	default:
+		newIdx = append(newIdx, mergeBinaryIndexes(binIdx))
+	}
 	mi.idx = newIdx
 
 	return nil
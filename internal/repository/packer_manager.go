@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"io/ioutil"
 	"os"
 	"sync"
 
@@ -31,23 +32,39 @@ type Packer struct {
 
 // packerManager keeps a list of open packs and creates new on demand.
 type packerManager struct {
-	be      Saver
-	key     *crypto.Key
-	pm      sync.Mutex
-	packers []*Packer
+	be       Saver
+	key      *crypto.Key
+	packSize uint
+	pm       sync.Mutex
+	packers  []*Packer
 }
 
-const minPackSize = 4 * 1024 * 1024
+const (
+	// MinPackSize is the smallest target size for pack files.
+	MinPackSize = 4 * 1024 * 1024
+	// MaxPackSize is the largest target size for pack files.
+	MaxPackSize = 128 * 1024 * 1024
+	// DefaultPackSize is used if neither the options nor the repository
+	// config specify a pack size.
+	DefaultPackSize = MinPackSize
+)
 
 // newPackerManager returns an new packer manager which writes temporary files
-// to a temporary directory
-func newPackerManager(be Saver, key *crypto.Key) *packerManager {
+// to a temporary directory. Packs are saved once they reach packSize bytes.
+func newPackerManager(be Saver, key *crypto.Key, packSize uint) *packerManager {
 	return &packerManager{
-		be:  be,
-		key: key,
+		be:       be,
+		key:      key,
+		packSize: packSize,
 	}
 }
 
+// isFull returns true if p has reached the target pack size or cannot hold
+// any more blobs, so that it should be saved.
+func (r *packerManager) isFull(p *Packer) bool {
+	return p.Size() >= r.packSize || p.HeaderFull()
+}
+
 // findPacker returns a packer for a new blob of size bytes. Either a new one is
 // created or one is returned that already has some blobs.
 func (r *packerManager) findPacker() (packer *Packer, err error) {
@@ -129,6 +146,23 @@ func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packe
 		}
 	}
 
+	if r.parity != nil {
+		_, err = p.tmpfile.Seek(0, 0)
+		if err != nil {
+			return errors.Wrap(err, "Seek")
+		}
+
+		buf, err := ioutil.ReadAll(p.tmpfile)
+		if err != nil {
+			return errors.Wrap(err, "ReadAll")
+		}
+
+		err = r.parity.add(ctx, r, id, buf)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = p.tmpfile.Close()
 	if err != nil {
 		return errors.Wrap(err, "close tempfile")
@@ -70,7 +70,7 @@ func fillPacks(t testing.TB, rnd *rand.Rand, be Saver, pm *packerManager, buf []
 		// Only change a few bytes so we know we're not benchmarking the RNG.
 		rnd.Read(buf[:min(l, 4)])
 
-		n, err := packer.Add(restic.DataBlob, id, buf)
+		n, err := packer.Add(restic.DataBlob, id, buf, 0)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -79,7 +79,7 @@ func fillPacks(t testing.TB, rnd *rand.Rand, be Saver, pm *packerManager, buf []
 		}
 		bytes += l
 
-		if packer.Size() < minPackSize {
+		if !pm.isFull(packer) {
 			pm.insertPacker(packer)
 			continue
 		}
@@ -129,7 +129,7 @@ func testPackerManager(t testing.TB) int64 {
 	rnd := rand.New(rand.NewSource(randomSeed))
 
 	be := mem.New()
-	pm := newPackerManager(be, crypto.NewRandomKey())
+	pm := newPackerManager(be, crypto.NewRandomKey(), DefaultPackSize)
 
 	blobBuf := make([]byte, maxBlobSize)
 
@@ -159,7 +159,7 @@ func BenchmarkPackerManager(t *testing.B) {
 
 	for i := 0; i < t.N; i++ {
 		rnd.Seed(randomSeed)
-		pm := newPackerManager(be, crypto.NewRandomKey())
+		pm := newPackerManager(be, crypto.NewRandomKey(), DefaultPackSize)
 		fillPacks(t, rnd, be, pm, blobBuf)
 		flushRemainingPacks(t, be, pm)
 	}
@@ -0,0 +1,481 @@
+package repository
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/reedsolomon"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/progress"
+)
+
+// A parity file contains the parity shards for a group of pack files,
+// followed by the encrypted header and the length of the encrypted header:
+//
+//   Shard_1 || ... || Shard_m || EncryptedHeader || HeaderLength
+//
+// The shards are computed from the pack files as they are stored in the
+// backend. They are not encrypted again, as they only consist of linear
+// combinations of ciphertext.
+
+// parityEagerSize is the number of bytes at the end of a parity file which
+// are loaded to read the header.
+const parityEagerSize = 4096
+
+// ParityPack is a pack file covered by a parity file.
+type ParityPack struct {
+	ID   restic.ID `json:"id"`
+	Size uint      `json:"size"`
+
+	// Removed is set for pack files which have been removed from the
+	// parity, their slot is kept so that the other packs keep their index.
+	Removed bool `json:"removed,omitempty"`
+}
+
+// ParityHeader describes the contents of a parity file.
+type ParityHeader struct {
+	Packs     []ParityPack `json:"packs"`
+	Shards    uint         `json:"shards"`
+	ShardSize uint         `json:"shard_size"`
+}
+
+// Find returns the index of the pack id, or -1 if the pack is not covered by
+// the parity file.
+func (h *ParityHeader) Find(id restic.ID) int {
+	for i, p := range h.Packs {
+		if p.ID.Equal(id) && !p.Removed {
+			return i
+		}
+	}
+	return -1
+}
+
+// Parity is a parity file together with its shards.
+type Parity struct {
+	ParityHeader
+	shards [][]byte
+}
+
+// newParity returns an empty parity file with the given number of shards.
+func newParity(shards uint) *Parity {
+	return &Parity{
+		ParityHeader: ParityHeader{Shards: shards},
+		shards:       make([][]byte, shards),
+	}
+}
+
+// add adds the pack file with the content buf to the parity.
+func (p *Parity) add(id restic.ID, buf []byte) error {
+	if uint(len(buf)) > p.ShardSize {
+		for i := range p.shards {
+			p.shards[i] = append(p.shards[i], make([]byte, uint(len(buf))-p.ShardSize)...)
+		}
+		p.ShardSize = uint(len(buf))
+	}
+
+	err := reedsolomon.Update(p.shards, len(p.Packs), buf)
+	if err != nil {
+		return err
+	}
+
+	p.Packs = append(p.Packs, ParityPack{ID: id, Size: uint(len(buf))})
+	return nil
+}
+
+// remove removes the pack file at index i with the content buf from the
+// parity.
+func (p *Parity) remove(i int, buf []byte) error {
+	err := reedsolomon.Update(p.shards, i, buf)
+	if err != nil {
+		return err
+	}
+
+	p.Packs[i].Removed = true
+	return nil
+}
+
+// empty returns true if the parity does not cover any pack file.
+func (p *Parity) empty() bool {
+	for _, pack := range p.Packs {
+		if !pack.Removed {
+			return false
+		}
+	}
+	return true
+}
+
+// saveParity stores the parity file p in the repository.
+func saveParity(ctx context.Context, repo restic.Repository, p *Parity) (restic.ID, error) {
+	header, err := json.Marshal(p.ParityHeader)
+	if err != nil {
+		return restic.ID{}, errors.Wrap(err, "json.Marshal")
+	}
+
+	key := repo.Key()
+	buf := make([]byte, 0, p.Shards*p.ShardSize+uint(len(header)+key.Overhead()+key.NonceSize()+4))
+	for _, shard := range p.shards {
+		buf = append(buf, shard...)
+	}
+
+	nonce := crypto.NewRandomNonce()
+	start := len(buf)
+	buf = append(buf, nonce...)
+	buf = key.Seal(buf, nonce, header, nil)
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(buf)-start))
+	buf = append(buf, length[:]...)
+
+	id := restic.Hash(buf)
+	h := restic.Handle{Type: restic.ParityFile, Name: id.String()}
+	err = repo.Backend().Save(ctx, h, restic.NewByteReader(buf))
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	debug.Log("saved parity file %v for %d packs", id.Str(), len(p.Packs))
+	return id, nil
+}
+
+// parseParityHeader decrypts the header at the end of buf, which contains the
+// last bytes of a parity file of the given size.
+func parseParityHeader(key *crypto.Key, buf []byte, size int64) (*ParityHeader, error) {
+	if len(buf) < 4 {
+		return nil, errors.New("parity file is too short")
+	}
+
+	length := int64(binary.LittleEndian.Uint32(buf[len(buf)-4:]))
+	if length+4 > size {
+		return nil, errors.New("parity header is larger than the file")
+	}
+	if length+4 > int64(len(buf)) {
+		return nil, errNeedMore{length + 4}
+	}
+
+	ciphertext := buf[len(buf)-4-int(length) : len(buf)-4]
+	if len(ciphertext) < key.NonceSize()+key.Overhead() {
+		return nil, errors.New("parity header is too short")
+	}
+
+	nonce, ciphertext := ciphertext[:key.NonceSize()], ciphertext[key.NonceSize():]
+	plaintext, err := key.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := &ParityHeader{}
+	err = json.Unmarshal(plaintext, hdr)
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+
+	if int64(hdr.Shards*hdr.ShardSize)+length+4 != size {
+		return nil, errors.New("invalid parity file size")
+	}
+	return hdr, nil
+}
+
+// errNeedMore is returned by parseParityHeader if more data is needed.
+type errNeedMore struct {
+	size int64
+}
+
+func (e errNeedMore) Error() string {
+	return "parity header exceeds the loaded data"
+}
+
+// LoadParityHeader loads the header of the parity file id with the given size.
+func LoadParityHeader(ctx context.Context, repo restic.Repository, id restic.ID, size int64) (*ParityHeader, error) {
+	h := restic.Handle{Type: restic.ParityFile, Name: id.String()}
+
+	load := func(length int64) ([]byte, error) {
+		if length > size {
+			length = size
+		}
+		buf := make([]byte, length)
+		err := repo.Backend().Load(ctx, h, int(length), size-length, func(rd io.Reader) error {
+			_, err := io.ReadFull(rd, buf)
+			return err
+		})
+		return buf, err
+	}
+
+	buf, err := load(parityEagerSize)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr, err := parseParityHeader(repo.Key(), buf, size)
+	if more, ok := err.(errNeedMore); ok {
+		buf, err = load(more.size)
+		if err != nil {
+			return nil, err
+		}
+		hdr, err = parseParityHeader(repo.Key(), buf, size)
+	}
+	return hdr, err
+}
+
+// LoadParity loads the parity file id including its shards.
+func LoadParity(ctx context.Context, repo restic.Repository, id restic.ID) (*Parity, error) {
+	h := restic.Handle{Type: restic.ParityFile, Name: id.String()}
+	buf, err := backend.LoadAll(ctx, nil, repo.Backend(), h)
+	if err != nil {
+		return nil, err
+	}
+
+	if !restic.Hash(buf).Equal(id) {
+		return nil, errors.Errorf("parity file %v is damaged", id.Str())
+	}
+
+	hdr, err := parseParityHeader(repo.Key(), buf, int64(len(buf)))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Parity{ParityHeader: *hdr, shards: make([][]byte, hdr.Shards)}
+	for i := range p.shards {
+		p.shards[i] = buf[uint(i)*hdr.ShardSize : uint(i+1)*hdr.ShardSize]
+	}
+	return p, nil
+}
+
+// ForAllParity calls fn with the header of each parity file in the repository.
+func ForAllParity(ctx context.Context, repo restic.Repository, fn func(id restic.ID, hdr *ParityHeader) error) error {
+	return repo.List(ctx, restic.ParityFile, func(id restic.ID, size int64) error {
+		hdr, err := LoadParityHeader(ctx, repo, id, size)
+		if err != nil {
+			return errors.Wrapf(err, "parity file %v", id.Str())
+		}
+		return fn(id, hdr)
+	})
+}
+
+// loadPack loads the pack file id and verifies its hash.
+func loadPack(ctx context.Context, repo restic.Repository, id restic.ID) ([]byte, error) {
+	h := restic.Handle{Type: restic.PackFile, Name: id.String()}
+	buf, err := backend.LoadAll(ctx, nil, repo.Backend(), h)
+	if err != nil {
+		return nil, err
+	}
+
+	if !restic.Hash(buf).Equal(id) {
+		return nil, errors.Errorf("pack %v is damaged", id.Str())
+	}
+	return buf, nil
+}
+
+// RepairPacks restores the damaged pack files covered by the parity file id.
+// Other pack files of the group which turn out to be damaged are restored as
+// well. Returned are the IDs of all restored pack files.
+func RepairPacks(ctx context.Context, repo restic.Repository, id restic.ID, damaged restic.IDSet) (restic.IDs, error) {
+	p, err := LoadParity(ctx, repo, id)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([][]byte, len(p.Packs))
+	for i, pack := range p.Packs {
+		if pack.Removed {
+			data[i] = []byte{}
+			continue
+		}
+		if damaged.Has(pack.ID) {
+			continue
+		}
+
+		buf, err := loadPack(ctx, repo, pack.ID)
+		if err != nil {
+			debug.Log("unable to load pack %v: %v", pack.ID.Str(), err)
+			continue
+		}
+		data[i] = buf
+	}
+
+	var missing []int
+	for i, buf := range data {
+		if buf == nil {
+			missing = append(missing, i)
+		}
+	}
+
+	err = reedsolomon.Reconstruct(data, p.shards)
+	if err == reedsolomon.ErrTooFewShards {
+		return nil, errors.Errorf("%d packs of the group are damaged, but the parity can only restore %d", len(missing), p.Shards)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var repaired restic.IDs
+	for _, i := range missing {
+		pack := p.Packs[i]
+		buf := data[i][:pack.Size]
+		if !restic.Hash(buf).Equal(pack.ID) {
+			return repaired, errors.Errorf("restored pack %v does not match its ID", pack.ID.Str())
+		}
+
+		// backends refuse to overwrite files, remove the damaged pack first
+		h := restic.Handle{Type: restic.PackFile, Name: pack.ID.String()}
+		exists, err := repo.Backend().Test(ctx, h)
+		if err != nil {
+			return repaired, err
+		}
+		if exists {
+			err = repo.Backend().Remove(ctx, h)
+			if err != nil {
+				return repaired, err
+			}
+		}
+
+		err = repo.Backend().Save(ctx, h, restic.NewByteReader(buf))
+		if err != nil {
+			return repaired, err
+		}
+		repaired = append(repaired, pack.ID)
+	}
+
+	return repaired, nil
+}
+
+// RemoveFromParity removes the pack files from the parity files which cover
+// them, so that the pack files can be deleted. The pack files are loaded for
+// this. If a pack file cannot be loaded, the parity file covering it is
+// deleted, the IDs of these parity files are returned.
+func RemoveFromParity(ctx context.Context, repo restic.Repository, packs restic.IDSet, p *progress.Counter) (dropped restic.IDs, err error) {
+	type parityFile struct {
+		id    restic.ID
+		packs []int
+	}
+	var files []parityFile
+
+	err = ForAllParity(ctx, repo, func(id restic.ID, hdr *ParityHeader) error {
+		f := parityFile{id: id}
+		for i, pack := range hdr.Packs {
+			if !pack.Removed && packs.Has(pack.ID) {
+				f.packs = append(f.packs, i)
+			}
+		}
+		if len(f.packs) > 0 {
+			files = append(files, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.SetMax(uint64(len(files)))
+	for _, f := range files {
+		parity, err := LoadParity(ctx, repo, f.id)
+		if err != nil {
+			return dropped, err
+		}
+
+		for _, i := range f.packs {
+			buf, err := loadPack(ctx, repo, parity.Packs[i].ID)
+			if err == nil {
+				err = parity.remove(i, buf)
+			}
+			if err != nil {
+				debug.Log("unable to remove pack %v from parity: %v", parity.Packs[i].ID.Str(), err)
+				dropped = append(dropped, f.id)
+				parity = nil
+				break
+			}
+		}
+
+		if parity != nil && !parity.empty() {
+			_, err = saveParity(ctx, repo, parity)
+			if err != nil {
+				return dropped, err
+			}
+		}
+
+		err = repo.Backend().Remove(ctx, restic.Handle{Type: restic.ParityFile, Name: f.id.String()})
+		if err != nil {
+			return dropped, err
+		}
+		p.Add(1)
+	}
+
+	return dropped, nil
+}
+
+// CreateParity creates parity files for the pack files, using the parity
+// config cfg.
+func CreateParity(ctx context.Context, repo restic.Repository, packs restic.IDs, cfg restic.ParityConfig, p *progress.Counter) error {
+	pw := newParityWriter(cfg)
+	for _, id := range packs {
+		buf, err := loadPack(ctx, repo, id)
+		if err != nil {
+			return err
+		}
+
+		err = pw.add(ctx, repo, id, buf)
+		if err != nil {
+			return err
+		}
+		p.Add(1)
+	}
+
+	return pw.flush(ctx, repo)
+}
+
+// parityWriter collects pack files into groups and saves a parity file for
+// each group.
+type parityWriter struct {
+	cfg restic.ParityConfig
+
+	m      sync.Mutex
+	parity *Parity
+}
+
+func newParityWriter(cfg restic.ParityConfig) *parityWriter {
+	return &parityWriter{cfg: cfg}
+}
+
+// add adds the pack file id with the content buf to the current group. When
+// the group is complete, its parity file is saved.
+func (pw *parityWriter) add(ctx context.Context, repo restic.Repository, id restic.ID, buf []byte) error {
+	pw.m.Lock()
+	defer pw.m.Unlock()
+
+	if pw.parity == nil {
+		pw.parity = newParity(pw.cfg.Shards)
+	}
+
+	err := pw.parity.add(id, buf)
+	if err != nil {
+		return err
+	}
+
+	if uint(len(pw.parity.Packs)) < pw.cfg.GroupSize {
+		return nil
+	}
+
+	_, err = saveParity(ctx, repo, pw.parity)
+	pw.parity = nil
+	return err
+}
+
+// flush saves the parity file for an incomplete group.
+func (pw *parityWriter) flush(ctx context.Context, repo restic.Repository) error {
+	pw.m.Lock()
+	defer pw.m.Unlock()
+
+	if pw.parity == nil {
+		return nil
+	}
+
+	_, err := saveParity(ctx, repo, pw.parity)
+	pw.parity = nil
+	return err
+}
@@ -0,0 +1,128 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+
+	resticbackend "github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// openParityRepo returns a repository which creates parity files and uses
+// small pack files.
+func openParityRepo(t *testing.T, be restic.Backend, cfg restic.ParityConfig) *repository.Repository {
+	repository.TestUseLowSecurityKDFParameters(t)
+
+	repo := repository.New(be, repository.Options{PackSize: 64 * 1024})
+	rtest.OK(t, repo.Init(context.TODO(), restic.StableRepoVersion, rtest.TestPassword, nil))
+
+	config := repo.Config()
+	config.AddFeatures(restic.FeatureParity)
+	config.Parity = &cfg
+	rtest.OK(t, restic.ReplaceConfig(context.TODO(), repo, config))
+
+	repo = repository.New(be, repository.Options{PackSize: 64 * 1024})
+	rtest.OK(t, repo.SearchKey(context.TODO(), rtest.TestPassword, 1, ""))
+	return repo
+}
+
+func saveRandomBlobs(t *testing.T, repo restic.Repository, n int) {
+	for i := 0; i < n; i++ {
+		// each blob fills a pack file
+		buf := rtest.Random(i, 80*1024)
+		_, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, buf, restic.ID{}, false)
+		rtest.OK(t, err)
+	}
+	rtest.OK(t, repo.Flush(context.TODO()))
+}
+
+func listFiles(t *testing.T, repo restic.Repository, tpe restic.FileType) restic.IDs {
+	var ids restic.IDs
+	rtest.OK(t, repo.List(context.TODO(), tpe, func(id restic.ID, size int64) error {
+		ids = append(ids, id)
+		return nil
+	}))
+	return ids
+}
+
+func TestParityRepairPacks(t *testing.T) {
+	be, cleanup := repository.TestBackend(t)
+	defer cleanup()
+
+	repo := openParityRepo(t, be, restic.ParityConfig{GroupSize: 4, Shards: 2})
+	saveRandomBlobs(t, repo, 10)
+
+	packs := listFiles(t, repo, restic.PackFile)
+	rtest.Assert(t, len(packs) == 10, "expected 10 packs, got %d", len(packs))
+
+	covered := restic.NewIDSet()
+	var parityID restic.ID
+	rtest.OK(t, repository.ForAllParity(context.TODO(), repo, func(id restic.ID, hdr *repository.ParityHeader) error {
+		rtest.Assert(t, len(hdr.Packs) <= 4, "parity file %v covers %d packs", id.Str(), len(hdr.Packs))
+		for _, pack := range hdr.Packs {
+			covered.Insert(pack.ID)
+		}
+		if len(hdr.Packs) == 4 {
+			parityID = id
+		}
+		return nil
+	}))
+	rtest.Equals(t, restic.NewIDSet(packs...), covered)
+	rtest.Assert(t, !parityID.IsNull(), "no full parity group found")
+
+	hdr, err := repository.LoadParity(context.TODO(), repo, parityID)
+	rtest.OK(t, err)
+
+	// remove two packs of the group and restore them
+	damaged := restic.NewIDSet(hdr.Packs[0].ID, hdr.Packs[2].ID)
+	for id := range damaged {
+		rtest.OK(t, be.Remove(context.TODO(), restic.Handle{Type: restic.PackFile, Name: id.String()}))
+	}
+
+	restored, err := repository.RepairPacks(context.TODO(), repo, parityID, damaged)
+	rtest.OK(t, err)
+	rtest.Equals(t, damaged, restic.NewIDSet(restored...))
+	rtest.Equals(t, restic.NewIDSet(packs...), restic.NewIDSet(listFiles(t, repo, restic.PackFile)...))
+
+	for id := range damaged {
+		buf, err := resticbackend.LoadAll(context.TODO(), nil, be, restic.Handle{Type: restic.PackFile, Name: id.String()})
+		rtest.OK(t, err)
+		rtest.Equals(t, id, restic.Hash(buf))
+	}
+}
+
+func TestParityRemovePacks(t *testing.T) {
+	be, cleanup := repository.TestBackend(t)
+	defer cleanup()
+
+	repo := openParityRepo(t, be, restic.ParityConfig{GroupSize: 3, Shards: 1})
+	saveRandomBlobs(t, repo, 3)
+
+	packs := listFiles(t, repo, restic.PackFile)
+	rtest.Assert(t, len(packs) == 3, "expected 3 packs, got %d", len(packs))
+	parity := listFiles(t, repo, restic.ParityFile)
+	rtest.Assert(t, len(parity) == 1, "expected 1 parity file, got %d", len(parity))
+
+	// remove a pack from the parity file, then the remaining packs can still
+	// be restored
+	dropped, err := repository.RemoveFromParity(context.TODO(), repo, restic.NewIDSet(packs[0]), nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(dropped))
+	rtest.OK(t, be.Remove(context.TODO(), restic.Handle{Type: restic.PackFile, Name: packs[0].String()}))
+
+	parity = listFiles(t, repo, restic.ParityFile)
+	rtest.Assert(t, len(parity) == 1, "expected 1 parity file, got %d", len(parity))
+	rtest.OK(t, be.Remove(context.TODO(), restic.Handle{Type: restic.PackFile, Name: packs[1].String()}))
+
+	restored, err := repository.RepairPacks(context.TODO(), repo, parity[0], restic.NewIDSet(packs[1]))
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.IDs{packs[1]}, restored)
+
+	// removing all packs also removes the parity file
+	dropped, err = repository.RemoveFromParity(context.TODO(), repo, restic.NewIDSet(packs[1:]...), nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(dropped))
+	rtest.Equals(t, 0, len(listFiles(t, repo, restic.ParityFile)))
+}
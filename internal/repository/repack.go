@@ -12,6 +12,7 @@ import (
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/ui/progress"
 
+	"github.com/klauspost/compress/zstd"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -19,14 +20,20 @@ const numRepackWorkers = 8
 
 // Repack takes a list of packs together with a list of blobs contained in
 // these packs. Each pack is loaded and the blobs listed in keepBlobs is saved
-// into a new pack. Returned is the list of obsolete packs which can then
-// be removed.
+// into a new pack in dstRepo, which may be the same as repo. Returned is the
+// list of obsolete packs which can then be removed.
 //
 // The map keepBlobs is modified by Repack, it is used to keep track of which
 // blobs have been processed.
-func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, keepBlobs restic.BlobSet, p *progress.Counter) (obsoletePacks restic.IDSet, err error) {
+func Repack(ctx context.Context, repo restic.Repository, dstRepo restic.Repository, packs restic.IDSet, keepBlobs restic.BlobSet, p *progress.Counter) (obsoletePacks restic.IDSet, err error) {
 	debug.Log("repacking %d packs while keeping %d blobs", len(packs), len(keepBlobs))
 
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "zstd.NewReader")
+	}
+	defer dec.Close()
+
 	wg, wgCtx := errgroup.WithContext(ctx)
 
 	downloadQueue := make(chan restic.ID)
@@ -127,12 +134,20 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 						h, tempfile.Name(), len(buf), n)
 				}
 
-				nonce, ciphertext := buf[:repo.Key().NonceSize()], buf[repo.Key().NonceSize():]
-				plaintext, err := repo.Key().Open(ciphertext[:0], nonce, ciphertext, nil)
+				key := repo.Key().WithSealedFallback()
+				nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+				plaintext, err := key.Open(ciphertext[:0], nonce, ciphertext, nil)
 				if err != nil {
 					return err
 				}
 
+				if entry.IsCompressed() {
+					plaintext, err = dec.DecodeAll(plaintext, make([]byte, 0, entry.DataLength()))
+					if err != nil {
+						return errors.Wrap(err, "DecodeAll")
+					}
+				}
+
 				id := restic.Hash(plaintext)
 				if !id.Equal(entry.ID) {
 					debug.Log("read blob %v/%v from %v: wrong data returned, hash is %v",
@@ -154,7 +169,7 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 				}
 
 				// We do want to save already saved blobs!
-				_, _, err = repo.SaveBlob(wgCtx, entry.Type, plaintext, entry.ID, true)
+				_, _, err = dstRepo.SaveBlob(wgCtx, entry.Type, plaintext, entry.ID, true)
 				if err != nil {
 					return err
 				}
@@ -182,7 +197,7 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 		return nil, err
 	}
 
-	if err := repo.Flush(ctx); err != nil {
+	if err := dstRepo.Flush(ctx); err != nil {
 		return nil, err
 	}
 
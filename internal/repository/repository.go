@@ -20,6 +20,7 @@ import (
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/ui/progress"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/minio/sha256-simd"
 	"golang.org/x/sync/errgroup"
 )
@@ -33,21 +34,109 @@ type Repository struct {
 	idx     *MasterIndex
 	Cache   *cache.Cache
 
+	// metaKey is used to encrypt index and lock files in repositories which
+	// support write-only keys.
+	metaKey   *crypto.Key
+	writeOnly bool
+
+	// capabilities of the key used to open the repository
+	capabilities []string
+
+	// parity collects new pack files and saves parity files for them, it is
+	// only set if the repository uses parity files.
+	parity *parityWriter
+
+	opts Options
+
 	noAutoIndexUpdate bool
 
 	treePM *packerManager
 	dataPM *packerManager
+
+	allocEnc sync.Once
+	allocDec sync.Once
+	enc      *zstd.Encoder
+	dec      *zstd.Decoder
+}
+
+// Options configures how data is written to the repository.
+type Options struct {
+	Compression CompressionMode
+	// PackSize is the target size of pack files in bytes. If zero, the pack
+	// size from the repository config or DefaultPackSize is used.
+	PackSize uint
+}
+
+// CompressionMode configures if data should be compressed.
+type CompressionMode uint
+
+// Constants for the different compression levels.
+const (
+	CompressionAuto    CompressionMode = 0
+	CompressionOff     CompressionMode = 1
+	CompressionMax     CompressionMode = 2
+	CompressionInvalid CompressionMode = 3
+)
+
+// Set implements the method needed for pflag command flag parsing.
+func (c *CompressionMode) Set(s string) error {
+	switch s {
+	case "auto":
+		*c = CompressionAuto
+	case "off":
+		*c = CompressionOff
+	case "max":
+		*c = CompressionMax
+	default:
+		*c = CompressionInvalid
+		return errors.Fatalf("invalid compression mode %q, must be one of (auto|off|max)", s)
+	}
+
+	return nil
+}
+
+func (c *CompressionMode) String() string {
+	switch *c {
+	case CompressionAuto:
+		return "auto"
+	case CompressionOff:
+		return "off"
+	case CompressionMax:
+		return "max"
+	default:
+		return "invalid"
+	}
+}
+
+// Type implements the method needed for pflag command flag parsing.
+func (c *CompressionMode) Type() string {
+	return "mode"
 }
 
 // New returns a new repository with backend be.
-func New(be restic.Backend) *Repository {
+func New(be restic.Backend, opts Options) *Repository {
 	repo := &Repository{
-		be:     be,
-		idx:    NewMasterIndex(),
-		dataPM: newPackerManager(be, nil),
-		treePM: newPackerManager(be, nil),
+		be:   be,
+		opts: opts,
+		idx:  NewMasterIndex(),
 	}
+	repo.dataPM = newPackerManager(be, nil, repo.PackSize())
+	repo.treePM = newPackerManager(be, nil, repo.PackSize())
+
+	return repo
+}
 
+// WithKey returns a new repository for the same backend and config, which uses
+// the master key k. The index of the new repository is empty.
+func (r *Repository) WithKey(k *crypto.Key) *Repository {
+	repo := New(r.be, r.opts)
+	repo.setConfig(r.cfg)
+	repo.key = k
+	repo.dataPM.key = k
+	repo.treePM.key = k
+	if repo.cfg.HasFeature(restic.FeatureWriteOnlyKeys) {
+		repo.metaKey = k.MetadataKey()
+	}
 	return repo
 }
 
@@ -112,15 +201,119 @@ func (r *Repository) LoadAndDecrypt(ctx context.Context, buf []byte, t restic.Fi
 		return nil, errors.Errorf("load %v: invalid data returned", h)
 	}
 
-	nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
-	plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	key := r.key
+	if r.writeOnly {
+		if !isMetadata(t) {
+			return nil, errors.Fatalf("cannot read %v files with a write-only key", t)
+		}
+		key = r.metaKey
+	} else if r.metaKey != nil && isMetadata(t) {
+		key = r.key.WithMetadataFallback().WithSealedFallback()
+	} else if t == restic.SnapshotFile {
+		// snapshots saved with a write-only key are sealed
+		key = r.key.WithSealedFallback()
+	}
+
+	nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+	plaintext, err := key.Open(ciphertext[:0], nonce, ciphertext, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	if t != restic.ConfigFile {
+		return r.decompressUnpacked(plaintext)
+	}
+
 	return plaintext, nil
 }
 
+// getZstdEncoder returns the zstd encoder for the configured compression
+// level, it is allocated on first use.
+func (r *Repository) getZstdEncoder() *zstd.Encoder {
+	r.allocEnc.Do(func() {
+		level := zstd.SpeedDefault
+		if r.opts.Compression == CompressionMax {
+			level = zstd.SpeedBestCompression
+		}
+
+		opts := []zstd.EOption{
+			// Set the compression level configured.
+			zstd.WithEncoderLevel(level),
+			// Disable CRC, we have enough checks in place, makes the
+			// compressed data four bytes shorter.
+			zstd.WithEncoderCRC(false),
+			// Set a window of 512kbyte, so we have good lookbehind for usual
+			// blob sizes.
+			zstd.WithWindowSize(512 * 1024),
+		}
+
+		enc, err := zstd.NewWriter(nil, opts...)
+		if err != nil {
+			panic(err)
+		}
+		r.enc = enc
+	})
+	return r.enc
+}
+
+// getZstdDecoder returns the zstd decoder, it is allocated on first use.
+func (r *Repository) getZstdDecoder() *zstd.Decoder {
+	r.allocDec.Do(func() {
+		opts := []zstd.DOption{
+			// Use all available cores.
+			zstd.WithDecoderConcurrency(0),
+			// Limit the maximum decompressed memory. Set to a very high,
+			// conservative value.
+			zstd.WithDecoderMaxMemory(16 * 1024 * 1024 * 1024),
+		}
+
+		dec, err := zstd.NewReader(nil, opts...)
+		if err != nil {
+			panic(err)
+		}
+		r.dec = dec
+	})
+	return r.dec
+}
+
+// compressUnpacked compresses the data p for files that are saved unpacked,
+// if the repository format supports it. The first byte of the result
+// encodes the format version of the data.
+func (r *Repository) compressUnpacked(p []byte) ([]byte, error) {
+	// compression is only available starting from version 2
+	if r.cfg.Version < 2 {
+		return p, nil
+	}
+
+	// version byte
+	out := []byte{2}
+	out = r.getZstdEncoder().EncodeAll(p, out)
+	return out, nil
+}
+
+// decompressUnpacked reverts compressUnpacked.
+func (r *Repository) decompressUnpacked(p []byte) ([]byte, error) {
+	// compression is only available starting from version 2
+	if r.cfg.Version < 2 {
+		return p, nil
+	}
+
+	if len(p) == 0 {
+		// too short for version header
+		return p, nil
+	}
+	if p[0] == '[' || p[0] == '{' {
+		// probably raw JSON
+		return p, nil
+	}
+	// version
+	if p[0] != 2 {
+		return nil, errors.New("not supported encoding format")
+	}
+
+	return r.getZstdDecoder().DecodeAll(p[1:], nil)
+}
+
 type haver interface {
 	Has(restic.Handle) bool
 }
@@ -155,6 +348,10 @@ func sortCachedPacksFirst(cache haver, blobs []restic.PackedBlob) {
 func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.ID, buf []byte) ([]byte, error) {
 	debug.Log("load %v with id %v (buf len %v, cap %d)", t, id, len(buf), cap(buf))
 
+	if r.writeOnly {
+		return nil, errors.Fatalf("cannot read %v blobs with a write-only key", t)
+	}
+
 	// lookup packs
 	blobs := r.idx.Lookup(restic.BlobHandle{ID: id, Type: t})
 	if len(blobs) == 0 {
@@ -198,22 +395,35 @@ func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.
 		}
 
 		// decrypt
-		nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
-		plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+		key := r.key.WithSealedFallback()
+		nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+		plaintext, err := key.Open(ciphertext[:0], nonce, ciphertext, nil)
 		if err != nil {
 			lastError = errors.Errorf("decrypting blob %v failed: %v", id, err)
 			continue
 		}
 
+		if blob.IsCompressed() {
+			plaintext, err = r.getZstdDecoder().DecodeAll(plaintext, make([]byte, 0, blob.DataLength()))
+			if err != nil {
+				lastError = errors.Errorf("decompressing blob %v failed: %v", id, err)
+				continue
+			}
+		}
+
 		// check hash
 		if !restic.Hash(plaintext).Equal(id) {
 			lastError = errors.Errorf("blob %v returned invalid hash", id)
 			continue
 		}
 
+		if len(plaintext) > cap(buf) {
+			return plaintext, nil
+		}
 		// move decrypted data to the start of the buffer
+		buf = buf[:len(plaintext)]
 		copy(buf, plaintext)
-		return buf[:len(plaintext)], nil
+		return buf, nil
 	}
 
 	if lastError != nil {
@@ -245,6 +455,29 @@ func (r *Repository) LookupBlobSize(id restic.ID, tpe restic.BlobType) (uint, bo
 func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data []byte, id restic.ID) error {
 	debug.Log("save id %v (%v, %d bytes)", id, t, len(data))
 
+	if err := r.cfg.CheckWritable(); err != nil {
+		return err
+	}
+
+	uncompressedLength := 0
+	if r.cfg.Version > 1 {
+		// we have a repo v2, so compression is available. if the user opts
+		// to not compress, we won't compress any data, but tree blobs are
+		// always compressed.
+		//
+		// write-only keys always compress the data: the plaintext length
+		// of uncompressed blobs is derived from the ciphertext length,
+		// which is larger for sealed blobs.
+		if r.opts.Compression != CompressionOff || t != restic.DataBlob || r.writeOnly {
+			compressed := r.getZstdEncoder().EncodeAll(data, nil)
+			// only use the compressed data if it actually saves space
+			if len(compressed) < len(data) || r.writeOnly {
+				uncompressedLength = len(data)
+				data = compressed
+			}
+		}
+	}
+
 	nonce := crypto.NewRandomNonce()
 
 	ciphertext := make([]byte, 0, restic.CiphertextLength(len(data)))
@@ -271,13 +504,13 @@ func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data
 	}
 
 	// save ciphertext
-	_, err = packer.Add(t, id, ciphertext)
+	_, err = packer.Add(t, id, ciphertext, uncompressedLength)
 	if err != nil {
 		return err
 	}
 
 	// if the pack is not full enough, put back to the list
-	if packer.Size() < minPackSize {
+	if !pm.isFull(packer) {
 		debug.Log("pack is not full enough (%d bytes)", packer.Size())
 		pm.insertPacker(packer)
 		return nil
@@ -302,12 +535,31 @@ func (r *Repository) SaveJSONUnpacked(ctx context.Context, t restic.FileType, it
 // SaveUnpacked encrypts data and stores it in the backend. Returned is the
 // storage hash.
 func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, p []byte) (id restic.ID, err error) {
+	// lock files are also created when only reading from the repository
+	if t != restic.LockFile {
+		if err := r.cfg.CheckWritable(); err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	if t != restic.ConfigFile {
+		p, err = r.compressUnpacked(p)
+		if err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	key := r.key
+	if r.metaKey != nil && isMetadata(t) {
+		key = r.metaKey
+	}
+
 	ciphertext := restic.NewBlobBuffer(len(p))
 	ciphertext = ciphertext[:0]
 	nonce := crypto.NewRandomNonce()
 	ciphertext = append(ciphertext, nonce...)
 
-	ciphertext = r.key.Seal(ciphertext, nonce, p, nil)
+	ciphertext = key.Seal(ciphertext, nonce, p, nil)
 
 	if t == restic.ConfigFile {
 		id = restic.ID{}
@@ -326,6 +578,12 @@ func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, p []by
 	return id, nil
 }
 
+// isMetadata returns true for the file types which are encrypted with the
+// metadata key in repositories which support write-only keys.
+func isMetadata(t restic.FileType) bool {
+	return t == restic.IndexFile || t == restic.LockFile
+}
+
 // Flush saves all remaining packs and the index
 func (r *Repository) Flush(ctx context.Context) error {
 	if err := r.FlushPacks(ctx); err != nil {
@@ -363,6 +621,10 @@ func (r *Repository) FlushPacks(ctx context.Context) error {
 		p.pm.packers = p.pm.packers[:0]
 		p.pm.pm.Unlock()
 	}
+
+	if r.parity != nil {
+		return r.parity.flush(ctx, r)
+	}
 	return nil
 }
 
@@ -399,7 +661,12 @@ func (r *Repository) SetIndex(i restic.MasterIndex) error {
 func SaveIndex(ctx context.Context, repo restic.Repository, index *Index) (restic.ID, error) {
 	buf := bytes.NewBuffer(nil)
 
-	err := index.Encode(buf)
+	var err error
+	if repo.Config().HasFeature(restic.FeatureBinaryIndex) {
+		err = index.EncodeBinary(buf)
+	} else {
+		err = index.Encode(buf)
+	}
 	if err != nil {
 		return restic.ID{}, err
 	}
@@ -605,20 +872,72 @@ func (r *Repository) SearchKey(ctx context.Context, password string, maxKeys int
 		return err
 	}
 
-	r.key = key.master
-	r.dataPM.key = key.master
-	r.treePM.key = key.master
 	r.keyName = key.Name()
-	r.cfg, err = restic.LoadConfig(ctx, r)
+	r.capabilities = key.Capabilities
+	if key.WriteOnly() {
+		return r.useWriteOnlyKey(key)
+	}
+
+	err = r.UseMasterKey(ctx, key.master)
 	if err != nil {
 		return errors.Fatalf("config cannot be loaded: %v", err)
 	}
 	return nil
 }
 
+// UseMasterKey configures the repository to use the master key k without a
+// key file, afterwards the config is read and parsed. If k is not the master
+// key of the repository, crypto.ErrUnauthenticated is returned.
+func (r *Repository) UseMasterKey(ctx context.Context, k *crypto.Key) error {
+	r.key = k
+	r.dataPM.key = k
+	r.treePM.key = k
+
+	cfg, err := restic.LoadConfig(ctx, r)
+	if err != nil {
+		return err
+	}
+	r.setConfig(cfg)
+
+	if r.cfg.HasFeature(restic.FeatureWriteOnlyKeys) {
+		r.metaKey = k.MetadataKey()
+	}
+	return nil
+}
+
+// useWriteOnlyKey configures the repository to seal all new data to the
+// public key stored in the write-only key. The config cannot be decrypted,
+// the copy stored in the key is used instead.
+func (r *Repository) useWriteOnlyKey(key *Key) error {
+	var pub crypto.PublicKey
+	copy(pub[:], key.writeOnly.PublicKey)
+
+	k, err := crypto.NewSealingKey(pub)
+	if err != nil {
+		return err
+	}
+
+	r.key = k
+	r.metaKey = key.writeOnly.Metadata
+	// pack headers are encrypted with the metadata key, as the size of a
+	// pack file is calculated assuming the regular overhead for the header
+	r.dataPM.key = r.metaKey
+	r.treePM.key = r.metaKey
+	r.writeOnly = true
+	r.setConfig(key.writeOnly.Config)
+	return nil
+}
+
 // Init creates a new master key with the supplied password, initializes and
-// saves the repository config.
-func (r *Repository) Init(ctx context.Context, password string, chunkerPolynomial *chunker.Pol) error {
+// saves the repository config using the given repository format version.
+func (r *Repository) Init(ctx context.Context, version uint, password string, chunkerPolynomial *chunker.Pol) error {
+	if version > restic.MaxRepoVersion {
+		return errors.Fatal("unsupported repository version")
+	}
+	if version < restic.MinRepoVersion {
+		return errors.Fatal("repository version is too low")
+	}
+
 	has, err := r.be.Test(ctx, restic.Handle{Type: restic.ConfigFile})
 	if err != nil {
 		return err
@@ -627,13 +946,15 @@ func (r *Repository) Init(ctx context.Context, password string, chunkerPolynomia
 		return errors.New("repository master key and config already initialized")
 	}
 
-	cfg, err := restic.CreateConfig()
+	cfg, err := restic.CreateConfig(version)
 	if err != nil {
 		return err
 	}
 	if chunkerPolynomial != nil {
 		cfg.ChunkerPolynomial = *chunkerPolynomial
 	}
+	// store the pack size, so that it is used by default for this repository
+	cfg.PackSize = r.opts.PackSize / (1024 * 1024)
 
 	return r.init(ctx, password, cfg)
 }
@@ -650,16 +971,54 @@ func (r *Repository) init(ctx context.Context, password string, cfg restic.Confi
 	r.dataPM.key = key.master
 	r.treePM.key = key.master
 	r.keyName = key.Name()
-	r.cfg = cfg
+	r.setConfig(cfg)
 	_, err = r.SaveJSONUnpacked(ctx, restic.ConfigFile, cfg)
 	return err
 }
 
+// setConfig sets the repository config and updates the settings for new pack
+// files, which may be specified in the config.
+func (r *Repository) setConfig(cfg restic.Config) {
+	r.cfg = cfg
+	r.dataPM.packSize = r.PackSize()
+	r.treePM.packSize = r.PackSize()
+
+	r.parity = nil
+	if cfg.HasFeature(restic.FeatureParity) && cfg.Parity != nil {
+		r.parity = newParityWriter(*cfg.Parity)
+	}
+}
+
+// PackSize returns the target size of pack files in bytes. The size from the
+// options takes precedence over the one stored in the repository config.
+func (r *Repository) PackSize() uint {
+	if r.opts.PackSize > 0 {
+		return r.opts.PackSize
+	}
+
+	size := r.cfg.PackSize * 1024 * 1024
+	switch {
+	case size == 0:
+		return DefaultPackSize
+	case size < MinPackSize:
+		return MinPackSize
+	case size > MaxPackSize:
+		return MaxPackSize
+	}
+	return size
+}
+
 // Key returns the current master key.
 func (r *Repository) Key() *crypto.Key {
 	return r.key
 }
 
+// WriteOnly returns true if the repository has been opened with a write-only
+// key, which can add new data but cannot read existing data.
+func (r *Repository) WriteOnly() bool {
+	return r.writeOnly
+}
+
 // KeyName returns the name of the current key in the backend.
 func (r *Repository) KeyName() string {
 	return r.keyName
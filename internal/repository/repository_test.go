@@ -59,6 +59,73 @@ func TestSave(t *testing.T) {
 	}
 }
 
+func TestSaveCompressed(t *testing.T) {
+	for _, version := range []uint{1, 2} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			testSaveCompressed(t, version)
+		})
+	}
+}
+
+func testSaveCompressed(t *testing.T, version uint) {
+	repo, cleanup := repository.TestRepositoryWithVersion(t, version)
+	defer cleanup()
+
+	// highly compressible data
+	data := bytes.Repeat([]byte("restic compression test "), 10000)
+
+	id, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(context.Background()))
+
+	pbs := repo.Index().Lookup(restic.BlobHandle{ID: id, Type: restic.DataBlob})
+	rtest.Equals(t, 1, len(pbs))
+	rtest.Equals(t, version > 1, pbs[0].IsCompressed())
+	rtest.Equals(t, uint(len(data)), pbs[0].DataLength())
+	if version > 1 {
+		rtest.Assert(t, pbs[0].Length < uint(len(data)), "blob was not compressed, length %v", pbs[0].Length)
+	}
+
+	size, found := repo.LookupBlobSize(id, restic.DataBlob)
+	rtest.Assert(t, found, "blob not found")
+	rtest.Equals(t, uint(len(data)), size)
+
+	buf, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(buf, data), "data does not match")
+
+	// unpacked files are compressed as well
+	sn := restic.Snapshot{Hostname: "foobar", Paths: []string{string(data)}}
+	snID, err := repo.SaveJSONUnpacked(context.TODO(), restic.SnapshotFile, &sn)
+	rtest.OK(t, err)
+
+	var sn2 restic.Snapshot
+	rtest.OK(t, repo.LoadJSONUnpacked(context.TODO(), restic.SnapshotFile, snID, &sn2))
+	rtest.Equals(t, sn.Hostname, sn2.Hostname)
+	rtest.Equals(t, sn.Paths, sn2.Paths)
+}
+
+func TestPackSize(t *testing.T) {
+	be, cleanup := repository.TestBackend(t)
+	defer cleanup()
+	repository.TestUseLowSecurityKDFParameters(t)
+
+	// the pack size used during init is stored in the config
+	repo := repository.New(be, repository.Options{PackSize: 16 * 1024 * 1024})
+	rtest.OK(t, repo.Init(context.TODO(), restic.StableRepoVersion, rtest.TestPassword, nil))
+	rtest.Equals(t, uint(16), repo.Config().PackSize)
+
+	repo = repository.New(be, repository.Options{})
+	rtest.Equals(t, uint(repository.DefaultPackSize), repo.PackSize())
+	rtest.OK(t, repo.SearchKey(context.TODO(), rtest.TestPassword, 1, ""))
+	rtest.Equals(t, uint(16*1024*1024), repo.PackSize())
+
+	// the options take precedence over the config
+	repo = repository.New(be, repository.Options{PackSize: 32 * 1024 * 1024})
+	rtest.OK(t, repo.SearchKey(context.TODO(), rtest.TestPassword, 1, ""))
+	rtest.Equals(t, uint(32*1024*1024), repo.PackSize())
+}
+
 func TestSaveFrom(t *testing.T) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
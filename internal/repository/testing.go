@@ -14,11 +14,20 @@ import (
 	"github.com/restic/chunker"
 )
 
-// testKDFParams are the parameters for the KDF to be used during testing.
-var testKDFParams = crypto.Params{
-	N: 128,
-	R: 1,
-	P: 1,
+// testKDFParams are the parameters for the KDFs to be used during testing.
+var testKDFParams = map[string]crypto.Params{
+	crypto.KDFScrypt: {
+		KDF: crypto.KDFScrypt,
+		N:   128,
+		R:   1,
+		P:   1,
+	},
+	crypto.KDFArgon2id: {
+		KDF:     crypto.KDFArgon2id,
+		Time:    1,
+		Memory:  64,
+		Threads: 1,
+	},
 }
 
 type logger interface {
@@ -28,7 +37,9 @@ type logger interface {
 // TestUseLowSecurityKDFParameters configures low-security KDF parameters for testing.
 func TestUseLowSecurityKDFParameters(t logger) {
 	t.Logf("using low-security KDF parameters for test")
-	Params = &testKDFParams
+	for kdf, p := range testKDFParams {
+		Params[kdf] = p
+	}
 }
 
 // TestBackend returns a fully configured in-memory backend.
@@ -40,8 +51,9 @@ const TestChunkerPol = chunker.Pol(0x3DA3358B4DC173)
 
 // TestRepositoryWithBackend returns a repository initialized with a test
 // password. If be is nil, an in-memory backend is used. A constant polynomial
-// is used for the chunker and low-security test parameters.
-func TestRepositoryWithBackend(t testing.TB, be restic.Backend) (r restic.Repository, cleanup func()) {
+// is used for the chunker and low-security test parameters. If version is
+// zero, the stable repository version is used.
+func TestRepositoryWithBackend(t testing.TB, be restic.Backend, version uint) (r restic.Repository, cleanup func()) {
 	t.Helper()
 	TestUseLowSecurityKDFParameters(t)
 	restic.TestDisableCheckPolynomial(t)
@@ -51,9 +63,13 @@ func TestRepositoryWithBackend(t testing.TB, be restic.Backend) (r restic.Reposi
 		be, beCleanup = TestBackend(t)
 	}
 
-	repo := New(be)
+	if version == 0 {
+		version = restic.StableRepoVersion
+	}
+
+	repo := New(be, Options{})
 
-	cfg := restic.TestCreateConfig(t, TestChunkerPol)
+	cfg := restic.TestCreateConfig(t, TestChunkerPol, version)
 	err := repo.init(context.TODO(), test.TestPassword, cfg)
 	if err != nil {
 		t.Fatalf("TestRepository(): initialize repo failed: %v", err)
@@ -71,6 +87,13 @@ func TestRepositoryWithBackend(t testing.TB, be restic.Backend) (r restic.Reposi
 // a non-existing directory, a local backend is created there and this is used
 // instead. The directory is not removed, but left there for inspection.
 func TestRepository(t testing.TB) (r restic.Repository, cleanup func()) {
+	t.Helper()
+	return TestRepositoryWithVersion(t, 0)
+}
+
+// TestRepositoryWithVersion returns a repository like TestRepository, using
+// the given repository format version.
+func TestRepositoryWithVersion(t testing.TB, version uint) (r restic.Repository, cleanup func()) {
 	t.Helper()
 	dir := os.Getenv("RESTIC_TEST_REPO")
 	if dir != "" {
@@ -80,7 +103,7 @@ func TestRepository(t testing.TB) (r restic.Repository, cleanup func()) {
 			if err != nil {
 				t.Fatalf("error creating local backend at %v: %v", dir, err)
 			}
-			return TestRepositoryWithBackend(t, be)
+			return TestRepositoryWithBackend(t, be, version)
 		}
 
 		if err == nil {
@@ -88,7 +111,7 @@ func TestRepository(t testing.TB) (r restic.Repository, cleanup func()) {
 		}
 	}
 
-	return TestRepositoryWithBackend(t, nil)
+	return TestRepositoryWithBackend(t, nil, version)
 }
 
 // TestOpenLocal opens a local repository.
@@ -98,7 +121,7 @@ func TestOpenLocal(t testing.TB, dir string) (r restic.Repository) {
 		t.Fatal(err)
 	}
 
-	repo := New(be)
+	repo := New(be, Options{})
 	err = repo.SearchKey(context.TODO(), test.TestPassword, 10, "")
 	if err != nil {
 		t.Fatal(err)
@@ -2,6 +2,7 @@ package restic
 
 import (
 	"context"
+	"sort"
 	"testing"
 
 	"github.com/restic/restic/internal/errors"
@@ -16,11 +17,102 @@ type Config struct {
 	Version           uint        `json:"version"`
 	ID                string      `json:"id"`
 	ChunkerPolynomial chunker.Pol `json:"chunker_polynomial"`
+
+	// Features lists the optional features used by the repository. A client
+	// must not modify a repository which uses features it does not know.
+	Features []string `json:"features,omitempty"`
+
+	// PackSize is the default target size of pack files in MiB. If unset,
+	// the built-in default is used.
+	PackSize uint `json:"pack_size,omitempty"`
+
+	// Parity configures the redundancy of pack files, it is only used if
+	// the repository has the feature FeatureParity.
+	Parity *ParityConfig `json:"parity,omitempty"`
+}
+
+// ParityConfig configures how parity files are created. Each group of up to
+// GroupSize pack files is covered by a parity file with Shards parity shards,
+// which allows repairing up to Shards damaged pack files of the group.
+type ParityConfig struct {
+	GroupSize uint `json:"group_size"`
+	Shards    uint `json:"shards"`
+}
+
+const (
+	// MinRepoVersion is the oldest repository format version that can be
+	// read and written.
+	MinRepoVersion = 1
+	// MaxRepoVersion is the newest repository format version that can be
+	// read and written.
+	MaxRepoVersion = 2
+	// StableRepoVersion is the version that is written to the config when a
+	// repository is newly created with Init() and no version was requested.
+	StableRepoVersion = 1
+)
+
+// FeatureWriteOnlyKeys marks repositories which support write-only keys. The
+// index and lock files are encrypted with the metadata key, so that they can
+// be read with write-only keys.
+const FeatureWriteOnlyKeys = "write-only-keys"
+
+// FeatureParity marks repositories which store parity files for all new
+// pack files, according to the parity config.
+const FeatureParity = "parity"
+
+// FeatureBinaryIndex marks repositories in which new index files are written
+// in the binary index format.
+const FeatureBinaryIndex = "binary-index"
+
+// knownFeatures contains all repository features supported by this version of
+// restic.
+var knownFeatures = map[string]struct{}{
+	FeatureWriteOnlyKeys: {},
+	FeatureParity:        {},
+	FeatureBinaryIndex:   {},
+}
+
+// HasFeature returns true if the repository uses the feature name.
+func (cfg Config) HasFeature(name string) bool {
+	for _, f := range cfg.Features {
+		if f == name {
+			return true
+		}
+	}
+	return false
 }
 
-// RepoVersion is the version that is written to the config when a repository
-// is newly created with Init().
-const RepoVersion = 1
+// AddFeatures adds the features to the config. The list of features is kept
+// sorted and free of duplicates.
+func (cfg *Config) AddFeatures(names ...string) {
+	for _, name := range names {
+		if !cfg.HasFeature(name) {
+			cfg.Features = append(cfg.Features, name)
+		}
+	}
+	sort.Strings(cfg.Features)
+}
+
+// UnknownFeatures returns the features used by the repository which are not
+// supported by this version of restic.
+func (cfg Config) UnknownFeatures() (unknown []string) {
+	for _, f := range cfg.Features {
+		if _, ok := knownFeatures[f]; !ok {
+			unknown = append(unknown, f)
+		}
+	}
+	return unknown
+}
+
+// CheckWritable returns an error if the repository uses features which are
+// unknown to this version of restic. Such a repository can still be read, but
+// must not be modified.
+func (cfg Config) CheckWritable() error {
+	if unknown := cfg.UnknownFeatures(); len(unknown) > 0 {
+		return errors.Fatalf("repository uses unsupported features %v and cannot be modified by this version of restic, please upgrade", unknown)
+	}
+	return nil
+}
 
 // JSONUnpackedLoader loads unpacked JSON.
 type JSONUnpackedLoader interface {
@@ -28,8 +120,8 @@ type JSONUnpackedLoader interface {
 }
 
 // CreateConfig creates a config file with a randomly selected polynomial and
-// ID.
-func CreateConfig() (Config, error) {
+// ID for the given repository format version.
+func CreateConfig(version uint) (Config, error) {
 	var (
 		err error
 		cfg Config
@@ -40,19 +132,23 @@ func CreateConfig() (Config, error) {
 		return Config{}, errors.Wrap(err, "chunker.RandomPolynomial")
 	}
 
+	if version < MinRepoVersion || version > MaxRepoVersion {
+		return Config{}, errors.Errorf("unsupported repository version %v", version)
+	}
+
 	cfg.ID = NewRandomID().String()
-	cfg.Version = RepoVersion
+	cfg.Version = version
 
 	debug.Log("New config: %#v", cfg)
 	return cfg, nil
 }
 
 // TestCreateConfig creates a config for use within tests.
-func TestCreateConfig(t testing.TB, pol chunker.Pol) (cfg Config) {
+func TestCreateConfig(t testing.TB, pol chunker.Pol, version uint) (cfg Config) {
 	cfg.ChunkerPolynomial = pol
 
 	cfg.ID = NewRandomID().String()
-	cfg.Version = RepoVersion
+	cfg.Version = version
 
 	return cfg
 }
@@ -77,8 +173,8 @@ func LoadConfig(ctx context.Context, r JSONUnpackedLoader) (Config, error) {
 		return Config{}, err
 	}
 
-	if cfg.Version != RepoVersion {
-		return Config{}, errors.New("unsupported repository version")
+	if cfg.Version < MinRepoVersion || cfg.Version > MaxRepoVersion {
+		return Config{}, errors.Errorf("unsupported repository version %v", cfg.Version)
 	}
 
 	if checkPolynomial {
@@ -89,3 +185,19 @@ func LoadConfig(ctx context.Context, r JSONUnpackedLoader) (Config, error) {
 
 	return cfg, nil
 }
+
+// ReplaceConfig replaces the config of the repository by cfg. Backends refuse
+// to overwrite files, so the old config must be removed first. If the new
+// config cannot be saved, the old one is lost.
+func ReplaceConfig(ctx context.Context, r Repository, cfg Config) error {
+	h := Handle{Type: ConfigFile}
+	if err := r.Backend().Remove(ctx, h); err != nil {
+		return errors.Wrap(err, "remove config")
+	}
+
+	_, err := r.SaveJSONUnpacked(ctx, ConfigFile, cfg)
+	if err != nil {
+		return errors.Fatalf("unable to save new config, the repository is unusable until the config is restored: %v", err)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package restic_test
 
 import (
 	"context"
+	"reflect"
 	"testing"
 
 	"github.com/restic/restic/internal/restic"
@@ -32,7 +33,7 @@ func TestConfig(t *testing.T) {
 		return restic.ID{}, nil
 	}
 
-	cfg1, err := restic.CreateConfig()
+	cfg1, err := restic.CreateConfig(restic.MaxRepoVersion)
 	rtest.OK(t, err)
 
 	_, err = saver(save).SaveJSONUnpacked(restic.ConfigFile, cfg1)
@@ -51,6 +52,20 @@ func TestConfig(t *testing.T) {
 	cfg2, err := restic.LoadConfig(context.TODO(), loader(load))
 	rtest.OK(t, err)
 
-	rtest.Assert(t, cfg1 == cfg2,
+	rtest.Assert(t, reflect.DeepEqual(cfg1, cfg2),
 		"configs aren't equal: %v != %v", cfg1, cfg2)
 }
+
+func TestConfigFeatures(t *testing.T) {
+	cfg, err := restic.CreateConfig(restic.MaxRepoVersion)
+	rtest.OK(t, err)
+
+	rtest.Assert(t, !cfg.HasFeature("foo"), "new config has feature foo")
+	rtest.OK(t, cfg.CheckWritable())
+
+	cfg.AddFeatures("foo", "bar", "foo")
+	rtest.Equals(t, []string{"bar", "foo"}, cfg.Features)
+	rtest.Assert(t, cfg.HasFeature("foo"), "feature foo is missing")
+	rtest.Equals(t, []string{"bar", "foo"}, cfg.UnknownFeatures())
+	rtest.Assert(t, cfg.CheckWritable() != nil, "config with unknown features is writable")
+}
@@ -17,6 +17,12 @@ const (
 	SnapshotFile FileType = "snapshot"
 	IndexFile    FileType = "index"
 	ConfigFile   FileType = "config"
+	// MigrationFile stores the progress of an interrupted migration
+	MigrationFile FileType = "migration"
+	// ParityFile contains parity data for a group of pack files
+	ParityFile FileType = "parity"
+	// TombstoneFile records snapshots which have been removed by forget
+	TombstoneFile FileType = "tombstone"
 )
 
 // Handle is used to store and access data in a backend.
@@ -46,6 +52,9 @@ func (h Handle) Valid() error {
 	case SnapshotFile:
 	case IndexFile:
 	case ConfigFile:
+	case MigrationFile:
+	case ParityFile:
+	case TombstoneFile:
 	default:
 		return errors.Errorf("invalid Type %q", h.Type)
 	}
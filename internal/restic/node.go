@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"os"
 	"os/user"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -34,6 +36,7 @@ type Node struct {
 	ModTime            time.Time           `json:"mtime,omitempty"`
 	AccessTime         time.Time           `json:"atime,omitempty"`
 	ChangeTime         time.Time           `json:"ctime,omitempty"`
+	BirthTime          time.Time           `json:"-"` // creation time, saved as "btime" if known
 	UID                uint32              `json:"uid"`
 	GID                uint32              `json:"gid"`
 	User               string              `json:"user,omitempty"`
@@ -44,7 +47,8 @@ type Node struct {
 	Links              uint64              `json:"links,omitempty"`
 	LinkTarget         string              `json:"linktarget,omitempty"`
 	ExtendedAttributes []ExtendedAttribute `json:"extended_attributes,omitempty"`
-	Device             uint64              `json:"device,omitempty"` // in case of Type == "dev", stat.st_rdev
+	InodeFlags         uint32              `json:"inode_flags,omitempty"` // see InodeFlagsMask
+	Device             uint64              `json:"device,omitempty"`      // in case of Type == "dev", stat.st_rdev
 	Content            IDs                 `json:"content"`
 	Subtree            *ID                 `json:"subtree,omitempty"`
 
@@ -53,6 +57,45 @@ type Node struct {
 	Path string `json:"-"`
 }
 
+// Inode flags which are saved in Node.InodeFlags. The values are those of the
+// FS_*_FL flags on Linux, see chattr(1).
+const (
+	InodeFlagSync      = 0x00000008 // S: synchronous updates
+	InodeFlagImmutable = 0x00000010 // i: immutable
+	InodeFlagAppend    = 0x00000020 // a: append only
+	InodeFlagNoDump    = 0x00000040 // d: no dump
+	InodeFlagNoAtime   = 0x00000080 // A: no atime updates
+	InodeFlagDirSync   = 0x00010000 // D: synchronous directory updates
+
+	// InodeFlagsMask contains all inode flags which are saved and restored.
+	InodeFlagsMask = InodeFlagSync | InodeFlagImmutable | InodeFlagAppend |
+		InodeFlagNoDump | InodeFlagNoAtime | InodeFlagDirSync
+)
+
+var inodeFlagLetters = []struct {
+	flag   uint32
+	letter byte
+}{
+	{InodeFlagSync, 'S'},
+	{InodeFlagDirSync, 'D'},
+	{InodeFlagImmutable, 'i'},
+	{InodeFlagAppend, 'a'},
+	{InodeFlagNoDump, 'd'},
+	{InodeFlagNoAtime, 'A'},
+}
+
+// InodeFlagsString returns the letters used by chattr(1) for the flags, in
+// the order used by lsattr(1).
+func InodeFlagsString(flags uint32) string {
+	var s []byte
+	for _, f := range inodeFlagLetters {
+		if flags&f.flag != 0 {
+			s = append(s, f.letter)
+		}
+	}
+	return string(s)
+}
+
 // Nodes is a slice of nodes that can be sorted.
 type Nodes []*Node
 
@@ -182,6 +225,10 @@ func (node Node) RestoreMetadata(path string) error {
 	return err
 }
 
+// restoreMetadata restores the metadata in an order which does not undo
+// previous steps: lchown clears capabilities and setuid bits, chmod modifies
+// the mask entry of POSIX ACLs and the immutable and append-only flags
+// prevent all further changes.
 func (node Node) restoreMetadata(path string) error {
 	var firsterr error
 
@@ -200,22 +247,29 @@ func (node Node) restoreMetadata(path string) error {
 
 	if node.Type != "symlink" {
 		if err := fs.Chmod(path, node.Mode); err != nil {
-			if firsterr != nil {
+			if firsterr == nil {
 				firsterr = errors.Wrap(err, "Chmod")
 			}
 		}
 	}
 
+	if err := node.restoreExtendedAttributes(path); err != nil {
+		debug.Log("error restoring extended attributes for %v: %v", path, err)
+		if firsterr == nil {
+			firsterr = err
+		}
+	}
+
 	if err := node.RestoreTimestamps(path); err != nil {
 		debug.Log("error restoring timestamps for dir %v: %v", path, err)
-		if firsterr != nil {
+		if firsterr == nil {
 			firsterr = err
 		}
 	}
 
-	if err := node.restoreExtendedAttributes(path); err != nil {
-		debug.Log("error restoring extended attributes for %v: %v", path, err)
-		if firsterr != nil {
+	if err := node.RestoreInodeFlags(path); err != nil {
+		debug.Log("error restoring inode flags for %v: %v", path, err)
+		if firsterr == nil {
 			firsterr = err
 		}
 	}
@@ -223,6 +277,16 @@ func (node Node) restoreMetadata(path string) error {
 	return firsterr
 }
 
+// RestoreInodeFlags sets the inode flags of path. Nothing is done if the
+// node has no inode flags.
+func (node Node) RestoreInodeFlags(path string) error {
+	if node.InodeFlags == 0 {
+		return nil
+	}
+
+	return fs.SetInodeFlags(path, node.InodeFlags, InodeFlagsMask)
+}
+
 func (node Node) restoreExtendedAttributes(path string) error {
 	for _, attr := range node.ExtendedAttributes {
 		err := Setxattr(path, attr.Name, attr.Value)
@@ -348,18 +412,36 @@ func (node Node) MarshalJSON() ([]byte, error) {
 	name := strconv.Quote(node.Name)
 	nj.Name = name[1 : len(name)-1]
 
-	return json.Marshal(nj)
+	// the creation time is only saved if it is known, so that the trees of
+	// file systems without creation times do not change
+	var btime *time.Time
+	if !node.BirthTime.IsZero() {
+		t := FixTime(node.BirthTime)
+		btime = &t
+	}
+
+	return json.Marshal(struct {
+		nodeJSON
+		BirthTime *time.Time `json:"btime,omitempty"`
+	}{nj, btime})
 }
 
 func (node *Node) UnmarshalJSON(data []byte) error {
 	type nodeJSON Node
-	nj := (*nodeJSON)(node)
+	nj := struct {
+		*nodeJSON
+		BirthTime *time.Time `json:"btime,omitempty"`
+	}{nodeJSON: (*nodeJSON)(node)}
 
-	err := json.Unmarshal(data, nj)
+	err := json.Unmarshal(data, &nj)
 	if err != nil {
 		return errors.Wrap(err, "Unmarshal")
 	}
 
+	if nj.BirthTime != nil {
+		node.BirthTime = *nj.BirthTime
+	}
+
 	nj.Name, err = strconv.Unquote(`"` + nj.Name + `"`)
 	return errors.Wrap(err, "Unquote")
 }
@@ -383,6 +465,9 @@ func (node Node) Equals(other Node) bool {
 	if !node.ChangeTime.Equal(other.ChangeTime) {
 		return false
 	}
+	if !node.BirthTime.Equal(other.BirthTime) {
+		return false
+	}
 	if node.UID != other.UID {
 		return false
 	}
@@ -413,6 +498,9 @@ func (node Node) Equals(other Node) bool {
 	if node.Device != other.Device {
 		return false
 	}
+	if node.InodeFlags != other.InodeFlags {
+		return false
+	}
 	if !node.sameContent(other) {
 		return false
 	}
@@ -568,6 +656,11 @@ func lookupGroup(gid uint32) string {
 }
 
 func (node *Node) fillExtra(path string, fi os.FileInfo) error {
+	if entry, ok := fi.Sys().(*fs.TarEntry); ok {
+		node.fillTarEntry(entry)
+		return nil
+	}
+
 	stat, ok := toStatT(fi.Sys())
 	if !ok {
 		// fill minimal info with current values for uid, gid
@@ -612,9 +705,74 @@ func (node *Node) fillExtra(path string, fi os.FileInfo) error {
 		return err
 	}
 
+	node.fillInodeAttributes(path)
+
 	return nil
 }
 
+// fillInodeAttributes fills the inode flags and the creation time. Errors
+// are ignored, not all file systems support them.
+func (node *Node) fillInodeAttributes(path string) {
+	if node.Type == "file" || node.Type == "dir" {
+		flags, err := fs.GetInodeFlags(path)
+		if err != nil {
+			debug.Log("unable to read inode flags of %v: %v", path, err)
+		}
+		node.InodeFlags = flags & InodeFlagsMask
+	}
+
+	btime, err := fs.BirthTime(path)
+	if err != nil {
+		debug.Log("unable to read creation time of %v: %v", path, err)
+	}
+	node.BirthTime = btime
+}
+
+// paxXattrPrefix is the prefix of PAX records which contain extended attributes.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// fillTarEntry fills the node with the metadata from a tar header.
+func (node *Node) fillTarEntry(entry *fs.TarEntry) {
+	hdr := entry.Header
+	node.UID, node.GID = uint32(hdr.Uid), uint32(hdr.Gid)
+	node.User, node.Group = hdr.Uname, hdr.Gname
+	node.Inode = entry.Inode
+
+	node.AccessTime, node.ChangeTime = hdr.AccessTime, hdr.ChangeTime
+	if node.AccessTime.IsZero() {
+		node.AccessTime = node.ModTime
+	}
+	if node.ChangeTime.IsZero() {
+		node.ChangeTime = node.ModTime
+	}
+
+	switch node.Type {
+	case "file":
+		node.Links = entry.Links
+	case "symlink":
+		node.LinkTarget = hdr.Linkname
+		return
+	case "dev", "chardev":
+		node.Device = entry.Device
+	}
+
+	var names []string
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, paxXattrPrefix) {
+			names = append(names, key)
+		}
+	}
+	sort.Strings(names)
+
+	node.ExtendedAttributes = make([]ExtendedAttribute, 0, len(names))
+	for _, key := range names {
+		node.ExtendedAttributes = append(node.ExtendedAttributes, ExtendedAttribute{
+			Name:  strings.TrimPrefix(key, paxXattrPrefix),
+			Value: []byte(hdr.PAXRecords[key]),
+		})
+	}
+}
+
 func (node *Node) fillExtendedAttributes(path string) error {
 	if node.Type == "symlink" {
 		return nil
@@ -1,6 +1,8 @@
 package restic_test
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"io/ioutil"
 	"os"
@@ -9,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
 )
@@ -296,3 +299,51 @@ func TestFixTime(t *testing.T) {
 		})
 	}
 }
+
+func TestNodeFromTarEntry(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	rtest.OK(t, tw.WriteHeader(&tar.Header{
+		Name: "file", Typeflag: tar.TypeReg, Mode: 0640, Size: 3, ModTime: mtime,
+		Uid: 1000, Gid: 100, Uname: "user", Gname: "users", Format: tar.FormatPAX,
+		PAXRecords: map[string]string{"SCHILY.xattr.user.b": "2", "SCHILY.xattr.user.a": "1", "comment": "x"},
+	}))
+	_, err := tw.Write([]byte("foo"))
+	rtest.OK(t, err)
+	rtest.OK(t, tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "file"}))
+	rtest.OK(t, tw.WriteHeader(&tar.Header{Name: "symlink", Typeflag: tar.TypeSymlink, Linkname: "file", Mode: 0777, ModTime: mtime}))
+	rtest.OK(t, tw.Close())
+
+	tarFS, err := fs.NewTar(buf)
+	rtest.OK(t, err)
+	defer func() { rtest.OK(t, tarFS.Close()) }()
+
+	fi, err := tarFS.Lstat("/file")
+	rtest.OK(t, err)
+	node, err := restic.NodeFromFileInfo("/file", fi)
+	rtest.OK(t, err)
+
+	rtest.Equals(t, "file", node.Type)
+	rtest.Equals(t, os.FileMode(0640), node.Mode)
+	rtest.Equals(t, uint64(3), node.Size)
+	rtest.Equals(t, uint64(2), node.Links)
+	rtest.Equals(t, uint32(1000), node.UID)
+	rtest.Equals(t, uint32(100), node.GID)
+	rtest.Equals(t, "user", node.User)
+	rtest.Equals(t, "users", node.Group)
+	rtest.Assert(t, node.ModTime.Equal(mtime), "wrong mtime %v", node.ModTime)
+	rtest.Assert(t, node.ChangeTime.Equal(mtime), "wrong ctime %v", node.ChangeTime)
+	rtest.Equals(t, []restic.ExtendedAttribute{
+		{Name: "user.a", Value: []byte("1")},
+		{Name: "user.b", Value: []byte("2")},
+	}, node.ExtendedAttributes)
+
+	fi, err = tarFS.Lstat("/symlink")
+	rtest.OK(t, err)
+	node, err = restic.NodeFromFileInfo("/symlink", fi)
+	rtest.OK(t, err)
+	rtest.Equals(t, "symlink", node.Type)
+	rtest.Equals(t, "file", node.LinkTarget)
+}
@@ -23,6 +23,8 @@ type Repository interface {
 	LoadIndex(context.Context) error
 
 	Config() Config
+	// PackSize returns the target size of pack files in bytes.
+	PackSize() uint
 
 	LookupBlobSize(ID, BlobType) (uint, bool)
 
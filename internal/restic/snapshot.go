@@ -5,12 +5,14 @@ import (
 	"fmt"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
 
 	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
 )
 
 // Snapshot is the state of a resource at one point in time.
@@ -27,9 +29,57 @@ type Snapshot struct {
 	Tags     []string  `json:"tags,omitempty"`
 	Original *ID       `json:"original,omitempty"`
 
+	// Description and Labels are set by the user to describe the snapshot.
+	// Like the tags, the labels can be modified later.
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+
+	// Command is the command whose standard output was saved, if the
+	// snapshot was created with --stdin-from-command.
+	Command []string `json:"command,omitempty"`
+
+	// ChainPrev is the content hash of the previous snapshot of the same
+	// host and paths, ChainDigest the running digest of the chain.
+	ChainPrev   *ID `json:"chain_prev,omitempty"`
+	ChainDigest *ID `json:"chain_digest,omitempty"`
+
+	// Incomplete is set for checkpoints saved periodically during a
+	// backup. They only contain the files and directories completed so far
+	// and are removed once the backup is complete.
+	Incomplete bool `json:"incomplete,omitempty"`
+
+	// Summary contains statistics about the backup which created the
+	// snapshot. It is not set for snapshots created by other commands.
+	Summary *SnapshotSummary `json:"summary,omitempty"`
+
 	id *ID // plaintext ID, used during restore
 }
 
+// SnapshotSummary contains statistics about the backup which created a
+// snapshot.
+type SnapshotSummary struct {
+	BackupStart time.Time `json:"backup_start"`
+	BackupEnd   time.Time `json:"backup_end"`
+
+	FilesNew        uint `json:"files_new"`
+	FilesChanged    uint `json:"files_changed"`
+	FilesUnmodified uint `json:"files_unmodified"`
+	DirsNew         uint `json:"dirs_new"`
+	DirsChanged     uint `json:"dirs_changed"`
+	DirsUnmodified  uint `json:"dirs_unmodified"`
+
+	DataBlobs      int    `json:"data_blobs"`
+	TreeBlobs      int    `json:"tree_blobs"`
+	DataAdded      uint64 `json:"data_added"`
+	FilesProcessed uint   `json:"total_files_processed"`
+	BytesProcessed uint64 `json:"total_bytes_processed"`
+}
+
+// Duration returns how long the backup took.
+func (s *SnapshotSummary) Duration() time.Duration {
+	return s.BackupEnd.Sub(s.BackupStart)
+}
+
 // NewSnapshot returns an initialized snapshot struct for the current user and
 // time.
 func NewSnapshot(paths []string, tags []string, hostname string, time time.Time) (*Snapshot, error) {
@@ -261,6 +311,81 @@ func (sn *Snapshot) HasHostname(hostnames []string) bool {
 	return false
 }
 
+// ParseLabels parses labels in the format "key=value". The value may be
+// empty, the key must not. For an empty list, nil is returned.
+func ParseLabels(labels []string) (map[string]string, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+
+	res := make(map[string]string, len(labels))
+	for _, label := range labels {
+		key, value := splitLabel(label)
+		if key == "" || !strings.Contains(label, "=") {
+			return nil, errors.Errorf("invalid label %q, must be key=value", label)
+		}
+		res[key] = value
+	}
+	return res, nil
+}
+
+// splitLabel splits label at the first "=".
+func splitLabel(label string) (key, value string) {
+	if i := strings.Index(label, "="); i >= 0 {
+		return label[:i], label[i+1:]
+	}
+	return label, ""
+}
+
+// SetLabels sets the given labels, existing labels with the same key are
+// replaced. It returns true if any changes were made.
+func (sn *Snapshot) SetLabels(labels map[string]string) (changed bool) {
+	for key, value := range labels {
+		if old, ok := sn.Labels[key]; ok && old == value {
+			continue
+		}
+		if sn.Labels == nil {
+			sn.Labels = make(map[string]string)
+		}
+		sn.Labels[key] = value
+		changed = true
+	}
+	return
+}
+
+// RemoveLabels removes the labels with the given keys and returns true if
+// any changes were made.
+func (sn *Snapshot) RemoveLabels(keys []string) (changed bool) {
+	for _, key := range keys {
+		if _, ok := sn.Labels[key]; ok {
+			delete(sn.Labels, key)
+			changed = true
+		}
+	}
+	if len(sn.Labels) == 0 {
+		sn.Labels = nil
+	}
+	return
+}
+
+// HasLabels returns true if the snapshot matches all filters in l. A filter
+// "key=value" matches if the label key has the given value, a filter "key"
+// matches if the label key is set at all.
+func (sn *Snapshot) HasLabels(l []string) bool {
+	for _, filter := range l {
+		key, value := splitLabel(filter)
+		snValue, ok := sn.Labels[key]
+		if !ok {
+			return false
+		}
+		if strings.Contains(filter, "=") && snValue != value {
+			return false
+		}
+	}
+
+	return true
+}
+
 // Snapshots is a list of snapshots.
 type Snapshots []*Snapshot
 
@@ -0,0 +1,579 @@
+package restic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// The snapshots of each host and set of paths form a hash chain: every new
+// snapshot records the content hash of the previous snapshot of its group
+// and a running digest over the content hashes of all snapshots of the
+// group. Removing, replacing or modifying a snapshot therefore breaks the
+// chain, unless the snapshot is listed in a tombstone, which forget saves for
+// the removed snapshots. As nothing refers to the newest snapshot of a group,
+// saving a snapshot also records the newest snapshot of each group in a
+// tombstone, so that its removal is detected as well.
+//
+// Tombstones are not signed, they are encrypted with the master key like all
+// other files. Every key which holds the master key can therefore save a
+// tombstone, so the chain does not protect against the holders of such keys.
+
+// ContentHash returns the hash of the snapshot's content, which includes all
+// fields except the tags, the labels and the chain digest. It does not change
+// when the tags or labels of a snapshot are modified.
+func (sn *Snapshot) ContentHash() ID {
+	content := *sn
+	content.Paths = append([]string(nil), sn.Paths...)
+	sort.Strings(content.Paths)
+	content.Tags = nil
+	content.Labels = nil
+	content.ChainDigest = nil
+
+	buf, err := json.Marshal(content)
+	if err != nil {
+		panic(err)
+	}
+	return Hash(buf)
+}
+
+// chainDigest returns the running digest for a snapshot with the content hash
+// hash, following a snapshot with the digest prev.
+func chainDigest(prev ID, hash ID) ID {
+	buf := make([]byte, 0, 2*len(prev))
+	buf = append(buf, prev[:]...)
+	buf = append(buf, hash[:]...)
+	return Hash(buf)
+}
+
+// chainGroup returns the key of the chain a snapshot belongs to.
+func chainGroup(hostname string, paths []string) string {
+	paths = append([]string(nil), paths...)
+	sort.Strings(paths)
+	return hostname + "\x00" + strings.Join(paths, "\x00")
+}
+
+// splitChainGroup returns the host and paths of the group key.
+func splitChainGroup(group string) (hostname string, paths []string) {
+	parts := strings.Split(group, "\x00")
+	return parts[0], parts[1:]
+}
+
+// Tombstone records snapshots which have been removed by forget, so that
+// these removals are not reported as gaps in the chain. Heads records the newest
+// snapshot of each group at the time the tombstone was written, Rewrites the
+// snapshots whose content hash was changed by a command.
+type Tombstone struct {
+	Time      time.Time        `json:"time"`
+	Hostname  string           `json:"hostname,omitempty"`
+	Username  string           `json:"username,omitempty"`
+	Reason    string           `json:"reason"`
+	Snapshots []TombstoneEntry `json:"snapshots"`
+	Heads     []TombstoneEntry `json:"heads,omitempty"`
+	Rewrites  []ChainRewrite   `json:"rewrites,omitempty"`
+}
+
+// ChainRewrite records that a chained snapshot was saved again with a
+// different content hash. This happens when the references to other
+// snapshots change, e.g. when tag sets the original snapshot ID or the
+// snapshot IDs change because the master key is rotated.
+type ChainRewrite struct {
+	Hash    ID `json:"hash"`
+	NewHash ID `json:"new_hash"`
+}
+
+// TombstoneEntry describes a removed snapshot or the newest snapshot of a
+// group.
+type TombstoneEntry struct {
+	ID          ID        `json:"id"`
+	Time        time.Time `json:"time"`
+	Hostname    string    `json:"hostname,omitempty"`
+	Paths       []string  `json:"paths"`
+	Hash        ID        `json:"hash"`
+	ChainPrev   *ID       `json:"chain_prev,omitempty"`
+	ChainDigest *ID       `json:"chain_digest,omitempty"`
+}
+
+// NewTombstone returns a tombstone for the removed snapshots.
+func NewTombstone(reason string, snapshots Snapshots) *Tombstone {
+	t := &Tombstone{
+		Time:   time.Now(),
+		Reason: reason,
+	}
+	t.Hostname, _ = os.Hostname()
+	if usr, err := user.Current(); err == nil {
+		t.Username = usr.Username
+	}
+
+	for _, sn := range snapshots {
+		var id ID
+		if sn.ID() != nil {
+			id = *sn.ID()
+		}
+		t.Snapshots = append(t.Snapshots, newTombstoneEntry(id, sn))
+	}
+
+	return t
+}
+
+func newTombstoneEntry(id ID, sn *Snapshot) TombstoneEntry {
+	return TombstoneEntry{
+		ID:          id,
+		Time:        sn.Time,
+		Hostname:    sn.Hostname,
+		Paths:       sn.Paths,
+		Hash:        sn.ContentHash(),
+		ChainPrev:   sn.ChainPrev,
+		ChainDigest: sn.ChainDigest,
+	}
+}
+
+// SaveTombstone saves the tombstone in the repository.
+func SaveTombstone(ctx context.Context, repo Repository, t *Tombstone) (ID, error) {
+	return repo.SaveJSONUnpacked(ctx, TombstoneFile, t)
+}
+
+// SaveChainRewrites records that the snapshots with the content hashes of the
+// rewrites have been modified, before the modified snapshots are saved.
+func SaveChainRewrites(ctx context.Context, repo Repository, reason string, rewrites []ChainRewrite) error {
+	if len(rewrites) == 0 {
+		return nil
+	}
+
+	t := NewTombstone(reason, nil)
+	t.Rewrites = rewrites
+	_, err := SaveTombstone(ctx, repo, t)
+	return err
+}
+
+// chainEntry is a snapshot or a removed snapshot in the chain.
+type chainEntry struct {
+	group     string
+	time      time.Time
+	hash      ID
+	prev      *ID
+	digest    ID
+	id        *ID // storage ID of the snapshot, if known
+	tombstone bool
+}
+
+func (e *chainEntry) String() string {
+	var name string
+	if e.id != nil {
+		name = e.id.Str()
+	} else {
+		name = "with hash " + e.hash.Str()
+	}
+	if e.tombstone {
+		return "removed snapshot " + name
+	}
+	return "snapshot " + name
+}
+
+// SnapshotChain contains the chained snapshots and removed snapshots of a
+// repository.
+type SnapshotChain struct {
+	entries []*chainEntry
+	byHash  map[ID]*chainEntry
+
+	// heads are the newest snapshots of the groups recorded in the
+	// tombstones, records the IDs of the tombstones which do not record
+	// removed snapshots
+	heads   []*chainEntry
+	records IDs
+
+	// rewritten maps the content hash of a rewritten snapshot to its
+	// previous content hash
+	rewritten map[ID]ID
+}
+
+// NewSnapshotChain returns an empty chain.
+func NewSnapshotChain() *SnapshotChain {
+	return &SnapshotChain{
+		byHash:    make(map[ID]*chainEntry),
+		rewritten: make(map[ID]ID),
+	}
+}
+
+// chainHash returns the content hash the snapshot with the content hash hash
+// was chained with, following the recorded rewrites.
+func (c *SnapshotChain) chainHash(hash ID) ID {
+	seen := NewIDSet()
+	for !seen.Has(hash) {
+		seen.Insert(hash)
+		prev, ok := c.rewritten[hash]
+		if !ok {
+			break
+		}
+		hash = prev
+	}
+	return hash
+}
+
+// LoadSnapshotChain loads all snapshots and tombstones of the repository.
+func LoadSnapshotChain(ctx context.Context, repo Repository) (*SnapshotChain, error) {
+	var snapshots Snapshots
+	err := ForAllSnapshots(ctx, repo, nil, func(id ID, sn *Snapshot, err error) error {
+		if err != nil {
+			return errors.Wrapf(err, "load snapshot %v", id.Str())
+		}
+		snapshots = append(snapshots, sn)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return BuildSnapshotChain(ctx, repo, snapshots)
+}
+
+// BuildSnapshotChain returns the chain of the snapshots, which have been
+// loaded from repo before, and the tombstones of the repository.
+func BuildSnapshotChain(ctx context.Context, repo Repository, snapshots Snapshots) (*SnapshotChain, error) {
+	c := NewSnapshotChain()
+	for _, sn := range snapshots {
+		c.AddSnapshot(*sn.ID(), sn)
+	}
+
+	err := repo.List(ctx, TombstoneFile, func(id ID, size int64) error {
+		var t Tombstone
+		err := repo.LoadJSONUnpacked(ctx, TombstoneFile, id, &t)
+		if err != nil && repo.Backend().IsNotExist(errors.Cause(err)) {
+			// a record which has been replaced by a concurrent backup
+			debug.Log("tombstone %v has been removed", id.Str())
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "load tombstone %v", id.Str())
+		}
+		c.AddTombstone(&t)
+		if len(t.Snapshots) == 0 {
+			c.records = append(c.records, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *SnapshotChain) add(e *chainEntry) {
+	e.hash = c.chainHash(e.hash)
+	if old, ok := c.byHash[e.hash]; ok {
+		// a snapshot whose removal by forget was interrupted is listed twice
+		if !old.tombstone || e.tombstone {
+			return
+		}
+		*old = *e
+		return
+	}
+
+	c.entries = append(c.entries, e)
+	c.byHash[e.hash] = e
+}
+
+// remove removes the entry e from the chain.
+func (c *SnapshotChain) remove(e *chainEntry) {
+	for i, other := range c.entries {
+		if other == e {
+			c.entries = append(c.entries[:i], c.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// AddSnapshot adds the snapshot with the storage ID id to the chain.
+// Snapshots without chain digest are ignored.
+func (c *SnapshotChain) AddSnapshot(id ID, sn *Snapshot) {
+	if sn.ChainDigest == nil {
+		debug.Log("snapshot %v is not chained", id.Str())
+		return
+	}
+
+	c.add(&chainEntry{
+		group:  chainGroup(sn.Hostname, sn.Paths),
+		time:   sn.Time,
+		hash:   sn.ContentHash(),
+		prev:   sn.ChainPrev,
+		digest: *sn.ChainDigest,
+		id:     &id,
+	})
+}
+
+// AddTombstone adds the snapshots removed by forget, the recorded newest
+// snapshots of the groups and the rewritten snapshots to the chain.
+func (c *SnapshotChain) AddTombstone(t *Tombstone) {
+	for _, rw := range t.Rewrites {
+		if rw.Hash == rw.NewHash {
+			continue
+		}
+		c.rewritten[rw.NewHash] = rw.Hash
+
+		// the rewritten snapshot may have been added already
+		if e, ok := c.byHash[rw.NewHash]; ok {
+			delete(c.byHash, rw.NewHash)
+			c.remove(e)
+			c.add(e)
+		}
+	}
+
+	for _, ts := range t.Snapshots {
+		if ts.ChainDigest == nil {
+			continue
+		}
+
+		e := newTombstoneChainEntry(ts)
+		e.tombstone = true
+		c.add(e)
+	}
+
+	for _, ts := range t.Heads {
+		if ts.ChainDigest == nil {
+			continue
+		}
+		c.heads = append(c.heads, newTombstoneChainEntry(ts))
+	}
+}
+
+func newTombstoneChainEntry(ts TombstoneEntry) *chainEntry {
+	id := ts.ID
+	return &chainEntry{
+		group:  chainGroup(ts.Hostname, ts.Paths),
+		time:   ts.Time,
+		hash:   ts.Hash,
+		prev:   ts.ChainPrev,
+		digest: *ts.ChainDigest,
+		id:     &id,
+	}
+}
+
+// Heads returns the newest snapshot of each group, for recording them in a
+// tombstone. Recorded newest snapshots which are missing are included as
+// well, so that their removal is still detected.
+func (c *SnapshotChain) Heads() []TombstoneEntry {
+	var heads []TombstoneEntry
+	missing := NewIDSet()
+	for _, h := range c.heads {
+		hash := c.chainHash(h.hash)
+		if _, ok := c.byHash[hash]; ok || missing.Has(hash) {
+			continue
+		}
+		missing.Insert(hash)
+		heads = append(heads, newChainEntryTombstone(h))
+	}
+
+	seen := make(map[string]struct{})
+	for _, e := range c.entries {
+		if _, ok := seen[e.group]; ok {
+			continue
+		}
+		seen[e.group] = struct{}{}
+
+		heads = append(heads, newChainEntryTombstone(c.head(e.group)))
+	}
+	return heads
+}
+
+func newChainEntryTombstone(e *chainEntry) TombstoneEntry {
+	digest := e.digest
+	ts := TombstoneEntry{
+		Time:        e.time,
+		Hash:        e.hash,
+		ChainPrev:   e.prev,
+		ChainDigest: &digest,
+	}
+	ts.Hostname, ts.Paths = splitChainGroup(e.group)
+	if e.id != nil {
+		ts.ID = *e.id
+	}
+	return ts
+}
+
+// Rewrites returns all recorded rewrites of snapshots.
+func (c *SnapshotChain) Rewrites() []ChainRewrite {
+	var rewrites []ChainRewrite
+	for newHash, hash := range c.rewritten {
+		rewrites = append(rewrites, ChainRewrite{Hash: hash, NewHash: newHash})
+	}
+	sort.Slice(rewrites, func(i, j int) bool {
+		return rewrites[i].NewHash.String() < rewrites[j].NewHash.String()
+	})
+	return rewrites
+}
+
+// SaveHead records the snapshot sn, which has been linked by Link and saved
+// with the ID id, as the newest snapshot of its group. Without such a record,
+// removing the newest snapshot of a group cannot be detected. The tombstone
+// records the newest snapshots of all groups and all rewrites, the records
+// saved before are removed.
+func (c *SnapshotChain) SaveHead(ctx context.Context, repo Repository, id ID, sn *Snapshot) error {
+	if e, ok := c.byHash[c.chainHash(sn.ContentHash())]; ok {
+		e.id = &id
+	}
+
+	t := NewTombstone("head", nil)
+	t.Heads = c.Heads()
+	t.Rewrites = c.Rewrites()
+	recordID, err := SaveTombstone(ctx, repo, t)
+	if err != nil {
+		return err
+	}
+
+	// concurrent backups may remove the same records, and removing them
+	// fails for append-only repositories, which just keep them
+	records := IDs{recordID}
+	for _, old := range c.records {
+		err := repo.Backend().Remove(ctx, Handle{Type: TombstoneFile, Name: old.String()})
+		if err != nil && !repo.Backend().IsNotExist(err) {
+			debug.Log("unable to remove tombstone %v: %v", old.Str(), err)
+			records = append(records, old)
+		}
+	}
+	c.records = records
+	return nil
+}
+
+// head returns the newest entry of the group which has no successor.
+func (c *SnapshotChain) head(group string) *chainEntry {
+	referenced := NewIDSet()
+	for _, e := range c.entries {
+		if e.group == group && e.prev != nil {
+			referenced.Insert(*e.prev)
+		}
+	}
+
+	var head *chainEntry
+	for _, e := range c.entries {
+		if e.group != group || referenced.Has(e.hash) {
+			continue
+		}
+		if head == nil || e.time.After(head.time) {
+			head = e
+		}
+	}
+	return head
+}
+
+// Link sets the chain fields of the new snapshot sn, such that it follows
+// the newest snapshot of the same host and paths, and adds sn to the chain.
+func (c *SnapshotChain) Link(sn *Snapshot) {
+	group := chainGroup(sn.Hostname, sn.Paths)
+
+	var prevDigest ID
+	sn.ChainPrev, sn.ChainDigest = nil, nil
+	if head := c.head(group); head != nil {
+		prev := head.hash
+		sn.ChainPrev = &prev
+		prevDigest = head.digest
+	}
+
+	hash := sn.ContentHash()
+	digest := chainDigest(prevDigest, hash)
+	sn.ChainDigest = &digest
+
+	c.add(&chainEntry{
+		group:  group,
+		time:   sn.Time,
+		hash:   hash,
+		prev:   sn.ChainPrev,
+		digest: digest,
+	})
+}
+
+// ChainProblem describes an inconsistency in the snapshot chain.
+type ChainProblem struct {
+	// Kind is one of "gap", "fork" or "rewritten".
+	Kind string
+	// Snapshot is the storage ID of the affected snapshot.
+	Snapshot ID
+	Msg      string
+}
+
+func (p ChainProblem) Error() string {
+	return fmt.Sprintf("%v: %v", p.Kind, p.Msg)
+}
+
+// Verify checks the chain and returns all problems found. A gap is a
+// snapshot which is missing and not listed in a tombstone, including a
+// recorded newest snapshot of a group, a fork a
+// snapshot with several successors and a rewritten snapshot one whose
+// content does not match the chain digest.
+func (c *SnapshotChain) Verify() []ChainProblem {
+	entries := append([]*chainEntry(nil), c.entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].time.Before(entries[j].time)
+	})
+
+	var problems []ChainProblem
+	report := func(kind string, e *chainEntry, format string, args ...interface{}) {
+		p := ChainProblem{Kind: kind, Msg: e.String() + " " + fmt.Sprintf(format, args...)}
+		if e.id != nil {
+			p.Snapshot = *e.id
+		}
+		problems = append(problems, p)
+	}
+
+	successors := make(map[ID][]*chainEntry)
+	roots := make(map[string][]*chainEntry)
+	for _, e := range entries {
+		var prevDigest ID
+		if e.prev == nil {
+			roots[e.group] = append(roots[e.group], e)
+		} else {
+			prev, ok := c.byHash[*e.prev]
+			if !ok {
+				report("gap", e, "follows a snapshot with hash %v which is missing and not listed in a tombstone", e.prev.Str())
+				continue
+			}
+			if prev.group != e.group {
+				report("rewritten", e, "follows %v of a different host or set of paths", prev)
+				continue
+			}
+			successors[prev.hash] = append(successors[prev.hash], e)
+			prevDigest = prev.digest
+		}
+
+		if chainDigest(prevDigest, e.hash) != e.digest {
+			report("rewritten", e, "does not match its chain digest")
+		}
+	}
+
+	reported := NewIDSet()
+	for _, h := range c.heads {
+		if _, ok := c.byHash[c.chainHash(h.hash)]; ok || reported.Has(h.hash) {
+			continue
+		}
+		reported.Insert(h.hash)
+		hostname, paths := splitChainGroup(h.group)
+		report("gap", h, "was the newest snapshot of host %q and paths %v, but is missing and not listed in a tombstone", hostname, paths)
+	}
+
+	for _, e := range entries {
+		if next := successors[e.hash]; len(next) > 1 {
+			report("fork", e, "is followed by %d snapshots: %v", len(next), chainEntryList(next))
+		}
+		if r := roots[e.group]; len(r) > 1 && r[0] == e {
+			report("fork", e, "starts a chain, but %d other snapshots also do: %v", len(r)-1, chainEntryList(r[1:]))
+		}
+	}
+
+	return problems
+}
+
+func chainEntryList(entries []*chainEntry) string {
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.String())
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,194 @@
+package restic_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// testChain links n snapshots of the same host and paths and returns them
+// with their IDs.
+func testChain(t *testing.T, n int) (restic.Snapshots, restic.IDs) {
+	chain := restic.NewSnapshotChain()
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var snapshots restic.Snapshots
+	var ids restic.IDs
+	for i := 0; i < n; i++ {
+		sn, err := restic.NewSnapshot([]string{"/home/user"}, nil, "host", start.Add(time.Duration(i)*time.Hour))
+		rtest.OK(t, err)
+		tree := restic.NewRandomID()
+		sn.Tree = &tree
+
+		chain.Link(sn)
+		snapshots = append(snapshots, sn)
+		ids = append(ids, restic.NewRandomID())
+	}
+	return snapshots, ids
+}
+
+func verifyChain(snapshots restic.Snapshots, ids restic.IDs, tombstones ...*restic.Tombstone) []restic.ChainProblem {
+	chain := restic.NewSnapshotChain()
+	for i, sn := range snapshots {
+		if sn != nil {
+			chain.AddSnapshot(ids[i], sn)
+		}
+	}
+	for _, t := range tombstones {
+		chain.AddTombstone(t)
+	}
+	return chain.Verify()
+}
+
+func problemKinds(problems []restic.ChainProblem) []string {
+	var kinds []string
+	for _, p := range problems {
+		kinds = append(kinds, p.Kind)
+	}
+	return kinds
+}
+
+func TestSnapshotChain(t *testing.T) {
+	snapshots, ids := testChain(t, 4)
+	rtest.Assert(t, snapshots[0].ChainPrev == nil, "first snapshot has a predecessor")
+	for i := 1; i < len(snapshots); i++ {
+		rtest.Equals(t, snapshots[i-1].ContentHash(), *snapshots[i].ChainPrev)
+	}
+	rtest.Equals(t, 0, len(verifyChain(snapshots, ids)))
+
+	// changing the tags does not affect the chain
+	snapshots[1].AddTags([]string{"foo"})
+	rtest.Equals(t, 0, len(verifyChain(snapshots, ids)))
+
+	// removing the newest snapshot cannot be detected without a record
+	rtest.Equals(t, 0, len(verifyChain(snapshots[:3], ids)))
+}
+
+func TestSnapshotChainHead(t *testing.T) {
+	snapshots, ids := testChain(t, 4)
+
+	chain := restic.NewSnapshotChain()
+	for i, sn := range snapshots {
+		chain.AddSnapshot(ids[i], sn)
+	}
+	record := restic.NewTombstone("head", nil)
+	record.Heads = chain.Heads()
+	rtest.Equals(t, 1, len(record.Heads))
+	rtest.Equals(t, ids[3], record.Heads[0].ID)
+	rtest.Equals(t, 0, len(verifyChain(snapshots, ids, record)))
+
+	// removing the recorded newest snapshot is a gap
+	problems := verifyChain(snapshots[:3], ids, record)
+	rtest.Equals(t, []string{"gap"}, problemKinds(problems))
+	rtest.Equals(t, ids[3], problems[0].Snapshot)
+
+	// unless it was removed by forget
+	tombstone := restic.NewTombstone("forget", restic.Snapshots{snapshots[3]})
+	rtest.Equals(t, 0, len(verifyChain(snapshots[:3], ids, record, tombstone)))
+
+	// the heads of a chain with a missing newest snapshot still contain it
+	chain = restic.NewSnapshotChain()
+	for i, sn := range snapshots[:3] {
+		chain.AddSnapshot(ids[i], sn)
+	}
+	chain.AddTombstone(record)
+	record = restic.NewTombstone("head", nil)
+	record.Heads = chain.Heads()
+	rtest.Equals(t, []string{"gap"}, problemKinds(verifyChain(snapshots[:3], ids, record)))
+}
+
+func TestSnapshotChainGap(t *testing.T) {
+	snapshots, ids := testChain(t, 4)
+	removed := snapshots[1]
+	snapshots[1] = nil
+
+	problems := verifyChain(snapshots, ids)
+	rtest.Equals(t, []string{"gap"}, problemKinds(problems))
+	rtest.Equals(t, ids[2], problems[0].Snapshot)
+
+	// a snapshot removed by forget is no gap
+	tombstone := restic.NewTombstone("forget", restic.Snapshots{removed})
+	rtest.Equals(t, 0, len(verifyChain(snapshots, ids, tombstone)))
+
+	// a tombstone for a snapshot which still exists is ignored
+	snapshots[1] = removed
+	rtest.Equals(t, 0, len(verifyChain(snapshots, ids, tombstone)))
+}
+
+func TestSnapshotChainRewritten(t *testing.T) {
+	snapshots, ids := testChain(t, 3)
+	tree := restic.NewRandomID()
+	snapshots[1].Tree = &tree
+
+	// the modified snapshot does not match its digest, and its successor
+	// refers to a snapshot which no longer exists
+	rtest.Equals(t, []string{"rewritten", "gap"}, problemKinds(verifyChain(snapshots, ids)))
+
+	// all fields except the tags and labels are covered by the chain
+	for _, modify := range []func(sn *restic.Snapshot){
+		func(sn *restic.Snapshot) { sn.Parent = &ids[0] },
+		func(sn *restic.Snapshot) { sn.Original = &ids[0] },
+		func(sn *restic.Snapshot) { sn.Description = "modified" },
+		func(sn *restic.Snapshot) { sn.Summary = &restic.SnapshotSummary{FilesNew: 1} },
+	} {
+		snapshots, ids := testChain(t, 3)
+		modify(snapshots[1])
+		rtest.Equals(t, []string{"rewritten", "gap"}, problemKinds(verifyChain(snapshots, ids)))
+	}
+}
+
+func TestSnapshotChainRewrites(t *testing.T) {
+	snapshots, ids := testChain(t, 3)
+	hash := snapshots[1].ContentHash()
+	snapshots[1].Original = &ids[1]
+
+	// a recorded rewrite keeps the chain intact
+	record := restic.NewTombstone("tag", nil)
+	record.Rewrites = []restic.ChainRewrite{{Hash: hash, NewHash: snapshots[1].ContentHash()}}
+	rtest.Equals(t, 0, len(verifyChain(snapshots, ids, record)))
+
+	// also when the rewritten snapshot is removed by forget later
+	tombstone := restic.NewTombstone("forget", restic.Snapshots{snapshots[1]})
+	snapshots[1] = nil
+	rtest.Equals(t, 0, len(verifyChain(snapshots, ids, record, tombstone)))
+}
+
+func TestSnapshotChainFork(t *testing.T) {
+	snapshots, ids := testChain(t, 3)
+
+	// a snapshot which was linked to the second snapshot while the third
+	// one already existed
+	chain := restic.NewSnapshotChain()
+	chain.AddSnapshot(ids[0], snapshots[0])
+	chain.AddSnapshot(ids[1], snapshots[1])
+	sn, err := restic.NewSnapshot([]string{"/home/user"}, nil, "host", snapshots[2].Time.Add(time.Minute))
+	rtest.OK(t, err)
+	chain.Link(sn)
+
+	snapshots = append(snapshots, sn)
+	ids = append(ids, restic.NewRandomID())
+	problems := verifyChain(snapshots, ids)
+	rtest.Equals(t, []string{"fork"}, problemKinds(problems))
+	rtest.Equals(t, ids[1], problems[0].Snapshot)
+}
+
+func TestSnapshotChainGroups(t *testing.T) {
+	chain := restic.NewSnapshotChain()
+	var snapshots restic.Snapshots
+	var ids restic.IDs
+	for i, host := range []string{"foo", "bar", "foo", "bar"} {
+		sn, err := restic.NewSnapshot([]string{"/home/user"}, nil, host, time.Unix(int64(i), 0))
+		rtest.OK(t, err)
+		chain.Link(sn)
+		snapshots = append(snapshots, sn)
+		ids = append(ids, restic.NewRandomID())
+	}
+
+	// snapshots of other hosts have their own chain
+	rtest.Assert(t, snapshots[1].ChainPrev == nil, "first snapshot of host bar has a predecessor")
+	rtest.Equals(t, snapshots[0].ContentHash(), *snapshots[2].ChainPrev)
+	rtest.Equals(t, snapshots[1].ContentHash(), *snapshots[3].ChainPrev)
+	rtest.Equals(t, 0, len(verifyChain(snapshots, ids)))
+}
@@ -13,8 +13,34 @@ import (
 // ErrNoSnapshotFound is returned when no snapshot for the given criteria could be found.
 var ErrNoSnapshotFound = errors.New("no snapshot found")
 
-// FindLatestSnapshot finds latest snapshot with optional target/directory, tags and hostname filters.
-func FindLatestSnapshot(ctx context.Context, repo Repository, targets []string, tagLists []TagList, hostnames []string) (ID, error) {
+// FindLatestSnapshot finds latest snapshot with optional target/directory, tags, hostname and label filters.
+// Incomplete checkpoints are ignored.
+func FindLatestSnapshot(ctx context.Context, repo Repository, targets []string, tagLists []TagList, hostnames []string, labels []string) (ID, error) {
+	return findLatestSnapshot(targets, tagLists, hostnames, labels, func(fn func(ID, *Snapshot, error) error) error {
+		return ForAllSnapshots(ctx, repo, nil, func(id ID, sn *Snapshot, err error) error {
+			if err == nil && sn.Incomplete {
+				return nil
+			}
+			return fn(id, sn, err)
+		})
+	})
+}
+
+// FindLatestSnapshotIn is like FindLatestSnapshot, but searches the already
+// loaded snapshots. Incomplete checkpoints are included, so that an
+// interrupted backup can use them as the parent.
+func FindLatestSnapshotIn(snapshots Snapshots, targets []string, tagLists []TagList, hostnames []string) (ID, error) {
+	return findLatestSnapshot(targets, tagLists, hostnames, nil, func(fn func(ID, *Snapshot, error) error) error {
+		for _, sn := range snapshots {
+			if err := fn(*sn.ID(), sn, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func findLatestSnapshot(targets []string, tagLists []TagList, hostnames []string, labels []string, forAll func(fn func(ID, *Snapshot, error) error) error) (ID, error) {
 	var err error
 	absTargets := make([]string, 0, len(targets))
 	for _, target := range targets {
@@ -33,7 +59,7 @@ func FindLatestSnapshot(ctx context.Context, repo Repository, targets []string,
 		found    bool
 	)
 
-	err = ForAllSnapshots(ctx, repo, nil, func(id ID, snapshot *Snapshot, err error) error {
+	err = forAll(func(id ID, snapshot *Snapshot, err error) error {
 		if err != nil {
 			return errors.Errorf("Error loading snapshot %v: %v", id.Str(), err)
 		}
@@ -50,6 +76,10 @@ func FindLatestSnapshot(ctx context.Context, repo Repository, targets []string,
 			return nil
 		}
 
+		if !snapshot.HasLabels(labels) {
+			return nil
+		}
+
 		if !snapshot.HasPaths(absTargets) {
 			return nil
 		}
@@ -86,7 +116,7 @@ func FindSnapshot(ctx context.Context, repo Repository, s string) (ID, error) {
 
 // FindFilteredSnapshots yields Snapshots filtered from the list of all
 // snapshots.
-func FindFilteredSnapshots(ctx context.Context, repo Repository, hosts []string, tags []TagList, paths []string) (Snapshots, error) {
+func FindFilteredSnapshots(ctx context.Context, repo Repository, hosts []string, tags []TagList, paths []string, labels []string) (Snapshots, error) {
 	results := make(Snapshots, 0, 20)
 
 	err := ForAllSnapshots(ctx, repo, nil, func(id ID, sn *Snapshot, err error) error {
@@ -95,7 +125,7 @@ func FindFilteredSnapshots(ctx context.Context, repo Repository, hosts []string,
 			return nil
 		}
 
-		if !sn.HasHostname(hosts) || !sn.HasTagList(tags) || !sn.HasPaths(paths) {
+		if !sn.HasHostname(hosts) || !sn.HasTagList(tags) || !sn.HasPaths(paths) || !sn.HasLabels(labels) {
 			return nil
 		}
 
@@ -14,10 +14,15 @@ type SnapshotGroupKey struct {
 	Hostname string   `json:"hostname"`
 	Paths    []string `json:"paths"`
 	Tags     []string `json:"tags"`
+
+	// Labels contains the values of the labels used for grouping, an
+	// empty value if a snapshot does not have the label.
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 // GroupSnapshots takes a list of snapshots and a grouping criteria and creates
-// a group list of snapshots.
+// a group list of snapshots. Besides host, paths and tags, the snapshots can
+// be grouped by the value of a label with "label:key".
 func GroupSnapshots(snapshots Snapshots, options string) (map[string]Snapshots, bool, error) {
 	// group by hostname and dirs
 	snapshotGroups := make(map[string]Snapshots)
@@ -25,9 +30,19 @@ func GroupSnapshots(snapshots Snapshots, options string) (map[string]Snapshots,
 	var GroupByTag bool
 	var GroupByHost bool
 	var GroupByPath bool
+	var GroupByLabels []string
 	GroupOptionList := strings.Split(options, ",")
 
 	for _, option := range GroupOptionList {
+		if strings.HasPrefix(option, "label:") {
+			key := strings.TrimPrefix(option, "label:")
+			if key == "" {
+				return nil, false, errors.Fatal("missing label key in grouping option: '" + option + "'")
+			}
+			GroupByLabels = append(GroupByLabels, key)
+			continue
+		}
+
 		switch option {
 		case "host", "hosts":
 			GroupByHost = true
@@ -46,6 +61,7 @@ func GroupSnapshots(snapshots Snapshots, options string) (map[string]Snapshots,
 		var tags []string
 		var hostname string
 		var paths []string
+		var labels map[string]string
 
 		if GroupByTag {
 			tags = sn.Tags
@@ -57,12 +73,18 @@ func GroupSnapshots(snapshots Snapshots, options string) (map[string]Snapshots,
 		if GroupByPath {
 			paths = sn.Paths
 		}
+		if len(GroupByLabels) > 0 {
+			labels = make(map[string]string, len(GroupByLabels))
+			for _, key := range GroupByLabels {
+				labels[key] = sn.Labels[key]
+			}
+		}
 
 		sort.Strings(sn.Paths)
 		var k []byte
 		var err error
 
-		k, err = json.Marshal(SnapshotGroupKey{Tags: tags, Hostname: hostname, Paths: paths})
+		k, err = json.Marshal(SnapshotGroupKey{Tags: tags, Hostname: hostname, Paths: paths, Labels: labels})
 
 		if err != nil {
 			return nil, false, err
@@ -70,5 +92,5 @@ func GroupSnapshots(snapshots Snapshots, options string) (map[string]Snapshots,
 		snapshotGroups[string(k)] = append(snapshotGroups[string(k)], sn)
 	}
 
-	return snapshotGroups, GroupByTag || GroupByHost || GroupByPath, nil
+	return snapshotGroups, GroupByTag || GroupByHost || GroupByPath || len(GroupByLabels) > 0, nil
 }
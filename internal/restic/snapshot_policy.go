@@ -149,6 +149,8 @@ type KeepReason struct {
 // ApplyPolicy returns the snapshots from list that are to be kept and removed
 // according to the policy p. list is sorted in the process. reasons contains
 // the reasons to keep each snapshot, it is in the same order as keep.
+// Incomplete checkpoints are kept as long as they are newer than all
+// complete snapshots in list.
 func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reasons []KeepReason) {
 	sort.Sort(list)
 
@@ -162,8 +164,34 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 		return list, remove, reasons
 	}
 
+	// Checkpoints are not subject to the policy. The ones older than the
+	// newest complete snapshot have been superseded, the others belong to a
+	// backup which is still running or has been interrupted.
+	var complete, checkpoints Snapshots
+	for _, sn := range list {
+		if sn.Incomplete {
+			checkpoints = append(checkpoints, sn)
+		} else {
+			complete = append(complete, sn)
+		}
+	}
+	list = complete
+
+	for _, sn := range checkpoints {
+		if len(list) > 0 && !sn.Time.After(list[0].Time) {
+			remove = append(remove, sn)
+			continue
+		}
+
+		keep = append(keep, sn)
+		reasons = append(reasons, KeepReason{
+			Snapshot: sn,
+			Matches:  []string{"incomplete checkpoint"},
+		})
+	}
+
 	if len(list) == 0 {
-		return list, nil, nil
+		return keep, remove, reasons
 	}
 
 	var buckets = [6]struct {
@@ -269,3 +269,44 @@ func TestApplyPolicy(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyPolicyCheckpoints(t *testing.T) {
+	list := restic.Snapshots{
+		{Time: parseTimeUTC("2016-01-01 10:00:00"), Incomplete: true},
+		{Time: parseTimeUTC("2016-01-02 10:00:00")},
+		{Time: parseTimeUTC("2016-01-03 10:00:00")},
+		{Time: parseTimeUTC("2016-01-02 12:00:00"), Incomplete: true},
+		{Time: parseTimeUTC("2016-01-04 10:00:00"), Incomplete: true},
+	}
+
+	keep, remove, reasons := restic.ApplyPolicy(list, restic.ExpirePolicy{Last: 1})
+
+	var keepTimes, removeTimes []time.Time
+	for _, sn := range keep {
+		keepTimes = append(keepTimes, sn.Time)
+	}
+	for _, sn := range remove {
+		removeTimes = append(removeTimes, sn.Time)
+	}
+
+	wantKeep := []time.Time{
+		parseTimeUTC("2016-01-04 10:00:00"),
+		parseTimeUTC("2016-01-03 10:00:00"),
+	}
+	if !cmp.Equal(wantKeep, keepTimes) {
+		t.Error(cmp.Diff(wantKeep, keepTimes))
+	}
+
+	wantRemove := []time.Time{
+		parseTimeUTC("2016-01-02 12:00:00"),
+		parseTimeUTC("2016-01-01 10:00:00"),
+		parseTimeUTC("2016-01-02 10:00:00"),
+	}
+	if !cmp.Equal(wantRemove, removeTimes) {
+		t.Error(cmp.Diff(wantRemove, removeTimes))
+	}
+
+	if len(reasons) != len(keep) || reasons[0].Matches[0] != "incomplete checkpoint" {
+		t.Errorf("unexpected keep reasons %v", reasons)
+	}
+}
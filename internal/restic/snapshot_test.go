@@ -14,3 +14,45 @@ func TestNewSnapshot(t *testing.T) {
 	_, err := restic.NewSnapshot(paths, nil, "foo", time.Now())
 	rtest.OK(t, err)
 }
+
+func TestParseLabels(t *testing.T) {
+	labels, err := restic.ParseLabels([]string{"ticket=OPS-123", "empty=", "expr=a=b"})
+	rtest.OK(t, err)
+	rtest.Equals(t, map[string]string{"ticket": "OPS-123", "empty": "", "expr": "a=b"}, labels)
+
+	labels, err = restic.ParseLabels(nil)
+	rtest.OK(t, err)
+	rtest.Assert(t, labels == nil, "labels for an empty list are not nil: %v", labels)
+
+	for _, label := range []string{"ticket", "=OPS-123"} {
+		_, err = restic.ParseLabels([]string{label})
+		rtest.Assert(t, err != nil, "no error for invalid label %q", label)
+	}
+}
+
+func TestSnapshotLabels(t *testing.T) {
+	sn, err := restic.NewSnapshot([]string{"/home/foobar"}, nil, "foo", time.Now())
+	rtest.OK(t, err)
+
+	rtest.Assert(t, sn.HasLabels(nil), "snapshot does not match an empty filter")
+	rtest.Assert(t, !sn.HasLabels([]string{"ticket"}), "snapshot without labels matches")
+
+	rtest.Assert(t, sn.SetLabels(map[string]string{"ticket": "OPS-123", "env": "prod"}), "labels were not changed")
+	rtest.Assert(t, !sn.SetLabels(map[string]string{"ticket": "OPS-123"}), "setting the same label changed the snapshot")
+
+	for filter, match := range map[string]bool{
+		"ticket":         true,
+		"ticket=OPS-123": true,
+		"ticket=OPS-124": false,
+		"ticket=":        false,
+		"owner":          false,
+	} {
+		rtest.Equals(t, match, sn.HasLabels([]string{filter}))
+	}
+	rtest.Assert(t, sn.HasLabels([]string{"ticket", "env=prod"}), "snapshot does not match all labels")
+	rtest.Assert(t, !sn.HasLabels([]string{"ticket", "env=test"}), "snapshot matches a wrong label")
+
+	rtest.Assert(t, !sn.RemoveLabels([]string{"owner"}), "removing a missing label changed the snapshot")
+	rtest.Assert(t, sn.RemoveLabels([]string{"ticket", "env"}), "labels were not removed")
+	rtest.Assert(t, sn.Labels == nil, "labels are not nil: %v", sn.Labels)
+}
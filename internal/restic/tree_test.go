@@ -6,7 +6,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
@@ -79,6 +81,35 @@ func TestNodeMarshal(t *testing.T) {
 	}
 }
 
+func TestNodeMarshalInodeAttributes(t *testing.T) {
+	btime := time.Date(2020, 6, 1, 12, 0, 0, 123, time.UTC)
+
+	for _, n := range []restic.Node{
+		{Name: "plain"},
+		{Name: "btime", BirthTime: btime},
+		{Name: "flags", BirthTime: btime, InodeFlags: restic.InodeFlagImmutable | restic.InodeFlagNoDump},
+	} {
+		t.Run(n.Name, func(t *testing.T) {
+			data, err := json.Marshal(&n)
+			rtest.OK(t, err)
+
+			// unset fields must not change the encoding of existing nodes
+			rtest.Equals(t, !n.BirthTime.IsZero(), strings.Contains(string(data), `"btime"`))
+			rtest.Equals(t, n.InodeFlags != 0, strings.Contains(string(data), `"inode_flags"`))
+
+			var node restic.Node
+			rtest.OK(t, json.Unmarshal(data, &node))
+			rtest.Assert(t, n.Equals(node), "nodes are not equal, want:\n  %#v\ngot:\n  %#v", n, node)
+		})
+	}
+}
+
+func TestInodeFlagsString(t *testing.T) {
+	rtest.Equals(t, "", restic.InodeFlagsString(0))
+	rtest.Equals(t, "ia", restic.InodeFlagsString(restic.InodeFlagImmutable|restic.InodeFlagAppend))
+	rtest.Equals(t, "SDiadA", restic.InodeFlagsString(restic.InodeFlagsMask))
+}
+
 func TestNodeComparison(t *testing.T) {
 	fi, err := os.Lstat("tree_test.go")
 	rtest.OK(t, err)
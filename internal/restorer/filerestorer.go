@@ -9,6 +9,7 @@ import (
 	"sort"
 	"sync"
 
+	"github.com/klauspost/compress/zstd"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/restic/restic/internal/crypto"
@@ -51,10 +52,12 @@ type packInfo struct {
 // fileRestorer restores set of files
 type fileRestorer struct {
 	key        *crypto.Key
+	dec        *zstd.Decoder
 	idx        func(restic.BlobHandle) []restic.PackedBlob
 	packLoader func(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error
 
 	filesWriter *filesWriter
+	sparse      bool
 
 	dst   string
 	files []*fileInfo
@@ -64,13 +67,22 @@ type fileRestorer struct {
 func newFileRestorer(dst string,
 	packLoader func(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error,
 	key *crypto.Key,
-	idx func(restic.BlobHandle) []restic.PackedBlob) *fileRestorer {
+	idx func(restic.BlobHandle) []restic.PackedBlob,
+	sparse bool) *fileRestorer {
+
+	// zstd.NewReader only fails for invalid options
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		panic(err)
+	}
 
 	return &fileRestorer{
 		key:         key,
+		dec:         dec,
 		idx:         idx,
 		packLoader:  packLoader,
 		filesWriter: newFilesWriter(workerCount),
+		sparse:      sparse,
 		dst:         dst,
 		Error:       restorerAbortOnAllErrors,
 	}
@@ -120,7 +132,7 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 		err := r.forEachBlob(fileBlobs, func(packID restic.ID, blob restic.Blob) {
 			if largeFile {
 				packsMap[packID] = append(packsMap[packID], fileBlobInfo{id: blob.ID, offset: fileOffset})
-				fileOffset += int64(blob.Length) - crypto.Extension
+				fileOffset += int64(blob.DataLength())
 			}
 			pack, ok := packs[packID]
 			if !ok {
@@ -182,9 +194,10 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
 	// calculate pack byte range and blob->[]files->[]offsets mappings
 	start, end := int64(math.MaxInt64), int64(0)
 	blobs := make(map[restic.ID]struct {
-		offset int64                 // offset of the blob in the pack
-		length int                   // length of the blob
-		files  map[*fileInfo][]int64 // file -> offsets (plural!) of the blob in the file
+		offset             int64                 // offset of the blob in the pack
+		length             int                   // length of the blob
+		uncompressedLength int                   // length of the plaintext, zero for uncompressed blobs
+		files              map[*fileInfo][]int64 // file -> offsets (plural!) of the blob in the file
 	})
 	for file := range pack.files {
 		addBlob := func(blob restic.Blob, fileOffset int64) {
@@ -198,6 +211,7 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
 			if !ok {
 				blobInfo.offset = int64(blob.Offset)
 				blobInfo.length = int(blob.Length)
+				blobInfo.uncompressedLength = int(blob.UncompressedLength)
 				blobInfo.files = make(map[*fileInfo][]int64)
 				blobs[blob.ID] = blobInfo
 			}
@@ -209,7 +223,7 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
 				if packID.Equal(pack.id) {
 					addBlob(blob, fileOffset)
 				}
-				fileOffset += int64(blob.Length) - crypto.Extension
+				fileOffset += int64(blob.DataLength())
 			})
 			if err != nil {
 				// restoreFiles should have caught this error before
@@ -258,7 +272,7 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
 			if err != nil {
 				return err
 			}
-			blobData, buf, err = r.loadBlob(bufRd, blobID, blob.length, buf)
+			blobData, buf, err = r.loadBlob(bufRd, blobID, blob.length, blob.uncompressedLength, buf)
 			if err != nil {
 				for file := range blob.files {
 					if errFile := sanitizeError(file, err); errFile != nil {
@@ -286,7 +300,7 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
 							file.inProgress = true
 							createSize = file.size
 						}
-						return r.filesWriter.writeToFile(r.targetPath(file.location), blobData, offset, createSize)
+						return r.filesWriter.writeToFile(r.targetPath(file.location), blobData, offset, createSize, r.sparse)
 					}
 					err := sanitizeError(file, writeToFile())
 					if err != nil {
@@ -309,7 +323,7 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
 	return nil
 }
 
-func (r *fileRestorer) loadBlob(rd io.Reader, blobID restic.ID, length int, buf []byte) ([]byte, []byte, error) {
+func (r *fileRestorer) loadBlob(rd io.Reader, blobID restic.ID, length, uncompressedLength int, buf []byte) ([]byte, []byte, error) {
 	// TODO reconcile with Repository#loadBlob implementation
 
 	if cap(buf) < length {
@@ -328,12 +342,20 @@ func (r *fileRestorer) loadBlob(rd io.Reader, blobID restic.ID, length int, buf
 	}
 
 	// decrypt
-	nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
-	plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	key := r.key.WithSealedFallback()
+	nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+	plaintext, err := key.Open(ciphertext[:0], nonce, ciphertext, nil)
 	if err != nil {
 		return nil, nil, errors.Errorf("decrypting blob %v failed: %v", blobID, err)
 	}
 
+	if uncompressedLength != 0 {
+		plaintext, err = r.dec.DecodeAll(plaintext, make([]byte, 0, uncompressedLength))
+		if err != nil {
+			return nil, nil, errors.Errorf("decompressing blob %v failed: %v", blobID, err)
+		}
+	}
+
 	// check hash
 	if !restic.Hash(plaintext).Equal(blobID) {
 		return nil, nil, errors.Errorf("blob %v returned invalid hash", blobID)
@@ -34,7 +34,10 @@ func newFilesWriter(count int) *filesWriter {
 	}
 }
 
-func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, createSize int64) error {
+// writeToFile writes blob to the file at offset. If createSize is not
+// negative, the file is created with that size. In sparse mode, blobs which
+// only contain zeros are not written, so that holes remain in the file.
+func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, createSize int64, sparse bool) error {
 	bucket := &w.buckets[uint(xxhash.Sum64String(path))%uint(len(w.buckets))]
 
 	acquireWriter := func() (*os.File, error) {
@@ -61,7 +64,16 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 		bucket.files[path] = wr
 		bucket.users[path] = 1
 
-		if createSize >= 0 {
+		if createSize >= 0 && sparse {
+			// allocating the whole file would fill the holes
+			err := wr.Truncate(createSize)
+			if err != nil {
+				_ = wr.Close()
+				delete(bucket.files, path)
+				delete(bucket.users, path)
+				return nil, err
+			}
+		} else if createSize >= 0 {
 			err := preallocateFile(wr, createSize)
 			if err != nil {
 				// Just log the preallocate error but don't let it cause the restore process to fail.
@@ -94,7 +106,9 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 		return err
 	}
 
-	_, err = wr.WriteAt(blob, offset)
+	if !sparse || !allZero(blob) {
+		_, err = wr.WriteAt(blob, offset)
+	}
 
 	if err != nil {
 		// ignore subsequent errors
@@ -104,3 +118,13 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 
 	return releaseWriter(wr)
 }
+
+// allZero returns true if buf only contains zeros.
+func allZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
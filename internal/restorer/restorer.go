@@ -14,19 +14,30 @@ import (
 
 // Restorer is used to restore a snapshot to a directory.
 type Restorer struct {
-	repo restic.Repository
-	sn   *restic.Snapshot
+	repo   restic.Repository
+	sn     *restic.Snapshot
+	sparse bool
+
+	// inodeFlags holds the items whose inode flags are restored last
+	inodeFlags []pendingInodeFlags
 
 	Error        func(location string, err error) error
 	SelectFilter func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool)
 }
 
+type pendingInodeFlags struct {
+	node             *restic.Node
+	target, location string
+}
+
 var restorerAbortOnAllErrors = func(location string, err error) error { return err }
 
-// NewRestorer creates a restorer preloaded with the content from the snapshot id.
-func NewRestorer(ctx context.Context, repo restic.Repository, id restic.ID) (*Restorer, error) {
+// NewRestorer creates a restorer preloaded with the content from the snapshot
+// id. If sparse is set, files are restored as sparse files.
+func NewRestorer(ctx context.Context, repo restic.Repository, id restic.ID, sparse bool) (*Restorer, error) {
 	r := &Restorer{
 		repo:         repo,
+		sparse:       sparse,
 		Error:        restorerAbortOnAllErrors,
 		SelectFilter: func(string, string, *restic.Node) (bool, bool) { return true, true },
 	}
@@ -170,6 +181,17 @@ func (res *Restorer) restoreNodeTo(ctx context.Context, node *restic.Node, targe
 
 func (res *Restorer) restoreNodeMetadataTo(node *restic.Node, target, location string) error {
 	debug.Log("restoreNodeMetadata %v %v %v", node.Name, target, location)
+
+	// the immutable and append-only flags prevent creating hard links to a
+	// file and restoring the contents of a directory, so the inode flags are
+	// only set after all items have been restored
+	if node.InodeFlags != 0 {
+		res.inodeFlags = append(res.inodeFlags, pendingInodeFlags{node, target, location})
+		n := *node
+		n.InodeFlags = 0
+		node = &n
+	}
+
 	err := node.RestoreMetadata(target)
 	if err != nil {
 		debug.Log("node.RestoreMetadata(%s) error %v", target, err)
@@ -214,8 +236,9 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 	}
 
 	idx := restic.NewHardlinkIndex()
+	res.inodeFlags = nil
 
-	filerestorer := newFileRestorer(dst, res.repo.Backend().Load, res.repo.Key(), res.repo.Index().Lookup)
+	filerestorer := newFileRestorer(dst, res.repo.Backend().Load, res.repo.Key(), res.repo.Index().Lookup, res.sparse)
 	filerestorer.Error = res.Error
 
 	debug.Log("first pass for %q", dst)
@@ -302,7 +325,28 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 			return res.restoreNodeMetadataTo(node, target, location)
 		},
 	})
-	return err
+	if err != nil {
+		return err
+	}
+
+	return res.restoreInodeFlags()
+}
+
+// restoreInodeFlags sets the inode flags collected by restoreNodeMetadataTo.
+func (res *Restorer) restoreInodeFlags() error {
+	for _, item := range res.inodeFlags {
+		debug.Log("restore inode flags %q", item.location)
+		err := item.node.RestoreInodeFlags(item.target)
+		if err != nil {
+			debug.Log("node.RestoreInodeFlags(%s) error %v", item.target, err)
+			err = res.Error(item.location, err)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
 }
 
 // Snapshot returns the snapshot this restorer is configured to use.
@@ -0,0 +1,73 @@
+package restorer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRestorerInodeFlags(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("setting the immutable flag requires root")
+	}
+
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				InodeFlags: restic.InodeFlagAppend,
+				Nodes: map[string]Node{
+					"file1": File{Data: "content", Links: 2, Inode: 1, InodeFlags: restic.InodeFlagImmutable},
+					"file2": File{Data: "content", Links: 2, Inode: 1, InodeFlags: restic.InodeFlagImmutable},
+					"file3": File{Data: "other", InodeFlags: restic.InodeFlagNoDump},
+				},
+			},
+		},
+	})
+
+	res, err := NewRestorer(context.TODO(), repo, id, false)
+	rtest.OK(t, err)
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	items := []string{"dir", "dir/file1", "dir/file2", "dir/file3"}
+	defer func() {
+		// the flags prevent removing the files
+		for _, item := range items {
+			_ = fs.SetInodeFlags(filepath.Join(tempdir, item), 0, restic.InodeFlagsMask)
+		}
+	}()
+
+	err = res.RestoreTo(context.TODO(), tempdir)
+	if err != nil && os.IsPermission(errors.Cause(err)) {
+		t.Skipf("unable to set inode flags: %v", err)
+	}
+	rtest.OK(t, err)
+
+	for item, want := range map[string]uint32{
+		"dir":       restic.InodeFlagAppend,
+		"dir/file1": restic.InodeFlagImmutable,
+		"dir/file2": restic.InodeFlagImmutable,
+		"dir/file3": restic.InodeFlagNoDump,
+	} {
+		flags, err := fs.GetInodeFlags(filepath.Join(tempdir, item))
+		rtest.OK(t, err)
+		rtest.Equals(t, want, flags&restic.InodeFlagsMask)
+	}
+
+	f1, err := os.Stat(filepath.Join(tempdir, "dir/file1"))
+	rtest.OK(t, err)
+	f2, err := os.Stat(filepath.Join(tempdir, "dir/file2"))
+	rtest.OK(t, err)
+	rtest.Assert(t, os.SameFile(f1, f2), "files are not hard linked")
+}
@@ -24,17 +24,19 @@ type Snapshot struct {
 }
 
 type File struct {
-	Data    string
-	Links   uint64
-	Inode   uint64
-	Mode    os.FileMode
-	ModTime time.Time
+	Data       string
+	Links      uint64
+	Inode      uint64
+	Mode       os.FileMode
+	ModTime    time.Time
+	InodeFlags uint32
 }
 
 type Dir struct {
-	Nodes   map[string]Node
-	Mode    os.FileMode
-	ModTime time.Time
+	Nodes      map[string]Node
+	Mode       os.FileMode
+	ModTime    time.Time
+	InodeFlags uint32
 }
 
 func saveFile(t testing.TB, repo restic.Repository, node File) restic.ID {
@@ -75,16 +77,17 @@ func saveDir(t testing.TB, repo restic.Repository, nodes map[string]Node, inode
 				mode = 0644
 			}
 			err := tree.Insert(&restic.Node{
-				Type:    "file",
-				Mode:    mode,
-				ModTime: node.ModTime,
-				Name:    name,
-				UID:     uint32(os.Getuid()),
-				GID:     uint32(os.Getgid()),
-				Content: fc,
-				Size:    uint64(len(n.(File).Data)),
-				Inode:   fi,
-				Links:   lc,
+				Type:       "file",
+				Mode:       mode,
+				ModTime:    node.ModTime,
+				Name:       name,
+				UID:        uint32(os.Getuid()),
+				GID:        uint32(os.Getgid()),
+				Content:    fc,
+				Size:       uint64(len(n.(File).Data)),
+				Inode:      fi,
+				Links:      lc,
+				InodeFlags: node.InodeFlags,
 			})
 			rtest.OK(t, err)
 		case Dir:
@@ -96,13 +99,14 @@ func saveDir(t testing.TB, repo restic.Repository, nodes map[string]Node, inode
 			}
 
 			err := tree.Insert(&restic.Node{
-				Type:    "dir",
-				Mode:    mode,
-				ModTime: node.ModTime,
-				Name:    name,
-				UID:     uint32(os.Getuid()),
-				GID:     uint32(os.Getgid()),
-				Subtree: &id,
+				Type:       "dir",
+				Mode:       mode,
+				ModTime:    node.ModTime,
+				Name:       name,
+				UID:        uint32(os.Getuid()),
+				GID:        uint32(os.Getgid()),
+				Subtree:    &id,
+				InodeFlags: node.InodeFlags,
 			})
 			rtest.OK(t, err)
 		default:
@@ -322,7 +326,7 @@ func TestRestorer(t *testing.T) {
 			_, id := saveSnapshot(t, repo, test.Snapshot)
 			t.Logf("snapshot saved as %v", id.Str())
 
-			res, err := NewRestorer(context.TODO(), repo, id)
+			res, err := NewRestorer(context.TODO(), repo, id, false)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -440,7 +444,7 @@ func TestRestorerRelative(t *testing.T) {
 			_, id := saveSnapshot(t, repo, test.Snapshot)
 			t.Logf("snapshot saved as %v", id.Str())
 
-			res, err := NewRestorer(context.TODO(), repo, id)
+			res, err := NewRestorer(context.TODO(), repo, id, false)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -672,7 +676,7 @@ func TestRestorerTraverseTree(t *testing.T) {
 			defer cleanup()
 			sn, id := saveSnapshot(t, repo, test.Snapshot)
 
-			res, err := NewRestorer(context.TODO(), repo, id)
+			res, err := NewRestorer(context.TODO(), repo, id, false)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -754,7 +758,7 @@ func TestRestorerConsistentTimestampsAndPermissions(t *testing.T) {
 		},
 	})
 
-	res, err := NewRestorer(context.TODO(), repo, id)
+	res, err := NewRestorer(context.TODO(), repo, id, false)
 	rtest.OK(t, err)
 
 	res.SelectFilter = func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
@@ -0,0 +1,104 @@
+// Package shamir implements Shamir's secret sharing over GF(2^8). A secret is
+// split into a number of shares, any threshold of which can be combined to
+// recover the secret. Fewer shares reveal nothing about the secret.
+package shamir
+
+import (
+	"crypto/rand"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/gf256"
+)
+
+// Share is a single share of a secret. X is the point at which the random
+// polynomials were evaluated, Y contains one value per byte of the secret.
+type Share struct {
+	X byte
+	Y []byte
+}
+
+// evaluate returns the value of the polynomial with the coefficients coeffs at x.
+func evaluate(coeffs []byte, x byte) byte {
+	// Horner's method
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = gf256.Mul(y, x) ^ coeffs[i]
+	}
+	return y
+}
+
+// Split splits secret into n shares, any threshold of which can recover the
+// secret.
+func Split(secret []byte, n, threshold int) ([]Share, error) {
+	switch {
+	case len(secret) == 0:
+		return nil, errors.New("secret is empty")
+	case threshold < 2:
+		return nil, errors.New("threshold must be at least 2")
+	case n < threshold:
+		return nil, errors.New("number of shares must not be smaller than the threshold")
+	case n > 255:
+		return nil, errors.New("number of shares must not be larger than 255")
+	}
+
+	shares := make([]Share, n)
+	for i := range shares {
+		shares[i] = Share{X: byte(i + 1), Y: make([]byte, len(secret))}
+	}
+
+	// for each byte of the secret, use a random polynomial of degree
+	// threshold-1 with the secret byte as constant term
+	coeffs := make([]byte, threshold)
+	for i, b := range secret {
+		coeffs[0] = b
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, errors.Wrap(err, "rand.Read")
+		}
+
+		for _, s := range shares {
+			s.Y[i] = evaluate(coeffs, s.X)
+		}
+	}
+
+	return shares, nil
+}
+
+// Combine recovers the secret from shares. If less shares than the threshold
+// used for splitting the secret are passed, a wrong secret is returned.
+func Combine(shares []Share) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least two shares are required")
+	}
+
+	seen := make(map[byte]struct{}, len(shares))
+	for _, s := range shares {
+		if s.X == 0 {
+			return nil, errors.New("invalid share with x = 0")
+		}
+		if _, ok := seen[s.X]; ok {
+			return nil, errors.Errorf("duplicate share %d", s.X)
+		}
+		seen[s.X] = struct{}{}
+
+		if len(s.Y) != len(shares[0].Y) {
+			return nil, errors.New("shares have different lengths")
+		}
+	}
+
+	// Lagrange interpolation at x = 0
+	secret := make([]byte, len(shares[0].Y))
+	for i, si := range shares {
+		basis := byte(1)
+		for j, sj := range shares {
+			if i == j {
+				continue
+			}
+			// sj.X / (sj.X - si.X), subtraction is xor in GF(2^8)
+			basis = gf256.Mul(basis, gf256.Div(sj.X, sj.X^si.X))
+		}
+
+		gf256.MulAdd(secret, si.Y, basis)
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,58 @@
+package shamir
+
+import (
+	"bytes"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestSplitCombine(t *testing.T) {
+	secret := rtest.Random(23, 64)
+
+	shares, err := Split(secret, 5, 3)
+	rtest.OK(t, err)
+	rtest.Equals(t, 5, len(shares))
+
+	// all combinations of three shares recover the secret
+	for i := 0; i < 5; i++ {
+		for j := i + 1; j < 5; j++ {
+			for k := j + 1; k < 5; k++ {
+				res, err := Combine([]Share{shares[i], shares[j], shares[k]})
+				rtest.OK(t, err)
+				rtest.Equals(t, secret, res)
+			}
+		}
+	}
+
+	// so do all shares
+	res, err := Combine(shares)
+	rtest.OK(t, err)
+	rtest.Equals(t, secret, res)
+
+	// but not two of them
+	res, err = Combine(shares[:2])
+	rtest.OK(t, err)
+	rtest.Assert(t, !bytes.Equal(secret, res), "two shares recovered the secret")
+}
+
+func TestSplitInvalid(t *testing.T) {
+	for _, test := range []struct {
+		n, threshold int
+	}{
+		{5, 1},
+		{2, 3},
+		{256, 3},
+	} {
+		_, err := Split([]byte("secret"), test.n, test.threshold)
+		rtest.Assert(t, err != nil, "Split(%d, %d) did not return an error", test.n, test.threshold)
+	}
+}
+
+func TestCombineDuplicate(t *testing.T) {
+	shares, err := Split([]byte("secret"), 3, 2)
+	rtest.OK(t, err)
+
+	_, err = Combine([]Share{shares[0], shares[0]})
+	rtest.Assert(t, err != nil, "duplicate shares were accepted")
+}
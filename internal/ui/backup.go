@@ -35,6 +35,7 @@ type Backup struct {
 	start time.Time
 
 	totalBytes uint64
+	dryRun     bool
 
 	totalCh     chan counter
 	processedCh chan counter
@@ -337,7 +338,11 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 		}
 
 		if previous == nil {
-			b.VV("new       %v, saved in %.3fs (%v added)", item, d.Seconds(), formatBytes(s.DataSize))
+			if b.dryRun {
+				b.P("would add new      %v (%v)", item, formatBytes(s.DataSize))
+			} else {
+				b.VV("new       %v, saved in %.3fs (%v added)", item, d.Seconds(), formatBytes(s.DataSize))
+			}
 			b.summary.Lock()
 			b.summary.Files.New++
 			b.summary.Unlock()
@@ -350,7 +355,11 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 			b.summary.Files.Unchanged++
 			b.summary.Unlock()
 		} else {
-			b.VV("modified  %v, saved in %.3fs (%v added)", item, d.Seconds(), formatBytes(s.DataSize))
+			if b.dryRun {
+				b.P("would add modified %v (%v)", item, formatBytes(s.DataSize))
+			} else {
+				b.VV("modified  %v, saved in %.3fs (%v added)", item, d.Seconds(), formatBytes(s.DataSize))
+			}
 			b.summary.Lock()
 			b.summary.Files.Changed++
 			b.summary.Unlock()
@@ -385,7 +394,11 @@ func (b *Backup) Finish(snapshotID restic.ID) {
 	b.P("Dirs:        %5d new, %5d changed, %5d unmodified\n", b.summary.Dirs.New, b.summary.Dirs.Changed, b.summary.Dirs.Unchanged)
 	b.V("Data Blobs:  %5d new\n", b.summary.ItemStats.DataBlobs)
 	b.V("Tree Blobs:  %5d new\n", b.summary.ItemStats.TreeBlobs)
-	b.P("Added to the repo: %-5s\n", formatBytes(b.summary.ItemStats.DataSize+b.summary.ItemStats.TreeSize))
+	if b.dryRun {
+		b.P("Would add to the repo: %-5s\n", formatBytes(b.summary.ItemStats.DataSize+b.summary.ItemStats.TreeSize))
+	} else {
+		b.P("Added to the repo: %-5s\n", formatBytes(b.summary.ItemStats.DataSize+b.summary.ItemStats.TreeSize))
+	}
 	b.P("\n")
 	b.P("processed %v files, %v in %s",
 		b.summary.Files.New+b.summary.Files.Changed+b.summary.Files.Unchanged,
@@ -394,6 +407,32 @@ func (b *Backup) Finish(snapshotID restic.ID) {
 	)
 }
 
+// HookOutput reports a line of output of the pre- or post-hook command.
+func (b *Backup) HookOutput(hook, line string) {
+	b.V("%v-hook: %v\n", hook, line)
+}
+
+// SetDryRun marks the backup as a dry run. New and modified files are
+// reported as files which would be added.
+func (b *Backup) SetDryRun() {
+	b.dryRun = true
+}
+
+// SetSkipped marks the backup as skipped, as nothing has changed since the
+// parent snapshot. It satisfies the ArchiveProgressReporter interface, the
+// summary is printed as usual.
+func (b *Backup) SetSkipped() {}
+
+// SetPartial marks the backup as partial, as the deadline has been reached
+// before all files were saved. It satisfies the ArchiveProgressReporter
+// interface, the summary is printed as usual.
+func (b *Backup) SetPartial() {}
+
+// AddSecondarySnapshot records the result of the backup to a secondary
+// repository. It satisfies the ArchiveProgressReporter interface, the result
+// is printed by the backup command.
+func (b *Backup) AddSecondarySnapshot(repository string, id restic.ID, err error) {}
+
 // SetMinUpdatePause sets b.MinUpdatePause. It satisfies the
 // ArchiveProgressReporter interface.
 func (b *Backup) SetMinUpdatePause(d time.Duration) {
@@ -36,6 +36,10 @@ type Backup struct {
 	start time.Time
 
 	totalBytes uint64
+	dryRun     bool
+	skipped    bool
+	partial    bool
+	secondary  []secondarySnapshot
 
 	totalCh     chan counter
 	processedCh chan counter
@@ -316,7 +320,7 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 		}
 
 		if previous == nil {
-			if b.v >= 3 {
+			if b.v >= 3 || b.dryRun {
 				b.print(verboseUpdate{
 					MessageType: "verbose_status",
 					Action:      "new",
@@ -343,7 +347,7 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 			b.summary.Files.Unchanged++
 			b.summary.Unlock()
 		} else {
-			if b.v >= 3 {
+			if b.v >= 3 || b.dryRun {
 				b.print(verboseUpdate{
 					MessageType: "verbose_status",
 					Action:      "modified",
@@ -388,7 +392,7 @@ func (b *Backup) Finish(snapshotID restic.ID) {
 	case <-b.closed:
 	}
 
-	b.print(summaryOutput{
+	summary := summaryOutput{
 		MessageType:         "summary",
 		FilesNew:            b.summary.Files.New,
 		FilesChanged:        b.summary.Files.Changed,
@@ -402,10 +406,60 @@ func (b *Backup) Finish(snapshotID restic.ID) {
 		TotalFilesProcessed: b.summary.Files.New + b.summary.Files.Changed + b.summary.Files.Unchanged,
 		TotalBytesProcessed: b.summary.ProcessedBytes,
 		TotalDuration:       time.Since(b.start).Seconds(),
-		SnapshotID:          snapshotID.Str(),
+		DryRun:              b.dryRun,
+		Skipped:             b.skipped,
+		Partial:             b.partial,
+		SecondarySnapshots:  b.secondary,
+	}
+	if !b.dryRun && !b.skipped {
+		summary.SnapshotID = snapshotID.Str()
+	}
+	b.print(summary)
+}
+
+// HookOutput reports a line of output of the pre- or post-hook command.
+func (b *Backup) HookOutput(hook, line string) {
+	b.print(hookOutput{
+		MessageType: "hook_output",
+		Hook:        hook,
+		Output:      line,
 	})
 }
 
+// SetDryRun marks the backup as a dry run. New and modified files are
+// always reported.
+func (b *Backup) SetDryRun() {
+	b.dryRun = true
+}
+
+// SetSkipped marks the backup as skipped, as nothing has changed since the
+// parent snapshot. The summary does not contain a snapshot ID then.
+func (b *Backup) SetSkipped() {
+	b.skipped = true
+}
+
+// SetPartial marks the backup as partial, as the deadline has been reached
+// before all files were saved.
+func (b *Backup) SetPartial() {
+	b.partial = true
+}
+
+// AddSecondarySnapshot records the result of the backup to a secondary
+// repository for the summary. A null id means that no snapshot was saved,
+// either as nothing has changed or as the deadline has been reached.
+func (b *Backup) AddSecondarySnapshot(repository string, id restic.ID, err error) {
+	s := secondarySnapshot{Repository: repository}
+	switch {
+	case err != nil:
+		s.Error = err.Error()
+	case id.IsNull():
+		s.Skipped = true
+	default:
+		s.SnapshotID = id.Str()
+	}
+	b.secondary = append(b.secondary, s)
+}
+
 // SetMinUpdatePause sets b.MinUpdatePause. It satisfies the
 // ArchiveProgressReporter interface.
 func (b *Backup) SetMinUpdatePause(d time.Duration) {
@@ -442,6 +496,12 @@ type verboseUpdate struct {
 	TotalFiles   uint    `json:"total_files"`
 }
 
+type hookOutput struct {
+	MessageType string `json:"message_type"` // "hook_output"
+	Hook        string `json:"hook"`
+	Output      string `json:"output"`
+}
+
 type summaryOutput struct {
 	MessageType         string  `json:"message_type"` // "summary"
 	FilesNew            uint    `json:"files_new"`
@@ -456,5 +516,17 @@ type summaryOutput struct {
 	TotalFilesProcessed uint    `json:"total_files_processed"`
 	TotalBytesProcessed uint64  `json:"total_bytes_processed"`
 	TotalDuration       float64 `json:"total_duration"` // in seconds
-	SnapshotID          string  `json:"snapshot_id"`
+	SnapshotID          string  `json:"snapshot_id,omitempty"`
+	DryRun              bool    `json:"dry_run,omitempty"`
+	Skipped             bool    `json:"skipped,omitempty"`
+	Partial             bool    `json:"partial,omitempty"`
+
+	SecondarySnapshots []secondarySnapshot `json:"secondary_snapshots,omitempty"`
+}
+
+type secondarySnapshot struct {
+	Repository string `json:"repository"`
+	SnapshotID string `json:"snapshot_id,omitempty"`
+	Skipped    bool   `json:"skipped,omitempty"`
+	Error      string `json:"error,omitempty"`
 }